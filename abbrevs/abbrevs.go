@@ -27,17 +27,27 @@ type AbbrevManager struct {
 	sync.Mutex
 	baseDir string
 	lists   map[string]map[string]string
+
+	// scope and ownership of the lists
+	meta map[string]ListMeta
+
+	// recorded changes of the lists, oldest first
+	history map[string][]Change
+
+	// duplicate abbreviations with different expansions, found in the
+	// list files by Load
+	loadConflicts []Conflict
 }
 
 func NewAbbrevManager(baseDir string) AbbrevManager {
 	return AbbrevManager{
 		baseDir: baseDir,
 		lists:   make(map[string]map[string]string),
+		meta:    make(map[string]ListMeta),
+		history: make(map[string][]Change),
 	}
 }
 
-// TODO warn for duplicate abbrevs with different expansions
-
 // Load reads the abbreviation list files of the 'baseDir' supplied
 // when creating a AbbrevManager.
 func (am *AbbrevManager) Load() error {
@@ -58,6 +68,7 @@ func (am *AbbrevManager) Load() error {
 		return fmt.Errorf("failed listing abb files: %v", err)
 	}
 
+	am.loadConflicts = []Conflict{}
 	for _, f := range files {
 
 		if verb {
@@ -70,9 +81,17 @@ func (am *AbbrevManager) Load() error {
 
 		fn := filepath.Base(f)
 		listName := strings.TrimSuffix(fn, ext)
-		// if listName does not already exist, initialise it
-		if _, ok := am.lists[listName]; !ok {
-			am.lists[listName] = make(map[string]string)
+		// the list is read from scratch, since the file may contain
+		// deletions of abbreviations already in the list
+		am.lists[listName] = make(map[string]string)
+		conflicts := map[string]Conflict{}
+		err = am.loadMeta(listName)
+		if err != nil {
+			return err
+		}
+		err = am.loadHistory(listName)
+		if err != nil {
+			return err
 		}
 
 		lines := strings.Split(strings.TrimSpace(string(bytes)), "\n")
@@ -96,6 +115,7 @@ func (am *AbbrevManager) Load() error {
 					}
 
 					delete(m, fs[0])
+					delete(conflicts, fs[0])
 				} else {
 					// TODO log and continue
 					return fmt.Errorf("could not delete non-existing abbrev '%s'", fs[0])
@@ -116,31 +136,42 @@ func (am *AbbrevManager) Load() error {
 					return fmt.Errorf("cannot have empty field: '%s'", l)
 				}
 
-				if _, ok := am.lists[listName][fs[0]]; ok {
-					log.Info("[abbrevs] Skipping duplicate abbrev\t%s\t%s\n", fs[0], fs[1])
+				if exp, ok := am.lists[listName][fs[0]]; ok {
+					if exp != fs[1] {
+						log.Warning("[abbrevs] Duplicate abbrev with different expansions in list %s, skipping\t%s\t%s (using %s)", listName, fs[0], fs[1], exp)
+						conflicts[fs[0]] = Conflict{Abbrev: fs[0], List1: listName, Expansion1: exp, List2: listName, Expansion2: fs[1]}
+					} else {
+						log.Info("[abbrevs] Skipping duplicate abbrev\t%s\t%s\n", fs[0], fs[1])
+					}
 					continue
 				}
 
 				am.lists[listName][fs[0]] = fs[1]
 			}
 		}
+		for _, c := range conflicts {
+			am.loadConflicts = append(am.loadConflicts, c)
+		}
 
 	}
 
 	return nil
 }
 
-// ListLength holds the list name and number of abbreviations
+// ListLength holds the list name and number of abbreviations, and the list metadata
 type ListLength struct {
 	Name   string `json:"name"`
 	Length int    `json:"length"`
+	ListMeta
 }
 
 func (am *AbbrevManager) ListsWithLength() []ListLength {
+	am.Lock()
+	defer am.Unlock()
 	var res []ListLength
 
 	for l, a := range am.lists {
-		res = append(res, ListLength{Name: l, Length: len(a)})
+		res = append(res, ListLength{Name: l, Length: len(a), ListMeta: am.metaFor(l)})
 	}
 
 	return res
@@ -214,6 +245,18 @@ func (am *AbbrevManager) DeleteListFile(l string) error {
 	}
 
 	delete(am.lists, l)
+	delete(am.history, l)
+	err := os.Remove(filepath.Join(am.baseDir, l+historyExt))
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove history for list '%s' : %v", l, err)
+	}
+	if _, ok := am.meta[l]; ok {
+		delete(am.meta, l)
+		err := os.Remove(filepath.Join(am.baseDir, l+metaExt))
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove metadata for list '%s' : %v", l, err)
+		}
+	}
 	return os.Remove(path)
 }
 
@@ -223,12 +266,14 @@ func (am *AbbrevManager) AbbrevsFor(list string) map[string]string {
 	return am.lists[list]
 }
 
-func (am *AbbrevManager) Add(listName, abbrev, expansion string) error {
-	return am.addOrDelete(listName, abbrev, expansion, "")
+// Add adds an abbreviation to a list. The change is recorded in the
+// list history, with the user name (see History).
+func (am *AbbrevManager) Add(listName, abbrev, expansion, userName string) error {
+	return am.addOrDelete(listName, abbrev, expansion, userName, false)
 }
 
 // AddCreateIfNotExists creates list listName if it does not already exist
-func (am *AbbrevManager) AddCreateIfNotExists(listName, abbrev, expansion string) error {
+func (am *AbbrevManager) AddCreateIfNotExists(listName, abbrev, expansion, userName string) error {
 
 	var exists bool
 	for _, list := range am.Lists() {
@@ -247,18 +292,27 @@ func (am *AbbrevManager) AddCreateIfNotExists(listName, abbrev, expansion string
 		}
 	}
 
-	return am.addOrDelete(listName, abbrev, expansion, "")
+	return am.addOrDelete(listName, abbrev, expansion, userName, false)
 }
 
-func (am *AbbrevManager) Delete(listName, abbrev string) error {
-	return am.addOrDelete(listName, abbrev, "", deletePrefix)
+// Delete deletes an abbreviation from a list. The change is recorded in
+// the list history, with the user name (see History).
+func (am *AbbrevManager) Delete(listName, abbrev, userName string) error {
+	return am.addOrDelete(listName, abbrev, "", userName, true)
 }
 
-// TODO Maybe a little odd to have an add or delete function (but both write to the same file
-func (am *AbbrevManager) addOrDelete(listName, abbrev, expansion, linePrefix string) error {
+// addOrDelete rewrites the list file (see commit), so that the list in
+// memory and the file cannot diverge if the write fails
+func (am *AbbrevManager) addOrDelete(listName, abbrev, expansion, userName string, del bool) error {
 	abbrev = strings.TrimSpace(abbrev)
 	expansion = strings.TrimSpace(expansion)
-	//linePrefix = strings.TrimSpace(linePrefix)
+
+	if abbrev == "" || (expansion == "" && !del) {
+		return fmt.Errorf("empty abbreviation or expansion")
+	}
+	if strings.ContainsAny(abbrev+expansion, "\t\n\r") || strings.HasPrefix(abbrev, deletePrefix) {
+		return fmt.Errorf("invalid abbreviation '%s' '%s'", abbrev, expansion)
+	}
 
 	am.Lock()
 	defer am.Unlock()
@@ -268,34 +322,26 @@ func (am *AbbrevManager) addOrDelete(listName, abbrev, expansion, linePrefix str
 		return fmt.Errorf("list '%s' doesn't exist", listName)
 	}
 
-	// TODO if more line prefixes are added, these need to be checked for.
-	// Alternatively, check that linePrefix is not the empty string
-	if _, ok := l[abbrev]; ok && linePrefix != deletePrefix {
-		return fmt.Errorf("abbreviation '%s' already exists in list '%s'", abbrev, listName)
-	}
-
-	path := filepath.Join(am.baseDir, listName+ext)
-	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0600)
-	if err != nil {
-		return fmt.Errorf("failed to open file : %v", err)
-	}
-	defer f.Close()
-
-	abbr := fmt.Sprintf("%s\t%s\n", abbrev, expansion)
-	if strings.TrimSpace(linePrefix) != "" {
-		abbr = linePrefix + abbr
+	old, exists := l[abbrev]
+	updated := copyList(l)
+	var change Change
+	if del {
+		if !exists {
+			return fmt.Errorf("abbreviation '%s' doesn't exist in list '%s'", abbrev, listName)
+		}
+		delete(updated, abbrev)
+		change = Change{Action: ActionDelete, Abbrev: abbrev, OldExpansion: old}
+	} else {
+		if exists {
+			return fmt.Errorf("abbreviation '%s' already exists in list '%s'", abbrev, listName)
+		}
+		updated[abbrev] = expansion
+		change = Change{Action: ActionAdd, Abbrev: abbrev, Expansion: expansion}
 	}
 
-	_, err = f.WriteString(abbr)
+	err := am.commit(listName, updated, []Change{change}, userName)
 	if err != nil {
-		return fmt.Errorf("failed to write abbreviation '%s' to list '%s' : %v", abbrev, listName, err)
-	}
-
-	// Delete or add to map, depending on value of linePrefix
-	if linePrefix == deletePrefix {
-		delete(am.lists[listName], abbrev)
-	} else {
-		am.lists[listName][abbrev] = expansion
+		return fmt.Errorf("failed to save abbreviation '%s' in list '%s' : %v", abbrev, listName, err)
 	}
 	return nil
 }
@@ -73,17 +73,24 @@ func TestLoad(t *testing.T) {
 		t.Errorf("wanted %d got %d", w, g)
 	}
 
+	// tabs and newlines would break the list file
+	for _, ae := range [][2]string{{"a\tb", "c"}, {"ab", "c\nd"}, {"", "c"}, {"ab", " "}} {
+		if err := am.Add(l1, ae[0], ae[1], ""); err == nil {
+			t.Errorf("expected error for '%s' '%s', got nil", ae[0], ae[1])
+		}
+	}
+
 	// add an abbrev
 	a := "sthlm"
 	e := "Stockholm"
-	err = am.Add(l1, a, e)
+	err = am.Add(l1, a, e, "")
 
 	if err != nil {
 		t.Errorf("%v", err)
 	}
 
 	// Cannot add same twice
-	err = am.Add(l1, a, e)
+	err = am.Add(l1, a, e, "")
 	if err == nil {
 		t.Errorf("expected error, got nil")
 	}
@@ -120,7 +127,7 @@ func TestLoad(t *testing.T) {
 	// Add and delete a new enty
 	a2 := "gtb"
 	e2 := "Jøttlabårj"
-	err = am.Add(l1, a2, e2)
+	err = am.Add(l1, a2, e2, "")
 	if err != nil {
 		t.Errorf("expected nil, got %v", err)
 	}
@@ -133,7 +140,7 @@ func TestLoad(t *testing.T) {
 		t.Errorf("wanted %s got %s", w, g)
 	}
 
-	err = am.Delete(l1, a2)
+	err = am.Delete(l1, a2, "")
 	if err != nil {
 		t.Errorf("expected nil, got %v", err)
 	}
@@ -147,7 +154,7 @@ func TestLoad(t *testing.T) {
 	}
 
 	l2 := "new_user_"
-	err = am.AddCreateIfNotExists(l2, "kr", "kronor")
+	err = am.AddCreateIfNotExists(l2, "kr", "kronor", "")
 	if err != nil {
 		t.Errorf("%v", err)
 	}
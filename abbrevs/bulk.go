@@ -0,0 +1,309 @@
+package abbrevs
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Import/export formats
+const (
+	FormatTSV  = "tsv"
+	FormatCSV  = "csv"
+	FormatJSON = "json"
+)
+
+// Entry is an abbreviation and its expansion
+type Entry struct {
+	Abbrev    string `json:"abbrev"`
+	Expansion string `json:"expansion"`
+}
+
+// Conflict is an abbreviation with different expansions, in the same
+// list or in two different lists
+type Conflict struct {
+	Abbrev     string `json:"abbrev"`
+	List1      string `json:"list1"`
+	Expansion1 string `json:"expansion1"`
+	List2      string `json:"list2"`
+	Expansion2 string `json:"expansion2"`
+}
+
+// ImportResult summarises an import or a merge. Conflicts are
+// abbreviations already in the list with a different expansion (List1
+// is the target list).
+type ImportResult struct {
+	List      string     `json:"list"`
+	Added     int        `json:"added"`
+	Unchanged int        `json:"unchanged"`
+	Replaced  int        `json:"replaced"`
+	Conflicts []Conflict `json:"conflicts"`
+}
+
+// Parse reads abbreviation entries in the given format: tab or comma
+// separated lines (abbreviation, expansion), or a JSON array of
+// entries. Empty lines are skipped.
+func Parse(format string, data []byte) ([]Entry, error) {
+	var res []Entry
+	switch format {
+	case FormatJSON:
+		err := json.Unmarshal(data, &res)
+		if err != nil {
+			return res, fmt.Errorf("failed to unmarshal JSON : %v", err)
+		}
+	case FormatTSV, FormatCSV:
+		r := csv.NewReader(bytes.NewReader(data))
+		if format == FormatTSV {
+			r.Comma = '\t'
+			r.LazyQuotes = true
+		}
+		r.FieldsPerRecord = 2
+		for {
+			rec, err := r.Read()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return res, fmt.Errorf("failed to read %s : %v", format, err)
+			}
+			res = append(res, Entry{Abbrev: rec[0], Expansion: rec[1]})
+		}
+	default:
+		return res, fmt.Errorf("unknown format '%s'", format)
+	}
+
+	for i, e := range res {
+		e.Abbrev = strings.TrimSpace(e.Abbrev)
+		e.Expansion = strings.TrimSpace(e.Expansion)
+		if e.Abbrev == "" || e.Expansion == "" {
+			return res, fmt.Errorf("empty field in entry %d: '%s' '%s'", i+1, e.Abbrev, e.Expansion)
+		}
+		if strings.HasPrefix(e.Abbrev, deletePrefix) || strings.ContainsAny(e.Abbrev+e.Expansion, "\t\n") {
+			return res, fmt.Errorf("invalid entry %d: '%s' '%s'", i+1, e.Abbrev, e.Expansion)
+		}
+		res[i] = e
+	}
+	return res, nil
+}
+
+// Export writes the abbreviations of a list, sorted, in the given format
+func (am *AbbrevManager) Export(listName, format string, w io.Writer) error {
+	am.Lock()
+	l, ok := am.lists[listName]
+	if !ok {
+		am.Unlock()
+		return fmt.Errorf("list '%s' doesn't exist", listName)
+	}
+	entries := sortedEntries(l)
+	am.Unlock()
+
+	switch format {
+	case FormatJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(entries)
+	case FormatTSV:
+		for _, e := range entries {
+			if _, err := fmt.Fprintf(w, "%s\t%s\n", e.Abbrev, e.Expansion); err != nil {
+				return err
+			}
+		}
+		return nil
+	case FormatCSV:
+		cw := csv.NewWriter(w)
+		for _, e := range entries {
+			if err := cw.Write([]string{e.Abbrev, e.Expansion}); err != nil {
+				return err
+			}
+		}
+		cw.Flush()
+		return cw.Error()
+	}
+	return fmt.Errorf("unknown format '%s'", format)
+}
+
+// Import adds the entries to a list, creating the list if it doesn't
+// exist. Entries already in the list with a different expansion are
+// reported as conflicts, and only replaced if overwrite is true. The
+// list file is rewritten without deleted lines (see Compact), and the
+// changes are recorded in the list history.
+func (am *AbbrevManager) Import(listName string, entries []Entry, overwrite bool, userName string) (ImportResult, error) {
+	am.Lock()
+	defer am.Unlock()
+	return am.importEntries(listName, listName, entries, overwrite, userName)
+}
+
+// Merge imports the abbreviations of list from into list to (see Import)
+func (am *AbbrevManager) Merge(from, to string, overwrite bool, userName string) (ImportResult, error) {
+	am.Lock()
+	defer am.Unlock()
+	l, ok := am.lists[from]
+	if !ok {
+		return ImportResult{List: to}, fmt.Errorf("list '%s' doesn't exist", from)
+	}
+	if from == to {
+		return ImportResult{List: to}, fmt.Errorf("cannot merge list '%s' with itself", from)
+	}
+	return am.importEntries(from, to, sortedEntries(l), overwrite, userName)
+}
+
+// exec in a locked context only
+func (am *AbbrevManager) importEntries(from, to string, entries []Entry, overwrite bool, userName string) (ImportResult, error) {
+	res := ImportResult{List: to, Conflicts: []Conflict{}}
+	if strings.TrimSpace(to) == "" || strings.ContainsAny(to, `/\`) {
+		return res, fmt.Errorf("invalid list name '%s'", to)
+	}
+	l, ok := am.lists[to]
+	if !ok {
+		l = make(map[string]string)
+	}
+	updated := copyList(l)
+
+	var changes []Change
+	seen := map[string]string{}
+	for _, e := range entries {
+		if exp, ok := seen[e.Abbrev]; ok && exp != e.Expansion {
+			return res, fmt.Errorf("abbreviation '%s' has different expansions in input: '%s' and '%s'", e.Abbrev, exp, e.Expansion)
+		}
+		seen[e.Abbrev] = e.Expansion
+
+		exp, ok := updated[e.Abbrev]
+		switch {
+		case !ok:
+			updated[e.Abbrev] = e.Expansion
+			res.Added++
+			changes = append(changes, Change{Action: ActionAdd, Abbrev: e.Abbrev, Expansion: e.Expansion})
+		case exp == e.Expansion:
+			res.Unchanged++
+		default:
+			res.Conflicts = append(res.Conflicts, Conflict{Abbrev: e.Abbrev, List1: to, Expansion1: exp, List2: from, Expansion2: e.Expansion})
+			if overwrite {
+				updated[e.Abbrev] = e.Expansion
+				res.Replaced++
+				changes = append(changes, Change{Action: ActionReplace, Abbrev: e.Abbrev, Expansion: e.Expansion, OldExpansion: exp})
+			}
+		}
+	}
+
+	if res.Added == 0 && res.Replaced == 0 && ok {
+		return res, nil
+	}
+	return res, am.commit(to, updated, changes, userName)
+}
+
+// Compact rewrites a list file without deleted lines and duplicates.
+// It returns the number of lines removed from the file.
+func (am *AbbrevManager) Compact(listName string) (int, error) {
+	am.Lock()
+	defer am.Unlock()
+	l, ok := am.lists[listName]
+	if !ok {
+		return 0, fmt.Errorf("list '%s' doesn't exist", listName)
+	}
+	bts, err := os.ReadFile(filepath.Join(am.baseDir, listName+ext))
+	if err != nil {
+		return 0, fmt.Errorf("failed to read list file : %v", err)
+	}
+	before := 0
+	for _, line := range strings.Split(string(bts), "\n") {
+		if strings.TrimSpace(line) != "" {
+			before++
+		}
+	}
+	err = am.writeList(listName, l)
+	if err != nil {
+		return 0, err
+	}
+	// conflicting duplicates in the file are gone
+	var conflicts []Conflict
+	for _, c := range am.loadConflicts {
+		if c.List1 != listName {
+			conflicts = append(conflicts, c)
+		}
+	}
+	am.loadConflicts = conflicts
+	return before - len(l), nil
+}
+
+// writeList writes a list file with one line per abbreviation, sorted.
+// The file is written to a temporary file first, and then renamed.
+// exec in a locked context only
+func (am *AbbrevManager) writeList(listName string, l map[string]string) error {
+	path := filepath.Join(am.baseDir, listName+ext)
+	tmp, err := os.CreateTemp(am.baseDir, listName+ext+".tmp*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file : %v", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	var b strings.Builder
+	for _, e := range sortedEntries(l) {
+		fmt.Fprintf(&b, "%s\t%s\n", e.Abbrev, e.Expansion)
+	}
+	if _, err := tmp.WriteString(b.String()); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write list '%s' : %v", listName, err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write list '%s' : %v", listName, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close file : %v", err)
+	}
+	if err := os.Chmod(tmp.Name(), 0600); err != nil {
+		return fmt.Errorf("failed to set file mode : %v", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to write list '%s' : %v", listName, err)
+	}
+	return nil
+}
+
+// Duplicates returns abbreviations with different expansions: within a
+// list file (as found by Load), and across lists
+func (am *AbbrevManager) Duplicates() []Conflict {
+	am.Lock()
+	defer am.Unlock()
+	res := append([]Conflict{}, am.loadConflicts...)
+
+	var lists []string
+	for l := range am.lists {
+		lists = append(lists, l)
+	}
+	sort.Strings(lists)
+	for i, l1 := range lists {
+		for _, l2 := range lists[i+1:] {
+			for a, e1 := range am.lists[l1] {
+				if e2, ok := am.lists[l2][a]; ok && e1 != e2 {
+					res = append(res, Conflict{Abbrev: a, List1: l1, Expansion1: e1, List2: l2, Expansion2: e2})
+				}
+			}
+		}
+	}
+	sort.SliceStable(res, func(i, j int) bool {
+		if res[i].Abbrev != res[j].Abbrev {
+			return res[i].Abbrev < res[j].Abbrev
+		}
+		if res[i].List1 != res[j].List1 {
+			return res[i].List1 < res[j].List1
+		}
+		return res[i].List2 < res[j].List2
+	})
+	return res
+}
+
+func sortedEntries(l map[string]string) []Entry {
+	res := make([]Entry, 0, len(l))
+	for a, e := range l {
+		res = append(res, Entry{Abbrev: a, Expansion: e})
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Abbrev < res[j].Abbrev })
+	return res
+}
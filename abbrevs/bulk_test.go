@@ -0,0 +1,155 @@
+package abbrevs
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	for _, test := range []struct {
+		format string
+		data   string
+		n      int
+	}{
+		{FormatTSV, "ca\tcirka\n\nkr\tkronor\n", 2},
+		{FormatCSV, "ca,cirka\n\"t.ex.\",\"till exempel\"\n", 2},
+		{FormatJSON, `[{"abbrev": "ca", "expansion": "cirka"}]`, 1},
+	} {
+		res, err := Parse(test.format, []byte(test.data))
+		if err != nil {
+			t.Errorf("%s: expected nil, got %v", test.format, err)
+		}
+		if w, g := test.n, len(res); w != g {
+			t.Errorf("%s: wanted %d got %d", test.format, w, g)
+		}
+	}
+
+	for _, test := range []struct {
+		format string
+		data   string
+	}{
+		{FormatTSV, "ca\tcirka\textra\n"},
+		{FormatTSV, "ca\t \n"},
+		{FormatJSON, `[{"abbrev": "ca"}]`},
+		{"xml", ""},
+	} {
+		if _, err := Parse(test.format, []byte(test.data)); err == nil {
+			t.Errorf("%s: expected error for '%s', got nil", test.format, test.data)
+		}
+	}
+}
+
+func TestImportMergeCompact(t *testing.T) {
+	baseDir, err := os.MkdirTemp("", "transtool_abbrevs_test")
+	if err != nil {
+		t.Fatalf("failed to create temp dir : %v", err)
+	}
+	defer os.RemoveAll(baseDir)
+
+	// a list file with a deleted entry and a conflicting duplicate
+	l1 := "l1"
+	err = os.WriteFile(filepath.Join(baseDir, l1+ext), []byte("ca\tcirka\nkr\tkronor\nDELETE:>>kr\t\nbl\tbland\nbl\tblad\n"), 0600)
+	if err != nil {
+		t.Fatalf("failed to write list file : %v", err)
+	}
+
+	am := NewAbbrevManager(baseDir)
+	err = am.Load()
+	if err != nil {
+		t.Fatalf("failed Load() : %v", err)
+	}
+	if w, g := 2, len(am.AbbrevsFor(l1)); w != g {
+		t.Errorf("wanted %d got %d", w, g)
+	}
+	dups := am.Duplicates()
+	if w, g := 1, len(dups); w != g {
+		t.Fatalf("wanted %d got %d", w, g)
+	}
+	if w, g := "blad", dups[0].Expansion2; w != g {
+		t.Errorf("wanted '%s' got '%s'", w, g)
+	}
+
+	// import into a new list
+	l2 := "l2"
+	res, err := am.Import(l2, []Entry{{"ca", "cirka"}, {"bl", "blå"}, {"st", "stycken"}}, false, "")
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	if w, g := 3, res.Added; w != g {
+		t.Errorf("wanted %d got %d", w, g)
+	}
+	// bl in l1 and l2, with different expansions
+	if w, g := 2, len(am.Duplicates()); w != g {
+		t.Errorf("wanted %d got %d", w, g)
+	}
+
+	// merge l2 into l1, without overwrite
+	res, err = am.Merge(l2, l1, false, "")
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	if w, g := 1, res.Added; w != g {
+		t.Errorf("wanted %d got %d", w, g)
+	}
+	if w, g := 1, res.Unchanged; w != g {
+		t.Errorf("wanted %d got %d", w, g)
+	}
+	if w, g := 1, len(res.Conflicts); w != g {
+		t.Fatalf("wanted %d got %d", w, g)
+	}
+	if w, g := "bland", am.AbbrevsFor(l1)["bl"]; w != g {
+		t.Errorf("wanted '%s' got '%s'", w, g)
+	}
+
+	// with overwrite
+	res, err = am.Merge(l2, l1, true, "")
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	if w, g := 1, res.Replaced; w != g {
+		t.Errorf("wanted %d got %d", w, g)
+	}
+	if w, g := "blå", am.AbbrevsFor(l1)["bl"]; w != g {
+		t.Errorf("wanted '%s' got '%s'", w, g)
+	}
+
+	// the list is saved compacted, also after a delete
+	am.Delete(l1, "st", "")
+	n, err := am.Compact(l1)
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	if w, g := 0, n; w != g {
+		t.Errorf("wanted %d got %d", w, g)
+	}
+	bts, err := os.ReadFile(filepath.Join(baseDir, l1+ext))
+	if err != nil {
+		t.Fatalf("failed to read list file : %v", err)
+	}
+	if w, g := "bl\tblå\nca\tcirka\n", string(bts); w != g {
+		t.Errorf("wanted '%s' got '%s'", w, g)
+	}
+	if w, g := 0, len(am.Duplicates()); w != g {
+		t.Errorf("wanted %d got %d", w, g)
+	}
+
+	// reloaded from file
+	err = am.Load()
+	if err != nil {
+		t.Fatalf("failed Load() : %v", err)
+	}
+	if w, g := 2, len(am.AbbrevsFor(l1)); w != g {
+		t.Errorf("wanted %d got %d", w, g)
+	}
+
+	var b strings.Builder
+	err = am.Export(l2, FormatCSV, &b)
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	if w, g := "bl,blå\nca,cirka\nst,stycken\n", b.String(); w != g {
+		t.Errorf("wanted '%s' got '%s'", w, g)
+	}
+}
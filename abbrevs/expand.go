@@ -0,0 +1,94 @@
+package abbrevs
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// ExpandOptions control how a text is split into tokens for abbreviation expansion
+type ExpandOptions struct {
+	// TokenSplit matches the token separators (typically the
+	// validation config's TokenSplitRegexp). If nil, tokens are
+	// split on whitespace.
+	TokenSplit *regexp.Regexp
+
+	// Tokens starting with LabelPrefix (and, if set, ending with
+	// LabelSuffix) are labels, and are never expanded
+	LabelPrefix string
+	LabelSuffix string
+}
+
+// Expansion is an abbreviation expanded in a text
+type Expansion struct {
+	Abbrev    string `json:"abbrev"`
+	Expansion string `json:"expansion"`
+	List      string `json:"list"`
+}
+
+// ExpandResult is the result of an abbreviation expansion
+type ExpandResult struct {
+	Input      string      `json:"input"`
+	Output     string      `json:"output"`
+	Expansions []Expansion `json:"expansions,omitempty"`
+}
+
+var whitespace = regexp.MustCompile(`\s+`)
+
+func (o ExpandOptions) isLabel(tok string) bool {
+	return o.LabelPrefix != "" && strings.HasPrefix(tok, o.LabelPrefix) && strings.HasSuffix(tok, o.LabelSuffix)
+}
+
+// Expand replaces abbreviations in text with their expansions, using
+// the lists in the order given (if an abbreviation is found in more
+// than one list, the first one is used). Only whole tokens are
+// expanded, and the token separators are kept as is.
+func (am *AbbrevManager) Expand(lists []string, text string, opts ExpandOptions) (ExpandResult, error) {
+	res := ExpandResult{Input: text, Output: text}
+
+	am.Lock()
+	defer am.Unlock()
+	var abbs []map[string]string
+	for _, l := range lists {
+		m, ok := am.lists[l]
+		if !ok {
+			return res, fmt.Errorf("abbrevs.AbbrevManager.Expand: no such list '%s'", l)
+		}
+		abbs = append(abbs, m)
+	}
+
+	split := opts.TokenSplit
+	if split == nil {
+		split = whitespace
+	}
+
+	var b strings.Builder
+	start := 0
+	expandTok := func(tok string) {
+		if tok == "" || opts.isLabel(tok) {
+			b.WriteString(tok)
+			return
+		}
+		for i, m := range abbs {
+			if exp, ok := m[tok]; ok {
+				b.WriteString(exp)
+				res.Expansions = append(res.Expansions, Expansion{Abbrev: tok, Expansion: exp, List: lists[i]})
+				return
+			}
+		}
+		b.WriteString(tok)
+	}
+	for _, sep := range split.FindAllStringIndex(text, -1) {
+		if sep[0] == sep[1] {
+			// empty match, not a separator
+			continue
+		}
+		expandTok(text[start:sep[0]])
+		b.WriteString(text[sep[0]:sep[1]])
+		start = sep[1]
+	}
+	expandTok(text[start:])
+
+	res.Output = b.String()
+	return res, nil
+}
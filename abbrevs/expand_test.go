@@ -0,0 +1,48 @@
+package abbrevs
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestExpand(t *testing.T) {
+	am := NewAbbrevManager("")
+	am.lists["sv"] = map[string]string{"t": "till", "kr": "kronor", "ca": "cirka", "AGENT": "agenten"}
+	am.lists["sv2"] = map[string]string{"kr": "kr", "bl": "bland"}
+
+	opts := ExpandOptions{
+		TokenSplit:  regexp.MustCompile(`[ \n,.!?]`),
+		LabelPrefix: "#",
+	}
+
+	res, err := am.Expand([]string{"sv", "sv2"}, "#AGENT det kostar ca 20 kr, bl annat t-shirts", opts)
+	if err != nil {
+		t.Fatalf("Expand failed : %v", err)
+	}
+	if w, g := "#AGENT det kostar cirka 20 kronor, bland annat t-shirts", res.Output; w != g {
+		t.Errorf("wanted '%s' got '%s'", w, g)
+	}
+	if w, g := 3, len(res.Expansions); w != g {
+		t.Errorf("wanted %d got %d", w, g)
+	}
+	if w, g := "sv2", res.Expansions[2].List; w != g {
+		t.Errorf("wanted '%s' got '%s'", w, g)
+	}
+
+	// the first list has precedence
+	res, _ = am.Expand([]string{"sv2", "sv"}, "20 kr", opts)
+	if w, g := "20 kr", res.Output; w != g {
+		t.Errorf("wanted '%s' got '%s'", w, g)
+	}
+
+	// whitespace split by default
+	res, _ = am.Expand([]string{"sv"}, "ca  kr.", ExpandOptions{})
+	if w, g := "cirka  kr.", res.Output; w != g {
+		t.Errorf("wanted '%s' got '%s'", w, g)
+	}
+
+	_, err = am.Expand([]string{"sv", "nope"}, "ca", opts)
+	if err == nil {
+		t.Errorf("expected error for unknown list")
+	}
+}
@@ -0,0 +1,188 @@
+package abbrevs
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Change actions
+const (
+	ActionAdd     = "add"
+	ActionDelete  = "delete"
+	ActionReplace = "replace"
+)
+
+var historyExt = ".history.jsonl"
+
+// Change is a recorded change to an abbreviation list. Expansion is the
+// expansion after the change (empty for deletions), and OldExpansion
+// the expansion before the change (empty for additions). The history
+// of a list is saved next to the list file, as <list
+// name>.history.jsonl, one change per line.
+type Change struct {
+	ID           int    `json:"id"`
+	Time         string `json:"time"`
+	User         string `json:"user,omitempty"`
+	Action       string `json:"action"`
+	Abbrev       string `json:"abbrev"`
+	Expansion    string `json:"expansion,omitempty"`
+	OldExpansion string `json:"old_expansion,omitempty"`
+	// Reverts is the ID of the change undone by this change, if any
+	Reverts int `json:"reverts,omitempty"`
+}
+
+// exec in a locked context only
+func (am *AbbrevManager) loadHistory(listName string) error {
+	bts, err := os.ReadFile(filepath.Join(am.baseDir, listName+historyExt))
+	if os.IsNotExist(err) {
+		delete(am.history, listName)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read history for list '%s' : %v", listName, err)
+	}
+	var res []Change
+	scanner := bufio.NewScanner(bytes.NewReader(bts))
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for n := 1; scanner.Scan(); n++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var c Change
+		err := json.Unmarshal(scanner.Bytes(), &c)
+		if err != nil {
+			return fmt.Errorf("failed to unmarshal history for list '%s', line %d : %v", listName, n, err)
+		}
+		res = append(res, c)
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read history for list '%s' : %v", listName, err)
+	}
+	am.history[listName] = res
+	return nil
+}
+
+// commit writes the updated list to file (atomically, see writeList),
+// replaces the list in memory, and records the changes in the list
+// history. The list in memory is only replaced if the list file was
+// written.
+// exec in a locked context only
+func (am *AbbrevManager) commit(listName string, updated map[string]string, changes []Change, userName string) error {
+	err := am.writeList(listName, updated)
+	if err != nil {
+		return err
+	}
+	am.lists[listName] = updated
+	return am.record(listName, changes, userName)
+}
+
+// exec in a locked context only
+func (am *AbbrevManager) record(listName string, changes []Change, userName string) error {
+	if len(changes) == 0 {
+		return nil
+	}
+	id := 0
+	if h := am.history[listName]; len(h) > 0 {
+		id = h[len(h)-1].ID
+	}
+	now := time.Now().Format("2006-01-02 15:04:05")
+
+	var b bytes.Buffer
+	for i := range changes {
+		id++
+		changes[i].ID = id
+		changes[i].Time = now
+		changes[i].User = userName
+		bts, err := json.Marshal(changes[i])
+		if err != nil {
+			return fmt.Errorf("failed to marshal change : %v", err)
+		}
+		b.Write(bts)
+		b.WriteString("\n")
+	}
+
+	f, err := os.OpenFile(filepath.Join(am.baseDir, listName+historyExt), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("list '%s' was saved, but the history could not be opened : %v", listName, err)
+	}
+	defer f.Close()
+	_, err = f.Write(b.Bytes())
+	if err != nil {
+		return fmt.Errorf("list '%s' was saved, but the history could not be written : %v", listName, err)
+	}
+	am.history[listName] = append(am.history[listName], changes...)
+	return nil
+}
+
+// History returns the recorded changes of a list, most recent first. A
+// limit of 0 means no limit.
+func (am *AbbrevManager) History(listName string, limit int) ([]Change, error) {
+	am.Lock()
+	defer am.Unlock()
+	if _, ok := am.lists[listName]; !ok {
+		return nil, fmt.Errorf("list '%s' doesn't exist", listName)
+	}
+	h := am.history[listName]
+	res := []Change{}
+	for i := len(h) - 1; i >= 0 && (limit <= 0 || len(res) < limit); i-- {
+		res = append(res, h[i])
+	}
+	return res, nil
+}
+
+// Revert undoes the change with the given ID, and returns the new
+// change recorded for the revert. A change can only be reverted if the
+// abbreviation has not been changed since.
+func (am *AbbrevManager) Revert(listName string, id int, userName string) (Change, error) {
+	am.Lock()
+	defer am.Unlock()
+	l, ok := am.lists[listName]
+	if !ok {
+		return Change{}, fmt.Errorf("list '%s' doesn't exist", listName)
+	}
+	var c Change
+	for _, c0 := range am.history[listName] {
+		if c0.ID == id {
+			c = c0
+			break
+		}
+	}
+	if c.ID == 0 {
+		return Change{}, fmt.Errorf("no change %d in the history of list '%s'", id, listName)
+	}
+
+	cur, exists := l[c.Abbrev]
+	if exists != (c.Action != ActionDelete) || cur != c.Expansion {
+		return Change{}, fmt.Errorf("abbreviation '%s' in list '%s' has been changed after change %d", c.Abbrev, listName, id)
+	}
+
+	updated := copyList(l)
+	res := Change{Abbrev: c.Abbrev, Expansion: c.OldExpansion, OldExpansion: c.Expansion, Reverts: c.ID}
+	switch {
+	case c.OldExpansion == "":
+		res.Action = ActionDelete
+		delete(updated, c.Abbrev)
+	case c.Expansion == "":
+		res.Action = ActionAdd
+		updated[c.Abbrev] = c.OldExpansion
+	default:
+		res.Action = ActionReplace
+		updated[c.Abbrev] = c.OldExpansion
+	}
+	changes := []Change{res}
+	err := am.commit(listName, updated, changes, userName)
+	return changes[0], err
+}
+
+func copyList(l map[string]string) map[string]string {
+	res := make(map[string]string, len(l))
+	for k, v := range l {
+		res[k] = v
+	}
+	return res
+}
@@ -0,0 +1,100 @@
+package abbrevs
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestHistory(t *testing.T) {
+	baseDir, err := os.MkdirTemp("", "transtool_abbrevs_test")
+	if err != nil {
+		t.Fatalf("failed to create temp dir : %v", err)
+	}
+	defer os.RemoveAll(baseDir)
+
+	am := NewAbbrevManager(baseDir)
+	l1 := "l1"
+	if err := am.CreateList(l1); err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	if err := am.Add(l1, "ca", "cirka", "anna"); err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	if err := am.Add(l1, "kr", "kronor", "anna"); err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	if _, err := am.Import(l1, []Entry{{"ca", "cirkus"}}, true, "bo"); err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	if err := am.Delete(l1, "kr", "bo"); err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	// deleting a missing abbreviation is an error, and not recorded
+	if err := am.Delete(l1, "kr", "bo"); err == nil {
+		t.Errorf("expected error, got nil")
+	}
+
+	h, err := am.History(l1, 0)
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	if w, g := 4, len(h); w != g {
+		t.Fatalf("wanted %d got %d", w, g)
+	}
+	// most recent first
+	if w, g := (Change{ID: 4, Time: h[0].Time, User: "bo", Action: ActionDelete, Abbrev: "kr", OldExpansion: "kronor"}), h[0]; w != g {
+		t.Errorf("wanted %#v got %#v", w, g)
+	}
+	if w, g := (Change{ID: 3, Time: h[1].Time, User: "bo", Action: ActionReplace, Abbrev: "ca", Expansion: "cirkus", OldExpansion: "cirka"}), h[1]; w != g {
+		t.Errorf("wanted %#v got %#v", w, g)
+	}
+	if h, _ := am.History(l1, 1); len(h) != 1 {
+		t.Errorf("wanted %d got %d", 1, len(h))
+	}
+
+	// the change of ca can be reverted, but not the addition it replaced
+	if _, err := am.Revert(l1, 1, "anna"); err == nil {
+		t.Errorf("expected error, got nil")
+	}
+	c, err := am.Revert(l1, 3, "anna")
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	if w, g := (Change{ID: 5, Time: c.Time, User: "anna", Action: ActionReplace, Abbrev: "ca", Expansion: "cirka", OldExpansion: "cirkus", Reverts: 3}), c; w != g {
+		t.Errorf("wanted %#v got %#v", w, g)
+	}
+	if _, err := am.Revert(l1, 4, "anna"); err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	if w, g := "kronor", am.AbbrevsFor(l1)["kr"]; w != g {
+		t.Errorf("wanted '%s' got '%s'", w, g)
+	}
+	if _, err := am.Revert(l1, 17, "anna"); err == nil {
+		t.Errorf("expected error, got nil")
+	}
+
+	// the list file has no deleted lines, and the history is reloaded
+	bts, err := os.ReadFile(filepath.Join(baseDir, l1+ext))
+	if err != nil {
+		t.Fatalf("failed to read list file : %v", err)
+	}
+	if w, g := "ca\tcirka\nkr\tkronor\n", string(bts); w != g {
+		t.Errorf("wanted '%s' got '%s'", w, g)
+	}
+	am = NewAbbrevManager(baseDir)
+	if err := am.Load(); err != nil {
+		t.Fatalf("failed Load() : %v", err)
+	}
+	h, _ = am.History(l1, 0)
+	if w, g := 6, len(h); w != g {
+		t.Fatalf("wanted %d got %d", w, g)
+	}
+	if err := am.Add(l1, "st", "stycken", "anna"); err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	h, _ = am.History(l1, 1)
+	if w, g := 7, h[0].ID; w != g {
+		t.Errorf("wanted %d got %d", w, g)
+	}
+}
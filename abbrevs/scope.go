@@ -0,0 +1,191 @@
+package abbrevs
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// List scopes, in order of precedence (see ResolveLists)
+const (
+	// ScopeUser lists belong to a single user (the owner)
+	ScopeUser = "user"
+	// ScopeProject lists are used for a single sub-project
+	ScopeProject = "project"
+	// ScopeGlobal lists are used by everyone. Lists without metadata are global.
+	ScopeGlobal = "global"
+)
+
+var scopeOrder = map[string]int{
+	ScopeUser:    0,
+	ScopeProject: 1,
+	ScopeGlobal:  2,
+}
+
+var metaExt = ".meta.json"
+
+// ListMeta holds the scope and ownership of a list. It is saved next to
+// the list file, as <list name>.meta.json.
+type ListMeta struct {
+	Scope   string `json:"scope"`
+	Owner   string `json:"owner,omitempty"`
+	SubProj string `json:"sub_proj,omitempty"`
+	Created string `json:"created,omitempty"`
+}
+
+func (m ListMeta) validate() error {
+	switch m.Scope {
+	case ScopeGlobal:
+	case ScopeProject:
+		if strings.TrimSpace(m.SubProj) == "" {
+			return fmt.Errorf("scope '%s' requires a sub project", m.Scope)
+		}
+	case ScopeUser:
+		if strings.TrimSpace(m.Owner) == "" {
+			return fmt.Errorf("scope '%s' requires an owner", m.Scope)
+		}
+	default:
+		return fmt.Errorf("unknown scope '%s' (expected %s, %s or %s)", m.Scope, ScopeGlobal, ScopeProject, ScopeUser)
+	}
+	return nil
+}
+
+// visible returns true if a list with this metadata is used by userName
+// in subProj. An empty userName or subProj means unknown, and doesn't
+// hide any lists. A sub project matches by full path or by base name.
+func (m ListMeta) visible(userName, subProj string) bool {
+	switch m.Scope {
+	case ScopeUser:
+		return userName == "" || m.Owner == userName
+	case ScopeProject:
+		return subProj == "" || m.SubProj == subProj || m.SubProj == filepath.Base(subProj)
+	}
+	return true
+}
+
+// exec in a locked context only
+func (am *AbbrevManager) loadMeta(listName string) error {
+	bts, err := os.ReadFile(filepath.Join(am.baseDir, listName+metaExt))
+	if os.IsNotExist(err) {
+		delete(am.meta, listName)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read metadata for list '%s' : %v", listName, err)
+	}
+	var m ListMeta
+	err = json.Unmarshal(bts, &m)
+	if err != nil {
+		return fmt.Errorf("failed to unmarshal metadata for list '%s' : %v", listName, err)
+	}
+	if err := m.validate(); err != nil {
+		return fmt.Errorf("invalid metadata for list '%s' : %v", listName, err)
+	}
+	am.meta[listName] = m
+	return nil
+}
+
+// CreateScopedList creates a new list with scope and ownership metadata
+func (am *AbbrevManager) CreateScopedList(listName string, meta ListMeta) error {
+	if err := meta.validate(); err != nil {
+		return err
+	}
+	if meta.Created == "" {
+		meta.Created = time.Now().Format("2006-01-02 15:04:05")
+	}
+	bts, err := json.MarshalIndent(meta, "", " ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal list metadata : %v", err)
+	}
+
+	err = am.CreateList(listName)
+	if err != nil {
+		return err
+	}
+
+	am.Lock()
+	defer am.Unlock()
+	err = os.WriteFile(filepath.Join(am.baseDir, listName+metaExt), bts, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to write metadata for list '%s' : %v", listName, err)
+	}
+	am.meta[listName] = meta
+	return nil
+}
+
+// Meta returns the metadata of a list
+func (am *AbbrevManager) Meta(listName string) (ListMeta, bool) {
+	am.Lock()
+	defer am.Unlock()
+	if _, ok := am.lists[listName]; !ok {
+		return ListMeta{}, false
+	}
+	return am.metaFor(listName), true
+}
+
+// exec in a locked context only
+func (am *AbbrevManager) metaFor(listName string) ListMeta {
+	if m, ok := am.meta[listName]; ok {
+		return m
+	}
+	return ListMeta{Scope: ScopeGlobal}
+}
+
+// CanDelete returns true if the list may be deleted by the user: the
+// owner of the list, or an admin. Lists without an owner can only be
+// deleted by admins.
+func (am *AbbrevManager) CanDelete(listName, userName string, admin bool) bool {
+	if admin {
+		return true
+	}
+	m, ok := am.Meta(listName)
+	return ok && m.Owner != "" && m.Owner == userName
+}
+
+// CanEdit returns true if entries of the list may be added or deleted
+// by the user. Global lists can be edited by everyone, and user lists
+// only by their owner, or an admin. Sub-project lists are shared by the
+// users working on the sub-project. Since users are not assigned to sub
+// projects, they can be edited by every known user (a non-empty
+// userName), or an admin.
+func (am *AbbrevManager) CanEdit(listName, userName string, admin bool) bool {
+	m, ok := am.Meta(listName)
+	if !ok {
+		return false
+	}
+	if admin || m.Scope == ScopeGlobal {
+		return true
+	}
+	if m.Scope == ScopeProject {
+		return userName != ""
+	}
+	return m.Owner != "" && m.Owner == userName
+}
+
+// ResolveLists returns the lists used by userName in subProj, in order
+// of precedence: the user's own lists, then the lists of the sub
+// project, and last the global lists. Lists of the same scope are
+// sorted by name. An empty userName or subProj means unknown (see
+// ListMeta).
+func (am *AbbrevManager) ResolveLists(userName, subProj string) []string {
+	am.Lock()
+	defer am.Unlock()
+	res := []string{}
+	for l := range am.lists {
+		if am.metaFor(l).visible(userName, subProj) {
+			res = append(res, l)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		si, sj := scopeOrder[am.metaFor(res[i]).Scope], scopeOrder[am.metaFor(res[j]).Scope]
+		if si != sj {
+			return si < sj
+		}
+		return res[i] < res[j]
+	})
+	return res
+}
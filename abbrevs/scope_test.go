@@ -0,0 +1,108 @@
+package abbrevs
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestScopes(t *testing.T) {
+	baseDir, err := os.MkdirTemp("", "transtool_abbrevs_test")
+	if err != nil {
+		t.Fatalf("failed to create temp dir : %v", err)
+	}
+	defer os.RemoveAll(baseDir)
+
+	am := NewAbbrevManager(baseDir)
+	if err := am.Load(); err != nil {
+		t.Fatalf("failed Load() : %v", err)
+	}
+
+	// a list without metadata is global
+	if err := am.CreateList("general"); err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	for name, meta := range map[string]ListMeta{
+		"names":   {Scope: ScopeGlobal, Owner: "anna"},
+		"medical": {Scope: ScopeProject, SubProj: "sp1", Owner: "anna"},
+		"annas":   {Scope: ScopeUser, Owner: "anna"},
+		"bos":     {Scope: ScopeUser, Owner: "bo"},
+	} {
+		if err := am.CreateScopedList(name, meta); err != nil {
+			t.Fatalf("expected nil, got %v", err)
+		}
+	}
+	for _, meta := range []ListMeta{
+		{Scope: ScopeUser},
+		{Scope: ScopeProject},
+		{Scope: "team"},
+	} {
+		if err := am.CreateScopedList("invalid", meta); err == nil {
+			t.Errorf("expected error for %#v, got nil", meta)
+		}
+	}
+
+	if w, g := "annas medical general names", strings.Join(am.ResolveLists("anna", "/data/sp1"), " "); w != g {
+		t.Errorf("wanted '%s' got '%s'", w, g)
+	}
+	if w, g := "bos general names", strings.Join(am.ResolveLists("bo", "sp2"), " "); w != g {
+		t.Errorf("wanted '%s' got '%s'", w, g)
+	}
+
+	// user and sub project unknown
+	if w, g := 5, len(am.ResolveLists("", "")); w != g {
+		t.Errorf("wanted %d got %d", w, g)
+	}
+
+	if !am.CanDelete("annas", "anna", false) {
+		t.Errorf("expected owner to be allowed to delete list")
+	}
+	if am.CanDelete("annas", "bo", false) {
+		t.Errorf("expected other user not to be allowed to delete list")
+	}
+	if am.CanDelete("general", "bo", false) {
+		t.Errorf("expected other user not to be allowed to delete list without owner")
+	}
+	if !am.CanDelete("general", "bo", true) {
+		t.Errorf("expected admin to be allowed to delete list")
+	}
+
+	if !am.CanEdit("names", "bo", false) || !am.CanEdit("general", "", false) {
+		t.Errorf("expected everyone to be allowed to edit global lists")
+	}
+	if !am.CanEdit("annas", "anna", false) || !am.CanEdit("medical", "anna", false) {
+		t.Errorf("expected owner to be allowed to edit lists")
+	}
+	if am.CanEdit("annas", "bo", false) {
+		t.Errorf("expected other users not to be allowed to edit user lists")
+	}
+	if !am.CanEdit("medical", "bo", false) || am.CanEdit("medical", "", false) {
+		t.Errorf("expected known users to be allowed to edit sub project lists")
+	}
+	if !am.CanEdit("bos", "anna", true) {
+		t.Errorf("expected admin to be allowed to edit list")
+	}
+	if am.CanEdit("nonexistent", "anna", true) {
+		t.Errorf("expected false for missing list")
+	}
+
+	// metadata is reloaded from file
+	am = NewAbbrevManager(baseDir)
+	if err := am.Load(); err != nil {
+		t.Fatalf("failed Load() : %v", err)
+	}
+	m, ok := am.Meta("medical")
+	if !ok {
+		t.Fatalf("expected list metadata")
+	}
+	if w, g := "sp1", m.SubProj; w != g {
+		t.Errorf("wanted '%s' got '%s'", w, g)
+	}
+
+	if err := am.DeleteListFile("annas"); err != nil {
+		t.Errorf("expected nil, got %v", err)
+	}
+	if _, err := os.Stat(baseDir + "/annas" + metaExt); !os.IsNotExist(err) {
+		t.Errorf("expected metadata file to be removed")
+	}
+}
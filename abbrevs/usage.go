@@ -0,0 +1,199 @@
+package abbrevs
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Usage is the number of occurrences of an abbreviation's expansion in a corpus
+type Usage struct {
+	Abbrev    string `json:"abbrev"`
+	Expansion string `json:"expansion"`
+	Count     int    `json:"count"`
+}
+
+// Suggestion is a frequent word or phrase that no abbreviation list
+// covers, with a suggested abbreviation. Score is the approximate
+// number of characters saved in the corpus (occurrences times phrase
+// length).
+type Suggestion struct {
+	Phrase string `json:"phrase"`
+	Abbrev string `json:"abbrev"`
+	Count  int    `json:"count"`
+	Score  int    `json:"score"`
+}
+
+// SuggestOptions control which phrases are suggested
+type SuggestOptions struct {
+	// MinLength is the minimum phrase length, in characters
+	MinLength int
+	// MinCount is the minimum number of occurrences
+	MinCount int
+	// MaxWords is the maximum number of words in a phrase
+	MaxWords int
+	// Limit is the maximum number of suggestions
+	Limit int
+}
+
+// SuggestOptionsDefault are the default suggestion options
+var SuggestOptionsDefault = SuggestOptions{
+	MinLength: 8,
+	MinCount:  3,
+	MaxWords:  3,
+	Limit:     50,
+}
+
+// words splits a text into lowercased sequences of words. Labels and
+// tokens that are not words (containing no letters, or digits) split
+// the sequences, so that phrases never span them.
+func words(text string, opts ExpandOptions) [][]string {
+	split := opts.TokenSplit
+	if split == nil {
+		split = whitespace
+	}
+	var res [][]string
+	var seq []string
+	for _, tok := range split.Split(text, -1) {
+		tok = strings.TrimFunc(tok, unicode.IsPunct)
+		if tok == "" {
+			continue
+		}
+		isWord := !opts.isLabel(tok) && strings.IndexFunc(tok, unicode.IsLetter) >= 0 && strings.IndexFunc(tok, unicode.IsDigit) < 0
+		if !isWord {
+			if len(seq) > 0 {
+				res = append(res, seq)
+			}
+			seq = nil
+			continue
+		}
+		seq = append(seq, strings.ToLower(tok))
+	}
+	if len(seq) > 0 {
+		res = append(res, seq)
+	}
+	return res
+}
+
+// ngrams counts the phrases of 1 to maxWords words in the texts
+func ngrams(texts []string, maxWords int, opts ExpandOptions) map[string]int {
+	res := map[string]int{}
+	for _, t := range texts {
+		for _, seq := range words(t, opts) {
+			for i := range seq {
+				for n := 1; n <= maxWords && i+n <= len(seq); n++ {
+					res[strings.Join(seq[i:i+n], " ")]++
+				}
+			}
+		}
+	}
+	return res
+}
+
+// Usage counts how often the expansion of each abbreviation in the list
+// occurs in the texts (case insensitive, whole words only). The result
+// is sorted by count, highest first.
+func (am *AbbrevManager) Usage(listName string, texts []string, opts ExpandOptions) ([]Usage, error) {
+	am.Lock()
+	l, ok := am.lists[listName]
+	if !ok {
+		am.Unlock()
+		return nil, fmt.Errorf("list '%s' doesn't exist", listName)
+	}
+	entries := sortedEntries(l)
+	am.Unlock()
+
+	maxWords := 1
+	for _, e := range entries {
+		if n := len(strings.Fields(e.Expansion)); n > maxWords {
+			maxWords = n
+		}
+	}
+	counts := ngrams(texts, maxWords, opts)
+
+	res := []Usage{}
+	for _, e := range entries {
+		phrase := strings.Join(strings.Fields(strings.ToLower(e.Expansion)), " ")
+		res = append(res, Usage{Abbrev: e.Abbrev, Expansion: e.Expansion, Count: counts[phrase]})
+	}
+	sort.SliceStable(res, func(i, j int) bool { return res[i].Count > res[j].Count })
+	return res, nil
+}
+
+// Suggest lists frequent words and phrases in the texts that are not
+// the expansion of any abbreviation in any list, with suggested
+// abbreviations for the list listName (not already used in it). The
+// result is sorted by score, highest first.
+func (am *AbbrevManager) Suggest(listName string, texts []string, opts ExpandOptions, sOpts SuggestOptions) ([]Suggestion, error) {
+	am.Lock()
+	l, ok := am.lists[listName]
+	if !ok {
+		am.Unlock()
+		return nil, fmt.Errorf("list '%s' doesn't exist", listName)
+	}
+	taken := map[string]bool{}
+	for a := range l {
+		taken[a] = true
+	}
+	covered := map[string]bool{}
+	for _, list := range am.lists {
+		for _, e := range list {
+			covered[strings.Join(strings.Fields(strings.ToLower(e)), " ")] = true
+		}
+	}
+	am.Unlock()
+
+	if sOpts.MaxWords < 1 {
+		sOpts.MaxWords = 1
+	}
+
+	res := []Suggestion{}
+	for phrase, n := range ngrams(texts, sOpts.MaxWords, opts) {
+		length := len([]rune(phrase))
+		if n < sOpts.MinCount || length < sOpts.MinLength || covered[phrase] {
+			continue
+		}
+		res = append(res, Suggestion{Phrase: phrase, Count: n, Score: n * length})
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Score != res[j].Score {
+			return res[i].Score > res[j].Score
+		}
+		return res[i].Phrase < res[j].Phrase
+	})
+	if sOpts.Limit > 0 && len(res) > sOpts.Limit {
+		res = res[:sOpts.Limit]
+	}
+
+	for i, s := range res {
+		a := suggestAbbrev(s.Phrase, taken)
+		res[i].Abbrev = a
+		taken[a] = true
+	}
+	return res, nil
+}
+
+// suggestAbbrev returns the initials of a phrase, or the first three
+// letters of a single word, followed by a number if needed to make it
+// unique
+func suggestAbbrev(phrase string, taken map[string]bool) string {
+	var base string
+	ws := strings.Fields(phrase)
+	if len(ws) > 1 {
+		for _, w := range ws {
+			base += string([]rune(w)[0])
+		}
+	} else {
+		rs := []rune(phrase)
+		if len(rs) > 3 {
+			rs = rs[:3]
+		}
+		base = string(rs)
+	}
+	res := base
+	for i := 2; taken[res]; i++ {
+		res = fmt.Sprintf("%s%d", base, i)
+	}
+	return res
+}
@@ -0,0 +1,91 @@
+package abbrevs
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestUsageAndSuggest(t *testing.T) {
+	am := NewAbbrevManager("")
+	am.lists["sv"] = map[string]string{"te": "till exempel", "sthlm": "Stockholm", "ca": "cirka"}
+	am.lists["other"] = map[string]string{"gbg": "Göteborg"}
+
+	opts := ExpandOptions{
+		TokenSplit:  regexp.MustCompile(`[ \n,.!?]`),
+		LabelPrefix: "#",
+	}
+	texts := []string{
+		"#AGENT till exempel i Stockholm",
+		"Till exempel försäkringsbolaget i Göteborg",
+		"försäkringsbolaget ringer tillbaka #NOISE försäkringsbolaget",
+		"jag ringer försäkringsbolaget i morgon, till exempel 10 gånger",
+		"ringer tillbaka i Göteborg",
+		"ringer tillbaka",
+	}
+
+	usage, err := am.Usage("sv", texts, opts)
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	if w, g := 3, len(usage); w != g {
+		t.Fatalf("wanted %d got %d", w, g)
+	}
+	for i, w := range []Usage{
+		{"te", "till exempel", 3},
+		{"sthlm", "Stockholm", 1},
+		{"ca", "cirka", 0},
+	} {
+		if g := usage[i]; w != g {
+			t.Errorf("wanted %#v got %#v", w, g)
+		}
+	}
+
+	sOpts := SuggestOptionsDefault
+	sOpts.MinCount = 3
+	sugg, err := am.Suggest("sv", texts, opts, sOpts)
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	byPhrase := map[string]Suggestion{}
+	for _, s := range sugg {
+		byPhrase[s.Phrase] = s
+	}
+	// covered by a list
+	if _, ok := byPhrase["till exempel"]; ok {
+		t.Errorf("expected no suggestion for 'till exempel'")
+	}
+	// too short
+	if _, ok := byPhrase["ringer"]; ok {
+		t.Errorf("expected no suggestion for 'ringer'")
+	}
+	// phrases don't span labels
+	if w, g := 1, byPhrase["tillbaka försäkringsbolaget"].Count; g >= w {
+		t.Errorf("expected no suggestion for phrase spanning label")
+	}
+	if w, g := 4, byPhrase["försäkringsbolaget"].Count; w != g {
+		t.Errorf("wanted %d got %d", w, g)
+	}
+	if w, g := "för", byPhrase["försäkringsbolaget"].Abbrev; w != g {
+		t.Errorf("wanted '%s' got '%s'", w, g)
+	}
+	if w, g := "rt", byPhrase["ringer tillbaka"].Abbrev; w != g {
+		t.Errorf("wanted '%s' got '%s'", w, g)
+	}
+	if w, g := "försäkringsbolaget", sugg[0].Phrase; w != g {
+		t.Errorf("wanted '%s' got '%s'", w, g)
+	}
+
+	if _, err := am.Usage("nope", texts, opts); err == nil {
+		t.Errorf("expected error for unknown list")
+	}
+}
+
+func TestSuggestAbbrev(t *testing.T) {
+	taken := map[string]bool{"te": true, "te2": true}
+	if w, g := "te3", suggestAbbrev("till exempel", taken); w != g {
+		t.Errorf("wanted '%s' got '%s'", w, g)
+	}
+	if w, g := "för", suggestAbbrev("försäkring", taken); w != g {
+		t.Errorf("wanted '%s' got '%s'", w, g)
+	}
+}
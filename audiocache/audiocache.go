@@ -0,0 +1,271 @@
+// Package audiocache is an on-disk cache of extracted audio segments.
+package audiocache
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/stts-se/transtool-open/log"
+	"github.com/stts-se/transtool-open/protocol"
+)
+
+// Key identifies an extracted segment: the audio file, the chunk with
+// context, and the encoding of the segment
+type Key struct {
+	Request  protocol.SplitRequestPayload
+	Encoding string
+}
+
+// Cache is a content-addressed cache of extracted audio segments. A
+// segment is saved in a file named by the hash of the key, and the
+// modification time and size of the audio file (so that segments of a
+// changed audio file are not used). When the total size of the files
+// exceeds the size limit, the least recently used segments are removed.
+// For initialization, use New().
+type Cache struct {
+	dir     string
+	maxSize int64
+
+	mutex   sync.Mutex
+	size    int64
+	lru     *list.List               // of *entry, most recently used first
+	entries map[string]*list.Element // file name -> entry
+	// extractions in progress, to avoid extracting the same segment twice
+	inflight map[string]*sync.WaitGroup
+
+	hits, misses int64
+}
+
+type entry struct {
+	name string
+	size int64
+}
+
+const fileExt = ".seg"
+
+// New creates a cache in dir, with maxSize bytes as size limit. Segments
+// already in dir are kept, with their modification times as last use.
+func New(dir string, maxSize int64) (*Cache, error) {
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cache dir : %v", err)
+	}
+	c := &Cache{
+		dir:      dir,
+		maxSize:  maxSize,
+		lru:      list.New(),
+		entries:  map[string]*list.Element{},
+		inflight: map[string]*sync.WaitGroup{},
+	}
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cache dir : %v", err)
+	}
+	type cached struct {
+		entry
+		used time.Time
+	}
+	var existing []cached
+	for _, f := range files {
+		if f.IsDir() || !strings.HasSuffix(f.Name(), fileExt) {
+			continue
+		}
+		info, err := f.Info()
+		if err != nil {
+			continue
+		}
+		existing = append(existing, cached{entry{name: f.Name(), size: info.Size()}, info.ModTime()})
+	}
+	sort.Slice(existing, func(i, j int) bool { return existing[i].used.After(existing[j].used) })
+	for _, e := range existing {
+		e0 := e.entry
+		c.entries[e.name] = c.lru.PushBack(&e0)
+		c.size += e.size
+	}
+	c.mutex.Lock()
+	c.evict()
+	c.mutex.Unlock()
+	return c, nil
+}
+
+// fileName returns the cache file name of the key. The audio file has
+// to exist.
+func (c *Cache) fileName(key Key) (string, error) {
+	info, err := os.Stat(key.Request.Audio)
+	if err != nil {
+		return "", fmt.Errorf("no such file: %s", key.Request.Audio)
+	}
+	chunk := key.Request.WithContext()
+	s := fmt.Sprintf("%s\t%d\t%d\t%d\t%d\t%s", key.Request.Audio, info.ModTime().UnixNano(), info.Size(), chunk.Start, chunk.End, key.Encoding)
+	// channel 0 is left out, to keep the names of files cached before
+	// channels were supported
+	if key.Request.Channel != 0 {
+		s += fmt.Sprintf("\tchannel %d", key.Request.Channel)
+	}
+	return fmt.Sprintf("%x%s", sha256.Sum256([]byte(s)), fileExt), nil
+}
+
+// Get returns the segment of the key. If it is not cached, it is
+// extracted using the extract function, and saved in the cache. Failures
+// to save the segment are logged, but not returned.
+func (c *Cache) Get(key Key, extract func() ([]byte, error)) ([]byte, error) {
+	return c.get(key, extract, true)
+}
+
+// Prefetch extracts and saves the segment of the key, if it is not
+// already cached
+func (c *Cache) Prefetch(key Key, extract func() ([]byte, error)) error {
+	_, err := c.get(key, extract, false)
+	return err
+}
+
+func (c *Cache) get(key Key, extract func() ([]byte, error), read bool) ([]byte, error) {
+	name, err := c.fileName(key)
+	if err != nil {
+		return nil, err
+	}
+	path := filepath.Join(c.dir, name)
+
+	for {
+		c.mutex.Lock()
+		if el, ok := c.entries[name]; ok {
+			c.lru.MoveToFront(el)
+			c.hits++
+			c.mutex.Unlock()
+			if !read {
+				return nil, nil
+			}
+			now := time.Now()
+			os.Chtimes(path, now, now)
+			bts, err := os.ReadFile(path)
+			if err == nil {
+				return bts, nil
+			}
+			// the file has been removed: extract again
+			log.Warning("[audiocache] failed to read cached segment : %v", err)
+			c.remove(name)
+			continue
+		}
+		if wg, ok := c.inflight[name]; ok {
+			// wait for the extraction in progress, then try again
+			c.mutex.Unlock()
+			wg.Wait()
+			if _, ok := c.lookup(name); ok {
+				continue
+			}
+			// the extraction in progress failed: extract here
+			c.mutex.Lock()
+			if _, ok := c.inflight[name]; ok {
+				c.mutex.Unlock()
+				continue
+			}
+		}
+		wg := &sync.WaitGroup{}
+		wg.Add(1)
+		c.inflight[name] = wg
+		c.misses++
+		c.mutex.Unlock()
+
+		bts, err := extract()
+		if err == nil {
+			// the audio is returned, even if it cannot be cached
+			if wErr := c.write(name, bts); wErr != nil {
+				log.Warning("[audiocache] %v", wErr)
+			}
+		}
+		c.mutex.Lock()
+		delete(c.inflight, name)
+		c.mutex.Unlock()
+		wg.Done()
+		return bts, err
+	}
+}
+
+func (c *Cache) lookup(name string) (*list.Element, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	el, ok := c.entries[name]
+	return el, ok
+}
+
+func (c *Cache) remove(name string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if el, ok := c.entries[name]; ok {
+		c.size -= el.Value.(*entry).size
+		c.lru.Remove(el)
+		delete(c.entries, name)
+	}
+}
+
+// write saves a segment to a temp file, which is then renamed, so that
+// a cache file is never partly written
+func (c *Cache) write(name string, bts []byte) error {
+	tmp, err := os.CreateTemp(c.dir, name+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create cache file : %v", err)
+	}
+	_, err = tmp.Write(bts)
+	if cErr := tmp.Close(); err == nil {
+		err = cErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), filepath.Join(c.dir, name))
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write cache file : %v", err)
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if el, ok := c.entries[name]; ok {
+		c.size -= el.Value.(*entry).size
+		c.lru.Remove(el)
+	}
+	c.entries[name] = c.lru.PushFront(&entry{name: name, size: int64(len(bts))})
+	c.size += int64(len(bts))
+	c.evict()
+	return nil
+}
+
+// evict removes the least recently used segments, until the size is
+// within the limit. The most recently used segment is always kept.
+// exec in a locked context only
+func (c *Cache) evict() {
+	for c.size > c.maxSize && c.lru.Len() > 1 {
+		el := c.lru.Back()
+		e := el.Value.(*entry)
+		err := os.Remove(filepath.Join(c.dir, e.name))
+		if err != nil && !os.IsNotExist(err) {
+			log.Warning("[audiocache] failed to remove cached segment : %v", err)
+		}
+		c.size -= e.size
+		c.lru.Remove(el)
+		delete(c.entries, e.name)
+	}
+}
+
+// Stats holds the number of cached segments, their total size, and the
+// number of cache hits and misses since the cache was created
+type Stats struct {
+	Segments int   `json:"segments"`
+	Size     int64 `json:"size"`
+	MaxSize  int64 `json:"max_size"`
+	Hits     int64 `json:"hits"`
+	Misses   int64 `json:"misses"`
+}
+
+func (c *Cache) Stats() Stats {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return Stats{Segments: c.lru.Len(), Size: c.size, MaxSize: c.maxSize, Hits: c.hits, Misses: c.misses}
+}
@@ -0,0 +1,168 @@
+package audiocache
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stts-se/transtool-open/protocol"
+)
+
+func key(audio string, start, end int64) Key {
+	return Key{
+		Request: protocol.SplitRequestPayload{
+			Audio: audio,
+			Chunk: protocol.Chunk{Start: start, End: end},
+		},
+	}
+}
+
+func TestCache(t *testing.T) {
+	dir := t.TempDir()
+	audio := filepath.Join(dir, "a.wav")
+	err := os.WriteFile(audio, []byte("audio"), 0600)
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+
+	c, err := New(filepath.Join(dir, "cache"), 25)
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+
+	n := 0
+	extract := func(s string) func() ([]byte, error) {
+		return func() ([]byte, error) {
+			n++
+			return []byte(s), nil
+		}
+	}
+
+	bts, err := c.Get(key(audio, 0, 1000), extract("0123456789"))
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	if w, g := "0123456789", string(bts); w != g {
+		t.Errorf("wanted '%s' got '%s'", w, g)
+	}
+	bts, err = c.Get(key(audio, 0, 1000), extract("not extracted"))
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	if w, g := "0123456789", string(bts); w != g {
+		t.Errorf("wanted '%s' got '%s'", w, g)
+	}
+	if w, g := 1, n; w != g {
+		t.Errorf("wanted %d got %d", w, g)
+	}
+
+	// different chunk
+	err = c.Prefetch(key(audio, 1000, 2000), extract("abcdefghij"))
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	if w, g := 2, n; w != g {
+		t.Errorf("wanted %d got %d", w, g)
+	}
+
+	// the first chunk is the most recently used, the second one is evicted
+	c.Get(key(audio, 0, 1000), extract("not extracted"))
+	c.Get(key(audio, 2000, 3000), extract("ABCDEFGHIJ"))
+	st := c.Stats()
+	if w, g := 2, st.Segments; w != g {
+		t.Errorf("wanted %d got %d", w, g)
+	}
+	if w, g := int64(20), st.Size; w != g {
+		t.Errorf("wanted %d got %d", w, g)
+	}
+	c.Get(key(audio, 1000, 2000), extract("abcdefghij"))
+	if w, g := 4, n; w != g {
+		t.Errorf("wanted %d got %d", w, g)
+	}
+
+	// extraction errors are not cached
+	_, err = c.Get(key(audio, 5000, 6000), func() ([]byte, error) { return nil, fmt.Errorf("failed") })
+	if err == nil {
+		t.Errorf("expected error, got nil")
+	}
+	if w, g := 2, c.Stats().Segments; w != g {
+		t.Errorf("wanted %d got %d", w, g)
+	}
+
+	// a changed audio file is extracted again
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(audio, later, later)
+	c.Get(key(audio, 0, 1000), extract("9876543210"))
+	if w, g := 5, n; w != g {
+		t.Errorf("wanted %d got %d", w, g)
+	}
+
+	// missing audio file
+	_, err = c.Get(key(filepath.Join(dir, "b.wav"), 0, 1000), extract("0123456789"))
+	if err == nil {
+		t.Errorf("expected error, got nil")
+	}
+}
+
+func TestCacheReload(t *testing.T) {
+	dir := t.TempDir()
+	audio := filepath.Join(dir, "a.wav")
+	err := os.WriteFile(audio, []byte("audio"), 0600)
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	cacheDir := filepath.Join(dir, "cache")
+	c, err := New(cacheDir, 100)
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	seg := bytes.Repeat([]byte("x"), 10)
+	for i := int64(0); i < 3; i++ {
+		c.Prefetch(key(audio, i*1000, (i+1)*1000), func() ([]byte, error) { return seg, nil })
+	}
+
+	// a smaller cache keeps the most recently used segment only
+	c, err = New(cacheDir, 15)
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	if w, g := 1, c.Stats().Segments; w != g {
+		t.Errorf("wanted %d got %d", w, g)
+	}
+	files, _ := filepath.Glob(filepath.Join(cacheDir, "*"+fileExt))
+	if w, g := 1, len(files); w != g {
+		t.Errorf("wanted %d got %d", w, g)
+	}
+}
+
+func TestCacheWriteError(t *testing.T) {
+	dir := t.TempDir()
+	audio := filepath.Join(dir, "a.wav")
+	err := os.WriteFile(audio, []byte("audio"), 0600)
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	cacheDir := filepath.Join(dir, "cache")
+	c, err := New(cacheDir, 100)
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	// the cache files cannot be written
+	if err := os.RemoveAll(cacheDir); err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+
+	bts, err := c.Get(key(audio, 0, 1000), func() ([]byte, error) { return []byte("0123456789"), nil })
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	if w, g := "0123456789", string(bts); w != g {
+		t.Errorf("wanted '%s' got '%s'", w, g)
+	}
+	if w, g := 0, c.Stats().Segments; w != g {
+		t.Errorf("wanted %d got %d", w, g)
+	}
+}
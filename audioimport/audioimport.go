@@ -0,0 +1,233 @@
+// Package audioimport converts the source audio of sub-projects to a
+// working format, such as 16 kHz mono FLAC for ASR. The working copies
+// are kept next to the original audio, and listed in a mapping file in
+// the sub-project dir. Pages still reference the original audio.
+package audioimport
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/stts-se/transtool-open/dbapi"
+	"github.com/stts-se/transtool-open/log"
+	"github.com/stts-se/transtool-open/modules/ffmpeg"
+	"github.com/stts-se/transtool-open/modules/ffprobe"
+)
+
+// MappingFileName is the name of the mapping file in the sub-project dir
+const MappingFileName = "audio_import.json"
+
+// Entry is the working copy of an original audio file
+type Entry struct {
+	// Working is the working copy, relative to the source dir (as the
+	// audio of pages). It is the original audio, if that already has
+	// the working format.
+	Working string                 `json:"working"`
+	Format  ffmpeg.TranscodeFormat `json:"format"`
+
+	// Codec, SampleRate and Channels are those of the original audio
+	Codec      string `json:"codec"`
+	SampleRate int64  `json:"sample_rate"`
+	Channels   int64  `json:"channels"`
+}
+
+// Mapping maps original audio files (relative to the source dir, as
+// the audio of pages) to their working copies
+type Mapping map[string]Entry
+
+// Load reads the mapping file of a sub-project. A missing mapping file
+// gives an empty mapping.
+func Load(projectDir string) (Mapping, error) {
+	res := Mapping{}
+	f := path.Join(projectDir, MappingFileName)
+	bts, err := os.ReadFile(f)
+	if os.IsNotExist(err) {
+		return res, nil
+	}
+	if err != nil {
+		return res, fmt.Errorf("failed to read %s : %v", f, err)
+	}
+	err = json.Unmarshal(bts, &res)
+	if err != nil {
+		return res, fmt.Errorf("failed to unmarshal %s : %v", f, err)
+	}
+	return res, nil
+}
+
+// Save writes the mapping file of a sub-project
+func (m Mapping) Save(projectDir string) error {
+	bts, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal mapping : %v", err)
+	}
+	f := path.Join(projectDir, MappingFileName)
+	tmpFile := f + ".tmp"
+	err = os.WriteFile(tmpFile, bts, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to write %s : %v", tmpFile, err)
+	}
+	return os.Rename(tmpFile, f)
+}
+
+// Working returns the working copy of an original audio file, or the
+// original audio file if it has no working copy
+func (m Mapping) Working(audio string) string {
+	if e, ok := m[audio]; ok && e.Working != "" {
+		return e.Working
+	}
+	return audio
+}
+
+// WorkingAudioPath returns the path of the working copy of the audio
+// of a page in the sub-project, or the path of the original audio if
+// it has no working copy
+func WorkingAudioPath(db *dbapi.DBAPI, audio string) (string, error) {
+	m, err := Load(db.ProjectDir)
+	if err != nil {
+		return "", err
+	}
+	return db.BuildAudioPath(m.Working(audio))
+}
+
+// workingName returns the name of the working copy of an audio file.
+// The extension of the original is kept in the name, so that originals
+// with the same base name get different working copies.
+func workingName(audio string, format ffmpeg.TranscodeFormat) string {
+	return audio + ".working." + format.Encoding
+}
+
+// hasFormat returns true if audio with the info already has the format
+// (and the file extension of the encoding, since ASR encodings are
+// derived from the file extension)
+func hasFormat(audio string, info ffprobe.AudioInfo, format ffmpeg.TranscodeFormat) bool {
+	return format.Loudness == 0 &&
+		info.Codec == format.Codec() &&
+		strings.EqualFold(filepath.Ext(audio), "."+format.Encoding) &&
+		(format.SampleRate == 0 || info.SampleRate == int64(format.SampleRate)) &&
+		(format.Channels == 0 || info.ChannelCount == int64(format.Channels))
+}
+
+// upToDate returns true if the working copy exists, and is not older
+// than the original
+func upToDate(originalPath, workingPath string) bool {
+	o, err := os.Stat(originalPath)
+	if err != nil {
+		return false
+	}
+	w, err := os.Stat(workingPath)
+	if err != nil {
+		return false
+	}
+	return !w.ModTime().Before(o.ModTime())
+}
+
+// Importer converts the source audio of sub-projects to a working
+// format. For initialization, use NewImporter().
+type Importer struct {
+	Format        ffmpeg.TranscodeFormat
+	infoExtractor ffprobe.InfoExtractor
+}
+
+// NewImporter creates a new Importer after first checking the format,
+// and that the ffmpeg and ffprobe commands exist
+func NewImporter(format ffmpeg.TranscodeFormat) (Importer, error) {
+	if err := format.Validate(); err != nil {
+		return Importer{}, err
+	}
+	if err := ffmpeg.Enabled(); err != nil {
+		return Importer{}, err
+	}
+	infoExtractor, err := ffprobe.NewInfoExtractor()
+	if err != nil {
+		return Importer{}, err
+	}
+	return Importer{Format: format, infoExtractor: infoExtractor}, nil
+}
+
+// Result is the result of importing an audio file
+type Result struct {
+	Audio   string `json:"audio"`
+	Working string `json:"working"`
+	// Status is transcoded, unchanged (the original already has the
+	// working format) or skipped (already imported)
+	Status string `json:"status"`
+}
+
+// Import converts the audio of the source pages of a sub-project to the
+// working format, and saves the mapping file. Audio already imported
+// with the same format is skipped, unless force is true, or the
+// original audio has changed.
+func (im Importer) Import(db *dbapi.DBAPI, force bool) ([]Result, error) {
+	res := []Result{}
+	pages, _, err := db.LoadSourceData()
+	if err != nil {
+		return res, fmt.Errorf("failed to load source data : %v", err)
+	}
+	var audioFiles []string
+	seen := map[string]bool{}
+	for _, p := range pages {
+		if !seen[p.Audio] {
+			audioFiles = append(audioFiles, p.Audio)
+			seen[p.Audio] = true
+		}
+	}
+	sort.Strings(audioFiles)
+
+	m, err := Load(db.ProjectDir)
+	if err != nil {
+		return res, err
+	}
+	for _, audio := range audioFiles {
+		originalPath, err := db.BuildAudioPath(audio)
+		if err != nil {
+			return res, err
+		}
+		if e, ok := m[audio]; ok && !force && e.Format == im.Format {
+			workingPath, err := db.BuildAudioPath(e.Working)
+			if err != nil {
+				return res, err
+			}
+			if e.Working == audio || upToDate(originalPath, workingPath) {
+				res = append(res, Result{Audio: audio, Working: e.Working, Status: "skipped"})
+				continue
+			}
+		}
+
+		info, err := im.infoExtractor.Process(originalPath)
+		if err != nil {
+			return res, fmt.Errorf("failed to read audio info for %s : %v", audio, err)
+		}
+		e := Entry{Format: im.Format, Codec: info.Codec, SampleRate: info.SampleRate, Channels: info.ChannelCount}
+		r := Result{Audio: audio}
+		if hasFormat(audio, info, im.Format) {
+			e.Working = audio
+			r.Status = "unchanged"
+		} else {
+			e.Working = workingName(audio, im.Format)
+			workingPath, err := db.BuildAudioPath(e.Working)
+			if err != nil {
+				return res, err
+			}
+			err = ffmpeg.Transcode(originalPath, workingPath, im.Format)
+			if err != nil {
+				return res, fmt.Errorf("failed to transcode %s : %v", audio, err)
+			}
+			r.Status = "transcoded"
+			log.Info("[audioimport] Transcoded %s to %s", audio, e.Working)
+		}
+		r.Working = e.Working
+		m[audio] = e
+		// save after each file, so that a failed import can be resumed
+		err = m.Save(db.ProjectDir)
+		if err != nil {
+			return res, err
+		}
+		res = append(res, r)
+	}
+	return res, nil
+}
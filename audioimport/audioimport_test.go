@@ -0,0 +1,100 @@
+package audioimport
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stts-se/transtool-open/dbapi"
+	"github.com/stts-se/transtool-open/modules/ffmpeg"
+	"github.com/stts-se/transtool-open/modules/ffprobe"
+)
+
+func TestMapping(t *testing.T) {
+	dir := t.TempDir()
+	m, err := Load(dir)
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	if w, g := 0, len(m); w != g {
+		t.Errorf("wanted %d got %d", w, g)
+	}
+
+	m["a.mp3"] = Entry{Working: "a.mp3.working.flac", Format: ffmpeg.DefaultTranscodeFormat, Codec: "mp3", SampleRate: 48000, Channels: 2}
+	err = m.Save(dir)
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	m, err = Load(dir)
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	if w, g := "a.mp3.working.flac", m.Working("a.mp3"); w != g {
+		t.Errorf("wanted %s got %s", w, g)
+	}
+	if w, g := "b.wav", m.Working("b.wav"); w != g {
+		t.Errorf("wanted %s got %s", w, g)
+	}
+	if w, g := 16000, m["a.mp3"].Format.SampleRate; w != g {
+		t.Errorf("wanted %d got %d", w, g)
+	}
+
+	db := dbapi.NewDBAPI(dir, nil)
+	p, err := WorkingAudioPath(db, "a.mp3")
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	if w, g := filepath.Join(dir, "source", "a.mp3.working.flac"), p; w != g {
+		t.Errorf("wanted %s got %s", w, g)
+	}
+}
+
+func TestHasFormat(t *testing.T) {
+	info := ffprobe.AudioInfo{Codec: "flac", SampleRate: 16000, ChannelCount: 1}
+	if !hasFormat("a.flac", info, ffmpeg.DefaultTranscodeFormat) {
+		t.Errorf("expected true for %v", info)
+	}
+	if hasFormat("a.ogg", info, ffmpeg.DefaultTranscodeFormat) {
+		t.Errorf("expected false for other extension")
+	}
+	format := ffmpeg.DefaultTranscodeFormat
+	format.Loudness = -23
+	if hasFormat("a.flac", info, format) {
+		t.Errorf("expected false with loudness normalisation")
+	}
+	info.ChannelCount = 2
+	if hasFormat("a.flac", info, ffmpeg.DefaultTranscodeFormat) {
+		t.Errorf("expected false for %v", info)
+	}
+	if w, g := "x/a.mp3.working.flac", workingName("x/a.mp3", ffmpeg.DefaultTranscodeFormat); w != g {
+		t.Errorf("wanted %s got %s", w, g)
+	}
+}
+
+func TestImportSkipped(t *testing.T) {
+	dir := t.TempDir()
+	source := filepath.Join(dir, "source")
+	if err := os.MkdirAll(source, 0755); err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	pages := `[{"id": "p1", "audio": "a.mp3", "start": 0, "end": 1000}]`
+	for name, content := range map[string]string{"pages.json": pages, "a.mp3": "audio", "a.mp3.working.flac": "audio"} {
+		if err := os.WriteFile(filepath.Join(source, name), []byte(content), 0644); err != nil {
+			t.Fatalf("expected nil, got %v", err)
+		}
+	}
+	m := Mapping{"a.mp3": Entry{Working: "a.mp3.working.flac", Format: ffmpeg.DefaultTranscodeFormat}}
+	if err := m.Save(dir); err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+
+	// already imported: no need for ffmpeg
+	im := Importer{Format: ffmpeg.DefaultTranscodeFormat}
+	res, err := im.Import(dbapi.NewDBAPI(dir, nil), false)
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	if len(res) != 1 || res[0].Status != "skipped" {
+		t.Errorf("expected a.mp3 to be skipped, got %v", res)
+	}
+}
@@ -0,0 +1,274 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Authentication modes
+const (
+	// ModeNone trusts the user name supplied by the client (the
+	// pre-authentication behaviour)
+	ModeNone = "none"
+
+	// ModeLocal authenticates against a local user file, and keeps
+	// track of logged in users with signed session tokens
+	ModeLocal = "local"
+
+	// ModeProxy trusts a user name header set by a reverse proxy
+	// that has already authenticated the user
+	ModeProxy = "proxy"
+)
+
+// CookieName is the name of the session cookie
+const CookieName = "transtool_session"
+
+// DefaultSessionTTL is the lifetime of a session token
+const DefaultSessionTTL = 12 * time.Hour
+
+// Sessions issues and verifies HMAC signed session tokens. A token
+// has the form <base64 user name>.<unix expiry time>.<base64 signature>.
+type Sessions struct {
+	secret []byte
+	TTL    time.Duration
+}
+
+// NewSessions creates a Sessions with the given secret. The secret must be at least 16 bytes.
+func NewSessions(secret []byte, ttl time.Duration) (Sessions, error) {
+	if len(secret) < 16 {
+		return Sessions{}, fmt.Errorf("session secret too short: %d bytes (min 16)", len(secret))
+	}
+	if ttl <= 0 {
+		ttl = DefaultSessionTTL
+	}
+	return Sessions{secret: secret, TTL: ttl}, nil
+}
+
+// RandomSecret returns a new random session secret
+func RandomSecret() ([]byte, error) {
+	res := make([]byte, 32)
+	_, err := rand.Read(res)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate random secret : %v", err)
+	}
+	return res, nil
+}
+
+// ReadSecretFile reads a session secret from file. If the file does
+// not exist, a random secret is created and written to it, so that
+// sessions survive server restarts.
+func ReadSecretFile(file string) ([]byte, error) {
+	bts, err := os.ReadFile(file)
+	if err == nil {
+		secret := []byte(strings.TrimSpace(string(bts)))
+		if len(secret) < 16 {
+			return nil, fmt.Errorf("session secret in %s too short: %d bytes (min 16)", file, len(secret))
+		}
+		return secret, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read session secret file : %v", err)
+	}
+	secret, err := RandomSecret()
+	if err != nil {
+		return nil, err
+	}
+	encoded := []byte(base64.RawURLEncoding.EncodeToString(secret))
+	err = os.WriteFile(file, append(encoded, '\n'), 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to write session secret file : %v", err)
+	}
+	return encoded, nil
+}
+
+func (s Sessions) sign(payload string) string {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Issue creates a session token for the user
+func (s Sessions) Issue(userName string) (string, time.Time) {
+	expires := time.Now().Add(s.TTL)
+	payload := base64.RawURLEncoding.EncodeToString([]byte(userName)) + "." + strconv.FormatInt(expires.Unix(), 10)
+	return payload + "." + s.sign(payload), expires
+}
+
+// Verify checks the signature and expiry time of a session token, and returns the user name
+func (s Sessions) Verify(token string) (string, error) {
+	fs := strings.Split(token, ".")
+	if len(fs) != 3 {
+		return "", fmt.Errorf("malformed session token")
+	}
+	payload := fs[0] + "." + fs[1]
+	if !hmac.Equal([]byte(s.sign(payload)), []byte(fs[2])) {
+		return "", fmt.Errorf("invalid session token signature")
+	}
+	expires, err := strconv.ParseInt(fs[1], 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("malformed session token expiry time")
+	}
+	if time.Now().Unix() > expires {
+		return "", fmt.Errorf("session token has expired")
+	}
+	user, err := base64.RawURLEncoding.DecodeString(fs[0])
+	if err != nil {
+		return "", fmt.Errorf("malformed session token user name")
+	}
+	return string(user), nil
+}
+
+// Identity is an authenticated user
+type Identity struct {
+	UserName string `json:"user_name"`
+	Admin    bool   `json:"admin"`
+}
+
+// Authenticator identifies the user behind an HTTP request.
+// For initialization, use NewAuthenticator().
+type Authenticator struct {
+	Mode     string
+	Users    *UserDB
+	Sessions Sessions
+
+	// ProxyHeader is the request header holding the user name in ModeProxy
+	ProxyHeader string
+	// TrustedProxies are the IP addresses allowed to set ProxyHeader
+	TrustedProxies map[string]bool
+}
+
+// NewAuthenticator creates an Authenticator for the given mode.
+// users may be nil in ModeProxy (then no user is admin).
+func NewAuthenticator(mode string, users *UserDB, sessions Sessions, proxyHeader string, trustedProxies []string) (Authenticator, error) {
+	res := Authenticator{
+		Mode:           mode,
+		Users:          users,
+		Sessions:       sessions,
+		ProxyHeader:    proxyHeader,
+		TrustedProxies: map[string]bool{},
+	}
+	switch mode {
+	case ModeNone:
+	case ModeLocal:
+		if users == nil {
+			return res, fmt.Errorf("auth mode '%s' requires a user file", mode)
+		}
+		if sessions.secret == nil {
+			return res, fmt.Errorf("auth mode '%s' requires a session secret", mode)
+		}
+	case ModeProxy:
+		if strings.TrimSpace(proxyHeader) == "" {
+			return res, fmt.Errorf("auth mode '%s' requires a proxy header name", mode)
+		}
+		if len(trustedProxies) == 0 {
+			return res, fmt.Errorf("auth mode '%s' requires at least one trusted proxy address", mode)
+		}
+		for _, p := range trustedProxies {
+			p = strings.TrimSpace(p)
+			if p != "" {
+				res.TrustedProxies[p] = true
+			}
+		}
+	default:
+		return res, fmt.Errorf("unknown auth mode '%s' (expected %s, %s or %s)", mode, ModeNone, ModeLocal, ModeProxy)
+	}
+	return res, nil
+}
+
+// Enabled returns false if the authenticator is in ModeNone
+func (a Authenticator) Enabled() bool {
+	return a.Mode != ModeNone && a.Mode != ""
+}
+
+func (a Authenticator) isAdmin(userName string) bool {
+	if a.Users == nil {
+		return false
+	}
+	u, ok := a.Users.Get(userName)
+	return ok && u.Admin
+}
+
+// Identify returns the identity of the user behind the request
+func (a Authenticator) Identify(r *http.Request) (Identity, error) {
+	switch a.Mode {
+	case ModeLocal:
+		token := ""
+		if h := r.Header.Get("Authorization"); strings.HasPrefix(h, "Bearer ") {
+			token = strings.TrimSpace(strings.TrimPrefix(h, "Bearer "))
+		} else if c, err := r.Cookie(CookieName); err == nil {
+			token = c.Value
+		}
+		if token == "" {
+			return Identity{}, fmt.Errorf("not logged in")
+		}
+		user, err := a.Sessions.Verify(token)
+		if err != nil {
+			return Identity{}, err
+		}
+		// users removed from the user file are logged out
+		if _, ok := a.Users.Get(user); !ok {
+			return Identity{}, fmt.Errorf("unknown user '%s'", user)
+		}
+		return Identity{UserName: user, Admin: a.isAdmin(user)}, nil
+
+	case ModeProxy:
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			host = r.RemoteAddr
+		}
+		if !a.TrustedProxies[host] {
+			return Identity{}, fmt.Errorf("request from untrusted address '%s'", host)
+		}
+		user := NormaliseUserName(r.Header.Get(a.ProxyHeader))
+		if user == "" {
+			return Identity{}, fmt.Errorf("no user name in header '%s'", a.ProxyHeader)
+		}
+		return Identity{UserName: user, Admin: a.isAdmin(user)}, nil
+	}
+
+	return Identity{}, fmt.Errorf("authentication is not enabled")
+}
+
+// Login checks the password of a user, and sets a session cookie (ModeLocal only).
+// The returned token can be used as a Bearer token by non-browser clients.
+func (a Authenticator) Login(w http.ResponseWriter, r *http.Request, userName, password string) (Identity, string, error) {
+	if a.Mode != ModeLocal {
+		return Identity{}, "", fmt.Errorf("login is not available in auth mode '%s'", a.Mode)
+	}
+	u, err := a.Users.Check(userName, password)
+	if err != nil {
+		return Identity{}, "", err
+	}
+	token, expires := a.Sessions.Issue(u.UserName)
+	http.SetCookie(w, &http.Cookie{
+		Name:     CookieName,
+		Value:    token,
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteStrictMode,
+	})
+	return Identity{UserName: u.UserName, Admin: u.Admin}, token, nil
+}
+
+// Logout removes the session cookie
+func (a Authenticator) Logout(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     CookieName,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	})
+}
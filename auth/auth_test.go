@@ -0,0 +1,198 @@
+package auth
+
+import (
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUserDB(t *testing.T) {
+	dir, err := os.MkdirTemp("", "transtool_auth_test")
+	if err != nil {
+		t.Fatalf("failed to create temp dir : %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	f := filepath.Join(dir, "users.json")
+	db, err := LoadUserDB(f)
+	if err != nil {
+		t.Errorf("expected nil, got %v", err)
+	}
+	if w, g := 0, len(db.UserNames()); w != g {
+		t.Errorf("wanted %d got %d", w, g)
+	}
+
+	if err := db.Set(" Kalle ", "hemligt", true); err != nil {
+		t.Errorf("expected nil, got %v", err)
+	}
+	if err := db.Set("a b", "hemligt", false); err == nil {
+		t.Errorf("expected error for invalid user name, got nil")
+	}
+
+	bts, err := os.ReadFile(f)
+	if err != nil {
+		t.Errorf("expected nil, got %v", err)
+	}
+	if strings.Contains(string(bts), "hemligt") {
+		t.Errorf("clear text password in user file: %s", bts)
+	}
+
+	// re-read from file
+	db, err = LoadUserDB(f)
+	if err != nil {
+		t.Errorf("expected nil, got %v", err)
+	}
+	if w, g := "kalle", strings.Join(db.UserNames(), " "); w != g {
+		t.Errorf("wanted '%s' got '%s'", w, g)
+	}
+
+	u, err := db.Check("KALLE", "hemligt")
+	if err != nil {
+		t.Errorf("expected nil, got %v", err)
+	}
+	if !u.Admin {
+		t.Errorf("expected admin user")
+	}
+	if _, err := db.Check("kalle", "fel"); err == nil {
+		t.Errorf("expected error for wrong password, got nil")
+	}
+	if _, err := db.Check("nisse", "hemligt"); err == nil {
+		t.Errorf("expected error for unknown user, got nil")
+	}
+
+	if err := db.Delete("kalle"); err != nil {
+		t.Errorf("expected nil, got %v", err)
+	}
+	if _, ok := db.Get("kalle"); ok {
+		t.Errorf("expected deleted user to be gone")
+	}
+}
+
+func TestSessions(t *testing.T) {
+	if _, err := NewSessions([]byte("short"), time.Hour); err == nil {
+		t.Errorf("expected error for short secret, got nil")
+	}
+
+	s, err := NewSessions([]byte("0123456789abcdef0123456789abcdef"), time.Hour)
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	token, _ := s.Issue("kalle")
+	user, err := s.Verify(token)
+	if err != nil {
+		t.Errorf("expected nil, got %v", err)
+	}
+	if w, g := "kalle", user; w != g {
+		t.Errorf("wanted '%s' got '%s'", w, g)
+	}
+
+	// tampered user name
+	fs := strings.Split(token, ".")
+	forged := "bmlzc2U" + "." + fs[1] + "." + fs[2]
+	if _, err := s.Verify(forged); err == nil {
+		t.Errorf("expected error for forged token, got nil")
+	}
+
+	// other secret
+	s2, _ := NewSessions([]byte("fedcba9876543210fedcba9876543210"), time.Hour)
+	if _, err := s2.Verify(token); err == nil {
+		t.Errorf("expected error for token signed with other secret, got nil")
+	}
+
+	// expired
+	s.TTL = -time.Minute
+	expired, _ := s.Issue("kalle")
+	if _, err := s.Verify(expired); err == nil {
+		t.Errorf("expected error for expired token, got nil")
+	}
+}
+
+func TestAuthenticatorLocal(t *testing.T) {
+	dir, err := os.MkdirTemp("", "transtool_auth_test")
+	if err != nil {
+		t.Fatalf("failed to create temp dir : %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	db := NewUserDB(filepath.Join(dir, "users.json"))
+	if err := db.Set("kalle", "hemligt", false); err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	s, _ := NewSessions([]byte("0123456789abcdef0123456789abcdef"), time.Hour)
+	a, err := NewAuthenticator(ModeLocal, db, s, "", nil)
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+
+	r := httptest.NewRequest("GET", "/ws/abc/nisse", nil)
+	if _, err := a.Identify(r); err == nil {
+		t.Errorf("expected error for request without session, got nil")
+	}
+
+	w := httptest.NewRecorder()
+	if _, _, err := a.Login(w, r, "kalle", "fel"); err == nil {
+		t.Errorf("expected error for wrong password, got nil")
+	}
+	_, token, err := a.Login(w, r, "kalle", "hemligt")
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+
+	// cookie
+	r = httptest.NewRequest("GET", "/ws/abc/nisse", nil)
+	for _, c := range w.Result().Cookies() {
+		r.AddCookie(c)
+	}
+	id, err := a.Identify(r)
+	if err != nil {
+		t.Errorf("expected nil, got %v", err)
+	}
+	if w, g := "kalle", id.UserName; w != g {
+		t.Errorf("wanted '%s' got '%s'", w, g)
+	}
+
+	// bearer token
+	r = httptest.NewRequest("GET", "/abbrev/list_lists", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	id, err = a.Identify(r)
+	if err != nil {
+		t.Errorf("expected nil, got %v", err)
+	}
+	if w, g := "kalle", id.UserName; w != g {
+		t.Errorf("wanted '%s' got '%s'", w, g)
+	}
+}
+
+func TestAuthenticatorProxy(t *testing.T) {
+	a, err := NewAuthenticator(ModeProxy, nil, Sessions{}, "X-Remote-User", []string{"127.0.0.1"})
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = "127.0.0.1:34567"
+	r.Header.Set("X-Remote-User", "Kalle")
+	id, err := a.Identify(r)
+	if err != nil {
+		t.Errorf("expected nil, got %v", err)
+	}
+	if w, g := "kalle", id.UserName; w != g {
+		t.Errorf("wanted '%s' got '%s'", w, g)
+	}
+
+	r.RemoteAddr = "10.0.0.1:34567"
+	if _, err := a.Identify(r); err == nil {
+		t.Errorf("expected error for untrusted proxy, got nil")
+	}
+
+	if _, _, err := a.Login(httptest.NewRecorder(), r, "kalle", "hemligt"); err == nil {
+		t.Errorf("expected error for login in proxy mode, got nil")
+	}
+
+	if _, err := NewAuthenticator("basic", nil, Sessions{}, "", nil); err == nil {
+		t.Errorf("expected error for unknown mode, got nil")
+	}
+}
@@ -0,0 +1,203 @@
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// User is an entry in the local user file. Passwords are never
+// stored in clear text, only as bcrypt hashes.
+type User struct {
+	UserName     string `json:"user_name"`
+	PasswordHash string `json:"password_hash"`
+	Admin        bool   `json:"admin,omitempty"`
+}
+
+// UserDB is a set of users read from (and written to) a JSON file
+type UserDB struct {
+	mutex *sync.RWMutex
+	file  string
+	users map[string]User
+}
+
+var dummyHash []byte
+var dummyHashOnce sync.Once
+
+// HashPassword returns a bcrypt hash for the password
+func HashPassword(password string) (string, error) {
+	if password == "" {
+		return "", fmt.Errorf("empty password")
+	}
+	bts, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", fmt.Errorf("failed to hash password : %v", err)
+	}
+	return string(bts), nil
+}
+
+// NormaliseUserName trims and lower-cases the user name (the client
+// has always lower-cased user names)
+func NormaliseUserName(userName string) string {
+	return strings.ToLower(strings.TrimSpace(userName))
+}
+
+// NewUserDB creates an empty UserDB that will be saved to file
+func NewUserDB(file string) *UserDB {
+	return &UserDB{
+		mutex: &sync.RWMutex{},
+		file:  file,
+		users: map[string]User{},
+	}
+}
+
+// LoadUserDB reads a user file. A non-existing file gives an empty UserDB.
+func LoadUserDB(file string) (*UserDB, error) {
+	res := NewUserDB(file)
+
+	bts, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		return res, nil
+	}
+	if err != nil {
+		return res, fmt.Errorf("failed to read user file %s : %v", file, err)
+	}
+
+	var users []User
+	err = json.Unmarshal(bts, &users)
+	if err != nil {
+		return res, fmt.Errorf("failed to unmarshal user file %s : %v", file, err)
+	}
+
+	for _, u := range users {
+		u.UserName = NormaliseUserName(u.UserName)
+		if u.UserName == "" {
+			return res, fmt.Errorf("empty user name in user file %s", file)
+		}
+		if u.PasswordHash == "" {
+			return res, fmt.Errorf("empty password hash for user '%s' in user file %s", u.UserName, file)
+		}
+		if _, ok := res.users[u.UserName]; ok {
+			return res, fmt.Errorf("duplicate user '%s' in user file %s", u.UserName, file)
+		}
+		res.users[u.UserName] = u
+	}
+
+	return res, nil
+}
+
+// Check verifies the password of a user
+func (db *UserDB) Check(userName, password string) (User, error) {
+	userName = NormaliseUserName(userName)
+
+	db.mutex.RLock()
+	u, ok := db.users[userName]
+	db.mutex.RUnlock()
+
+	if !ok {
+		// compare anyway, so that unknown and known users take about the same time
+		dummyHashOnce.Do(func() {
+			dummyHash, _ = bcrypt.GenerateFromPassword([]byte("dummy"), bcrypt.DefaultCost)
+		})
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return User{}, fmt.Errorf("invalid user name or password")
+	}
+	err := bcrypt.CompareHashAndPassword([]byte(u.PasswordHash), []byte(password))
+	if err != nil {
+		return User{}, fmt.Errorf("invalid user name or password")
+	}
+	return u, nil
+}
+
+// Get returns the user with the given name, if it exists
+func (db *UserDB) Get(userName string) (User, bool) {
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+	u, ok := db.users[NormaliseUserName(userName)]
+	return u, ok
+}
+
+// Set adds or updates a user, and saves the user file
+func (db *UserDB) Set(userName, password string, admin bool) error {
+	userName = NormaliseUserName(userName)
+	if userName == "" {
+		return fmt.Errorf("empty user name")
+	}
+	if strings.ContainsAny(userName, "/?#\t\n ") {
+		return fmt.Errorf("invalid user name '%s'", userName)
+	}
+	hash, err := HashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+	db.users[userName] = User{UserName: userName, PasswordHash: hash, Admin: admin}
+	return db.save()
+}
+
+// Delete removes a user, and saves the user file
+func (db *UserDB) Delete(userName string) error {
+	userName = NormaliseUserName(userName)
+
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+	if _, ok := db.users[userName]; !ok {
+		return fmt.Errorf("no such user '%s'", userName)
+	}
+	delete(db.users, userName)
+	return db.save()
+}
+
+// UserNames returns a sorted list of user names
+func (db *UserDB) UserNames() []string {
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+	res := []string{}
+	for u := range db.users {
+		res = append(res, u)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// save writes the user file (to a temp file first, then renamed, so
+// that a failed write doesn't leave a truncated user file). Exec
+// with write lock only.
+func (db *UserDB) save() error {
+	var users []User
+	for _, u := range db.users {
+		users = append(users, u)
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].UserName < users[j].UserName })
+
+	bts, err := json.MarshalIndent(users, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal users : %v", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(db.file), filepath.Base(db.file)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("failed to create temp file : %v", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(append(bts, '\n')); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write user file : %v", err)
+	}
+	if err := tmp.Chmod(0600); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to set permissions on user file : %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close user file : %v", err)
+	}
+	return os.Rename(tmp.Name(), db.file)
+}
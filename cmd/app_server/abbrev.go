@@ -3,16 +3,20 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
 	//"io/ioutil"
 	"net/http"
+	"path/filepath"
+	"regexp"
 
 	//"os/exec"
 	//"os/user"
-	//"path/filepath"
 	"sort"
-	//"strings"
+	"strconv"
+	"strings"
 	"sync"
 	//"time"
 
@@ -20,6 +24,7 @@ import (
 	"github.com/gorilla/websocket"
 	"github.com/stts-se/transtool-open/abbrevs"
 	"github.com/stts-se/transtool-open/log"
+	"github.com/stts-se/transtool-open/protocol"
 )
 
 // === CONSTANTS
@@ -233,6 +238,23 @@ func jsMessageToResponseWriter(w http.ResponseWriter, label string, serverMsg st
 
 // ==== ABBREVS
 
+// abbrevUser returns the user name of the request, and whether the user
+// is an admin. If authentication is disabled, the user is anonymous (an
+// empty user name), since user names sent by the client are not trusted
+// for list ownership. Without users to check ownership against, the
+// anonymous user may edit and delete all lists, as an admin (cf
+// requireAdmin).
+func abbrevUser(r *http.Request) (string, bool, error) {
+	if !authenticator.Enabled() {
+		return "", true, nil
+	}
+	id, err := authenticator.Identify(r)
+	if err != nil {
+		return "", false, err
+	}
+	return id.UserName, id.Admin, nil
+}
+
 // Abbrev is a tuple holding an abbreviation and its expansion.
 type Abbrev struct {
 	Abbrev    string `json:"abbrev"`
@@ -281,13 +303,32 @@ func listLists(w http.ResponseWriter, r *http.Request) {
 
 // }
 
+// listListsWithLength lists the abbreviation lists used by the user in
+// the sub project given by the "sub_proj" query param, in order of
+// precedence (see abbrevs.AbbrevManager.ResolveLists). Admins can list
+// all lists with the "all" query param set to true.
 func listListsWithLength(w http.ResponseWriter, r *http.Request) {
-	//res := []string{}
+	userName, admin, err := abbrevUser(r)
+	if err != nil {
+		httpError(w, fmt.Sprintf("listListsWithLength: %v", err), "unauthorized", http.StatusUnauthorized)
+		return
+	}
 
-	// TODO sort
-	lists := abbrevManager.ListsWithLength()
-	//Sort abbreviations alphabetically-ish
-	sort.Slice(lists, func(i, j int) bool { return lists[i].Name < lists[j].Name })
+	var lists []abbrevs.ListLength
+	if admin && r.URL.Query().Get("all") == "true" {
+		lists = abbrevManager.ListsWithLength()
+		//Sort abbreviations alphabetically-ish
+		sort.Slice(lists, func(i, j int) bool { return lists[i].Name < lists[j].Name })
+	} else {
+		all := map[string]abbrevs.ListLength{}
+		for _, l := range abbrevManager.ListsWithLength() {
+			all[l.Name] = l
+		}
+		lists = []abbrevs.ListLength{}
+		for _, l := range abbrevManager.ResolveLists(userName, r.URL.Query().Get("sub_proj")) {
+			lists = append(lists, all[l])
+		}
+	}
 
 	resJSON, err := json.Marshal(lists)
 	if err != nil {
@@ -304,9 +345,23 @@ func createNewList(w http.ResponseWriter, r *http.Request) {
 	sessionMutex.Lock()
 	defer sessionMutex.Unlock()
 
+	userName, _, err := abbrevUser(r)
+	if err != nil {
+		httpError(w, fmt.Sprintf("createNewList: %v", err), "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
 	params := mux.Vars(r)
 	listName := params["list_name"]
-	err := abbrevManager.CreateList(listName)
+	meta := abbrevs.ListMeta{
+		Scope:   r.URL.Query().Get("scope"),
+		SubProj: r.URL.Query().Get("sub_proj"),
+		Owner:   userName,
+	}
+	if meta.Scope == "" {
+		meta.Scope = abbrevs.ScopeGlobal
+	}
+	err = abbrevManager.CreateScopedList(listName, meta)
 	if err != nil {
 		msg := fmt.Sprintf("failed to create new abbreviation list : %v", err)
 		httpError(w, msg, msg, http.StatusInternalServerError)
@@ -340,9 +395,20 @@ func deleteList(w http.ResponseWriter, r *http.Request) {
 	sessionMutex.Lock()
 	defer sessionMutex.Unlock()
 
+	userName, admin, err := abbrevUser(r)
+	if err != nil {
+		httpError(w, fmt.Sprintf("deleteList: %v", err), "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
 	params := mux.Vars(r)
 	listName := params["list_name"]
-	err := abbrevManager.DeleteListFile(listName)
+	if !abbrevManager.CanDelete(listName, userName, admin) {
+		msg := fmt.Sprintf("list '%s' can only be deleted by its owner or an admin", listName)
+		httpError(w, fmt.Sprintf("deleteList: user '%s' : %s", userName, msg), msg, http.StatusForbidden)
+		return
+	}
+	err = abbrevManager.DeleteListFile(listName)
 	if err != nil {
 		msg := fmt.Sprintf("failed to delete list : %v", err)
 		httpError(w, msg, msg, http.StatusInternalServerError)
@@ -414,8 +480,17 @@ func addAbbrev(w http.ResponseWriter, r *http.Request) {
 	//	abbrevMutex.Unlock() // Can't use defer here, since call below uses
 	// locking
 
+	// requireAuth has already checked the request
+	userName, admin, _ := abbrevUser(r)
+	// missing lists are reported by the abbrev manager
+	if _, exists := abbrevManager.Meta(listName); exists && !abbrevManager.CanEdit(listName, userName, admin) {
+		msg := fmt.Sprintf("addAbbrev: user '%s' may not edit list '%s'", userName, listName)
+		httpError(w, msg, "forbidden", http.StatusForbidden)
+		return
+	}
+
 	// This could be done consurrently, but easier to catch errors this way
-	err := abbrevManager.Add(listName, abbrev, expansion)
+	err := abbrevManager.Add(listName, abbrev, expansion, userName)
 	if err != nil {
 		msg := fmt.Sprintf("failed to save abbrev : %v", err)
 		//httpError(w, msg, "failed to save abbreviation", http.StatusInternalServerError)
@@ -463,8 +538,16 @@ func addAbbrevCreateListIfNotExists(w http.ResponseWriter, r *http.Request) {
 	//	abbrevMutex.Unlock() // Can't use defer here, since call below uses
 	// locking
 
+	// requireAuth has already checked the request
+	userName, admin, _ := abbrevUser(r)
+	if _, exists := abbrevManager.Meta(listName); exists && !abbrevManager.CanEdit(listName, userName, admin) {
+		msg := fmt.Sprintf("addAbbrevCreateListIfNotExists: user '%s' may not edit list '%s'", userName, listName)
+		httpError(w, msg, "forbidden", http.StatusForbidden)
+		return
+	}
+
 	// This could be done consurrently, but easier to catch errors this way
-	err := abbrevManager.AddCreateIfNotExists(listName, abbrev, expansion)
+	err := abbrevManager.AddCreateIfNotExists(listName, abbrev, expansion, userName)
 	if err != nil {
 		msg := fmt.Sprintf("failed to save abbrev : %v", err)
 		//httpError(w, msg, "failed to save abbreviation", http.StatusInternalServerError)
@@ -512,8 +595,17 @@ func deleteAbbrev(w http.ResponseWriter, r *http.Request) {
 	//abbrevMutex.Unlock() // Can't use defer here, since call below uses
 	// locking
 
+	// requireAuth has already checked the request
+	userName, admin, _ := abbrevUser(r)
+	// missing lists are reported by the abbrev manager
+	if _, exists := abbrevManager.Meta(listName); exists && !abbrevManager.CanEdit(listName, userName, admin) {
+		msg := fmt.Sprintf("deleteAbbrev: user '%s' may not edit list '%s'", userName, listName)
+		httpError(w, msg, "forbidden", http.StatusForbidden)
+		return
+	}
+
 	// This could be done concurrently, but easier to catch errors this way
-	err := abbrevManager.Delete(listName, abbrev)
+	err := abbrevManager.Delete(listName, abbrev, userName)
 	if err != nil {
 		msg := fmt.Sprintf("deleteAbbrev: failed to delete abbrev : %v", err)
 		httpError(w, msg, "failed to delete abbreviation(s)", http.StatusInternalServerError)
@@ -995,3 +1087,372 @@ func deleteAbbrev(w http.ResponseWriter, r *http.Request) {
 // 	fmt.Fprintf(w, "</tbody></table>")
 // 	fmt.Fprintf(w, "</body></html>")
 // }
+
+// ==== ABBREVIATION EXPANSION
+
+// expandOptions returns the abbreviation expansion options of a sub
+// project: tokens are split and labels identified as in the
+// validation config of the sub project
+func expandOptions(subProj string) (abbrevs.ExpandOptions, error) {
+	vCfg := proj.Validator(subProj).Config()
+	res := abbrevs.ExpandOptions{
+		LabelPrefix: vCfg.LabelPrefix,
+		LabelSuffix: vCfg.LabelSuffix,
+	}
+	if vCfg.TokenSplitRegexp != "" {
+		re, err := regexp.Compile(vCfg.TokenSplitRegexp)
+		if err != nil {
+			return res, fmt.Errorf("invalid token split regexp : %v", err)
+		}
+		res.TokenSplit = re
+	}
+	return res, nil
+}
+
+// expandAbbrevs expands the abbreviations of the payload transcription.
+// If no lists are given, the lists of the user and sub project are used
+// (see abbrevs.AbbrevManager.ResolveLists).
+func expandAbbrevs(req *wsRequest, userName string, payload protocol.ExpandAbbrevsPayload) {
+	opts, err := expandOptions(payload.SubProj)
+	if err != nil {
+		msg := fmt.Sprintf("expandAbbrevs: %v", err)
+		req.error(msg, msg)
+		return
+	}
+	lists := payload.Lists
+	if len(lists) == 0 {
+		lists = abbrevManager.ResolveLists(userName, payload.SubProj)
+	}
+	res, err := abbrevManager.Expand(lists, payload.Trans, opts)
+	if err != nil {
+		msg := fmt.Sprintf("expandAbbrevs: %v", err)
+		req.error(msg, msg)
+		return
+	}
+	req.payload("expand_abbrevs", res)
+}
+
+// expandAbbrevsPayload is the request body of expandAbbrevsSubProj with apply
+type expandAbbrevsPayload struct {
+	Lists []string `json:"lists"`
+}
+
+// expandAbbrevsSubProj expands abbreviations in all transcriptions of
+// a sub project. Unless called with apply, it only lists the changes,
+// without saving, using the comma separated abbreviation lists of the
+// URL. With apply, the lists are read from a JSON body (see
+// expandAbbrevsPayload), and the handler should only be routed for POST.
+func expandAbbrevsSubProj(apply bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		params := mux.Vars(r)
+		subProj0 := params["subproj"]
+		subProj, ok := subProjParam(w, r, "expandAbbrevsSubProj")
+		if !ok {
+			return
+		}
+		var lists []string
+		if apply {
+			var payload expandAbbrevsPayload
+			if err := decodeJSONBody(r, &payload); err != nil {
+				msg := fmt.Sprintf("expandAbbrevsSubProj: %v", err)
+				httpError(w, msg, msg, http.StatusBadRequest)
+				return
+			}
+			lists = payload.Lists
+		} else {
+			lists = strings.Split(params["lists"], ",")
+		}
+		if len(lists) == 0 {
+			msg := "expandAbbrevsSubProj: no abbreviation lists"
+			httpError(w, msg, msg, http.StatusBadRequest)
+			return
+		}
+		log.Info("[main] Expanding abbreviations in sub project %s using lists %v (apply: %v)", subProj0, lists, apply)
+
+		opts, err := expandOptions(subProj)
+		if err != nil {
+			msg := fmt.Sprintf("expandAbbrevsSubProj: %v", err)
+			httpError(w, msg, msg, http.StatusBadRequest)
+			return
+		}
+		// check the lists before rewriting
+		if _, err := abbrevManager.Expand(lists, "", opts); err != nil {
+			msg := fmt.Sprintf("expandAbbrevsSubProj: %v", err)
+			httpError(w, msg, msg, http.StatusBadRequest)
+			return
+		}
+		rewrite := func(t string) string {
+			res, _ := abbrevManager.Expand(lists, t, opts)
+			return res.Output
+		}
+
+		res, err := proj.RewriteTrans(subProj, rewrite, apply)
+		if err != nil {
+			msg := fmt.Sprintf("expandAbbrevsSubProj: %v", err)
+			httpError(w, msg, msg, http.StatusBadRequest)
+			return
+		}
+		res.SubProj = subProj0
+		if apply {
+			log.Info("[main] Expanded abbreviations in %d transcriptions in sub project %s", len(res.Rewrites), subProj0)
+		}
+
+		resJSON, err := json.Marshal(res)
+		if err != nil {
+			msg := fmt.Sprintf("expandAbbrevsSubProj: failed to marshal result : %v", err)
+			httpError(w, msg, msg, http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, string(resJSON))
+	}
+}
+
+// ==== ABBREVIATION IMPORT/EXPORT
+
+// exec in a mutex lock context only
+func notifyAbbrevListeners(label string) {
+	jsb, err := json.Marshal(SocketJSON{Label: label})
+	if err != nil {
+		log.Info("failed to marshal %s : %v", label, err)
+		return
+	}
+	var tmp = wsProducersGlobalListeners
+	for _, conn := range tmp {
+		err := conn.WriteMessage(websocket.TextMessage, jsb)
+		if err != nil {
+			log.Info("notifyAbbrevListeners: failure to write to socket : %v", err)
+			wsProducersGlobalListeners = deleteConnection(conn, wsProducersGlobalListeners)
+		}
+	}
+}
+
+func writeJSONResponse(w http.ResponseWriter, caller string, res interface{}) {
+	resJSON, err := json.Marshal(res)
+	if err != nil {
+		msg := fmt.Sprintf("%s: failed to marshal result : %v", caller, err)
+		httpError(w, msg, msg, http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprint(w, string(resJSON))
+}
+
+// importAbbrevs reads abbreviations from the request body (or the form
+// file "file" of a multipart upload), in the format given by the
+// "format" query param (tsv, csv or json). If no format is given, it is
+// taken from the upload file name extension, with tsv as default.
+// Conflicting abbreviations are only replaced if the "overwrite" query
+// param is true.
+func importAbbrevs(w http.ResponseWriter, r *http.Request) {
+	sessionMutex.Lock()
+	defer sessionMutex.Unlock()
+
+	params := mux.Vars(r)
+	listName := params["list_name"]
+	format := r.URL.Query().Get("format")
+	overwrite := r.URL.Query().Get("overwrite") == "true"
+
+	var body io.Reader = r.Body
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		f, header, err := r.FormFile("file")
+		if err != nil {
+			msg := fmt.Sprintf("importAbbrevs: failed to read upload file : %v", err)
+			httpError(w, msg, msg, http.StatusBadRequest)
+			return
+		}
+		defer f.Close()
+		body = f
+		if format == "" {
+			format = strings.TrimPrefix(strings.ToLower(filepath.Ext(header.Filename)), ".")
+		}
+	}
+	if format == "" {
+		format = abbrevs.FormatTSV
+	}
+
+	bts, err := io.ReadAll(body)
+	if err != nil {
+		msg := fmt.Sprintf("importAbbrevs: failed to read request : %v", err)
+		httpError(w, msg, msg, http.StatusBadRequest)
+		return
+	}
+	entries, err := abbrevs.Parse(format, bts)
+	if err != nil {
+		msg := fmt.Sprintf("importAbbrevs: failed to parse abbreviations : %v", err)
+		httpError(w, msg, msg, http.StatusBadRequest)
+		return
+	}
+	// requireAuth has already checked the request
+	userName, admin, _ := abbrevUser(r)
+	// missing lists are reported by the abbrev manager
+	if _, exists := abbrevManager.Meta(listName); exists && !abbrevManager.CanEdit(listName, userName, admin) {
+		msg := fmt.Sprintf("importAbbrevs: user '%s' may not edit list '%s'", userName, listName)
+		httpError(w, msg, "forbidden", http.StatusForbidden)
+		return
+	}
+	res, err := abbrevManager.Import(listName, entries, overwrite, userName)
+	if err != nil {
+		msg := fmt.Sprintf("importAbbrevs: failed to import abbreviations : %v", err)
+		httpError(w, msg, msg, http.StatusInternalServerError)
+		return
+	}
+	log.Info("[main] Imported abbreviations to list %s: %d added, %d replaced, %d conflicts", listName, res.Added, res.Replaced, len(res.Conflicts))
+
+	notifyAbbrevListeners("abbrev_lists_updated")
+	writeJSONResponse(w, "importAbbrevs", res)
+}
+
+func exportAbbrevs(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	listName := params["list_name"]
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = abbrevs.FormatTSV
+	}
+
+	var b bytes.Buffer
+	err := abbrevManager.Export(listName, format, &b)
+	if err != nil {
+		msg := fmt.Sprintf("exportAbbrevs: failed to export list : %v", err)
+		httpError(w, msg, msg, http.StatusBadRequest)
+		return
+	}
+	contentType := map[string]string{
+		abbrevs.FormatTSV:  "text/tab-separated-values",
+		abbrevs.FormatCSV:  "text/csv",
+		abbrevs.FormatJSON: "application/json",
+	}[format]
+	w.Header().Set("Content-Type", contentType+"; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", listName+"."+format))
+	w.Write(b.Bytes())
+}
+
+// mergeAbbrevLists adds the abbreviations of list from to list to.
+// Conflicting abbreviations are only replaced if the "overwrite" query
+// param is true.
+func mergeAbbrevLists(w http.ResponseWriter, r *http.Request) {
+	sessionMutex.Lock()
+	defer sessionMutex.Unlock()
+
+	params := mux.Vars(r)
+	from := params["from"]
+	to := params["to"]
+	overwrite := r.URL.Query().Get("overwrite") == "true"
+
+	// requireAuth has already checked the request
+	userName, admin, _ := abbrevUser(r)
+	// missing lists are reported by the abbrev manager
+	if _, exists := abbrevManager.Meta(to); exists && !abbrevManager.CanEdit(to, userName, admin) {
+		msg := fmt.Sprintf("mergeAbbrevLists: user '%s' may not edit list '%s'", userName, to)
+		httpError(w, msg, "forbidden", http.StatusForbidden)
+		return
+	}
+	res, err := abbrevManager.Merge(from, to, overwrite, userName)
+	if err != nil {
+		msg := fmt.Sprintf("mergeAbbrevLists: failed to merge lists : %v", err)
+		httpError(w, msg, msg, http.StatusBadRequest)
+		return
+	}
+	log.Info("[main] Merged abbreviation list %s into %s: %d added, %d replaced, %d conflicts", from, to, res.Added, res.Replaced, len(res.Conflicts))
+
+	notifyAbbrevListeners("abbrev_lists_updated")
+	writeJSONResponse(w, "mergeAbbrevLists", res)
+}
+
+func compactAbbrevList(w http.ResponseWriter, r *http.Request) {
+	sessionMutex.Lock()
+	defer sessionMutex.Unlock()
+
+	params := mux.Vars(r)
+	listName := params["list_name"]
+
+	// requireAuth has already checked the request
+	userName, admin, _ := abbrevUser(r)
+	// missing lists are reported by the abbrev manager
+	if _, exists := abbrevManager.Meta(listName); exists && !abbrevManager.CanEdit(listName, userName, admin) {
+		msg := fmt.Sprintf("compactAbbrevList: user '%s' may not edit list '%s'", userName, listName)
+		httpError(w, msg, "forbidden", http.StatusForbidden)
+		return
+	}
+	n, err := abbrevManager.Compact(listName)
+	if err != nil {
+		msg := fmt.Sprintf("compactAbbrevList: failed to compact list : %v", err)
+		httpError(w, msg, msg, http.StatusBadRequest)
+		return
+	}
+	msg := fmt.Sprintf("compacted abbreviation list '%s', removed %d lines", listName, n)
+	infoToResponseWriter(w, msg, msg)
+}
+
+func abbrevDuplicates(w http.ResponseWriter, r *http.Request) {
+	writeJSONResponse(w, "abbrevDuplicates", abbrevManager.Duplicates())
+}
+
+// ==== ABBREVIATION USAGE AND SUGGESTIONS
+
+// abbrevUsage lists how often the expansions of the abbreviations in a
+// list occur in the okayed transcriptions of all loaded sub projects
+func abbrevUsage(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	listName := params["list_name"]
+
+	// default validation config, since the texts are from all sub projects
+	opts, err := expandOptions("")
+	if err != nil {
+		msg := fmt.Sprintf("abbrevUsage: %v", err)
+		httpError(w, msg, msg, http.StatusInternalServerError)
+		return
+	}
+	res, err := abbrevManager.Usage(listName, proj.OKTranscriptions(), opts)
+	if err != nil {
+		msg := fmt.Sprintf("abbrevUsage: %v", err)
+		httpError(w, msg, msg, http.StatusBadRequest)
+		return
+	}
+	writeJSONResponse(w, "abbrevUsage", res)
+}
+
+// abbrevSuggestions lists frequent words and phrases in the okayed
+// transcriptions of all loaded sub projects, not covered by any
+// abbreviation list, with suggested abbreviations for the list. The
+// query params min_length, min_count, max_words and limit override
+// abbrevs.SuggestOptionsDefault.
+func abbrevSuggestions(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	listName := params["list_name"]
+
+	sOpts := abbrevs.SuggestOptionsDefault
+	for name, p := range map[string]*int{
+		"min_length": &sOpts.MinLength,
+		"min_count":  &sOpts.MinCount,
+		"max_words":  &sOpts.MaxWords,
+		"limit":      &sOpts.Limit,
+	} {
+		v := r.URL.Query().Get(name)
+		if v == "" {
+			continue
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			msg := fmt.Sprintf("abbrevSuggestions: invalid value for %s: '%s'", name, v)
+			httpError(w, msg, msg, http.StatusBadRequest)
+			return
+		}
+		*p = n
+	}
+
+	opts, err := expandOptions("")
+	if err != nil {
+		msg := fmt.Sprintf("abbrevSuggestions: %v", err)
+		httpError(w, msg, msg, http.StatusInternalServerError)
+		return
+	}
+	res, err := abbrevManager.Suggest(listName, proj.OKTranscriptions(), opts, sOpts)
+	if err != nil {
+		msg := fmt.Sprintf("abbrevSuggestions: %v", err)
+		httpError(w, msg, msg, http.StatusBadRequest)
+		return
+	}
+	writeJSONResponse(w, "abbrevSuggestions", res)
+}
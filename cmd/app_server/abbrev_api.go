@@ -0,0 +1,333 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+
+	"github.com/stts-se/transtool-open/abbrevs"
+	"github.com/stts-se/transtool-open/log"
+)
+
+// ==== ABBREVIATION REST API (/api/v1/abbrev)
+
+const abbrevAPIPrefix = apiPrefix + "/abbrev"
+
+type apiNewList struct {
+	Name    string `json:"name"`
+	Scope   string `json:"scope,omitempty"`
+	SubProj string `json:"sub_proj,omitempty"`
+}
+
+type apiEntries struct {
+	List    string          `json:"list"`
+	Total   int             `json:"total"`
+	Offset  int             `json:"offset"`
+	Limit   int             `json:"limit"`
+	Entries []abbrevs.Entry `json:"entries"`
+}
+
+type apiDeleteEntry struct {
+	Abbrev string `json:"abbrev"`
+}
+
+// apiListAbbrevLists: GET /api/v1/abbrev/lists (see listListsWithLength for query params)
+func apiListAbbrevLists(w http.ResponseWriter, r *http.Request) {
+	userName, admin, err := abbrevUser(r)
+	if err != nil {
+		apiError(w, fmt.Sprintf("apiListAbbrevLists: %v", err), "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	all := map[string]abbrevs.ListLength{}
+	for _, l := range abbrevManager.ListsWithLength() {
+		all[l.Name] = l
+	}
+	res := []abbrevs.ListLength{}
+	if admin && r.URL.Query().Get("all") == "true" {
+		for _, l := range all {
+			res = append(res, l)
+		}
+		sort.Slice(res, func(i, j int) bool { return res[i].Name < res[j].Name })
+	} else {
+		for _, l := range abbrevManager.ResolveLists(userName, r.URL.Query().Get("sub_proj")) {
+			res = append(res, all[l])
+		}
+	}
+	apiResponse(w, "apiListAbbrevLists", http.StatusOK, res)
+}
+
+// apiCreateAbbrevList: POST /api/v1/abbrev/lists {"name": ..., "scope": ..., "sub_proj": ...}
+func apiCreateAbbrevList(w http.ResponseWriter, r *http.Request) {
+	sessionMutex.Lock()
+	defer sessionMutex.Unlock()
+
+	userName, _, err := abbrevUser(r)
+	if err != nil {
+		apiError(w, fmt.Sprintf("apiCreateAbbrevList: %v", err), "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	var payload apiNewList
+	if err := decodeJSONBody(r, &payload); err != nil {
+		apiError(w, fmt.Sprintf("apiCreateAbbrevList: %v", err), err.Error(), http.StatusBadRequest)
+		return
+	}
+	meta := abbrevs.ListMeta{Scope: payload.Scope, SubProj: payload.SubProj, Owner: userName}
+	if meta.Scope == "" {
+		meta.Scope = abbrevs.ScopeGlobal
+	}
+	if strings.TrimSpace(payload.Name) == "" || strings.ContainsAny(payload.Name, `/\`) {
+		msg := fmt.Sprintf("invalid list name '%s'", payload.Name)
+		apiError(w, "apiCreateAbbrevList: "+msg, msg, http.StatusBadRequest)
+		return
+	}
+	err = abbrevManager.CreateScopedList(payload.Name, meta)
+	if err != nil {
+		msg := fmt.Sprintf("failed to create new abbreviation list : %v", err)
+		apiError(w, "apiCreateAbbrevList: "+msg, msg, http.StatusConflict)
+		return
+	}
+	notifyAbbrevListeners("abbrev_lists_updated")
+
+	m, _ := abbrevManager.Meta(payload.Name)
+	apiResponse(w, "apiCreateAbbrevList", http.StatusCreated, abbrevs.ListLength{Name: payload.Name, ListMeta: m})
+}
+
+// apiDeleteAbbrevList: DELETE /api/v1/abbrev/lists/{name}
+func apiDeleteAbbrevList(w http.ResponseWriter, r *http.Request) {
+	sessionMutex.Lock()
+	defer sessionMutex.Unlock()
+
+	userName, admin, err := abbrevUser(r)
+	if err != nil {
+		apiError(w, fmt.Sprintf("apiDeleteAbbrevList: %v", err), "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	listName := mux.Vars(r)["name"]
+	if _, ok := abbrevManager.Meta(listName); !ok {
+		msg := fmt.Sprintf("no such list '%s'", listName)
+		apiError(w, "apiDeleteAbbrevList: "+msg, msg, http.StatusNotFound)
+		return
+	}
+	if !abbrevManager.CanDelete(listName, userName, admin) {
+		msg := fmt.Sprintf("list '%s' can only be deleted by its owner or an admin", listName)
+		apiError(w, fmt.Sprintf("apiDeleteAbbrevList: user '%s' : %s", userName, msg), msg, http.StatusForbidden)
+		return
+	}
+	err = abbrevManager.DeleteListFile(listName)
+	if err != nil {
+		msg := fmt.Sprintf("failed to delete list : %v", err)
+		apiError(w, "apiDeleteAbbrevList: "+msg, msg, http.StatusConflict)
+		return
+	}
+	notifyAbbrevListeners("abbrev_lists_updated")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// apiListAbbrevEntries: GET /api/v1/abbrev/lists/{name}/entries?offset=0&limit=100&prefix=...
+// The entries are sorted by abbreviation. A limit of 0 (the default) means no limit.
+func apiListAbbrevEntries(w http.ResponseWriter, r *http.Request) {
+	listName := mux.Vars(r)["name"]
+	if _, ok := abbrevManager.Meta(listName); !ok {
+		msg := fmt.Sprintf("no such list '%s'", listName)
+		apiError(w, "apiListAbbrevEntries: "+msg, msg, http.StatusNotFound)
+		return
+	}
+
+	offset, limit, err := pagingParams(r)
+	if err != nil {
+		apiError(w, fmt.Sprintf("apiListAbbrevEntries: %v", err), err.Error(), http.StatusBadRequest)
+		return
+	}
+	res := apiEntries{List: listName, Offset: offset, Limit: limit}
+
+	prefix := r.URL.Query().Get("prefix")
+	var entries []abbrevs.Entry
+	for a, e := range abbrevManager.AbbrevsFor(listName) {
+		if strings.HasPrefix(a, prefix) {
+			entries = append(entries, abbrevs.Entry{Abbrev: a, Expansion: e})
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Abbrev < entries[j].Abbrev })
+
+	res.Total = len(entries)
+	res.Entries = paged(entries, offset, limit)
+	apiResponse(w, "apiListAbbrevEntries", http.StatusOK, res)
+}
+
+// apiAddAbbrevEntry: POST /api/v1/abbrev/lists/{name}/entries {"abbrev": ..., "expansion": ...}
+// With the query param create_list=true, a (global) list is created if it doesn't exist.
+func apiAddAbbrevEntry(w http.ResponseWriter, r *http.Request) {
+	sessionMutex.Lock()
+	defer sessionMutex.Unlock()
+
+	listName := mux.Vars(r)["name"]
+	var payload abbrevs.Entry
+	if err := decodeJSONBody(r, &payload); err != nil {
+		apiError(w, fmt.Sprintf("apiAddAbbrevEntry: %v", err), err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	_, exists := abbrevManager.Meta(listName)
+	if !exists && r.URL.Query().Get("create_list") != "true" {
+		msg := fmt.Sprintf("no such list '%s'", listName)
+		apiError(w, "apiAddAbbrevEntry: "+msg, msg, http.StatusNotFound)
+		return
+	}
+
+	userName, admin, err := abbrevUser(r)
+	if err != nil {
+		apiError(w, fmt.Sprintf("apiAddAbbrevEntry: %v", err), "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if exists && !abbrevManager.CanEdit(listName, userName, admin) {
+		msg := fmt.Sprintf("list '%s' can only be edited by its owner or an admin", listName)
+		apiError(w, fmt.Sprintf("apiAddAbbrevEntry: user '%s' : %s", userName, msg), msg, http.StatusForbidden)
+		return
+	}
+	if exists {
+		err = abbrevManager.Add(listName, payload.Abbrev, payload.Expansion, userName)
+	} else {
+		err = abbrevManager.AddCreateIfNotExists(listName, payload.Abbrev, payload.Expansion, userName)
+	}
+	if err != nil {
+		msg := fmt.Sprintf("failed to save abbrev : %v", err)
+		apiError(w, "apiAddAbbrevEntry: "+msg, msg, http.StatusConflict)
+		return
+	}
+	if !exists {
+		notifyAbbrevListeners("abbrev_lists_updated")
+	}
+	notifyAbbrevListeners("abbrevs_updated")
+	log.Info("[main] saved abbreviation '%s' '%s' '%s'", listName, payload.Abbrev, payload.Expansion)
+
+	apiResponse(w, "apiAddAbbrevEntry", http.StatusCreated, payload)
+}
+
+// apiDeleteAbbrevEntry: DELETE /api/v1/abbrev/lists/{name}/entries {"abbrev": ...}
+func apiDeleteAbbrevEntry(w http.ResponseWriter, r *http.Request) {
+	sessionMutex.Lock()
+	defer sessionMutex.Unlock()
+
+	listName := mux.Vars(r)["name"]
+	var payload apiDeleteEntry
+	if err := decodeJSONBody(r, &payload); err != nil {
+		apiError(w, fmt.Sprintf("apiDeleteAbbrevEntry: %v", err), err.Error(), http.StatusBadRequest)
+		return
+	}
+	if _, ok := abbrevManager.AbbrevsFor(listName)[payload.Abbrev]; !ok {
+		msg := fmt.Sprintf("no abbreviation '%s' in list '%s'", payload.Abbrev, listName)
+		apiError(w, "apiDeleteAbbrevEntry: "+msg, msg, http.StatusNotFound)
+		return
+	}
+	userName, admin, err := abbrevUser(r)
+	if err != nil {
+		apiError(w, fmt.Sprintf("apiDeleteAbbrevEntry: %v", err), "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if !abbrevManager.CanEdit(listName, userName, admin) {
+		msg := fmt.Sprintf("list '%s' can only be edited by its owner or an admin", listName)
+		apiError(w, fmt.Sprintf("apiDeleteAbbrevEntry: user '%s' : %s", userName, msg), msg, http.StatusForbidden)
+		return
+	}
+	err = abbrevManager.Delete(listName, payload.Abbrev, userName)
+	if err != nil {
+		msg := fmt.Sprintf("failed to delete abbrev : %v", err)
+		apiError(w, "apiDeleteAbbrevEntry: "+msg, msg, http.StatusInternalServerError)
+		return
+	}
+	notifyAbbrevListeners("abbrevs_updated")
+	log.Info("[main] deleted abbreviation '%s' '%s'", listName, payload.Abbrev)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// apiAbbrevHistory: GET /api/v1/abbrev/lists/{name}/history?limit=50
+// The changes are listed most recent first. A limit of 0 means no limit.
+func apiAbbrevHistory(w http.ResponseWriter, r *http.Request) {
+	listName := mux.Vars(r)["name"]
+	limit := 50
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			msg := fmt.Sprintf("invalid value for limit: '%s'", v)
+			apiError(w, "apiAbbrevHistory: "+msg, msg, http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+	res, err := abbrevManager.History(listName, limit)
+	if err != nil {
+		apiError(w, fmt.Sprintf("apiAbbrevHistory: %v", err), err.Error(), http.StatusNotFound)
+		return
+	}
+	apiResponse(w, "apiAbbrevHistory", http.StatusOK, res)
+}
+
+// apiRevertAbbrevChange: POST /api/v1/abbrev/lists/{name}/history/{id}/revert
+// Returns the change recorded for the revert.
+func apiRevertAbbrevChange(w http.ResponseWriter, r *http.Request) {
+	sessionMutex.Lock()
+	defer sessionMutex.Unlock()
+
+	userName, admin, err := abbrevUser(r)
+	if err != nil {
+		apiError(w, fmt.Sprintf("apiRevertAbbrevChange: %v", err), "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	listName := mux.Vars(r)["name"]
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		msg := fmt.Sprintf("invalid change id '%s'", mux.Vars(r)["id"])
+		apiError(w, "apiRevertAbbrevChange: "+msg, msg, http.StatusBadRequest)
+		return
+	}
+	history, err := abbrevManager.History(listName, 0)
+	if err != nil {
+		apiError(w, fmt.Sprintf("apiRevertAbbrevChange: %v", err), err.Error(), http.StatusNotFound)
+		return
+	}
+	found := false
+	for _, c := range history {
+		if c.ID == id {
+			found = true
+			break
+		}
+	}
+	if !found {
+		msg := fmt.Sprintf("no change %d in the history of list '%s'", id, listName)
+		apiError(w, "apiRevertAbbrevChange: "+msg, msg, http.StatusNotFound)
+		return
+	}
+	if !abbrevManager.CanEdit(listName, userName, admin) {
+		msg := fmt.Sprintf("list '%s' can only be edited by its owner or an admin", listName)
+		apiError(w, fmt.Sprintf("apiRevertAbbrevChange: user '%s' : %s", userName, msg), msg, http.StatusForbidden)
+		return
+	}
+
+	res, err := abbrevManager.Revert(listName, id, userName)
+	if err != nil {
+		msg := fmt.Sprintf("failed to revert change : %v", err)
+		apiError(w, "apiRevertAbbrevChange: "+msg, msg, http.StatusConflict)
+		return
+	}
+	notifyAbbrevListeners("abbrevs_updated")
+	log.Info("[main] reverted abbreviation change %d in list '%s' (user %s)", id, listName, userName)
+	apiResponse(w, "apiRevertAbbrevChange", http.StatusOK, res)
+}
+
+func abbrevAPIRoutes() []apiRoute {
+	const lists = "/abbrev/lists"
+	return []apiRoute{
+		{Method: "GET", Path: lists, Handler: apiListAbbrevLists, Summary: "List abbreviation lists", Params: []string{"sub_proj", "all"}, Response: []abbrevs.ListLength{}, Status: http.StatusOK},
+		{Method: "POST", Path: lists, Handler: apiCreateAbbrevList, Summary: "Create an abbreviation list", Request: apiNewList{}, Response: abbrevs.ListLength{}, Status: http.StatusCreated},
+		{Method: "DELETE", Path: lists + "/{name}", Handler: apiDeleteAbbrevList, Summary: "Delete an empty abbreviation list", Status: http.StatusNoContent},
+		{Method: "GET", Path: lists + "/{name}/entries", Handler: apiListAbbrevEntries, Summary: "List abbreviations", Params: []string{"offset", "limit", "prefix"}, Response: apiEntries{}, Status: http.StatusOK},
+		{Method: "POST", Path: lists + "/{name}/entries", Handler: apiAddAbbrevEntry, Summary: "Add an abbreviation", Params: []string{"create_list"}, Request: abbrevs.Entry{}, Response: abbrevs.Entry{}, Status: http.StatusCreated},
+		{Method: "DELETE", Path: lists + "/{name}/entries", Handler: apiDeleteAbbrevEntry, Summary: "Delete an abbreviation", Request: apiDeleteEntry{}, Status: http.StatusNoContent},
+		{Method: "GET", Path: lists + "/{name}/history", Handler: apiAbbrevHistory, Summary: "List changes, most recent first", Params: []string{"limit"}, Response: []abbrevs.Change{}, Status: http.StatusOK},
+		{Method: "POST", Path: lists + "/{name}/history/{id}/revert", Handler: apiRevertAbbrevChange, Summary: "Revert a change", Response: abbrevs.Change{}, Status: http.StatusOK},
+	}
+}
@@ -0,0 +1,564 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+
+	"github.com/stts-se/transtool-open/dbapi"
+	"github.com/stts-se/transtool-open/log"
+	"github.com/stts-se/transtool-open/modules/waveform"
+	"github.com/stts-se/transtool-open/protocol"
+	"github.com/stts-se/transtool-open/validation"
+)
+
+// ==== REST API (/api/v1)
+//
+// The REST API gives scripts and other tools access to the same
+// operations as the websocket protocol, using the same dbapi.Proj
+// calls. Data is sent as JSON request bodies (not in the URL path),
+// and requests with a body must have the content type
+// application/json, so that they cannot be sent cross-site from a
+// plain HTML form. Errors are returned as JSON: {"error": "..."}.
+//
+// Sub-projects are named by their base name in URLs, or by their full
+// path, if the base name is ambiguous (see dbapi.Proj.SubProjName).
+// Pages are locked by the client id given in the X-Client-ID header
+// (default "api"), and the authenticated user (or the "user" query
+// param, if authentication is disabled). Unlike websocket clients, REST
+// API clients have to release their locks explicitly.
+
+const apiPrefix = "/api/v1"
+
+const apiDefaultClientID = "api"
+
+type apiErrorResponse struct {
+	Error string `json:"error"`
+}
+
+// apiError prints serverMsg to the server log, and returns a JSON error with clientMsg and the error code
+func apiError(w http.ResponseWriter, serverMsg string, clientMsg string, errCode int) {
+	log.Error(serverMsg)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(errCode)
+	json.NewEncoder(w).Encode(apiErrorResponse{Error: clientMsg})
+}
+
+func apiResponse(w http.ResponseWriter, caller string, code int, res interface{}) {
+	resJSON, err := json.Marshal(res)
+	if err != nil {
+		msg := fmt.Sprintf("%s: failed to marshal result : %v", caller, err)
+		apiError(w, msg, msg, http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	fmt.Fprint(w, string(resJSON))
+}
+
+// decodeJSONBody requires the content type application/json, and decodes the request body into v
+func decodeJSONBody(r *http.Request, v interface{}) error {
+	if !strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		return fmt.Errorf("expected content type application/json, found '%s'", r.Header.Get("Content-Type"))
+	}
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	err := dec.Decode(v)
+	if err != nil {
+		return fmt.Errorf("failed to unmarshal request body : %v", err)
+	}
+	return nil
+}
+
+// deprecatedRoute wraps a handler of an old route, that has been
+// replaced by the REST API route method + path
+func deprecatedRoute(method, path string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log.Warning("[main] Deprecated route %s called from %s, use %s %s", r.URL.Path, r.RemoteAddr, method, path)
+		w.Header().Set("Deprecation", "true")
+		w.Header().Set("Link", fmt.Sprintf("<%s>; rel=\"successor-version\"", path))
+		h(w, r)
+	}
+}
+
+// pagingParams returns the offset and limit query params. A limit of 0
+// (the default) means no limit.
+func pagingParams(r *http.Request) (int, int, error) {
+	var res [2]int
+	for i, name := range []string{"offset", "limit"} {
+		v := r.URL.Query().Get(name)
+		if v == "" {
+			continue
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return 0, 0, fmt.Errorf("invalid value for %s: '%s'", name, v)
+		}
+		res[i] = n
+	}
+	return res[0], res[1], nil
+}
+
+// paged returns the items from offset, at most limit items (if limit > 0)
+func paged[T any](items []T, offset, limit int) []T {
+	if offset >= len(items) {
+		return []T{}
+	}
+	items = items[offset:]
+	if limit > 0 && len(items) > limit {
+		items = items[:limit]
+	}
+	return items
+}
+
+// apiClientID returns the client id of a REST API request (see above)
+func apiClientID(r *http.Request) (dbapi.ClientID, error) {
+	userName, err := authUserName(r, r.URL.Query().Get("user"))
+	if err != nil {
+		return dbapi.ClientID{}, err
+	}
+	if strings.TrimSpace(userName) == "" {
+		return dbapi.ClientID{}, fmt.Errorf("no user name")
+	}
+	id := strings.TrimSpace(r.Header.Get("X-Client-ID"))
+	if id == "" {
+		id = apiDefaultClientID
+	}
+	return dbapi.ClientID{ID: id, UserName: userName}, nil
+}
+
+// apiSubProj resolves the sub_proj URL param. It returns false if the
+// sub-project doesn't exist (and the error has been sent).
+func apiSubProj(w http.ResponseWriter, r *http.Request, caller string) (string, bool) {
+	subProj, err := proj.ResolveSubProj(mux.Vars(r)["sub_proj"])
+	if err != nil {
+		apiError(w, fmt.Sprintf("%s: %v", caller, err), err.Error(), http.StatusNotFound)
+		return "", false
+	}
+	return subProj, true
+}
+
+type apiSubProjInfo struct {
+	Name string `json:"name"`
+	Dir  string `json:"dir"`
+}
+
+type apiPages struct {
+	SubProj string           `json:"sub_proj"`
+	Total   int              `json:"total"`
+	Offset  int              `json:"offset"`
+	Limit   int              `json:"limit"`
+	Pages   []dbapi.PageInfo `json:"pages"`
+}
+
+type apiQueryResult struct {
+	SubProj     string                       `json:"sub_proj"`
+	Total       int                          `json:"total"`
+	Offset      int                          `json:"offset"`
+	Limit       int                          `json:"limit"`
+	Annotations []protocol.AnnotationPayload `json:"annotations"`
+}
+
+type apiSaveResult struct {
+	Annotation protocol.AnnotationPayload `json:"annotation"`
+	Validation []validation.ValRes        `json:"validation"`
+}
+
+// apiNextRequest is the REST API version of the saveunlockandnext
+// websocket message (saving is a separate call, see apiSaveAnnotation)
+type apiNextRequest struct {
+	Query protocol.QueryPayload `json:"query"`
+	// Unlock is the id of a page to unlock, if a next page is found
+	Unlock string `json:"unlock,omitempty"`
+	// Lock the next page
+	Lock bool `json:"lock"`
+	// Audio includes the audio of the next page
+	Audio bool `json:"audio"`
+}
+
+type apiUnlockResult struct {
+	Unlocked int `json:"unlocked"`
+}
+
+// apiListSubProjs: GET /api/v1/sub_projs
+func apiListSubProjs(w http.ResponseWriter, r *http.Request) {
+	res := []apiSubProjInfo{}
+	for _, sp := range proj.ListSubProjs() {
+		res = append(res, apiSubProjInfo{Name: proj.SubProjName(sp), Dir: sp})
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Name < res[j].Name })
+	apiResponse(w, "apiListSubProjs", http.StatusOK, res)
+}
+
+// apiStats: GET /api/v1/stats
+func apiStats(w http.ResponseWriter, r *http.Request) {
+	apiResponse(w, "apiStats", http.StatusOK, proj.Stats())
+}
+
+// apiListAudioFiles: GET /api/v1/sub_projs/{sub_proj}/audio_files
+func apiListAudioFiles(w http.ResponseWriter, r *http.Request) {
+	subProj, ok := apiSubProj(w, r, "apiListAudioFiles")
+	if !ok {
+		return
+	}
+	res, err := proj.ListAudioFiles(subProj)
+	if err != nil {
+		apiError(w, fmt.Sprintf("apiListAudioFiles: %v", err), err.Error(), http.StatusInternalServerError)
+		return
+	}
+	apiResponse(w, "apiListAudioFiles", http.StatusOK, res)
+}
+
+// apiListPages: GET /api/v1/sub_projs/{sub_proj}/pages?offset=0&limit=100
+func apiListPages(w http.ResponseWriter, r *http.Request) {
+	subProj, ok := apiSubProj(w, r, "apiListPages")
+	if !ok {
+		return
+	}
+	offset, limit, err := pagingParams(r)
+	if err != nil {
+		apiError(w, fmt.Sprintf("apiListPages: %v", err), err.Error(), http.StatusBadRequest)
+		return
+	}
+	pages, err := proj.PageInfos(subProj)
+	if err != nil {
+		apiError(w, fmt.Sprintf("apiListPages: %v", err), err.Error(), http.StatusInternalServerError)
+		return
+	}
+	res := apiPages{SubProj: subProj, Total: len(pages), Offset: offset, Limit: limit, Pages: paged(pages, offset, limit)}
+	apiResponse(w, "apiListPages", http.StatusOK, res)
+}
+
+// apiGetAnnotation: GET /api/v1/sub_projs/{sub_proj}/pages/{page_id}?audio=true&context=0
+// With audio=true, the audio of the page is included (as for the audio_chunk websocket message).
+func apiGetAnnotation(w http.ResponseWriter, r *http.Request) {
+	subProj, ok := apiSubProj(w, r, "apiGetAnnotation")
+	if !ok {
+		return
+	}
+	pageID := mux.Vars(r)["page_id"]
+	res, err := proj.Annotation(subProj, pageID)
+	if err != nil {
+		apiError(w, fmt.Sprintf("apiGetAnnotation: %v", err), err.Error(), http.StatusNotFound)
+		return
+	}
+	if r.URL.Query().Get("audio") != "true" {
+		apiResponse(w, "apiGetAnnotation", http.StatusOK, res)
+		return
+	}
+
+	var context int64
+	if v := r.URL.Query().Get("context"); v != "" {
+		context, err = strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			msg := fmt.Sprintf("invalid value for context: '%s'", v)
+			apiError(w, "apiGetAnnotation: "+msg, msg, http.StatusBadRequest)
+			return
+		}
+	}
+	withAudio, err := audioFromPage(res, context)
+	if err != nil {
+		msg := fmt.Sprintf("failed to extract audio for page : %v", err)
+		apiError(w, "apiGetAnnotation: "+msg, msg, http.StatusInternalServerError)
+		return
+	}
+	apiResponse(w, "apiGetAnnotation", http.StatusOK, withAudio)
+}
+
+// apiSaveAnnotation: PUT /api/v1/sub_projs/{sub_proj}/pages/{page_id} (annotation as JSON body)
+// The page must be locked by the user (see apiLockPage), as in the websocket protocol (lock, save, unlock).
+// Returns the saved annotation and its validation result.
+func apiSaveAnnotation(w http.ResponseWriter, r *http.Request) {
+	subProj, ok := apiSubProj(w, r, "apiSaveAnnotation")
+	if !ok {
+		return
+	}
+	pageID := mux.Vars(r)["page_id"]
+	clientID, err := apiClientID(r)
+	if err != nil {
+		apiError(w, fmt.Sprintf("apiSaveAnnotation: %v", err), "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	var payload protocol.AnnotationPayload
+	if err := decodeJSONBody(r, &payload); err != nil {
+		apiError(w, fmt.Sprintf("apiSaveAnnotation: %v", err), err.Error(), http.StatusBadRequest)
+		return
+	}
+	if payload.SubProj == "" {
+		payload.SubProj = subProj
+	}
+	if payload.SubProj != subProj || payload.Page.ID != pageID {
+		msg := fmt.Sprintf("mismatching sub project/page id in URL and body: %s/%s, %s/%s", subProj, pageID, payload.SubProj, payload.Page.ID)
+		apiError(w, "apiSaveAnnotation: "+msg, msg, http.StatusBadRequest)
+		return
+	}
+	lockedBy, locked, _ := proj.LockedBy(subProj, pageID)
+	if !locked {
+		msg := fmt.Sprintf("page %s must be locked before saving", pageID)
+		apiError(w, "apiSaveAnnotation: "+msg, msg, http.StatusConflict)
+		return
+	}
+	if lockedBy.UserName != clientID.UserName {
+		msg := fmt.Sprintf("page %s is locked by another user", pageID)
+		apiError(w, fmt.Sprintf("apiSaveAnnotation: %s (%s)", msg, lockedBy.UserName), msg, http.StatusConflict)
+		return
+	}
+
+	err = stampSources(clientID, &payload)
+	if err != nil {
+		msg := fmt.Sprintf("failed to save annotation : %v", err)
+		apiError(w, "apiSaveAnnotation: "+msg, msg, http.StatusBadRequest)
+		return
+	}
+	err = proj.Save(payload)
+	if err != nil {
+		msg := fmt.Sprintf("failed to save annotation : %v", err)
+		apiError(w, "apiSaveAnnotation: "+msg, msg, http.StatusBadRequest)
+		return
+	}
+	log.Info("[main] Saved annotation for page id %s (REST API, user %s)", pageID, clientID.UserName)
+	go pushStats()
+
+	res := apiSaveResult{
+		Annotation: payload,
+		Validation: proj.Validator(subProj).ValidateAnnotation(payload),
+	}
+	if res.Validation == nil {
+		res.Validation = []validation.ValRes{}
+	}
+	apiResponse(w, "apiSaveAnnotation", http.StatusOK, res)
+}
+
+// apiLockPage: POST /api/v1/sub_projs/{sub_proj}/pages/{page_id}/lock
+func apiLockPage(w http.ResponseWriter, r *http.Request) {
+	subProj, ok := apiSubProj(w, r, "apiLockPage")
+	if !ok {
+		return
+	}
+	clientID, err := apiClientID(r)
+	if err != nil {
+		apiError(w, fmt.Sprintf("apiLockPage: %v", err), "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	pageID := mux.Vars(r)["page_id"]
+	if _, err := proj.PageFromID(subProj, pageID); err != nil {
+		apiError(w, fmt.Sprintf("apiLockPage: %v", err), err.Error(), http.StatusNotFound)
+		return
+	}
+	err = proj.Lock(subProj, pageID, clientID)
+	if err != nil {
+		msg := fmt.Sprintf("couldn't lock page : %v", err)
+		apiError(w, "apiLockPage: "+msg, msg, http.StatusConflict)
+		return
+	}
+	go pushStats()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// apiUnlockPage: DELETE /api/v1/sub_projs/{sub_proj}/pages/{page_id}/lock
+func apiUnlockPage(w http.ResponseWriter, r *http.Request) {
+	subProj, ok := apiSubProj(w, r, "apiUnlockPage")
+	if !ok {
+		return
+	}
+	clientID, err := apiClientID(r)
+	if err != nil {
+		apiError(w, fmt.Sprintf("apiUnlockPage: %v", err), "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	pageID := mux.Vars(r)["page_id"]
+	err = proj.Unlock(subProj, pageID, clientID)
+	if err != nil {
+		msg := fmt.Sprintf("couldn't unlock page : %v", err)
+		apiError(w, "apiUnlockPage: "+msg, msg, http.StatusConflict)
+		return
+	}
+	go pushStats()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// apiUnlockAll: DELETE /api/v1/locks
+// Releases all locks held by the client id of the request.
+func apiUnlockAll(w http.ResponseWriter, r *http.Request) {
+	clientID, err := apiClientID(r)
+	if err != nil {
+		apiError(w, fmt.Sprintf("apiUnlockAll: %v", err), "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	n, err := proj.UnlockAll(clientID)
+	if err != nil {
+		msg := fmt.Sprintf("failed to unlock : %v", err)
+		apiError(w, "apiUnlockAll: "+msg, msg, http.StatusInternalServerError)
+		return
+	}
+	go pushStats()
+	apiResponse(w, "apiUnlockAll", http.StatusOK, apiUnlockResult{Unlocked: n})
+}
+
+// apiNextPage: POST /api/v1/sub_projs/{sub_proj}/next (apiNextRequest as JSON body)
+// Returns the next page matching the query (see dbapi.Proj.GetNextPage),
+// or 404 with a message if there is none.
+func apiNextPage(w http.ResponseWriter, r *http.Request) {
+	subProj, ok := apiSubProj(w, r, "apiNextPage")
+	if !ok {
+		return
+	}
+	clientID, err := apiClientID(r)
+	if err != nil {
+		apiError(w, fmt.Sprintf("apiNextPage: %v", err), "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	var payload apiNextRequest
+	if err := decodeJSONBody(r, &payload); err != nil {
+		apiError(w, fmt.Sprintf("apiNextPage: %v", err), err.Error(), http.StatusBadRequest)
+		return
+	}
+	query := payload.Query
+	if query.StepSize == 0 && query.RequestIndex == "" {
+		msg := "neither step size nor request index was provided for query"
+		apiError(w, "apiNextPage: "+msg, msg, http.StatusBadRequest)
+		return
+	}
+
+	res, msg, err := proj.GetNextPage(subProj, query, payload.Unlock, clientID, payload.Lock)
+	if err != nil {
+		apiError(w, fmt.Sprintf("apiNextPage: %v", err), err.Error(), http.StatusBadRequest)
+		return
+	}
+	if res.Page.ID == "" {
+		if msg == "" {
+			msg = "no matching page"
+		}
+		apiError(w, "apiNextPage: "+msg, msg, http.StatusNotFound)
+		return
+	}
+	if payload.Unlock != "" {
+		err = proj.Unlock(subProj, payload.Unlock, clientID)
+		if err != nil {
+			log.Warning("[main] apiNextPage: couldn't unlock page : %v", err)
+		}
+	}
+	if payload.Lock || payload.Unlock != "" {
+		go pushStats()
+	}
+
+	if payload.Audio {
+		withAudio, err := audioFromPage(res, query.Context)
+		if err != nil {
+			msg := fmt.Sprintf("failed to extract audio for page : %v", err)
+			apiError(w, "apiNextPage: "+msg, msg, http.StatusInternalServerError)
+			return
+		}
+		apiResponse(w, "apiNextPage", http.StatusOK, withAudio)
+		return
+	}
+	apiResponse(w, "apiNextPage", http.StatusOK, res)
+}
+
+// apiQuery: POST /api/v1/sub_projs/{sub_proj}/query?offset=0&limit=100 (protocol.QueryRequest as JSON body)
+// Returns all annotations matching the query request. Fields not in the
+// request match anything ("any").
+func apiQuery(w http.ResponseWriter, r *http.Request) {
+	subProj, ok := apiSubProj(w, r, "apiQuery")
+	if !ok {
+		return
+	}
+	offset, limit, err := pagingParams(r)
+	if err != nil {
+		apiError(w, fmt.Sprintf("apiQuery: %v", err), err.Error(), http.StatusBadRequest)
+		return
+	}
+	request := protocol.QueryRequest{
+		PageStatus: dbapi.StatusAny,
+		Status:     dbapi.StatusAny,
+		Source:     dbapi.SourceAny,
+		AudioFile:  dbapi.AudioFileAny,
+	}
+	if err := decodeJSONBody(r, &request); err != nil {
+		apiError(w, fmt.Sprintf("apiQuery: %v", err), err.Error(), http.StatusBadRequest)
+		return
+	}
+	annotations, err := proj.Query(subProj, request)
+	if err != nil {
+		msg := fmt.Sprintf("query failed : %v", err)
+		apiError(w, "apiQuery: "+msg, msg, http.StatusBadRequest)
+		return
+	}
+	res := apiQueryResult{SubProj: subProj, Total: len(annotations), Offset: offset, Limit: limit, Annotations: paged(annotations, offset, limit)}
+	apiResponse(w, "apiQuery", http.StatusOK, res)
+}
+
+// apiValidate: POST /api/v1/validate (annotation as JSON body)
+func apiValidate(w http.ResponseWriter, r *http.Request) {
+	var payload protocol.AnnotationPayload
+	if err := decodeJSONBody(r, &payload); err != nil {
+		apiError(w, fmt.Sprintf("apiValidate: %v", err), err.Error(), http.StatusBadRequest)
+		return
+	}
+	if payload.SubProj != "" {
+		subProj, err := proj.ResolveSubProj(payload.SubProj)
+		if err != nil {
+			apiError(w, fmt.Sprintf("apiValidate: %v", err), err.Error(), http.StatusNotFound)
+			return
+		}
+		payload.SubProj = subProj
+	}
+	res := validation.Validation{Result: proj.Validator(payload.SubProj).ValidateAnnotation(payload)}
+	if res.Result == nil {
+		res.Result = []validation.ValRes{}
+	}
+	apiResponse(w, "apiValidate", http.StatusOK, res)
+}
+
+// apiRoute is a REST API route. The route tables are used both to
+// register the routes, and to generate the OpenAPI document (see
+// schema.go).
+type apiRoute struct {
+	Method  string
+	Path    string // relative to apiPrefix
+	Handler http.HandlerFunc
+	Summary string
+	// Params are the names of the query params
+	Params []string
+	// Request and Response are example values of the JSON request and
+	// response bodies, if any
+	Request  interface{}
+	Response interface{}
+	// Status is the status code of a successful response
+	Status int
+}
+
+func apiRoutes() []apiRoute {
+	res := []apiRoute{
+		{Method: "GET", Path: "/sub_projs", Handler: apiListSubProjs, Summary: "List sub-projects", Response: []apiSubProjInfo{}, Status: http.StatusOK},
+		{Method: "GET", Path: "/stats", Handler: apiStats, Summary: "Statistics per sub-project", Response: map[string]dbapi.SubProjStats{}, Status: http.StatusOK},
+		{Method: "DELETE", Path: "/locks", Handler: apiUnlockAll, Summary: "Release all locks of the client", Response: apiUnlockResult{}, Status: http.StatusOK},
+		{Method: "POST", Path: "/validate", Handler: apiValidate, Summary: "Validate an annotation", Request: protocol.AnnotationPayload{}, Response: validation.Validation{}, Status: http.StatusOK},
+		{Method: "GET", Path: "/sub_projs/{sub_proj}/audio_files", Handler: apiListAudioFiles, Summary: "List audio files", Response: []string{}, Status: http.StatusOK},
+		{Method: "GET", Path: "/sub_projs/{sub_proj}/audio_files/{audio_file}/peaks", Handler: apiAudioFilePeaks, Summary: "Get the waveform peaks of a whole audio file, in audiowaveform JSON format", Params: []string{"samples_per_pixel"}, Response: waveform.Peaks{}, Status: http.StatusOK},
+		{Method: "GET", Path: "/sub_projs/{sub_proj}/audio_files/{audio_file}/spectrogram", Handler: apiAudioFileSpectrogram, Summary: "Get the log-mel spectrogram of a whole audio file, as a PNG image (format=png) or JSON (format=json)", Params: []string{"format"}, Status: http.StatusOK},
+		{Method: "GET", Path: "/sub_projs/{sub_proj}/pages", Handler: apiListPages, Summary: "List pages", Params: []string{"offset", "limit"}, Response: apiPages{}, Status: http.StatusOK},
+		{Method: "GET", Path: "/sub_projs/{sub_proj}/pages/{page_id}", Handler: apiGetAnnotation, Summary: "Get the annotation of a page, with audio if audio=true", Params: []string{"audio", "context"}, Response: protocol.AnnotationWithAudioData{}, Status: http.StatusOK},
+		{Method: "PUT", Path: "/sub_projs/{sub_proj}/pages/{page_id}", Handler: apiSaveAnnotation, Summary: "Save the annotation of a page (locked by the client)", Request: protocol.AnnotationPayload{}, Response: apiSaveResult{}, Status: http.StatusOK},
+		{Method: "GET", Path: "/sub_projs/{sub_proj}/pages/{page_id}/audio", Handler: apiPageAudio, Summary: "Get the audio of a page, or of a chunk of the page, with context in milliseconds (supports Range requests)", Params: []string{"context", "chunk", "channel"}, Status: http.StatusOK},
+		{Method: "GET", Path: "/sub_projs/{sub_proj}/pages/{page_id}/peaks", Handler: apiPagePeaks, Summary: "Get the waveform peaks of the page audio, in audiowaveform JSON format", Params: []string{"context", "chunk", "channel", "samples_per_pixel"}, Response: waveform.Peaks{}, Status: http.StatusOK},
+		{Method: "GET", Path: "/sub_projs/{sub_proj}/pages/{page_id}/spectrogram", Handler: apiPageSpectrogram, Summary: "Get the log-mel spectrogram of the page audio, as a PNG image (format=png) or JSON (format=json)", Params: []string{"context", "chunk", "channel", "format"}, Status: http.StatusOK},
+		{Method: "GET", Path: "/sub_projs/{sub_proj}/pages/{page_id}/segments", Handler: apiPageSegments, Summary: "Split the page audio into chunks of speech (not saved), per channel if per_channel=true, with speaker labels pre-filled from the channels", Params: []string{"segmenter", "per_channel"}, Response: apiSegments{}, Status: http.StatusOK},
+		{Method: "POST", Path: "/sub_projs/{sub_proj}/pages/{page_id}/lock", Handler: apiLockPage, Summary: "Lock a page", Status: http.StatusNoContent},
+		{Method: "DELETE", Path: "/sub_projs/{sub_proj}/pages/{page_id}/lock", Handler: apiUnlockPage, Summary: "Unlock a page", Status: http.StatusNoContent},
+		{Method: "POST", Path: "/sub_projs/{sub_proj}/next", Handler: apiNextPage, Summary: "Get the next page matching a query", Request: apiNextRequest{}, Response: protocol.AnnotationWithAudioData{}, Status: http.StatusOK},
+		{Method: "POST", Path: "/sub_projs/{sub_proj}/query", Handler: apiQuery, Summary: "List annotations matching a query", Params: []string{"offset", "limit"}, Request: protocol.QueryRequest{}, Response: apiQueryResult{}, Status: http.StatusOK},
+	}
+	return append(res, abbrevAPIRoutes()...)
+}
+
+func addAPIRoutes(r *mux.Router) {
+	for _, route := range apiRoutes() {
+		r.HandleFunc(apiPrefix+route.Path, requireAuth(route.Handler)).Methods(route.Method)
+	}
+}
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+
+	"github.com/stts-se/transtool-open/audiocache"
+	"github.com/stts-se/transtool-open/dbapi"
+	"github.com/stts-se/transtool-open/log"
+	"github.com/stts-se/transtool-open/modules/ffmpeg"
+	"github.com/stts-se/transtool-open/protocol"
+)
+
+// ==== PAGE AUDIO
+//
+// The audio of a page (or a chunk of a page) is served over HTTP by
+// apiPageAudio, instead of being sent base64 encoded over websocket.
+// Websocket clients get the URL of the audio in the audio_chunk
+// message (see audioRefFromPage). The audio is extracted on request,
+// and served with support for Range requests and caching headers.
+//
+// Extracted audio is saved in an on-disk cache (see audiocache), if
+// enabled. To make navigation faster, the audio of the next pages
+// matching an editor's query is extracted in the background (see
+// prefetchPages).
+
+// audioMIMETypes are used for audio types without a mime type in the
+// mime package
+var audioMIMETypes = map[string]string{
+	"wav":  "audio/wav",
+	"mp3":  "audio/mpeg",
+	"opus": "audio/ogg",
+	"ogg":  "audio/ogg",
+	"flac": "audio/flac",
+	"m4a":  "audio/mp4",
+}
+
+func audioMIMEType(fileType string) string {
+	if t, ok := audioMIMETypes[fileType]; ok {
+		return t
+	}
+	return mime.TypeByExtension("." + fileType)
+}
+
+// pageAudioURL returns the URL of the audio of a page (see apiPageAudio)
+func pageAudioURL(subProj, pageID string, context int64) string {
+	return fmt.Sprintf("%s/sub_projs/%s/pages/%s/audio?context=%d", apiPrefix, url.PathEscape(proj.SubProjName(subProj)), url.PathEscape(pageID), context)
+}
+
+// audioRefFromPage is like audioFromPage, but instead of extracting
+// the audio, it sets the URL of the audio (see apiPageAudio)
+func audioRefFromPage(annotation protocol.AnnotationPayload, explicitContext int64) (protocol.AnnotationWithAudioData, error) {
+	res := protocol.AnnotationWithAudioData{}
+	context := fallbackContext
+	if explicitContext > 0 {
+		context = explicitContext
+	}
+	if annotation.Page.Audio == "" {
+		return res, fmt.Errorf("audioRefFromPage: no audio for page %s", annotation.Page.ID)
+	}
+	request := protocol.SplitRequestPayload{
+		Chunk:        protocol.Chunk{Start: annotation.Page.Start, End: annotation.Page.End},
+		LeftContext:  context,
+		RightContext: context,
+	}
+	ext := strings.TrimPrefix(filepath.Ext(annotation.Page.Audio), ".")
+
+	res.AnnotationPayload = annotation
+	res.Start = request.Chunk.Start
+	res.End = request.Chunk.End
+	res.Offset = request.WithContext().Start
+	res.FileType = ext
+	res.AudioURL = pageAudioURL(annotation.SubProj, annotation.Page.ID, context)
+	return res, nil
+}
+
+// pageAudioRequest returns the extraction request for the audio of a
+// page (or of a chunk of the page, if the chunk param is set), with
+// the context and channel params, and the file info of the audio file.
+// The channel param is a channel number (1 for the first channel), or
+// "mix" for all channels. It defaults to the channel of the chunk, if
+// any. On failure, an error response is written.
+func pageAudioRequest(w http.ResponseWriter, r *http.Request, caller string) (protocol.SplitRequestPayload, os.FileInfo, bool) {
+	var res protocol.SplitRequestPayload
+	subProj, ok := apiSubProj(w, r, caller)
+	if !ok {
+		return res, nil, false
+	}
+	pageID := mux.Vars(r)["page_id"]
+	annotation, err := proj.Annotation(subProj, pageID)
+	if err != nil {
+		apiError(w, fmt.Sprintf("%s: %v", caller, err), err.Error(), http.StatusNotFound)
+		return res, nil, false
+	}
+
+	var context int64
+	if v := r.URL.Query().Get("context"); v != "" {
+		context, err = strconv.ParseInt(v, 10, 64)
+		if err != nil || context < 0 {
+			msg := fmt.Sprintf("invalid value for context: '%s'", v)
+			apiError(w, caller+": "+msg, msg, http.StatusBadRequest)
+			return res, nil, false
+		}
+	}
+
+	chunk := annotation.Page.Chunk
+	channel := 0
+	if uuid := r.URL.Query().Get("chunk"); uuid != "" {
+		found := false
+		for _, ch := range annotation.Chunks {
+			if ch.UUID == uuid {
+				chunk = ch.Chunk
+				channel = ch.Channel
+				found = true
+				break
+			}
+		}
+		if !found {
+			msg := fmt.Sprintf("no chunk '%s' in page %s", uuid, pageID)
+			apiError(w, caller+": "+msg, msg, http.StatusNotFound)
+			return res, nil, false
+		}
+	}
+	switch v := r.URL.Query().Get("channel"); v {
+	case "":
+	case "mix":
+		channel = 0
+	default:
+		channel, err = strconv.Atoi(v)
+		if err != nil || channel < 1 {
+			msg := fmt.Sprintf("invalid value for channel: '%s'", v)
+			apiError(w, caller+": "+msg, msg, http.StatusBadRequest)
+			return res, nil, false
+		}
+	}
+
+	audioPath, err := proj.BuildAudioPath(subProj, annotation.Page.Audio)
+	if err != nil {
+		msg := fmt.Sprintf("couldn't build audio path : %v", err)
+		apiError(w, caller+": "+msg, msg, http.StatusInternalServerError)
+		return res, nil, false
+	}
+	info, err := os.Stat(audioPath)
+	if err != nil {
+		msg := fmt.Sprintf("no audio for page %s", pageID)
+		apiError(w, fmt.Sprintf("%s: %v", caller, err), msg, http.StatusNotFound)
+		return res, nil, false
+	}
+
+	res = protocol.SplitRequestPayload{
+		Audio:        audioPath,
+		Chunk:        chunk,
+		LeftContext:  context,
+		RightContext: context,
+		Channel:      channel,
+	}
+	return res, info, true
+}
+
+// audioETag returns an ETag for data derived from the audio of a
+// request: the data only changes if the audio file is changed
+func audioETag(request protocol.SplitRequestPayload, info os.FileInfo, variant string) string {
+	chunk := request.WithContext()
+	return fmt.Sprintf(`"%x"`, sha1.Sum([]byte(fmt.Sprintf("%s %d %d %d %d %d %s", request.Audio, info.ModTime().UnixNano(), info.Size(), chunk.Start, chunk.End, request.Channel, variant))))
+}
+
+// notModified sets the caching headers, and returns true (after
+// writing a 304 response) if the client has a fresh copy
+func notModified(w http.ResponseWriter, r *http.Request, etag string) bool {
+	if r.Header.Get("If-None-Match") != etag {
+		return false
+	}
+	setCacheHeaders(w, etag)
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+func setCacheHeaders(w http.ResponseWriter, etag string) {
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "private, max-age=86400")
+}
+
+// serveAudioData serves data derived from an audio file, with caching
+// headers and support for Range requests
+func serveAudioData(w http.ResponseWriter, r *http.Request, etag, contentType string, info os.FileInfo, bts []byte) {
+	setCacheHeaders(w, etag)
+	if contentType != "" {
+		w.Header().Set("Content-Type", contentType)
+	}
+	http.ServeContent(w, r, "", info.ModTime(), bytes.NewReader(bts))
+}
+
+// apiPageAudio: GET /api/v1/sub_projs/{sub_proj}/pages/{page_id}/audio?context=0&chunk=<uuid>&channel=mix
+// Returns the audio of the page, or of the chunk with the given uuid,
+// with context (in milliseconds) on both sides. If a channel is given
+// (or the chunk has a channel), only that channel is returned, as mono
+// audio.
+func apiPageAudio(w http.ResponseWriter, r *http.Request) {
+	request, info, ok := pageAudioRequest(w, r, "apiPageAudio")
+	if !ok {
+		return
+	}
+	etag := audioETag(request, info, "")
+	if notModified(w, r, etag) {
+		return
+	}
+	bts, res, err := extractAudio(request)
+	if err != nil {
+		msg := fmt.Sprintf("failed to extract audio for page %s", mux.Vars(r)["page_id"])
+		apiError(w, fmt.Sprintf("apiPageAudio: chunk extractor failed : %v", err), msg, http.StatusInternalServerError)
+		return
+	}
+	serveAudioData(w, r, etag, audioMIMEType(res.FileType), info, bts)
+}
+
+var audioCache *audiocache.Cache
+
+// extractAudio extracts the chunk of the request, with context, from
+// the audio cache if enabled
+func extractAudio(request protocol.SplitRequestPayload) ([]byte, protocol.AnnotationWithAudioData, error) {
+	if audioCache == nil {
+		return chunkExtractor.ExtractWithContext(request, "")
+	}
+	bts, err := audioCache.Get(audiocache.Key{Request: request}, func() ([]byte, error) {
+		bts, _, err := chunkExtractor.ExtractWithContext(request, "")
+		return bts, err
+	})
+	if err != nil {
+		return nil, protocol.AnnotationWithAudioData{}, err
+	}
+	return bts, ffmpeg.AudioInfo(request, ""), nil
+}
+
+// prefetchJob holds the query of an editor, and the page the editor is
+// currently at
+type prefetchJob struct {
+	subProj  string
+	query    protocol.QueryPayload
+	currID   string
+	clientID dbapi.ClientID
+}
+
+// prefetchJobs is buffered, so that editors never wait for the prefetch
+// worker. If the buffer is full, jobs are dropped.
+var prefetchJobs = make(chan prefetchJob, 32)
+
+// prefetch queues the prefetching of the pages after currID matching
+// the query. Only step-wise navigation (next/previous) is prefetched.
+func prefetch(subProj string, query protocol.QueryPayload, currID string, clientID dbapi.ClientID) {
+	if audioCache == nil || *cfg.Prefetch <= 0 || query.RequestIndex != "" || query.StepSize == 0 {
+		return
+	}
+	select {
+	case prefetchJobs <- prefetchJob{subProj: subProj, query: query, currID: currID, clientID: clientID}:
+	default:
+		log.Warning("[main] Prefetch queue is full, skipping prefetch after page %s", currID)
+	}
+}
+
+// prefetchPages is the prefetch worker: for each job, the audio of the
+// next pages matching the query (in the direction of the query's step
+// size) is extracted to the audio cache. The pages are not locked.
+func prefetchPages() {
+	for job := range prefetchJobs {
+		query := job.query
+		query.StepSize = 1
+		if job.query.StepSize < 0 {
+			query.StepSize = -1
+		}
+		query.CurrID = job.currID
+		for i := 0; i < *cfg.Prefetch; i++ {
+			annotation, _, err := proj.GetNextPage(job.subProj, query, "", job.clientID, false)
+			if err != nil {
+				log.Warning("[main] Prefetch failed : %v", err)
+				break
+			}
+			if annotation.Page.ID == "" {
+				break
+			}
+			query.CurrID = annotation.Page.ID
+
+			audioPath, err := proj.BuildAudioPath(job.subProj, annotation.Page.Audio)
+			if err != nil {
+				log.Warning("[main] Prefetch failed for page %s : %v", annotation.Page.ID, err)
+				continue
+			}
+			context := fallbackContext
+			if query.Context > 0 {
+				context = query.Context
+			}
+			request := protocol.SplitRequestPayload{
+				Audio:        audioPath,
+				Chunk:        annotation.Page.Chunk,
+				LeftContext:  context,
+				RightContext: context,
+			}
+			err = audioCache.Prefetch(audiocache.Key{Request: request}, func() ([]byte, error) {
+				bts, _, err := chunkExtractor.ExtractWithContext(request, "")
+				return bts, err
+			})
+			if err != nil {
+				log.Warning("[main] Prefetch failed for page %s : %v", annotation.Page.ID, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+
+	"github.com/stts-se/transtool-open/dbapi"
+	"github.com/stts-se/transtool-open/validation"
+)
+
+func TestPageAudioURL(t *testing.T) {
+	// two sub projects with the same base name
+	dir := t.TempDir()
+	var subProjs []string
+	for _, sp := range []string{filepath.Join(dir, "a", "sp1"), filepath.Join(dir, "b", "sp1"), filepath.Join(dir, "sp2")} {
+		for _, d := range []string{"source", "annotation"} {
+			if err := os.MkdirAll(filepath.Join(sp, d), 0755); err != nil {
+				t.Fatalf("failed to create dir : %v", err)
+			}
+		}
+		subProjs = append(subProjs, sp)
+	}
+	proj0, err := dbapi.NewProj(strings.Join(subProjs, ":"), &validation.Validator{})
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	proj = &proj0
+
+	r := mux.NewRouter()
+	r.HandleFunc(apiPrefix+"/sub_projs/{sub_proj}/pages/{page_id}/audio", func(w http.ResponseWriter, r *http.Request) {
+		subProj, ok := apiSubProj(w, r, "TestPageAudioURL")
+		if !ok {
+			return
+		}
+		fmt.Fprint(w, subProj)
+	})
+
+	for _, sp := range subProjs {
+		u := pageAudioURL(sp, "p 1", 500)
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, httptest.NewRequest("GET", u, nil))
+		if w, g := http.StatusOK, rec.Code; w != g {
+			t.Errorf("%s: wanted %d got %d (%s)", u, w, g, rec.Body.String())
+		}
+		if w, g := sp, rec.Body.String(); w != g {
+			t.Errorf("wanted '%s' got '%s'", w, g)
+		}
+	}
+	if w, g := apiPrefix+"/sub_projs/sp2/pages/p%201/audio?context=500", pageAudioURL(subProjs[2], "p 1", 500); w != g {
+		t.Errorf("wanted '%s' got '%s'", w, g)
+	}
+}
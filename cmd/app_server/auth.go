@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/stts-se/transtool-open/auth"
+	"github.com/stts-se/transtool-open/log"
+)
+
+// Initialised in main.go
+var authenticator auth.Authenticator
+
+type loginPayload struct {
+	UserName string `json:"user_name"`
+	Password string `json:"password"`
+}
+
+type whoAmI struct {
+	AuthMode string `json:"auth_mode"`
+	auth.Identity
+	LoggedIn bool   `json:"logged_in"`
+	Token    string `json:"token,omitempty"`
+}
+
+// authUserName returns the authenticated user name for the request. If
+// authentication is disabled, the fallback (typically a user name
+// from the URL) is returned.
+func authUserName(r *http.Request, fallback string) (string, error) {
+	if !authenticator.Enabled() {
+		return fallback, nil
+	}
+	id, err := authenticator.Identify(r)
+	if err != nil {
+		return "", err
+	}
+	if fallback != "" && auth.NormaliseUserName(fallback) != id.UserName {
+		log.Warning("[auth] request for user '%s' by authenticated user '%s' (using '%s')", fallback, id.UserName, id.UserName)
+	}
+	return id.UserName, nil
+}
+
+// requireAuth wraps a handler so that it can only be called by authenticated users
+func requireAuth(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if authenticator.Enabled() {
+			if _, err := authenticator.Identify(r); err != nil {
+				httpError(w, fmt.Sprintf("%s: unauthorized request from %s : %v", r.URL.Path, r.RemoteAddr, err), "unauthorized", http.StatusUnauthorized)
+				return
+			}
+		}
+		h(w, r)
+	}
+}
+
+// requireAdmin wraps a handler so that it can only be called by admin users (if authentication is enabled)
+func requireAdmin(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if authenticator.Enabled() {
+			id, err := authenticator.Identify(r)
+			if err != nil {
+				httpError(w, fmt.Sprintf("%s: unauthorized request from %s : %v", r.URL.Path, r.RemoteAddr, err), "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			if !id.Admin {
+				httpError(w, fmt.Sprintf("%s: user %s is not admin", r.URL.Path, id.UserName), "forbidden", http.StatusForbidden)
+				return
+			}
+		}
+		h(w, r)
+	}
+}
+
+func login(w http.ResponseWriter, r *http.Request) {
+	var payload loginPayload
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		err := json.NewDecoder(r.Body).Decode(&payload)
+		if err != nil {
+			msg := fmt.Sprintf("login: failed to unmarshal payload : %v", err)
+			httpError(w, msg, msg, http.StatusBadRequest)
+			return
+		}
+	} else {
+		payload.UserName = r.FormValue("user_name")
+		payload.Password = r.FormValue("password")
+	}
+
+	id, token, err := authenticator.Login(w, r, payload.UserName, payload.Password)
+	if err != nil {
+		httpError(w, fmt.Sprintf("login: failed login for user '%s' from %s : %v", payload.UserName, r.RemoteAddr, err), fmt.Sprintf("login failed: %v", err), http.StatusUnauthorized)
+		return
+	}
+	log.Info("[auth] User %s logged in from %s", id.UserName, r.RemoteAddr)
+
+	writeWhoAmI(w, whoAmI{AuthMode: authenticator.Mode, Identity: id, LoggedIn: true, Token: token})
+}
+
+func logout(w http.ResponseWriter, r *http.Request) {
+	authenticator.Logout(w)
+	writeWhoAmI(w, whoAmI{AuthMode: authenticator.Mode})
+}
+
+func whoami(w http.ResponseWriter, r *http.Request) {
+	res := whoAmI{AuthMode: authenticator.Mode}
+	if authenticator.Enabled() {
+		id, err := authenticator.Identify(r)
+		if err == nil {
+			res.Identity = id
+			res.LoggedIn = true
+		}
+	}
+	writeWhoAmI(w, res)
+}
+
+func writeWhoAmI(w http.ResponseWriter, res whoAmI) {
+	resJSON, err := json.Marshal(res)
+	if err != nil {
+		msg := fmt.Sprintf("Failed to marshal result : %v", err)
+		httpError(w, msg, msg, http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintf(w, "%s\n", string(resJSON))
+}
+
+func newAuthenticator() (auth.Authenticator, error) {
+	var users *auth.UserDB
+	var err error
+	if *cfg.UsersFile != "" {
+		users, err = auth.LoadUserDB(*cfg.UsersFile)
+		if err != nil {
+			return auth.Authenticator{}, err
+		}
+		if len(users.UserNames()) == 0 {
+			log.Warning("[auth] No users in user file %s", *cfg.UsersFile)
+		}
+	}
+
+	var sessions auth.Sessions
+	if *cfg.Auth == auth.ModeLocal {
+		var secret []byte
+		if *cfg.SessionSecretFile != "" {
+			secret, err = auth.ReadSecretFile(*cfg.SessionSecretFile)
+		} else {
+			log.Warning("[auth] No session secret file, using random secret (users will have to log in again after server restart)")
+			secret, err = auth.RandomSecret()
+		}
+		if err != nil {
+			return auth.Authenticator{}, err
+		}
+		sessions, err = auth.NewSessions(secret, auth.DefaultSessionTTL)
+		if err != nil {
+			return auth.Authenticator{}, err
+		}
+	}
+
+	return auth.NewAuthenticator(*cfg.Auth, users, sessions, *cfg.ProxyHeader, strings.Split(*cfg.TrustedProxies, ","))
+}
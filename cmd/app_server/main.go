@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"encoding/base64"
 	"encoding/json"
 	"flag"
 	"fmt"
@@ -21,6 +22,9 @@ import (
 	//	"github.com/rsc/getopt"
 
 	"github.com/stts-se/transtool-open/abbrevs"
+	"github.com/stts-se/transtool-open/audiocache"
+	"github.com/stts-se/transtool-open/audioimport"
+	"github.com/stts-se/transtool-open/auth"
 	"github.com/stts-se/transtool-open/dbapi"
 	"github.com/stts-se/transtool-open/log"
 	"github.com/stts-se/transtool-open/modules"
@@ -43,6 +47,19 @@ type Message struct {
 
 	// Info is for informational messages, nothing is disabled or cleared
 	Info string `json:"info,omitempty"`
+
+	// RequestID is an optional id set by the client. It is echoed in all
+	// messages sent in response to the message, and in the final ack or
+	// nack (see wsRequest).
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// Ack is the payload of the ack and nack messages, sent when a message
+// with a request id has been handled. A nack is sent if an error was
+// sent in response to the message, and Error is the first error.
+type Ack struct {
+	MessageType string `json:"message_type"`
+	Error       string `json:"error,omitempty"`
 }
 
 type AnnotationUnlockAndQueryPayload struct {
@@ -69,16 +86,23 @@ func httpError(w http.ResponseWriter, serverMsg string, clientMsg string, errCod
 	http.Error(w, clientMsg, errCode)
 }
 
-// print serverMsg to server log, send client message over websocket
-func wsError(conn *websocket.Conn, serverMsg string, clientMsg string) {
-	log.Error(serverMsg)
-	payload := Message{
-		Error: clientMsg,
+// subProjParam resolves the subproj URL param (see
+// dbapi.Proj.ResolveSubProj). It returns false if the sub-project
+// doesn't exist (and the error has been sent).
+func subProjParam(w http.ResponseWriter, r *http.Request, caller string) (string, bool) {
+	subProj, err := proj.ResolveSubProj(mux.Vars(r)["subproj"])
+	if err != nil {
+		httpError(w, fmt.Sprintf("%s: %v", caller, err), err.Error(), http.StatusNotFound)
+		return "", false
 	}
-	resJSON, err := json.Marshal(payload)
+	return subProj, true
+}
+
+// wsSend sends a message over websocket
+func wsSend(conn *websocket.Conn, msg Message) {
+	resJSON, err := json.Marshal(msg)
 	if err != nil {
-		msg := fmt.Sprintf("Failed to marshal result : %v", err)
-		log.Error(msg)
+		log.Error("Failed to marshal result : %v", err)
 		return
 	}
 	clientMutex.Lock()
@@ -90,25 +114,16 @@ func wsError(conn *websocket.Conn, serverMsg string, clientMsg string) {
 	}
 }
 
+// print serverMsg to server log, send client message over websocket
+func wsError(conn *websocket.Conn, serverMsg string, clientMsg string) {
+	log.Error(serverMsg)
+	wsSend(conn, Message{Error: clientMsg})
+}
+
 // print serverMsg to server log, send client message as non-recoverable error message over websocket
 func wsFatal(conn *websocket.Conn, serverMsg string, clientMsg string) {
 	log.Error(serverMsg)
-	payload := Message{
-		Fatal: clientMsg,
-	}
-	resJSON, err := json.Marshal(payload)
-	if err != nil {
-		msg := fmt.Sprintf("Failed to marshal result : %v", err)
-		log.Error(msg)
-		return
-	}
-	clientMutex.Lock()
-	defer clientMutex.Unlock()
-
-	err = conn.WriteMessage(websocket.TextMessage, resJSON)
-	if err != nil {
-		log.Error("Couldn't write to conn: %v", err)
-	}
+	wsSend(conn, Message{Fatal: clientMsg})
 }
 
 // print serverMsg to server log, send error message as json to client
@@ -182,10 +197,6 @@ func unlockOrphanedLockedPages() {
 
 func wsHandler(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
-	//HB test (second is pw)
-	//name, _, loggedin := r.BasicAuth()
-	//fmt.Printf("Testing.. username:%s loggedin:%t\n", name, loggedin)
-	//HB end
 
 	clientID := vars["client_id"]
 	if clientID == "" {
@@ -193,7 +204,14 @@ func wsHandler(w http.ResponseWriter, r *http.Request) {
 		jsonError(w, msg, msg)
 		return
 	}
-	userName := vars["user_name"]
+
+	// If authentication is enabled, the authenticated user name is
+	// used, not the one in the URL
+	userName, err := authUserName(r, vars["user_name"])
+	if err != nil {
+		httpError(w, fmt.Sprintf("wsHandler: unauthorized websocket request from %s : %v", r.RemoteAddr, err), "unauthorized", http.StatusUnauthorized)
+		return
+	}
 
 	ws, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
@@ -251,24 +269,7 @@ func wsPayload(conn *websocket.Conn, msgType string, payload interface{}) {
 		log.Error("failed to marshal struct into JSON : %v", err)
 		return
 	}
-	resp := Message{
-		//ClientID:    msg.ClientID,
-		MessageType: msgType,
-		Payload:     string(bts),
-	}
-
-	jsnMsg, err := json.Marshal(resp)
-	if err != nil {
-		msg := fmt.Sprintf("Failed to marshal struct into JSON : %v", err)
-		wsError(conn, msg, msg)
-		return
-	}
-	clientMutex.Lock()
-	defer clientMutex.Unlock()
-	err = conn.WriteMessage(websocket.TextMessage, jsnMsg)
-	if err != nil {
-		log.Error("Couldn't write to conn: %v", err)
-	}
+	wsSend(conn, Message{MessageType: msgType, Payload: string(bts)})
 }
 
 func wsPayloadAllClients(msgType string, payload interface{}) {
@@ -302,24 +303,57 @@ func wsPayloadAllClients(msgType string, payload interface{}) {
 }
 
 func wsInfo(conn *websocket.Conn, msg string) {
-	resp := Message{
-		//ClientID:    msg.ClientID,
-		Info: msg,
-	}
+	wsSend(conn, Message{Info: msg})
+}
+
+// wsRequest is an incoming websocket message being handled. Messages
+// sent using the wsRequest echo the request id of the incoming message,
+// so that the client can tell which request an error or info belongs
+// to.
+type wsRequest struct {
+	conn        *websocket.Conn
+	messageType string
+	requestID   string
+
+	// err is the first error sent in response to the message
+	err string
+}
 
-	jsnMsg, err := json.Marshal(resp)
+func (req *wsRequest) payload(msgType string, payload interface{}) {
+	bts, err := json.Marshal(payload)
 	if err != nil {
-		msg := fmt.Sprintf("Failed to marshal struct into JSON : %v", err)
-		wsError(conn, msg, msg)
+		msg := fmt.Sprintf("failed to marshal struct into JSON : %v", err)
+		req.error(msg, msg)
 		return
 	}
+	wsSend(req.conn, Message{MessageType: msgType, Payload: string(bts), RequestID: req.requestID})
+}
 
-	clientMutex.Lock()
-	defer clientMutex.Unlock()
-	err = conn.WriteMessage(websocket.TextMessage, jsnMsg)
-	if err != nil {
-		log.Error("Couldn't write to conn: %v", err)
+// error prints serverMsg to the server log, and sends clientMsg as a
+// (recoverable) error
+func (req *wsRequest) error(serverMsg string, clientMsg string) {
+	log.Error(serverMsg)
+	if req.err == "" {
+		req.err = clientMsg
 	}
+	wsSend(req.conn, Message{Error: clientMsg, RequestID: req.requestID})
+}
+
+func (req *wsRequest) info(msg string) {
+	wsSend(req.conn, Message{Info: msg, RequestID: req.requestID})
+}
+
+// done sends an ack, or a nack if an error has been sent. Nothing is
+// sent for messages without request id.
+func (req *wsRequest) done() {
+	if req.requestID == "" {
+		return
+	}
+	if req.err != "" {
+		req.payload("nack", Ack{MessageType: req.messageType, Error: req.err})
+		return
+	}
+	req.payload("ack", Ack{MessageType: req.messageType})
 }
 
 // When a websocket connection is estabilshed, the first thing that
@@ -368,15 +402,8 @@ func listenToClient(conn *websocket.Conn, clientID dbapi.ClientID) {
 	wsPayload(conn, "editor_names", editorNames)
 
 	for {
-		var msg Message
-		err := conn.ReadJSON(&msg)
+		_, data, err := conn.ReadMessage()
 		if err != nil {
-			emptyMsg := Message{}
-			if msg != emptyMsg {
-
-				msg := fmt.Sprintf("listenToClient: Websocket error for Message '%#v' : %v", msg, err)
-				log.Error(msg)
-			}
 			clientMutex.Lock()
 			delete(clients, clientID)
 			proj.UnlockAll(clientID)
@@ -387,23 +414,49 @@ func listenToClient(conn *websocket.Conn, clientID dbapi.ClientID) {
 			return
 		}
 
+		// message errors are sent to the client, but the connection is kept
+		var msg Message
+		err = json.Unmarshal(data, &msg)
+		if err != nil {
+			// the message type and request id may still be readable, if
+			// another field is invalid
+			var id struct {
+				MessageType string `json:"message_type"`
+				RequestID   string `json:"request_id"`
+			}
+			json.Unmarshal(data, &id)
+			req := &wsRequest{conn: conn, messageType: id.MessageType, requestID: id.RequestID}
+			msg := fmt.Sprintf("listenToClient: failed to unmarshal message : %v", err)
+			req.error(msg, msg)
+			req.done()
+			continue
+		}
+
 		//log.Info("[main] Payload received over websocket: %#v\n", msg)
 
+		req := &wsRequest{conn: conn, messageType: msg.MessageType, requestID: msg.RequestID}
+		err = validatePayload(msg.MessageType, msg.Payload)
+		if err != nil {
+			msg := fmt.Sprintf("listenToClient: %v", err)
+			req.error(msg, msg)
+			req.done()
+			continue
+		}
+
 		switch msg.MessageType {
 		case "stats":
 			stats := proj.Stats()
-			wsPayload(conn, "stats", stats)
+			req.payload("stats", stats)
 
 		case "saveunlockandnext":
 			var payload AnnotationUnlockAndQueryPayload
 			err := json.Unmarshal([]byte(msg.Payload), &payload)
 			if err != nil {
 				msg := fmt.Sprintf("saveunlockandnext: Failed to unmarshal payload : %v", err)
-				log.Error(msg)
-				wsError(conn, msg, msg)
-				return
+				req.error(msg, msg)
+				break
 			}
-			saveUnlockAndNext(conn, clientID, payload)
+			saveUnlockAndNext(req, clientID, payload)
 			go pushStats()
 
 		case "save":
@@ -411,11 +464,10 @@ func listenToClient(conn *websocket.Conn, clientID dbapi.ClientID) {
 			err := json.Unmarshal([]byte(msg.Payload), &payload)
 			if err != nil {
 				msg := fmt.Sprintf("save: Failed to unmarshal payload : %v", err)
-				log.Error(msg)
-				wsError(conn, msg, msg)
-				return
+				req.error(msg, msg)
+				break
 			}
-			save(conn, payload)
+			save(req, clientID, payload)
 			go pushStats()
 
 		case "unlock":
@@ -423,20 +475,18 @@ func listenToClient(conn *websocket.Conn, clientID dbapi.ClientID) {
 			err := json.Unmarshal([]byte(msg.Payload), &payload)
 			if err != nil {
 				msg := fmt.Sprintf("unlock: Failed to unmarshal payload : %v", err)
-				log.Error(msg)
-				wsError(conn, msg, msg)
-				return
+				req.error(msg, msg)
+				break
 			}
 
 			err = proj.Unlock(payload.SubProj, payload.PageID, clientID)
 			if err != nil {
 				msg := fmt.Sprintf("Couldn't unlock page: %v", err)
-				log.Error(msg)
-				wsError(conn, msg, msg)
-				return
+				req.error(msg, msg)
+				break
 			}
 			msg := fmt.Sprintf("Unlocked page %s for user %v", payload.PageID, clientID)
-			wsPayload(conn, "explicit_unlock_completed", msg)
+			req.payload("explicit_unlock_completed", msg)
 			go pushStats()
 
 		case "unlock_all":
@@ -444,20 +494,18 @@ func listenToClient(conn *websocket.Conn, clientID dbapi.ClientID) {
 			err := json.Unmarshal([]byte(msg.Payload), &payload)
 			if err != nil {
 				msg := fmt.Sprintf("unlock_all: Failed to unmarshal payload : %v", err)
-				log.Error(msg)
-				wsError(conn, msg, msg)
-				return
+				req.error(msg, msg)
+				break
 			}
 
 			n, err := proj.UnlockAll(clientID)
 			if err != nil {
 				msg := fmt.Sprintf("Failed to unlock : %v", err)
-				log.Error(msg)
-				wsError(conn, msg, msg)
-				return
+				req.error(msg, msg)
+				break
 			}
 			msg := fmt.Sprintf("Unlocked %d page%s for user %s", n, pluralS(n), clientID.UserName)
-			wsPayload(conn, "explicit_unlock_completed", msg)
+			req.payload("explicit_unlock_completed", msg)
 			go pushStats()
 
 		case "asr-request":
@@ -476,58 +524,73 @@ func listenToClient(conn *websocket.Conn, clientID dbapi.ClientID) {
 			err := json.Unmarshal([]byte(msg.Payload), &payload)
 			if err != nil {
 				msg := fmt.Sprintf("asr-request: Failed to unmarshal payload : %v", err)
-				log.Error(msg)
-				wsError(conn, msg, msg)
-				return
+				req.error(msg, msg)
+				break
 			}
 			log.Info("[main] payload: %#v", payload)
 			//HB
-			gCloudASR(conn, payload)
+			gCloudASR(req, payload)
 
 		case "validate":
 			var payload protocol.AnnotationPayload
 			err := json.Unmarshal([]byte(msg.Payload), &payload)
 			if err != nil {
 				msg := fmt.Sprintf("validate: Failed to unmarshal payload : %v", err)
-				wsError(conn, msg, msg)
-				return
+				req.error(msg, msg)
+				break
 			}
-			validate(conn, payload)
+			validate(req, payload)
 
 		case "validate_trans":
-			var payload string //protocol.TransChunk
+			var payload protocol.ValidateTransPayload
 			err := json.Unmarshal([]byte(msg.Payload), &payload)
 			if err != nil {
 				msg := fmt.Sprintf("validate_trans_chunk: Failed to unmarshal payload : %v", err)
-				log.Error(msg)
-				wsError(conn, msg, msg)
-				return
+				req.error(msg, msg)
+				break
+			}
+			validateTrans(req, payload)
+
+		case "expand_abbrevs":
+			var payload protocol.ExpandAbbrevsPayload
+			err := json.Unmarshal([]byte(msg.Payload), &payload)
+			if err != nil {
+				msg := fmt.Sprintf("expand_abbrevs: Failed to unmarshal payload : %v", err)
+				req.error(msg, msg)
+				break
 			}
-			validateTrans(conn, payload)
+			expandAbbrevs(req, clientID.UserName, payload)
+
+		case "normalise_preview":
+			var payload string
+			err := json.Unmarshal([]byte(msg.Payload), &payload)
+			if err != nil {
+				msg := fmt.Sprintf("normalise_preview: Failed to unmarshal payload : %v", err)
+				req.error(msg, msg)
+				break
+			}
+			normalisePreview(req, payload)
 
 		case "list-db-audio-files-request":
 			var payload protocol.ListFiles
 			err := json.Unmarshal([]byte(msg.Payload), &payload)
 			if err != nil {
 				msg := fmt.Sprintf("list-db-audio-files-request: Failed to unmarshal payload : %v", err)
-				log.Error(msg)
-				wsError(conn, msg, msg)
-				return
+				req.error(msg, msg)
+				break
 			}
 			if payload.SubProj == "" {
 				msg := "no value for 'sub_proj' in 'list-db-audio-files-request' payload"
-				log.Error(msg)
-				wsError(conn, msg, msg)
-				return
+				req.error(msg, msg)
+				break
 			}
 			res, err := proj.ListAudioFiles(payload.SubProj)
 			if err != nil {
 				msg := fmt.Sprintf("massage type list-db-audio-files-request error : %v", err)
-				log.Error(msg)
-				wsError(conn, msg, msg)
-				return
+				req.error(msg, msg)
+				break
 			}
-			wsPayload(conn, "list-db-audio-files-response", res)
+			req.payload("list-db-audio-files-response", res)
 
 			// NL 20210716 commenting out
 			// "audio_from_page", since it doesn't appear
@@ -554,95 +617,65 @@ func listenToClient(conn *websocket.Conn, clientID dbapi.ClientID) {
 		// 	wsPayload(conn, "audio_for_page_response", res)
 
 		default:
-			log.Error("Unknown message type: %s", msg.MessageType)
+			msg := fmt.Sprintf("Unknown message type: %s", msg.MessageType)
+			req.error(msg, msg)
 		}
+		req.done()
 	}
 }
 
 // TODO initialise validator on cmd line
-func validate(conn *websocket.Conn, payload protocol.AnnotationPayload) {
-	vres := validator.ValidateAnnotation(payload)
+func validate(req *wsRequest, payload protocol.AnnotationPayload) {
+	vres := proj.Validator(payload.SubProj).ValidateAnnotation(payload)
 	if len(vres) > 0 {
 		//fmt.Printf("VALIDATION: %#v\n", vres)
-		wsPayload(conn, "validation_result", validation.Validation{Result: vres})
+		req.payload("validation_result", validation.Validation{Result: vres})
 	}
 }
 
-func validateTrans(conn *websocket.Conn, payload string) {
-	valRes := validator.ValidateTrans(payload)
+// validateTrans validates the transcription of the chunk being edited,
+// with the speaker of the chunk as a leading label, using the validator
+// of the sub-project
+func validateTrans(req *wsRequest, payload protocol.ValidateTransPayload) {
+	valRes := proj.Validator(payload.SubProj).ValidateLabelledTrans(payload.SubProj, payload.TransChunk)
 	if len(valRes) > 0 {
-		wsPayload(conn, "trans_validation_result", validation.Validation{Result: valRes})
+		req.payload("trans_validation_result", validation.Validation{Result: valRes})
 	}
 }
 
 // TODO Global instaniated in main: var gcloudAsr modules.GoogleASR
-func gCloudASR(conn *websocket.Conn, payload protocol.ASRRequest) {
+func gCloudASR(req *wsRequest, payload protocol.ASRRequest) {
 
 	page, err := proj.PageFromID(payload.SubProj, payload.PageID)
 	if err != nil {
 		msg := fmt.Sprintf("db.PageFromID error: %v", err)
-		log.Error(msg)
-		message := Message{
-			Error: msg,
-		}
-		m, err := json.Marshal(message)
-		if err != nil {
-			log.Error("gCloudASR failed to marshal %#v", message)
-			return
-		}
-		clientMutex.Lock()
-		defer clientMutex.Unlock()
-		err = conn.WriteMessage(websocket.TextMessage, m)
-		if err != nil {
-			log.Error("gCloudASR couldn't write message to conn: %v", err)
-		}
+		req.error(msg, msg)
 		return
 	}
-	audioPath, err := proj.BuildAudioPath(payload.SubProj, page.Audio)
+	db := proj.GetDB(payload.SubProj)
+	if db == nil {
+		msg := fmt.Sprintf("gCloudASR: no such sub proj '%s'", payload.SubProj)
+		req.error(msg, msg)
+		return
+	}
+	// use the working copy of the audio, if imported (see the audioimport package)
+	audioPath, err := audioimport.WorkingAudioPath(db, page.Audio)
 	if err != nil {
-		msg := fmt.Sprintf("gCloudASR: db.BuildAudioPath error: %v", err)
-		log.Error(msg)
-		message := Message{
-			Error: msg,
-		}
-		m, err := json.Marshal(message)
-		if err != nil {
-			log.Error("gCloudASR failed to marshal %#v", message)
-			return
-		}
-		clientMutex.Lock()
-		defer clientMutex.Unlock()
-		err = conn.WriteMessage(websocket.TextMessage, m)
-		if err != nil {
-			log.Error("gCloudASR couldn't write message to conn: %v", err)
-		}
+		msg := fmt.Sprintf("gCloudASR: audioimport.WorkingAudioPath error: %v", err)
+		req.error(msg, msg)
 		return
 	}
 
 	info, err := aiExtractor.Process(audioPath)
 	if err != nil {
 		msg := fmt.Sprintf("aiExtractor.Process error: %v", err)
-		log.Error(msg)
-		message := Message{
-			Error: msg,
-		}
-		m, err := json.Marshal(message)
-		if err != nil {
-			log.Error("gCloudASR failed to marshal %#v", message)
-			return
-		}
-		clientMutex.Lock()
-		defer clientMutex.Unlock()
-		err = conn.WriteMessage(websocket.TextMessage, m)
-		if err != nil {
-			log.Error("gCloudASR couldn't write message to conn: %v", err)
-		}
+		req.error(msg, msg)
 		return
 	}
 	config := protocol.ASRConfig{
 		URL:          *cfg.ASRURL,
 		Lang:         payload.Lang,
-		Encoding:     strings.TrimPrefix(filepath.Ext(page.Audio), "."),
+		Encoding:     strings.TrimPrefix(filepath.Ext(audioPath), "."),
 		SampleRate:   int(info.SampleRate),   // 48000,
 		ChannelCount: int(info.ChannelCount), //2,
 	}
@@ -678,22 +711,7 @@ func gCloudASR(conn *websocket.Conn, payload protocol.ASRRequest) {
 		//msg := fmt.Sprintf("googleASR.Process error: %v", err)
 		msg := fmt.Sprintf("ASR Process error: %v", err)
 		//END HB
-		log.Error(msg)
-		message := Message{
-			Error: msg,
-		}
-		m, err := json.Marshal(message)
-		if err != nil {
-			log.Error("gCloudASR failed to marshal %#v", message)
-			return
-		}
-		clientMutex.Lock()
-		defer clientMutex.Unlock()
-
-		err = conn.WriteMessage(websocket.TextMessage, m)
-		if err != nil {
-			log.Error("gCloudASR couldn't write message to conn: %v", err)
-		}
+		req.error(msg, msg)
 		return
 	}
 
@@ -709,7 +727,7 @@ func gCloudASR(conn *websocket.Conn, payload protocol.ASRRequest) {
 	msg := fmt.Sprintf("Got ASR result: %s", txt)
 	log.Info("[main] %v", msg)
 
-	wsPayload(conn, "asr-response", resp)
+	req.payload("asr-response", resp)
 
 }
 
@@ -731,37 +749,13 @@ func pushStats() {
 
 const fallbackContext = int64(0)
 
-func load(conn *websocket.Conn, annotation protocol.AnnotationPayload, explicitContext int64) error {
-	var context int64
-	if explicitContext > 0 {
-		context = explicitContext
-		// } else if ctx, ok := contextMap[annotation.PageType]; ok {
-		// 	context = ctx
-	} else {
-		context = fallbackContext
-	}
-
-	audioPath, err := proj.BuildAudioPath(annotation.SubProj, annotation.Page.Audio)
+// load sends the annotation, with the URL of the page audio (see apiPageAudio)
+func load(req *wsRequest, annotation protocol.AnnotationPayload, explicitContext int64) error {
+	res, err := audioRefFromPage(annotation, explicitContext)
 	if err != nil {
-		return fmt.Errorf("load: couldn't build audio path: %v", err)
-	}
-	request := protocol.SplitRequestPayload{
-		Audio:        audioPath,
-		Chunk:        protocol.Chunk{Start: annotation.Page.Start, End: annotation.Page.End},
-		LeftContext:  context,
-		RightContext: context,
+		return fmt.Errorf("load: %v", err)
 	}
-	res, err := chunkExtractor.ProcessFileWithContext(request, "")
-	if err != nil {
-		serverMsg := fmt.Sprintf("Chunk extractor failed : %v", err)
-		wsError(conn, serverMsg, fmt.Sprintf("Chunk extractor failed for %s. See server log for details.", request.Audio))
-		return nil
-	}
-	res.AnnotationPayload = annotation
-	res.Start = request.Chunk.Start
-	res.End = request.Chunk.End
-
-	wsPayload(conn, "audio_chunk", res)
+	req.payload("audio_chunk", res)
 	return nil
 }
 
@@ -790,12 +784,13 @@ func audioFromPage(annotation protocol.AnnotationPayload, explicitContext int64)
 		LeftContext:  context,
 		RightContext: context,
 	}
-	res, err = chunkExtractor.ProcessFileWithContext(request, "")
+	bts, res, err := extractAudio(request)
 	if err != nil {
 		//serverMsg := fmt.Sprintf("Chunk extractor failed : %v", err)
 		//wsError(conn, serverMsg, fmt.Sprintf("Chunk extractor failed for %s. See server log for details.", request.Audio))
 		return res, fmt.Errorf("chunk extractor failed : %v", err)
 	}
+	res.Base64Audio = base64.StdEncoding.EncodeToString(bts)
 	res.AnnotationPayload = annotation
 	res.Start = request.Chunk.Start
 	res.End = request.Chunk.End
@@ -804,11 +799,27 @@ func audioFromPage(annotation protocol.AnnotationPayload, explicitContext int64)
 	return res, nil
 }
 
-func save(conn *websocket.Conn, payload protocol.AnnotationPayload) {
+// stampSources sets the status source of modified statuses to the
+// authenticated user (only if authentication is enabled)
+func stampSources(clientID dbapi.ClientID, payload *protocol.AnnotationPayload) error {
+	if !authenticator.Enabled() {
+		return nil
+	}
+	return proj.StampSources(payload, clientID.UserName)
+}
+
+func save(req *wsRequest, clientID dbapi.ClientID, payload protocol.AnnotationPayload) {
 	var err error
 	if payload.Page.ID == "" {
 		msg := fmt.Sprintf("Missing page id for annotation data : %v", payload)
-		wsError(conn, msg, msg)
+		req.error(msg, msg)
+		return
+	}
+
+	err = stampSources(clientID, &payload)
+	if err != nil {
+		msg := fmt.Sprintf("Failed to save annotation : %v", err)
+		req.error(msg, msg)
 		return
 	}
 
@@ -818,22 +829,22 @@ func save(conn *websocket.Conn, payload protocol.AnnotationPayload) {
 	err = proj.Save(payload)
 	if err != nil {
 		msg := fmt.Sprintf("Failed to save annotation : %v", err)
-		wsError(conn, msg, msg)
+		req.error(msg, msg)
 		return
 	}
 	log.Info("[main] Saved annotation for page id %s", payload.Page.ID)
 	msg := fmt.Sprintf("Saved annotation for page id %s", payload.Page.ID)
-	wsInfo(conn, msg)
-	validate(conn, payload)
+	req.info(msg)
+	validate(req, payload)
 
 	//updateSubProjListings()
 }
 
-func saveUnlockAndNext(conn *websocket.Conn, clientID dbapi.ClientID, payload AnnotationUnlockAndQueryPayload) {
+func saveUnlockAndNext(req *wsRequest, clientID dbapi.ClientID, payload AnnotationUnlockAndQueryPayload) {
 	var err error
 	if payload.Annotation.Page.ID != "" && payload.Annotation.Page.ID != payload.Unlock.PageID {
 		msg := fmt.Sprintf("Mismatching ids for annotation/unlock data : %v/%v", payload.Annotation.Page.ID, payload.Unlock.PageID)
-		wsError(conn, msg, msg)
+		req.error(msg, msg)
 		return
 	}
 
@@ -843,15 +854,21 @@ func saveUnlockAndNext(conn *websocket.Conn, clientID dbapi.ClientID, payload An
 
 	// save annotation
 	if payload.Annotation.Page.ID != "" {
+		err = stampSources(clientID, &payload.Annotation)
+		if err != nil {
+			msg := fmt.Sprintf("Failed to save annotation : %v", err)
+			req.error(msg, msg)
+			return
+		}
 		err = proj.Save(payload.Annotation)
 		if err != nil {
 			msg := fmt.Sprintf("Failed to save annotation : %v", err)
-			wsError(conn, msg, msg)
+			req.error(msg, msg)
 			return
 		}
 		log.Info("[main] Saved annotation %s", payload.Annotation.Page.ID)
 		msg := fmt.Sprintf("Saved annotation for page with id %s", payload.Annotation.Page.ID)
-		wsInfo(conn, msg)
+		req.info(msg)
 		savedAnnotation = payload.Annotation
 
 	}
@@ -862,18 +879,18 @@ func saveUnlockAndNext(conn *websocket.Conn, clientID dbapi.ClientID, payload An
 	query := payload.Query
 	if strings.TrimSpace(clientID.UserName) == "" {
 		msg := "User name not provided for query"
-		wsError(conn, msg, msg)
+		req.error(msg, msg)
 		return
 	}
 	if strings.TrimSpace(clientID.ID) == "" {
 		msg := "Client ID not provided for query"
-		wsError(conn, msg, msg)
+		req.error(msg, msg)
 		return
 	}
 
 	if query.StepSize == 0 && query.RequestIndex == "" {
 		msg := "Neither step size nor request index was provided for query"
-		wsError(conn, msg, msg)
+		req.error(msg, msg)
 		return
 	}
 	if query.CurrID == "undefined" {
@@ -888,17 +905,20 @@ func saveUnlockAndNext(conn *websocket.Conn, clientID dbapi.ClientID, payload An
 
 	if payload.Annotation.SubProj == "" {
 		msg := "Missing value in payload for annotation.sub_proj"
-		wsError(conn, msg, msg)
+		req.error(msg, msg)
 		return
 	}
 
 	aPage, msg, err := proj.GetNextPage(payload.Annotation.SubProj, query, payload.Unlock.PageID, clientID, true)
 	if err != nil {
 		msg := fmt.Sprintf("%v", err)
-		wsError(conn, msg, msg)
+		req.error(msg, msg)
 		return
 	}
 	if msg == "" || aPage.Page.ID != "" {
+		// the sub project may have its own validation config
+		req.payload("validation_config", proj.Validator(aPage.SubProj).Config())
+
 		// NL 20210609: i load fylls ljudet i, *och* skrivs till klienten
 		//err = load(conn, aPage, query.Context)
 		//if err != nil {
@@ -911,19 +931,21 @@ func saveUnlockAndNext(conn *websocket.Conn, clientID dbapi.ClientID, payload An
 		// NL 20210615: TMP: same as before, but split into separate steps
 
 		if payload.ReturnAudio {
-			annoWithAudio, err := audioFromPage(aPage, query.Context)
+			// the audio is fetched by the client from the audio URL
+			annoWithAudio, err := audioRefFromPage(aPage, query.Context)
 			if err != nil {
-				msg := fmt.Sprintf("saveUnlockAndNext: failed to extract audio for page : %v", err)
+				msg := fmt.Sprintf("saveUnlockAndNext: failed to get audio for page : %v", err)
 				log.Info(msg)
-				wsError(conn, msg, msg)
+				req.error(msg, msg)
 				return
 
 			}
 
-			wsPayload(conn, "audio_chunk", annoWithAudio)
+			req.payload("audio_chunk", annoWithAudio)
+			prefetch(aPage.SubProj, query, aPage.Page.ID, clientID)
 		} else {
 			//NL 20210615: Use this to return page without audio:
-			wsPayload(conn, "annotation_no_audio", aPage)
+			req.payload("annotation_no_audio", aPage)
 		}
 	} else {
 		msgFmted := ""
@@ -934,10 +956,10 @@ func saveUnlockAndNext(conn *websocket.Conn, clientID dbapi.ClientID, payload An
 			reqI, err := strconv.Atoi(query.RequestIndex)
 			if err == nil {
 				msg := fmt.Sprintf("Couldn't go to page %d%s", (reqI + 1), msgFmted)
-				wsPayload(conn, "no_audio_chunk", msg)
+				req.payload("no_audio_chunk", msg)
 			} else {
 				msg := fmt.Sprintf("Couldn't go to %s page%s", query.RequestIndex, msgFmted)
-				wsPayload(conn, "no_audio_chunk", msg)
+				req.payload("no_audio_chunk", msg)
 			}
 		} else {
 			direction := "next"
@@ -946,13 +968,13 @@ func saveUnlockAndNext(conn *websocket.Conn, clientID dbapi.ClientID, payload An
 			}
 			//msg := fmt.Sprintf("Couldn't find any %s pages matching status %v%s", direction, query.RequestStatus, msgFmted)
 			msg := fmt.Sprintf("Couldn't find any %s pages%s", direction, msgFmted)
-			wsPayload(conn, "no_audio_chunk", msg)
+			req.payload("no_audio_chunk", msg)
 		}
 		if savedAnnotation.Page.ID != "" {
-			err = load(conn, savedAnnotation, query.Context)
+			err = load(req, savedAnnotation, query.Context)
 			if err != nil {
 				msg := fmt.Sprintf("Couldn't load annotation: %v", err)
-				wsError(conn, msg, msg)
+				req.error(msg, msg)
 			}
 		}
 
@@ -964,11 +986,11 @@ func saveUnlockAndNext(conn *websocket.Conn, clientID dbapi.ClientID, payload An
 		err = proj.Unlock(payload.Annotation.SubProj, payload.Unlock.PageID, clientID)
 		if err != nil {
 			msg := fmt.Sprintf("Couldn't unlock page: %v", err)
-			wsError(conn, msg, msg)
+			req.error(msg, msg)
 			return
 		}
 		msg := fmt.Sprintf("Unlocked page %s for user %s", payload.Unlock.PageID, clientID.UserName)
-		wsInfo(conn, msg)
+		req.info(msg)
 	}
 
 }
@@ -992,28 +1014,13 @@ func generateDoc(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprint(w, "</body></html>")
 }
 
-type Pair struct {
-	Name string
-	JSN  string
-}
-
-func ptcl() ([]Pair, error) {
-	var res []Pair
-
-	p1, err := json.MarshalIndent(AnnotationUnlockAndQueryPayload{}, "", " ")
-	if err != nil {
-		return res, err
-	}
-	res = append(res, Pair{"saveunlockandnext", string(p1)})
-
-	return res, nil
-}
-
 func generateProtocolDoc() (string, error) {
 	//var res string
 
-	tmplStr := `<p><h1>Protocol structs</h1><p>{{ range . }} 
-<h2>{{ .Name }}</h2><pre>{{ .JSN }}</pre>
+	tmplStr := `<p><h1>Websocket message types</h1>
+<p>JSON Schema: <a href="/doc/schema.json">/doc/schema.json</a>, AsyncAPI: <a href="/doc/asyncapi.json">/doc/asyncapi.json</a>, REST API (OpenAPI): <a href="/doc/openapi.json">/doc/openapi.json</a></p>
+{{ range . }}
+<h2>{{ .Name }}</h2><p>{{ .Info }}</p>{{ if .JSN }}<pre>{{ .JSN }}</pre>{{ end }}
 {{ end }}`
 
 	t := template.New("protocol")
@@ -1173,6 +1180,33 @@ func listProjects(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprintf(w, "%s", string(resJSON))
 }
 
+func validateCorpus(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	subProj0 := params["subproj"]
+	log.Info("[main] Requesting corpus validation of sub project %v", subProj0)
+	subProj, ok := subProjParam(w, r, "validateCorpus")
+	if !ok {
+		return
+	}
+	res, err := proj.ValidateCorpus(subProj)
+	if err != nil {
+		msg := fmt.Sprintf("error: Corpus validation failed: %v", err)
+		log.Error("validateCorpus: " + msg)
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+	res.SubProj = subProj0
+	resJSON, err := json.Marshal(res)
+	if err != nil {
+		msg := fmt.Sprintf("Failed to marshal result : %v", err)
+		log.Error(msg)
+		http.Error(w, msg, http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintf(w, "%s", string(resJSON))
+}
+
 func reloadValidationConfig(w http.ResponseWriter, r *http.Request) {
 
 	remote := r.RemoteAddr
@@ -1209,6 +1243,14 @@ func reloadValidationConfig(w http.ResponseWriter, r *http.Request) {
 	}
 	validator = v
 
+	err = proj.ReloadValidationConfigs()
+	if err != nil {
+		msg := fmt.Sprintf("Failed to reload sub project validation configs : %v", err)
+		log.Error(msg)
+		http.Error(w, msg+"\n", http.StatusInternalServerError)
+		return
+	}
+
 	fmt.Fprintf(w, "Loaded validation config file:\n%s\n", string(vConfBts))
 
 	//newValidator, err := validation.NewValidator(valCfgFileName)
@@ -1234,6 +1276,7 @@ type Config struct {
 	GCloudCredentials    *string `json:"gcloud_credentials"`
 	AbbrevDir            *string `json:"abbrev_dir"`
 	ValidationConfigFile *string `json:"validation_config_file"`
+	NormaliseConfigFile  *string `json:"normalise_config_file"`
 
 	// AdminMode enables "unsafe" RestAPI calls reload/unload/load/list
 	AdminMode *bool `json:"admin"`
@@ -1244,6 +1287,18 @@ type Config struct {
 
 	// HL added 20230530
 	ASRURL *string `json:"asr_url"`
+
+	// Auth is the authentication mode: none, local or proxy
+	Auth              *string `json:"auth"`
+	UsersFile         *string `json:"users_file"`
+	SessionSecretFile *string `json:"session_secret_file"`
+	ProxyHeader       *string `json:"proxy_header"`
+	TrustedProxies    *string `json:"trusted_proxies"`
+
+	// AudioCacheDir is the directory of the extracted audio cache (empty: no cache)
+	AudioCacheDir  *string `json:"audio_cache_dir"`
+	AudioCacheSize *int64  `json:"audio_cache_size"`
+	Prefetch       *int    `json:"prefetch"`
 }
 
 // HB
@@ -1281,9 +1336,20 @@ func main() {
 	cfg.EnableAutoplay = flag.Bool("enable_autoplay", false, "Enable autoplay (experimental)")
 
 	cfg.ValidationConfigFile = flag.String("validation_config", "", "Validation config JSON file path. Example file: validation/sample_validation_config.json")
+	cfg.NormaliseConfigFile = flag.String("normalise_config", "", "Normalisation config JSON file path, used for normalisation preview (default: strip labels, remove punctuation and lowercase)")
 
 	cfg.ASRURL = flag.String("asr_url", "http://localhost:8887/recognise", "ASR `URL`")
 
+	cfg.Auth = flag.String("auth", auth.ModeNone, "Authentication `mode`: none (trust user name from client), local (user file with bcrypt passwords) or proxy (user name header set by reverse proxy)")
+	cfg.UsersFile = flag.String("users_file", "", "User file for auth mode local (optional for mode proxy, to define admin users). Use cmd/transtool_user to add users")
+	cfg.SessionSecretFile = flag.String("session_secret_file", "", "Session secret `file` for auth mode local (created if it doesn't exist). If not set, sessions are invalidated on server restart")
+	cfg.ProxyHeader = flag.String("proxy_header", "X-Remote-User", "User name `header` for auth mode proxy")
+	cfg.TrustedProxies = flag.String("trusted_proxies", "127.0.0.1,::1", "Comma separated list of IP addresses allowed to set the proxy header in auth mode proxy")
+
+	cfg.AudioCacheDir = flag.String("audio_cache_dir", filepath.Join(os.TempDir(), "transtool_audio_cache"), "Cache `directory` for extracted audio (empty to disable the cache)")
+	cfg.AudioCacheSize = flag.Int64("audio_cache_size", 500, "Max size of the audio cache in MB")
+	cfg.Prefetch = flag.Int("prefetch", 3, "Number of pages to prefetch audio for when an editor navigates (requires audio cache)")
+
 	help := flag.Bool("help", false, "Print usage and exit")
 	flag.Parse()
 
@@ -1373,6 +1439,14 @@ func main() {
 	vDatorcfgJSON, _ := json.MarshalIndent(validator.Config(), "", "\t")
 	log.Info("[main] Validator config:\n%s\n\n", string(vDatorcfgJSON))
 
+	authenticator, err = newAuthenticator()
+	if err != nil {
+		log.Fatal("Failed to initialise authentication : %v", err)
+	}
+	if !authenticator.Enabled() {
+		log.Warning("Authentication is disabled: user names supplied by clients are trusted. Use -auth to enable authentication")
+	}
+
 	proj0, err := dbapi.NewProj(*cfg.ProjectDirs, &validator)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "failed to load project dir : %v", err)
@@ -1394,11 +1468,28 @@ func main() {
 		log.Warning("!!! No abbreviation lists for '%s'", *cfg.ProjectDirs)
 	}
 
+	normaliser, err = newNormaliser()
+	if err != nil {
+		log.Fatal("Couldn't initialize normaliser: %v", err)
+	}
+
 	ffmpeg.FfmpegCmd = *cfg.Ffmpeg
 	chunkExtractor, err = ffmpeg.NewChunkExtractor()
 	if err != nil {
 		log.Fatal("Couldn't initialize chunk extractor: %v", err)
 	}
+	if err := ffmpeg.Enabled(); err != nil {
+		log.Warning("%v: only PCM WAV audio can be extracted. Use -ffmpeg to set the ffmpeg command", err)
+	}
+
+	if *cfg.AudioCacheDir != "" {
+		audioCache, err = audiocache.New(*cfg.AudioCacheDir, *cfg.AudioCacheSize*1024*1024)
+		if err != nil {
+			log.Fatal("Couldn't initialize audio cache: %v", err)
+		}
+		log.Info("[main] Audio cache: %s (%d MB)", *cfg.AudioCacheDir, *cfg.AudioCacheSize)
+		go prefetchPages()
+	}
 
 	if *cfg.GCloudCredentials != "" {
 		googleASR, err = modules.NewGoogleASR(*cfg.GCloudCredentials)
@@ -1424,17 +1515,29 @@ func main() {
 	r := mux.NewRouter()
 	r.StrictSlash(true)
 
-	r.HandleFunc("/doc/", generateDoc).Methods("GET")
+	r.HandleFunc("/doc/", requireAuth(generateDoc)).Methods("GET")
+	r.HandleFunc("/doc/schema.json", requireAuth(serveProtocolDoc(func(d protocolDocs) []byte { return d.schema }))).Methods("GET")
+	r.HandleFunc("/doc/asyncapi.json", requireAuth(serveProtocolDoc(func(d protocolDocs) []byte { return d.asyncAPI }))).Methods("GET")
+	r.HandleFunc("/doc/openapi.json", requireAuth(serveProtocolDoc(func(d protocolDocs) []byte { return d.openAPI }))).Methods("GET")
 	r.HandleFunc("/ws/{client_id}/{user_name}", wsHandler)
+	r.HandleFunc("/ws/{client_id}", wsHandler)
 	if !*cfg.BlockAudio {
-		r.HandleFunc("/audio/{file}", serveAudio).Methods("GET")
+		r.HandleFunc("/audio/{file}", requireAuth(serveAudio)).Methods("GET")
 	}
 
+	r.HandleFunc("/auth/login", login).Methods("POST")
+	r.HandleFunc("/auth/logout", logout)
+	r.HandleFunc("/auth/whoami", whoami).Methods("GET")
+
 	if *cfg.AdminMode {
-		r.HandleFunc("/admin/unload/{subproj}", unloadProject)
-		r.HandleFunc("/admin/reload/{subproj}", reloadProject)
-		r.HandleFunc("/admin/load/{subproj}", addProject)
-		r.HandleFunc("/admin/list_projects", listProjects)
+		r.HandleFunc("/admin/unload/{subproj}", requireAdmin(unloadProject))
+		r.HandleFunc("/admin/reload/{subproj}", requireAdmin(reloadProject))
+		r.HandleFunc("/admin/load/{subproj}", requireAdmin(addProject))
+		r.HandleFunc("/admin/list_projects", requireAdmin(listProjects))
+		r.HandleFunc("/admin/validate_corpus/{subproj}", requireAdmin(validateCorpus))
+		r.HandleFunc("/admin/expand_abbrevs/{subproj}/apply", requireAdmin(expandAbbrevsSubProj(true))).Methods("POST")
+		r.HandleFunc("/admin/expand_abbrevs/{subproj}/{lists}", requireAdmin(expandAbbrevsSubProj(false))).Methods("GET")
+		r.HandleFunc("/admin/analyse_quality/{subproj}", requireAdmin(analyseQuality)).Methods("POST")
 	}
 
 	docs := make(map[string]string)
@@ -1464,16 +1567,27 @@ func main() {
 
 	r.HandleFunc("/has_asr", hasASR)
 
-	r.HandleFunc("/abbrev/list_lists", listLists)
-	r.HandleFunc("/abbrev/list_lists_with_length", listListsWithLength)
-	r.HandleFunc("/abbrev/create_new_list/{list_name}", createNewList)
-	r.HandleFunc("/abbrev/delete_list/{list_name}", deleteList)
-	r.HandleFunc("/abbrev/list_abbrevs/{list_name}", listAbbrevs)
-	r.HandleFunc("/abbrev/add/{list_name}/{abbrev}/{expansion}", addAbbrev)
-	r.HandleFunc("/abbrev/add_create_list_if_not_exists/{list_name}/{abbrev}/{expansion}", addAbbrevCreateListIfNotExists)
-	r.HandleFunc("/abbrev/delete/{list_name}/{abbrev}", deleteAbbrev)
-
-	r.HandleFunc("/reload_validation_config", reloadValidationConfig)
+	r.HandleFunc("/abbrev/list_lists", requireAuth(listLists))
+	r.HandleFunc("/abbrev/list_lists_with_length", requireAuth(listListsWithLength))
+	// deprecated: use the REST API (see abbrevAPIRoutes)
+	r.HandleFunc("/abbrev/create_new_list/{list_name}", requireAuth(deprecatedRoute("POST", abbrevAPIPrefix+"/lists", createNewList)))
+	r.HandleFunc("/abbrev/delete_list/{list_name}", requireAuth(deprecatedRoute("DELETE", abbrevAPIPrefix+"/lists/{name}", deleteList)))
+	r.HandleFunc("/abbrev/list_abbrevs/{list_name}", requireAuth(deprecatedRoute("GET", abbrevAPIPrefix+"/lists/{name}/entries", listAbbrevs)))
+	r.HandleFunc("/abbrev/add/{list_name}/{abbrev}/{expansion}", requireAuth(deprecatedRoute("POST", abbrevAPIPrefix+"/lists/{name}/entries", addAbbrev)))
+	r.HandleFunc("/abbrev/add_create_list_if_not_exists/{list_name}/{abbrev}/{expansion}", requireAuth(deprecatedRoute("POST", abbrevAPIPrefix+"/lists/{name}/entries?create_list=true", addAbbrevCreateListIfNotExists)))
+	r.HandleFunc("/abbrev/delete/{list_name}/{abbrev}", requireAuth(deprecatedRoute("DELETE", abbrevAPIPrefix+"/lists/{name}/entries", deleteAbbrev)))
+
+	r.HandleFunc("/abbrev/import/{list_name}", requireAuth(importAbbrevs)).Methods("POST")
+	r.HandleFunc("/abbrev/export/{list_name}", requireAuth(exportAbbrevs)).Methods("GET")
+	r.HandleFunc("/abbrev/merge/{from}/{to}", requireAuth(mergeAbbrevLists)).Methods("POST")
+	r.HandleFunc("/abbrev/compact/{list_name}", requireAuth(compactAbbrevList)).Methods("POST")
+	r.HandleFunc("/abbrev/duplicates", requireAuth(abbrevDuplicates)).Methods("GET")
+	r.HandleFunc("/abbrev/usage/{list_name}", requireAuth(abbrevUsage)).Methods("GET")
+	r.HandleFunc("/abbrev/suggestions/{list_name}", requireAuth(abbrevSuggestions)).Methods("GET")
+
+	r.HandleFunc("/reload_validation_config", requireAdmin(reloadValidationConfig))
+
+	addAPIRoutes(r)
 
 	r.PathPrefix("/").Handler(http.StripPrefix("/", http.FileServer(http.Dir(*cfg.StaticDir))))
 
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"os"
+
+	"github.com/stts-se/transtool-open/log"
+	"github.com/stts-se/transtool-open/normalise"
+)
+
+// Initialised in main.go
+var normaliser normalise.Normaliser
+
+// newNormaliser reads the normalise config file, if any. Otherwise
+// normalise.ConfigExample is used, with the label prefix and suffix of
+// the validation config.
+func newNormaliser() (normalise.Normaliser, error) {
+	if *cfg.NormaliseConfigFile != "" {
+		bts, err := os.ReadFile(*cfg.NormaliseConfigFile)
+		if err != nil {
+			return normalise.Normaliser{}, err
+		}
+		return normalise.NewNormaliserFromJSON(bts, &abbrevManager)
+	}
+
+	c := normalise.ConfigExample
+	c.LabelPrefix = validator.Config().LabelPrefix
+	c.LabelSuffix = validator.Config().LabelSuffix
+	log.Info("[main] No normalise config file, using default normalisation (strip labels, remove punctuation, lowercase)")
+	return normalise.NewNormaliser(c, &abbrevManager)
+}
+
+func normalisePreview(req *wsRequest, trans string) {
+	req.payload("normalise_preview", normaliser.Normalise(trans))
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/mux"
+
+	"github.com/stts-se/transtool-open/dbapi"
+	"github.com/stts-se/transtool-open/log"
+	"github.com/stts-se/transtool-open/modules/quality"
+	"github.com/stts-se/transtool-open/protocol"
+)
+
+// ==== AUDIO QUALITY
+//
+// The audio quality of pages and chunks (clipping, levels, SNR
+// estimate and silence ratio, see the quality package) is analysed in
+// the server, so that pages locked by editors are left alone, and the
+// annotations in memory stay up to date. With auto_skip, pages matching
+// a quality condition are marked as skip, if no work has been done on
+// them (see dbapi.DBAPI.UpdateQuality).
+
+type analyseQualityPayload struct {
+	// AutoSkip is a quality condition, such as "snr < 10 dB" (see
+	// dbapi.QualityCondition), or empty for no auto-skip
+	AutoSkip string `json:"auto_skip"`
+	// Force is true to analyse pages already analysed
+	Force bool `json:"force"`
+}
+
+// analyseQuality: POST /admin/analyse_quality/{subproj} {"auto_skip": ..., "force": ...}
+func analyseQuality(w http.ResponseWriter, r *http.Request) {
+	subProj0 := mux.Vars(r)["subproj"]
+	subProj, ok := subProjParam(w, r, "analyseQuality")
+	if !ok {
+		return
+	}
+
+	var payload analyseQualityPayload
+	if err := decodeJSONBody(r, &payload); err != nil {
+		msg := fmt.Sprintf("analyseQuality: %v", err)
+		httpError(w, msg, msg, http.StatusBadRequest)
+		return
+	}
+	if payload.AutoSkip != "" {
+		if _, err := dbapi.ParseQualityCondition(payload.AutoSkip); err != nil {
+			msg := fmt.Sprintf("analyseQuality: %v", err)
+			httpError(w, msg, msg, http.StatusBadRequest)
+			return
+		}
+	}
+	analyser, err := quality.NewDefaultAnalyser()
+	if err != nil {
+		msg := fmt.Sprintf("analyseQuality: failed to initialise quality analyser : %v", err)
+		httpError(w, msg, msg, http.StatusInternalServerError)
+		return
+	}
+	log.Info("[main] Analysing audio quality in sub project %s (auto skip: '%s', force: %v)", subProj0, payload.AutoSkip, payload.Force)
+
+	analyse := func(a protocol.AnnotationPayload) (protocol.AnnotationPayload, error) {
+		audioFile, err := proj.BuildAudioPath(subProj, a.Page.Audio)
+		if err != nil {
+			return a, err
+		}
+		return analyser.AnalyseAnnotation(audioFile, a)
+	}
+	res, err := proj.UpdateQuality(subProj, analyse, payload.AutoSkip, payload.Force)
+	if err != nil {
+		msg := fmt.Sprintf("analyseQuality: %v", err)
+		httpError(w, msg, msg, http.StatusBadRequest)
+		return
+	}
+	res.SubProj = subProj0
+	log.Info("[main] Analysed %d pages in sub project %s (%d marked as skip, %d locked)", res.Analysed, subProj0, len(res.AutoSkipped), len(res.SkippedLocked))
+
+	writeJSONResponse(w, "analyseQuality", res)
+}
@@ -0,0 +1,352 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/stts-se/transtool-open/abbrevs"
+	"github.com/stts-se/transtool-open/dbapi"
+	"github.com/stts-se/transtool-open/log"
+	"github.com/stts-se/transtool-open/normalise"
+	"github.com/stts-se/transtool-open/protocol"
+	"github.com/stts-se/transtool-open/validation"
+)
+
+// ==== PROTOCOL SCHEMA
+//
+// messageTypes is the registry of websocket message types. The
+// payload of a Message is the JSON encoding of the registered payload
+// type. The registry is used to generate the protocol documents served
+// under /doc/ (JSON Schema, and AsyncAPI for the websocket protocol),
+// and to validate the payloads of incoming messages (see
+// validatePayload). The OpenAPI document for the REST API is generated
+// from the route tables (see apiRoutes).
+//
+// When adding a message type to listenToClient, or a new call to
+// wsPayload, the message type should be added here as well.
+
+type messageType struct {
+	Name string
+	// FromClient is true for messages sent by the client, and false for
+	// messages sent by the server
+	FromClient bool
+	// Payload is a value of the payload type, or nil if the message has no payload
+	Payload interface{}
+	Doc     string
+}
+
+var messageTypes = []messageType{
+	// client -> server
+	{Name: "stats", FromClient: true, Doc: "Request statistics (response: stats)"},
+	{Name: "saveunlockandnext", FromClient: true, Payload: AnnotationUnlockAndQueryPayload{}, Doc: "Save an annotation, unlock the page, and get the next page matching the query (response: audio_chunk, annotation_no_audio or no_audio_chunk)"},
+	{Name: "save", FromClient: true, Payload: protocol.AnnotationPayload{}, Doc: "Save an annotation"},
+	{Name: "unlock", FromClient: true, Payload: protocol.UnlockPayload{}, Doc: "Unlock a page (response: explicit_unlock_completed)"},
+	{Name: "unlock_all", FromClient: true, Payload: protocol.UnlockPayload{}, Doc: "Unlock all pages of the client (response: explicit_unlock_completed)"},
+	{Name: "asr-request", FromClient: true, Payload: protocol.ASRRequest{}, Doc: "Run ASR on a chunk (response: asr-response)"},
+	{Name: "validate", FromClient: true, Payload: protocol.AnnotationPayload{}, Doc: "Validate an annotation (response: validation_result, if there are issues)"},
+	{Name: "validate_trans", FromClient: true, Payload: protocol.ValidateTransPayload{}, Doc: "Validate the transcription of a chunk in a sub-project, with its speaker as a leading label (response: trans_validation_result, if there are issues)"},
+	{Name: "expand_abbrevs", FromClient: true, Payload: protocol.ExpandAbbrevsPayload{}, Doc: "Expand abbreviations in a transcription (response: expand_abbrevs)"},
+	{Name: "normalise_preview", FromClient: true, Payload: "", Doc: "Normalise a transcription, without saving (response: normalise_preview)"},
+	{Name: "list-db-audio-files-request", FromClient: true, Payload: protocol.ListFiles{}, Doc: "List the audio files of a sub-project (response: list-db-audio-files-response)"},
+
+	// server -> client
+	{Name: "enable_autoplay", Payload: true, Doc: "Sent on connect, if autoplay is enabled"},
+	{Name: "no_delete", Payload: true, Doc: "Sent on connect, if deleting chunks is disabled"},
+	{Name: "project_name", Payload: "", Doc: "The sub-projects, separated by colon"},
+	{Name: "validation_config", Payload: validation.Config{}, Doc: "The validation config"},
+	{Name: "stats", Payload: map[string]dbapi.SubProjStats{}, Doc: "Statistics per sub-project"},
+	{Name: "editor_names", Payload: []string{}, Doc: "The status sources (editors) of the project"},
+	{Name: "explicit_unlock_completed", Payload: "", Doc: "Unlock message"},
+	{Name: "list-db-audio-files-response", Payload: []string{}, Doc: "The audio files of a sub-project"},
+	{Name: "validation_result", Payload: validation.Validation{}, Doc: "Validation issues of an annotation"},
+	{Name: "trans_validation_result", Payload: validation.Validation{}, Doc: "Validation issues of a transcription"},
+	{Name: "asr-response", Payload: protocol.ASRResponse{}, Doc: "ASR result"},
+	{Name: "audio_chunk", Payload: protocol.AnnotationWithAudioData{}, Doc: "An annotation, with the URL of the page audio"},
+	{Name: "annotation_no_audio", Payload: protocol.AnnotationPayload{}, Doc: "An annotation, without audio"},
+	{Name: "no_audio_chunk", Payload: "", Doc: "No page matching the query"},
+	{Name: "expand_abbrevs", Payload: abbrevs.ExpandResult{}, Doc: "Expanded transcription"},
+	{Name: "normalise_preview", Payload: normalise.Result{}, Doc: "Normalised transcription"},
+	{Name: "ack", Payload: Ack{}, Doc: "A message with request id has been handled"},
+	{Name: "nack", Payload: Ack{}, Doc: "A message with request id has been handled, and an error was sent"},
+}
+
+func (m messageType) direction() string {
+	if m.FromClient {
+		return "client"
+	}
+	return "server"
+}
+
+// key is the message name, prefixed by the direction, since some
+// message names are used in both directions with different payloads
+func (m messageType) key() string {
+	return m.direction() + "." + m.Name
+}
+
+func (m messageType) goType() string {
+	if m.Payload == nil {
+		return "-"
+	}
+	return fmt.Sprintf("%T", m.Payload)
+}
+
+// envelopeSchema is the schema of a Message with the payload of m,
+// encoded as a JSON string
+func envelopeSchema(g *protocol.SchemaGenerator, m messageType) *protocol.Schema {
+	str := func(doc string) *protocol.Schema {
+		return &protocol.Schema{Type: protocol.SchemaType{"string"}, Description: doc}
+	}
+	payload := str("")
+	if m.Payload != nil {
+		payload.ContentMediaType = "application/json"
+		payload.ContentSchema = g.Schema(m.Payload)
+	}
+	return &protocol.Schema{
+		Title:       m.Name,
+		Description: m.Doc,
+		Type:        protocol.SchemaType{"object"},
+		Properties: map[string]*protocol.Schema{
+			"message_type": {Type: protocol.SchemaType{"string"}, Const: m.Name},
+			"payload":      payload,
+			"fatal":        str("Non-recoverable error"),
+			"error":        str("Recoverable error"),
+			"info":         str("Informational message"),
+			"request_id":   str("Optional id of a client message, echoed in all messages sent in response to it"),
+		},
+		Required: []string{"message_type"},
+	}
+}
+
+// protocolDocs holds the generated protocol documents, and the payload
+// schemas of client messages, used for validation
+type protocolDocs struct {
+	validator      *protocol.SchemaGenerator
+	clientPayloads map[string]*protocol.Schema
+
+	schema   []byte
+	openAPI  []byte
+	asyncAPI []byte
+}
+
+var (
+	docsOnce  sync.Once
+	protoDocs protocolDocs
+	docsErr   error
+)
+
+// getProtocolDocs generates the protocol documents on first call
+func getProtocolDocs() (protocolDocs, error) {
+	docsOnce.Do(func() {
+		protoDocs, docsErr = buildProtocolDocs()
+		if docsErr != nil {
+			log.Error("failed to build protocol documents : %v", docsErr)
+		}
+	})
+	return protoDocs, docsErr
+}
+
+func buildProtocolDocs() (protocolDocs, error) {
+	var err error
+	res := protocolDocs{
+		validator:      protocol.NewSchemaGenerator("#/$defs/"),
+		clientPayloads: map[string]*protocol.Schema{},
+	}
+
+	// JSON Schema: any message, with the payload types in $defs
+	g := res.validator
+	schema := &protocol.Schema{
+		Schema:      protocol.JSONSchemaDraft,
+		ID:          "/doc/schema.json",
+		Title:       "Transtool websocket message",
+		Description: "A message sent over the websocket /ws/{client_id}/{user_name}. Message definitions are named client.<message_type> (sent by the client) and server.<message_type> (sent by the server).",
+	}
+	envelopes := map[string]*protocol.Schema{}
+	for _, m := range messageTypes {
+		envelopes[m.key()] = envelopeSchema(g, m)
+		schema.AnyOf = append(schema.AnyOf, &protocol.Schema{Ref: g.RefPrefix + m.key()})
+		if m.FromClient && m.Payload != nil {
+			res.clientPayloads[m.Name] = g.Schema(m.Payload)
+		}
+	}
+	for k, s := range envelopes {
+		g.Defs[k] = s
+	}
+	schema.Defs = g.Defs
+	res.schema, err = json.MarshalIndent(schema, "", "  ")
+	if err != nil {
+		return res, fmt.Errorf("failed to marshal JSON Schema : %v", err)
+	}
+
+	res.asyncAPI, err = json.MarshalIndent(asyncAPIDoc(), "", "  ")
+	if err != nil {
+		return res, fmt.Errorf("failed to marshal AsyncAPI document : %v", err)
+	}
+	res.openAPI, err = json.MarshalIndent(openAPIDoc(apiRoutes()), "", "  ")
+	if err != nil {
+		return res, fmt.Errorf("failed to marshal OpenAPI document : %v", err)
+	}
+	return res, nil
+}
+
+// jsonObj is used for the parts of the AsyncAPI and OpenAPI documents
+// that are not schemas
+type jsonObj map[string]interface{}
+
+const componentsRefPrefix = "#/components/schemas/"
+
+func asyncAPIDoc() jsonObj {
+	g := protocol.NewSchemaGenerator(componentsRefPrefix)
+	messages := jsonObj{}
+	refs := map[bool][]jsonObj{}
+	for _, m := range messageTypes {
+		messages[m.key()] = jsonObj{
+			"name":    m.Name,
+			"title":   m.Name,
+			"summary": m.Doc,
+			"payload": envelopeSchema(g, m),
+		}
+		refs[m.FromClient] = append(refs[m.FromClient], jsonObj{"$ref": "#/components/messages/" + m.key()})
+	}
+	param := jsonObj{"schema": jsonObj{"type": "string"}}
+	return jsonObj{
+		"asyncapi":           "2.6.0",
+		"info":               jsonObj{"title": "Transtool websocket protocol", "version": "1"},
+		"defaultContentType": "application/json",
+		"channels": jsonObj{
+			"/ws/{client_id}/{user_name}": jsonObj{
+				"parameters": jsonObj{"client_id": param, "user_name": param},
+				"publish":    jsonObj{"summary": "Messages sent by the client", "message": jsonObj{"oneOf": refs[true]}},
+				"subscribe":  jsonObj{"summary": "Messages sent by the server", "message": jsonObj{"oneOf": refs[false]}},
+			},
+		},
+		"components": jsonObj{"messages": messages, "schemas": g.Defs},
+	}
+}
+
+var pathParamRE = regexp.MustCompile(`{([^}]+)}`)
+
+// handlerName returns the function name of h, used as operation id
+func handlerName(h http.HandlerFunc) string {
+	name := runtime.FuncForPC(reflect.ValueOf(h).Pointer()).Name()
+	return name[strings.LastIndex(name, ".")+1:]
+}
+
+func openAPIDoc(routes []apiRoute) jsonObj {
+	g := protocol.NewSchemaGenerator(componentsRefPrefix)
+	jsonContent := func(s *protocol.Schema) jsonObj {
+		return jsonObj{"application/json": jsonObj{"schema": s}}
+	}
+	errorResponse := jsonObj{"description": "Error", "content": jsonContent(g.Schema(apiErrorResponse{}))}
+
+	paths := map[string]jsonObj{}
+	for _, route := range routes {
+		var params []jsonObj
+		for _, p := range pathParamRE.FindAllStringSubmatch(route.Path, -1) {
+			params = append(params, jsonObj{"name": p[1], "in": "path", "required": true, "schema": jsonObj{"type": "string"}})
+		}
+		for _, p := range route.Params {
+			params = append(params, jsonObj{"name": p, "in": "query", "schema": jsonObj{"type": "string"}})
+		}
+		ok := jsonObj{"description": http.StatusText(route.Status)}
+		if route.Response != nil {
+			ok["content"] = jsonContent(g.Schema(route.Response))
+		}
+		op := jsonObj{
+			"operationId": handlerName(route.Handler),
+			"summary":     route.Summary,
+			"responses":   jsonObj{fmt.Sprintf("%d", route.Status): ok, "default": errorResponse},
+		}
+		if len(params) > 0 {
+			op["parameters"] = params
+		}
+		if route.Request != nil {
+			op["requestBody"] = jsonObj{"required": true, "content": jsonContent(g.Schema(route.Request))}
+		}
+		if _, ok := paths[route.Path]; !ok {
+			paths[route.Path] = jsonObj{}
+		}
+		paths[route.Path][strings.ToLower(route.Method)] = op
+	}
+	return jsonObj{
+		"openapi":    "3.1.0",
+		"info":       jsonObj{"title": "Transtool REST API", "version": "1"},
+		"servers":    []jsonObj{{"url": apiPrefix}},
+		"paths":      paths,
+		"components": jsonObj{"schemas": g.Defs},
+	}
+}
+
+// validatePayload validates the payload of an incoming message against
+// the schema of the message type. Unknown message types, and message
+// types without payload, are not validated.
+func validatePayload(msgType string, payload string) error {
+	d, err := getProtocolDocs()
+	if err != nil {
+		return nil
+	}
+	s, ok := d.clientPayloads[msgType]
+	if !ok {
+		return nil
+	}
+	err = d.validator.Validate(s, []byte(payload))
+	if err != nil {
+		return fmt.Errorf("invalid payload for message type %s : %v", msgType, err)
+	}
+	return nil
+}
+
+func serveProtocolDoc(doc func(protocolDocs) []byte) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		d, err := getProtocolDocs()
+		if err != nil {
+			msg := fmt.Sprintf("failed to build protocol documents : %v", err)
+			httpError(w, msg, msg, http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(doc(d))
+	}
+}
+
+// Pair is a message type or REST route, and an example JSON value of its
+// payload, listed by generateDoc
+type Pair struct {
+	Name string
+	Info string
+	JSN  string
+}
+
+// ptcl lists the websocket message types, sorted by direction and name
+func ptcl() ([]Pair, error) {
+	var res []Pair
+	mts := append([]messageType{}, messageTypes...)
+	sort.SliceStable(mts, func(i, j int) bool {
+		if mts[i].FromClient != mts[j].FromClient {
+			return mts[i].FromClient
+		}
+		return mts[i].Name < mts[j].Name
+	})
+	for _, m := range mts {
+		jsn := ""
+		if m.Payload != nil {
+			bts, err := json.MarshalIndent(m.Payload, "", " ")
+			if err != nil {
+				return res, err
+			}
+			jsn = string(bts)
+		}
+		dir := "server → client"
+		if m.FromClient {
+			dir = "client → server"
+		}
+		res = append(res, Pair{Name: m.Name, Info: fmt.Sprintf("%s, payload %s: %s", dir, m.goType(), m.Doc), JSN: jsn})
+	}
+	return res, nil
+}
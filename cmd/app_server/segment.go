@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/mux"
+
+	"github.com/stts-se/transtool-open/modules/ffmpeg"
+	"github.com/stts-se/transtool-open/modules/segment"
+	"github.com/stts-se/transtool-open/protocol"
+)
+
+// ==== SEGMENTATION
+//
+// The audio of a page can be split into chunks of speech, using the
+// ffmpeg silencedetect chunker or the native VAD (see the segment
+// package). Stereo recordings with one speaker per channel (such as
+// call-centre recordings) can be split per channel. The chunks are
+// then tagged with their channel, and with the speaker label of the
+// channel (see validation.Config.ChannelLabels), and put in one tier
+// per channel, so that they may overlap. The chunks are returned to
+// the client, and not saved.
+
+type apiSegments struct {
+	SubProj string `json:"sub_proj"`
+	PageID  string `json:"page_id"`
+	// Channels is the number of channels split separately, or 0 if
+	// the channels were mixed
+	Channels int                   `json:"channels"`
+	Chunks   []protocol.TransChunk `json:"chunks"`
+	// Tiers are the tiers of the channels, if split per channel
+	Tiers []protocol.Tier `json:"tiers,omitempty"`
+}
+
+// newSegmenter returns the segmenter with the given name: vad (the
+// default) or ffmpeg
+func newSegmenter(name string) (segment.ChannelSegmenter, error) {
+	switch name {
+	case "", "vad":
+		return segment.NewDefaultVAD()
+	case "ffmpeg":
+		return ffmpeg.NewDefaultChunker()
+	default:
+		return nil, fmt.Errorf("unknown segmenter '%s' (valid values: vad, ffmpeg)", name)
+	}
+}
+
+// apiPageSegments: GET /api/v1/sub_projs/{sub_proj}/pages/{page_id}/segments?segmenter=vad&per_channel=false
+// Splits the audio of the page into chunks of speech, per channel if per_channel=true
+func apiPageSegments(w http.ResponseWriter, r *http.Request) {
+	subProj, ok := apiSubProj(w, r, "apiPageSegments")
+	if !ok {
+		return
+	}
+	pageID := mux.Vars(r)["page_id"]
+	annotation, err := proj.Annotation(subProj, pageID)
+	if err != nil {
+		apiError(w, fmt.Sprintf("apiPageSegments: %v", err), err.Error(), http.StatusNotFound)
+		return
+	}
+	segmenter, err := newSegmenter(r.URL.Query().Get("segmenter"))
+	if err != nil {
+		apiError(w, fmt.Sprintf("apiPageSegments: %v", err), err.Error(), http.StatusBadRequest)
+		return
+	}
+	audioPath, err := proj.BuildAudioPath(subProj, annotation.Page.Audio)
+	if err != nil {
+		msg := fmt.Sprintf("couldn't build audio path : %v", err)
+		apiError(w, "apiPageSegments: "+msg, msg, http.StatusInternalServerError)
+		return
+	}
+
+	res := apiSegments{SubProj: subProj, PageID: pageID}
+	var label func(int) string
+	if r.URL.Query().Get("per_channel") == "true" {
+		res.Channels, err = segment.Channels(audioPath)
+		if err != nil {
+			msg := fmt.Sprintf("failed to read the number of channels for page %s", pageID)
+			apiError(w, fmt.Sprintf("apiPageSegments: %s : %v", msg, err), msg, http.StatusInternalServerError)
+			return
+		}
+		if v := proj.Validator(subProj); v != nil {
+			label = v.ChannelLabel
+		}
+	}
+	res.Tiers = segment.ChannelTiers(res.Channels, label)
+	res.Chunks, err = segment.ChannelChunks(segmenter, audioPath, annotation.Page.Chunk, res.Channels, label)
+	if err != nil {
+		msg := fmt.Sprintf("failed to split audio for page %s", pageID)
+		apiError(w, fmt.Sprintf("apiPageSegments: %s : %v", msg, err), msg, http.StatusInternalServerError)
+		return
+	}
+	apiResponse(w, "apiPageSegments", http.StatusOK, res)
+}
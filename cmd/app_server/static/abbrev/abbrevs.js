@@ -1,6 +1,17 @@
 "use strict";
 
 const baseURL =  window.location.protocol + '//' + window.location.host; // + window.location.pathname;
+const abbrevAPI = baseURL + "/api/v1/abbrev";
+
+// URL of the entries of an abbreviation list in the REST API
+function abbrevEntriesURL(listName) {
+    return abbrevAPI + "/lists/" + encodeURIComponent(listName) + "/entries";
+}
+
+// fetch options for a REST API request with a JSON body
+function jsonRequest(method, body) {
+    return {method: method, headers: {"Content-Type": "application/json"}, body: JSON.stringify(body)};
+}
 
 const keyCodeEnter = 13;
 const keyCodeSpace = 32;
@@ -39,7 +50,7 @@ function selectAbbrevList(selectListName) {
 }
 
 function fetchAbbrevListNames() {
-    fetch(baseURL + "/abbrev/list_lists_with_length")
+    fetch(baseURL + "/abbrev/list_lists_with_length?all=true")
 	.then(response => response.json())
 	.then(jzon => populateAbbrevListNames(jzon));
     
@@ -77,6 +88,11 @@ function populateAbbrevListNames(jzon) {
 	});
 	let text = document.createElement("span");
 	text.innerHTML = item.name + " ("+item.length+")";
+	text.title = "scope: " + item.scope;
+	if (item.sub_proj)
+	    text.title += "\nsub project: " + item.sub_proj;
+	if (item.owner)
+	    text.title += "\nowner: " + item.owner;
 	let label = document.createElement("label");
 	label.appendChild(radio);	
 	label.appendChild(text);
@@ -102,7 +118,10 @@ function populateAbbrevListNames(jzon) {
 		return;
 	    }
 
-	    fetch(baseURL + "/abbrev/delete_list/"+ listToDelete).then(function(response) {
+	    let params = new URLSearchParams();
+	    if (localStorage.getItem("username"))
+		params.set("user", localStorage.getItem("username"));
+	    fetch(abbrevAPI + "/lists/" + encodeURIComponent(listToDelete) + "?" + params.toString(), {method: "DELETE"}).then(function(response) {
 		if (response.ok) {
 		    let msg = "Deleted list '"+ listToDelete + "'";
 		    console.log(msg);
@@ -151,7 +170,15 @@ function createNewList(listName) {
 	return;
     }
 
-    fetch(baseURL + "/abbrev/create_new_list/" + listName)
+    let newList = {name: listName, scope: document.getElementById("new_abbrev_list_scope").value};
+    let subProj = document.getElementById("new_abbrev_list_sub_proj").value.trim();
+    if (subProj !== "")
+	newList.sub_proj = subProj;
+    // the owner, if authentication is disabled (set by the editor)
+    let params = new URLSearchParams();
+    if (localStorage.getItem("username"))
+	params.set("user", localStorage.getItem("username"));
+    fetch(abbrevAPI + "/lists?" + params.toString(), jsonRequest("POST", newList))
 	.then(async function(response){
 	    if (!response.ok) {
 		console.log("ERROR",response);
@@ -183,10 +210,10 @@ function loadAbbrevMap(listName) {
     
     let abbrevMap = {};
     // TODO: URL encode component
-    fetch(baseURL + "/abbrev/list_abbrevs/"+ listName).then(async function(response){
+    fetch(abbrevEntriesURL(listName)).then(async function(response){
 	if (response.ok) {
 	    //abbrevMap = {};
-	    let abbrevs = await response.json();
+	    let abbrevs = (await response.json()).entries;
 	    for (let i=0; i < abbrevs.length; i++) {
 		let a = abbrevs[i];
 		// TODO Check for and report dupes
@@ -235,7 +262,7 @@ function populateAbbrevsTable(listName, map) {
 	    let a = this.getAttribute("value");
 	    let ln = this.getAttribute("list_name");
 	    
-	    fetch(baseURL + "/abbrev/delete/"+ ln + "/" + a).then(function(response) {
+	    fetch(abbrevEntriesURL(ln), jsonRequest("DELETE", {abbrev: a})).then(function(response) {
 		if(response.ok) {
 		    let abbrevs = document.getElementById("abbrevs_table").children;
 		    for (var i = 0; i < abbrevs.length; i++) {
@@ -389,20 +416,13 @@ function addNewAbbrev(evt) {
 	return;
     }
 
-    //HB 0729
-    //encode e to allow for # character
-    e = encodeURIComponent(e);
-
-    
-    fetch(baseURL + "/abbrev/add/" + selectedList + "/" + a + "/" + e).then(async function(response) {
-	if (response.ok) {
-	    // TODO: refactor with chain of then's 
-	    let jzon = await response.json();
-	    if (jzon.label === "error") {
-	    	logMessage("error", jzon.content);
-	    	return;
-	    } else if (jzon.label === "info") {
-	    	logMessage("info", jzon.content);
+    fetch(abbrevEntriesURL(selectedList), jsonRequest("POST", {abbrev: a, expansion: e})).then(async function(response) {
+	// TODO: refactor with chain of then's 
+	let jzon = await response.json();
+	if (!response.ok) {
+	    logMessage("error", "Failed to add abbrevation " + a + " => " + e + ": " + jzon.error);
+	} else {
+	    logMessage("info", "Added abbreviation '" + a + "' => '" + e + "'");
 		// TODO Very brittle if table layout is changed!
 		let tr = document.createElement("tr");
 		let td1 = document.createElement("td");
@@ -427,7 +447,7 @@ function addNewAbbrev(evt) {
 		    let a = this.getAttribute("value");
 		    let ln = this.getAttribute("list_name");
 		    
-		    fetch(baseURL + "/abbrev/delete/"+ ln + "/" + a).then(function(response) {
+		    fetch(abbrevEntriesURL(ln), jsonRequest("DELETE", {abbrev: a})).then(function(response) {
 			if(response.ok) {
 			    let abbrevs = document.getElementById("abbrevs_table").children;
 			    for (var i = 0; i < abbrevs.length; i++) {
@@ -455,9 +475,6 @@ function addNewAbbrev(evt) {
 		let n = document.getElementById("abbrevs_table").children.length;
 		setAbbrevCountInListTable(selectedList, n);
 		//logMessage("info", "Added abbrevation " + a + " => " + e);
-	    }
-	} else {
-	    logMessage("error", "Failed to add abbrevation " + a + " => " + e);
 	}
     });
 }
@@ -17,8 +17,17 @@ let userName = document.getElementById("username");
 // ABBREVS
 
 
+// The lists are those of the current user and sub project (personal,
+// sub project and global lists)
 async function loadAbbrevListNames(firstLoad) {
-	await fetch(baseURL + "/abbrev/list_lists_with_length")
+	let params = new URLSearchParams();
+	let subProj = document.getElementById("project-selector");
+	if (subProj && subProj.value)
+		params.set("sub_proj", subProj.value);
+	let user = document.getElementById("username");
+	if (user && user.innerText.trim())
+		params.set("user", user.innerText.trim());
+	await fetch(baseURL + "/abbrev/list_lists_with_length?" + params.toString())
 		.then(response => response.json())
 		.then(jzon => populateAbbrevListNames(jzon, firstLoad));
 
@@ -290,9 +299,9 @@ async function loadAbbrevs(listNames) {
 	for (let li = 0; li < listNames.length; li++) {
 		const listName = listNames[li];
 		console.log("Loading " + listName); //  + " " + new Date())
-		await fetch(baseURL + "/abbrev/list_abbrevs/" + listName).then(async function (r) {
+		await fetch(baseURL + "/api/v1/abbrev/lists/" + encodeURIComponent(listName) + "/entries").then(async function (r) {
 			if (r.ok) {
-				const serverAbbrevs = await r.json();
+				const serverAbbrevs = (await r.json()).entries;
 				for (let i = 0; i < serverAbbrevs.length; i++) {
 					//console.log("i: ", i, serverAbbrevs[i]);
 					const a = serverAbbrevs[i];
@@ -516,15 +525,17 @@ function addNewAbbrevCreateListIfNotExists(listName, newAbbrev, newExpansion) {
     // 	return;
     // }
     
-    fetch(baseURL + "/abbrev/add_create_list_if_not_exists/" + listName + "/" + a + "/" + e).then(async function(response) {
-	if (response.ok) {
-	    // TODO: refactor with chain of then's 
-	    let jzon = await response.json();
-	    if (jzon.label === "error") {
-	    	logMessage("error", jzon.content);
-	    	return;
-	    } else if (jzon.label === "info") {
-	    	logMessage("info", jzon.content);
+    fetch(baseURL + "/api/v1/abbrev/lists/" + encodeURIComponent(listName) + "/entries?create_list=true", {
+	method: "POST",
+	headers: {"Content-Type": "application/json"},
+	body: JSON.stringify({abbrev: a, expansion: e}),
+    }).then(async function(response) {
+	// TODO: refactor with chain of then's 
+	let jzon = await response.json();
+	if (!response.ok) {
+	    logMessage("error", "Failed to add abbrevation " + a + " => " + e + ": " + jzon.error);
+	} else {
+	    logMessage("info", "Added abbreviation '" + a + "' => '" + e + "'");
 		// TODO Very brittle if table layout is changed!
 		// let tr = document.createElement("tr");
 		// let td1 = document.createElement("td");
@@ -577,9 +588,6 @@ function addNewAbbrevCreateListIfNotExists(listName, newAbbrev, newExpansion) {
 		// let n = document.getElementById("abbrevs_table").children.length;
 		// setAbbrevCountInListTable(selectedList, n);
 		// //logMessage("info", "Added abbrevation " + a + " => " + e);
-	    }
-	} else {
-	    logMessage("error", "Failed to add abbrevation " + a + " => " + e);
 	}
     });
 }
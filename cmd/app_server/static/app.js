@@ -41,6 +41,18 @@ let debugVar;
 
 let trtValidator; // See validation.js and ws.onmessage -> validation_config
 
+// Requests sent with a request_id, waiting for an ack or nack from the
+// server. The server echoes the request_id in all responses.
+let pendingRequests = {};
+let requestCounter = 0;
+
+function newRequestID(messageType, pageID) {
+    requestCounter++;
+    let id = clientID + "-" + requestCounter;
+    pendingRequests[id] = {message_type: messageType, page_id: pageID};
+    return id;
+}
+
 function logWarning(msg) {
     let div = logMessage("warning", msg);
     div.style.color = "orange";
@@ -160,7 +172,7 @@ function getFromURLParamsOrLocalStorage(paramName, urlParams) {
 	return null;
 }
 
-async function loadAudioBlob(blob, chunks) {
+function waveformRegions(chunks) {
     let wfRegions = [];
     for (let i = 0; i < chunks.length; i++) {
         let ch = chunks[i];
@@ -168,9 +180,19 @@ async function loadAudioBlob(blob, chunks) {
             start: ch.start - pageCache.offset,
             end: ch.end - pageCache.offset,
             uuid: ch.uuid,
+            tier: ch.tier,
         });
     }
-    waveform.loadAudioBlob(blob, wfRegions);
+    return wfRegions;
+}
+
+async function loadAudioBlob(blob, chunks) {
+    waveform.loadAudioBlob(blob, waveformRegions(chunks));
+}
+
+// the page audio is served over HTTP, see audio_url in the audio_chunk message
+async function loadAudioURL(url, chunks) {
+    waveform.loadAudioURL(url, waveformRegions(chunks));
 }
 
 function listAvailableAudioFiles() {
@@ -306,6 +328,17 @@ document.getElementById("editor-text-area").addEventListener("keyup", function (
     };
 });
 
+// applyTransFix replaces the text in the editor with a fix suggested by the server validation
+function applyTransFix(fix) {
+    let editor = document.getElementById("editor-text-area");
+    if (editor.hasAttribute("readonly")) {
+	return;
+    }
+    editor.innerText = fix;
+    editor.focus();
+    serverValidateCurrentTrans();
+}
+
 function serverValidateCurrentTrans() {
     let trans = document.getElementById("editor-text-area").innerText.trim();
     if (trans === "") {
@@ -313,13 +346,23 @@ function serverValidateCurrentTrans() {
     };
     document.getElementById("validation_result").innerText = '';
     //let ch = cacheActiveTranscription();
+    // the speaker of the chunk is validated as a leading label
+    let chunk = {'sub_proj': document.getElementById("project-selector").value, 'trans': trans};
+    let selected = waveform.getSelectedRegion();
+    if (selected && chunkCache[selected.uuid] && chunkCache[selected.uuid].speaker) {
+	chunk.speaker = chunkCache[selected.uuid].speaker;
+    };
     let request = {
         //'client_id': clientID,
         'message_type': 'validate_trans',
-        'payload': JSON.stringify(trans)}; 
+        'payload': JSON.stringify(chunk)}; 
+    let normRequest = {
+        'message_type': 'normalise_preview',
+        'payload': JSON.stringify(trans)};
     
     if (ws !== undefined) {  // Just to silence console errors when websocket ws is not initialised, e.g. when server is down
  	ws.send(JSON.stringify(request));
+ 	ws.send(JSON.stringify(normRequest));
     };
 }
 
@@ -628,6 +671,7 @@ if (document.getElementById("next_page_any")) {
 function clearTextEditor() {
     document.getElementById("editor-text-area").innerText = "";
     document.getElementById("validation_result").innerText = "";
+    document.getElementById("normalise_preview").innerText = "";
 };
 
 function clear() {
@@ -710,6 +754,9 @@ document.getElementById("project-selector").addEventListener("change", function
     //end fix
     
     localStorage.setItem("project_selected", evt.target.value);
+
+    // sub project abbreviation lists
+    loadAbbrevListNames(false);
 });
 
 document.getElementById("setstatus").addEventListener("change", function (evt) {
@@ -952,14 +999,7 @@ function updateStatusDisplay(statusLevel, uuid, status) {
 function displayAnnotationWithAudioData(anno) {
     clear();
     lockGUI();
-    // https://stackoverflow.com/questions/16245767/creating-a-blob-from-a-base64-string-in-javascript#16245768
-    let byteCharacters = atob(anno.base64audio);
-    let byteNumbers = new Array(byteCharacters.length);
-    for (let i = 0; i < byteCharacters.length; i++) {
-        byteNumbers[i] = byteCharacters.charCodeAt(i);
-    }
-    let byteArray = new Uint8Array(byteNumbers);
-
+    let base64audio = anno.base64audio;
     pageCache = anno;
     pageCache.base64audio = null; // no need to cache the audio blob
     chunkCache = {};
@@ -969,8 +1009,19 @@ function displayAnnotationWithAudioData(anno) {
     }
     console.log("res => cache", pageCache, chunkCache);
 
-    let blob = new Blob([byteArray], { 'type': anno.file_type });
-    loadAudioBlob(blob, anno.chunks);
+    if (anno.audio_url) {
+        loadAudioURL(anno.audio_url, anno.chunks);
+    } else {
+        // https://stackoverflow.com/questions/16245767/creating-a-blob-from-a-base64-string-in-javascript#16245768
+        let byteCharacters = atob(base64audio);
+        let byteNumbers = new Array(byteCharacters.length);
+        for (let i = 0; i < byteCharacters.length; i++) {
+            byteNumbers[i] = byteCharacters.charCodeAt(i);
+        }
+        let byteArray = new Uint8Array(byteNumbers);
+        let blob = new Blob([byteArray], { 'type': anno.file_type });
+        loadAudioBlob(blob, anno.chunks);
+    }
     let prettyLen = time_convert(anno.page.end - anno.page.start);
     //let prettyLen = (anno.page.end - anno.page.start) + " ms";
     document.getElementById("page_info").innerHTML = anno.index + " | " + anno.page.id + " | " + prettyLen + " | <span title='Location in full audio file'>" + anno.page.start + " - " + anno.page.end + "</span>";
@@ -1422,6 +1473,12 @@ function computeCurrentAnnotation(options, user) {
             chunk.trans = cachedChunk.trans;
             chunk.current_status = cachedChunk.current_status;
             chunk.status_history = cachedChunk.status_history;
+            // structured fields are not edited here, but must be kept
+            chunk.speaker = cachedChunk.speaker;
+            chunk.channel = cachedChunk.channel;
+            chunk.language = cachedChunk.language;
+            chunk.attributes = cachedChunk.attributes;
+            chunk.tier = cachedChunk.tier;
         } else {
             throw new Error("No status cache for chunk " + JSON.stringify(chunk));
             let status = {
@@ -1445,6 +1502,7 @@ function computeCurrentAnnotation(options, user) {
         labels: labels,
         comment: document.getElementById("comment").value,
         index: pageCache.index,
+        tiers: pageCache.tiers,
     };
     // if (options.status === "derive") {
     //     annotation.current_status.name = derivePageStatus(annotation);
@@ -1534,16 +1592,17 @@ function validateCurrentChunk(chunk, statusname) {
     // 	return false;
     // }
 
-    // See validation.js
-    if (statusname === "ok" || statusname === "ok2") { 
-	let validationResult = trtValidator.validateTrans(chunk.trans);
-	for (var i in validationResult) {
-	    let vr =  validationResult[i];
-	    // TODO What levels should trigger what response?
-	    if (vr.level === "fatal") { // || vr.level === "error") {
-		alert(vr.message);
-		return false;
-	    }
+    // See validation.js (rules are scoped by status and sub project)
+    let subProj = document.getElementById("project-selector").value;
+    // the speaker of the chunk is validated as a leading label, as on the server
+    let trans = chunk.speaker ? (chunk.speaker + " " + chunk.trans).trim() : chunk.trans;
+    let validationResult = trtValidator.validateTrans(trans, statusname, subProj);
+    for (var i in validationResult) {
+	let vr =  validationResult[i];
+	// TODO What levels should trigger what response?
+	if (vr.level === "fatal") { // || vr.level === "error") {
+	    alert(vr.message);
+	    return false;
 	}
     }
     
@@ -1634,6 +1693,7 @@ function savePage(options) {
         //'client_id': clientID,
         'message_type': 'save',
         'payload': JSON.stringify(payload),
+        'request_id': newRequestID('save', payload.page.id),
     };
     ws.send(JSON.stringify(request));
     let oldCache = pageCache;
@@ -1704,6 +1764,7 @@ function saveUnlockAndNext(options) {
         //'client_id': clientID,
         'message_type': 'saveunlockandnext',
         'payload': JSON.stringify(payload),
+        'request_id': newRequestID('saveunlockandnext', annotation.page ? annotation.page.id : undefined),
     };
     console.log("saveunlockandnext sending payload", payload);
     ws.send(JSON.stringify(request));
@@ -1763,6 +1824,61 @@ function checkAsrAvailableFetch() {
 
 
 
+// Returns the authenticated user name, or "" if authentication is
+// disabled on the server (the user name is then prompted for), or
+// null if login failed
+function authenticate() {
+    let whoami = authRequest("GET", "/auth/whoami");
+    if (whoami === null || whoami.auth_mode === "none")
+        return "";
+    if (whoami.logged_in)
+        return whoami.user_name;
+    if (whoami.auth_mode !== "local") {
+        let msg = "Not authenticated by server (auth mode " + whoami.auth_mode + ")";
+        logError(msg);
+        alert(msg);
+        return null;
+    }
+
+    let suggest = localStorage.getItem("username");
+    if (!suggest || suggest === null)
+        suggest = "";
+    let username = prompt("User name", suggest);
+    if (!username || username === null || username.trim() === "") {
+        let msg = "Username unset!";
+        logError(msg);
+        alert(msg);
+        return null;
+    }
+    let password = prompt("Password for " + username);
+    let res = authRequest("POST", "/auth/login", JSON.stringify({ user_name: username, password: password }));
+    if (res === null || !res.logged_in) {
+        let msg = "Login failed for user " + username;
+        logError(msg);
+        alert(msg);
+        return null;
+    }
+    return res.user_name;
+}
+
+function authRequest(method, path, body) {
+    const xhttp = new XMLHttpRequest();
+    xhttp.open(method, baseURL + path, false); // synchronous, login must be completed before the websocket is opened
+    if (body)
+        xhttp.setRequestHeader("Content-Type", "application/json");
+    try {
+        xhttp.send(body);
+    } catch (e) {
+        console.log("authRequest failed", path, e);
+        return null;
+    }
+    if (xhttp.status !== 200) {
+        console.log("authRequest failed", path, xhttp.status, xhttp.responseText);
+        return null;
+    }
+    return JSON.parse(xhttp.responseText);
+}
+
 onload = function () {
 
     //localStorage.clear();
@@ -1928,7 +2044,14 @@ onload = function () {
         requestIndex = requestIndex + "";
     }
 
-    if (params.get('username')) {
+    let authUser = authenticate();
+    if (authUser === null) {
+        return;
+    }
+    if (authUser) {
+	document.getElementById("username").innerText = authUser;
+    }
+    else if (params.get('username')) {
 	document.getElementById("username").innerText = params.get("username").toLowerCase();
     }
     else {
@@ -2194,6 +2317,16 @@ onload = function () {
 		//valResArea.innerText +=  vr.rule_name +"\t"+ vr.message +"\n";
 		let t = document.createTextNode(vr.message);
 		valResArea.appendChild(t);
+		if (vr.fix) {
+		    let fixButton = document.createElement("button");
+		    fixButton.classList.add("btn");
+		    fixButton.innerText = "apply fix";
+		    fixButton.title = vr.fix;
+		    fixButton.addEventListener("click", function () {
+			applyTransFix(vr.fix);
+		    });
+		    valResArea.appendChild(fixButton);
+		}
 		let p = document.createElement("p");
 		valResArea.appendChild(p);
 	    }
@@ -2203,6 +2336,14 @@ onload = function () {
 
 	}
 	
+	else if (resp.message_type === "normalise_preview") {
+	    let normRes = JSON.parse(resp.payload);
+	    let previewArea = document.getElementById("normalise_preview");
+	    previewArea.innerText = normRes.output;
+	    // The output of each normalisation step is shown as a "tool tip" (title)
+	    previewArea.title = (normRes.steps || []).map(s => s.rule + ": " + s.output).join("\n");
+	}
+	
 	else if (resp.message_type === "validation_config") {
 	    let cfg = JSON.parse(resp.payload);
 	    trtValidator = new TrtValidator(cfg);
@@ -2213,6 +2354,15 @@ onload = function () {
 	    //console.log("STATUS NAMES >>>>>>>", cfg.status_names);
 	    //console.log(JSON.stringify(cfg));
 	}
+	else if (resp.message_type === "ack" || resp.message_type === "nack") {
+	    let ack = JSON.parse(resp.payload);
+	    let pending = pendingRequests[resp.request_id];
+	    delete pendingRequests[resp.request_id];
+	    if (resp.message_type === "nack")
+		logError(`Request ${ack.message_type} failed: ${ack.error}`);
+	    else if (pending && pending.page_id)
+		logMessage(`Saved page ${pending.page_id} (confirmed)`);
+	}
 	else if (resp.message_type === "editor_names") {
 	    let editors = JSON.parse(resp.payload);
 	    let sourceSelect = document.getElementById("requestsource");
@@ -94,11 +94,39 @@ class TrtValidator {
 
     
 
+    // c.f. regexpValidator.appliesTo in validation/validation.go
+    // empty subProj means unknown, and doesn't exclude a rule
+    ruleApplies(r, transString, status, subProj) {
+	if (r.statuses && r.statuses.length > 0 && !r.statuses.includes(status)) {
+	    return false;
+	}
+	if ((!r.statuses || r.statuses.length === 0) && !status.startsWith("ok")) {
+	    return false;
+	}
+	if (subProj && r.sub_projs && r.sub_projs.length > 0) {
+	    let base = subProj.replace(/\/+$/, "").split("/").pop();
+	    if (!r.sub_projs.includes(subProj) && !r.sub_projs.includes(base)) {
+		return false;
+	    }
+	}
+	if (r.labels && r.labels.length > 0) {
+	    let toks = transString.split(this.token_split_regexp);
+	    if (!r.labels.some(l => toks.includes(l))) {
+		return false;
+	    }
+	}
+	return true;
+    }
+
     // returns a list of ValRes, empty if no rules fired
-    validateTrans(transString) {
+    // status (default "ok") and subProj are used for rule scope
+    validateTrans(transString, status = "ok", subProj = "") {
 	let res = [];
 	for (var i in this.trans_must_match) {
 	    let r =  this.trans_must_match[i];
+	    if (!this.ruleApplies(r, transString, status, subProj)) {
+		continue;
+	    }
 	    if (!transString.match(r.regexp)) {
 		let vr = new TrtValRes(r.rule_name, r.level, r.message); 
 		res.push(vr);
@@ -107,6 +135,9 @@ class TrtValidator {
 	
 	for (var i in this.trans_must_not_match) {
 	    let r =  this.trans_must_not_match[i];
+	    if (!this.ruleApplies(r, transString, status, subProj)) {
+		continue;
+	    }
 	    if (transString.match(r.regexp)) {
 		let vr = new TrtValRes(r.rule_name, r.level, r.message); 
 		res.push(vr);
@@ -114,10 +145,12 @@ class TrtValidator {
 	}
 
 
-	let charVres = this.validateTransChars(transString); 
-	if (charVres.length > 0) {
-	    res = res.concat(charVres);
-	};
+	if (status.startsWith("ok")) {
+	    let charVres = this.validateTransChars(transString); 
+	    if (charVres.length > 0) {
+		res = res.concat(charVres);
+	    };
+	}
 	
 	
 	// TODO Add this when there is a way of presenting non-fatal issues non-intrusively
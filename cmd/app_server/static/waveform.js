@@ -302,9 +302,10 @@ class Waveform {
 	if (this.options.regionMaxLength)
 	    region.maxLength = this.options.regionMaxLength;
 	
-	// stop user from accidentally overlapping regions
+	// stop user from accidentally overlapping regions (of the same tier)
 	let minGap = 0.1;
-	let regions = this.listRegions();
+	let tier = region.data ? region.data.tier : undefined;
+	let regions = this.listRegions().filter(r => (r.data ? r.data.tier : undefined) === tier);
 	let thisI = -1;
 	for (let i = 0; i < regions.length; i++) {
 	    if (regions[i].id === region.id)
@@ -450,6 +451,7 @@ class Waveform {
 		end: chunk.end / 1000.0,
 		color: this.defaultRegionBackground,
 		drag: false, // disable moving
+		data: { tier: chunk.tier },
 	    });
 	    //console.log("loadChunks debug added", added.element.title, added.id);
 	}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+
+	"github.com/stts-se/transtool-open/audiocache"
+	"github.com/stts-se/transtool-open/modules/ffmpeg"
+	"github.com/stts-se/transtool-open/modules/wav"
+	"github.com/stts-se/transtool-open/modules/waveform"
+	"github.com/stts-se/transtool-open/protocol"
+)
+
+// ==== WAVEFORM PEAKS AND SPECTROGRAMS
+//
+// Waveform peaks (see waveform.Peaks) and log-mel spectrograms are
+// computed on the server, for a page or for a whole audio file, so
+// that long recordings can be displayed without decoding the audio in
+// the client. The results are saved in the audio cache (if enabled),
+// next to the extracted audio.
+
+const defaultSamplesPerPixel = 256
+
+// openWAV returns a reader for the audio of a request, as PCM WAV. If
+// wholeFile is true, the chunk of the request is ignored, and the
+// whole audio file is read (converted using ffmpeg if needed). The
+// returned function closes the reader.
+func openWAV(request protocol.SplitRequestPayload, wholeFile bool) (*wav.Reader, func(), error) {
+	if !wholeFile {
+		bts, _, err := chunkExtractor.ExtractWithContext(request, "wav")
+		if err != nil {
+			return nil, nil, fmt.Errorf("chunk extractor failed : %v", err)
+		}
+		r, err := wav.NewReader(bytes.NewReader(bts))
+		return r, func() {}, err
+	}
+	return ffmpeg.OpenWAV(request.Audio)
+}
+
+// waveformVariant is the cache key variant of waveform data
+func waveformVariant(kind string, wholeFile bool, param string) string {
+	if wholeFile {
+		kind = "file-" + kind
+	}
+	return kind + "-" + param
+}
+
+// cachedWaveformData returns the data of a variant from the audio
+// cache, or computes it using compute. Compute returns the data of
+// all variants computed at the same time, to be cached as well.
+func cachedWaveformData(request protocol.SplitRequestPayload, variant string, compute func() (map[string][]byte, error)) ([]byte, error) {
+	computeVariant := func() ([]byte, error) {
+		res, err := compute()
+		if err != nil {
+			return nil, err
+		}
+		for v, bts := range res {
+			if v == variant || audioCache == nil {
+				continue
+			}
+			bts := bts
+			audioCache.Prefetch(audiocache.Key{Request: request, Encoding: v}, func() ([]byte, error) { return bts, nil })
+		}
+		bts, ok := res[variant]
+		if !ok {
+			return nil, fmt.Errorf("no data computed for %s", variant)
+		}
+		return bts, nil
+	}
+	if audioCache == nil {
+		return computeVariant()
+	}
+	return audioCache.Get(audiocache.Key{Request: request, Encoding: variant}, computeVariant)
+}
+
+// computePeaks computes the peaks of all zoom levels
+func computePeaks(request protocol.SplitRequestPayload, wholeFile bool) (map[string][]byte, error) {
+	r, closeWAV, err := openWAV(request, wholeFile)
+	if err != nil {
+		return nil, err
+	}
+	defer closeWAV()
+	peaks, err := waveform.ComputePeaks(r, waveform.ZoomLevels)
+	if err != nil {
+		return nil, err
+	}
+	res := map[string][]byte{}
+	for _, p := range peaks {
+		bts, err := json.Marshal(p)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal peaks : %v", err)
+		}
+		res[waveformVariant("peaks", wholeFile, strconv.Itoa(p.SamplesPerPixel))] = bts
+	}
+	return res, nil
+}
+
+// computeSpectrogram computes the spectrogram, as JSON and PNG
+func computeSpectrogram(request protocol.SplitRequestPayload, wholeFile bool) (map[string][]byte, error) {
+	r, closeWAV, err := openWAV(request, wholeFile)
+	if err != nil {
+		return nil, err
+	}
+	defer closeWAV()
+	s, err := waveform.ComputeSpectrogram(r, waveform.DefaultSpectrogramOptions)
+	if err != nil {
+		return nil, err
+	}
+	jsn, err := json.Marshal(s)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal spectrogram : %v", err)
+	}
+	var img bytes.Buffer
+	err = s.WritePNG(&img)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create spectrogram image : %v", err)
+	}
+	return map[string][]byte{
+		waveformVariant("spectrogram", wholeFile, "json"): jsn,
+		waveformVariant("spectrogram", wholeFile, "png"):  img.Bytes(),
+	}, nil
+}
+
+// audioFileRequest returns the request for a whole audio file of a
+// sub-project, by the name listed by apiListAudioFiles (the base name
+// without extension). On failure, an error response is written.
+func audioFileRequest(w http.ResponseWriter, r *http.Request, caller string) (protocol.SplitRequestPayload, os.FileInfo, bool) {
+	var res protocol.SplitRequestPayload
+	subProj, ok := apiSubProj(w, r, caller)
+	if !ok {
+		return res, nil, false
+	}
+	name := mux.Vars(r)["audio_file"]
+	pages, err := proj.PageInfos(subProj)
+	if err != nil {
+		apiError(w, fmt.Sprintf("%s: %v", caller, err), err.Error(), http.StatusNotFound)
+		return res, nil, false
+	}
+	audio := ""
+	for _, p := range pages {
+		base := path.Base(p.Audio)
+		if strings.TrimSuffix(base, filepath.Ext(base)) == name || base == name {
+			audio = p.Audio
+			break
+		}
+	}
+	if audio == "" {
+		msg := fmt.Sprintf("no audio file '%s'", name)
+		apiError(w, caller+": "+msg, msg, http.StatusNotFound)
+		return res, nil, false
+	}
+	audioPath, err := proj.BuildAudioPath(subProj, audio)
+	if err != nil {
+		msg := fmt.Sprintf("couldn't build audio path : %v", err)
+		apiError(w, caller+": "+msg, msg, http.StatusInternalServerError)
+		return res, nil, false
+	}
+	info, err := os.Stat(audioPath)
+	if err != nil {
+		msg := fmt.Sprintf("no audio file '%s'", name)
+		apiError(w, fmt.Sprintf("%s: %v", caller, err), msg, http.StatusNotFound)
+		return res, nil, false
+	}
+	return protocol.SplitRequestPayload{Audio: audioPath}, info, true
+}
+
+func servePeaks(w http.ResponseWriter, r *http.Request, caller string, wholeFile bool) {
+	var request protocol.SplitRequestPayload
+	var info os.FileInfo
+	var ok bool
+	if wholeFile {
+		request, info, ok = audioFileRequest(w, r, caller)
+	} else {
+		request, info, ok = pageAudioRequest(w, r, caller)
+	}
+	if !ok {
+		return
+	}
+	spp := defaultSamplesPerPixel
+	if v := r.URL.Query().Get("samples_per_pixel"); v != "" {
+		var err error
+		spp, err = strconv.Atoi(v)
+		if err != nil || !waveform.ValidZoomLevel(spp) {
+			msg := fmt.Sprintf("invalid value for samples_per_pixel: '%s' (valid values: %v)", v, waveform.ZoomLevels)
+			apiError(w, caller+": "+msg, msg, http.StatusBadRequest)
+			return
+		}
+	}
+	variant := waveformVariant("peaks", wholeFile, strconv.Itoa(spp))
+	etag := audioETag(request, info, variant)
+	if notModified(w, r, etag) {
+		return
+	}
+	bts, err := cachedWaveformData(request, variant, func() (map[string][]byte, error) { return computePeaks(request, wholeFile) })
+	if err != nil {
+		msg := "failed to compute waveform peaks"
+		apiError(w, fmt.Sprintf("%s: %s : %v", caller, msg, err), msg, http.StatusInternalServerError)
+		return
+	}
+	serveAudioData(w, r, etag, "application/json", info, bts)
+}
+
+func serveSpectrogram(w http.ResponseWriter, r *http.Request, caller string, wholeFile bool) {
+	var request protocol.SplitRequestPayload
+	var info os.FileInfo
+	var ok bool
+	if wholeFile {
+		request, info, ok = audioFileRequest(w, r, caller)
+	} else {
+		request, info, ok = pageAudioRequest(w, r, caller)
+	}
+	if !ok {
+		return
+	}
+	format := r.URL.Query().Get("format")
+	contentType := ""
+	switch format {
+	case "", "png":
+		format = "png"
+		contentType = "image/png"
+	case "json":
+		contentType = "application/json"
+	default:
+		msg := fmt.Sprintf("invalid value for format: '%s' (valid values: png, json)", format)
+		apiError(w, caller+": "+msg, msg, http.StatusBadRequest)
+		return
+	}
+	variant := waveformVariant("spectrogram", wholeFile, format)
+	etag := audioETag(request, info, variant)
+	if notModified(w, r, etag) {
+		return
+	}
+	bts, err := cachedWaveformData(request, variant, func() (map[string][]byte, error) { return computeSpectrogram(request, wholeFile) })
+	if err != nil {
+		msg := "failed to compute spectrogram"
+		apiError(w, fmt.Sprintf("%s: %s : %v", caller, msg, err), msg, http.StatusInternalServerError)
+		return
+	}
+	serveAudioData(w, r, etag, contentType, info, bts)
+}
+
+// apiPagePeaks: GET /api/v1/sub_projs/{sub_proj}/pages/{page_id}/peaks?context=0&chunk=<uuid>&channel=mix&samples_per_pixel=256
+// Returns the waveform peaks of the page audio (as served by apiPageAudio)
+func apiPagePeaks(w http.ResponseWriter, r *http.Request) {
+	servePeaks(w, r, "apiPagePeaks", false)
+}
+
+// apiPageSpectrogram: GET /api/v1/sub_projs/{sub_proj}/pages/{page_id}/spectrogram?context=0&chunk=<uuid>&channel=mix&format=png
+// Returns the log-mel spectrogram of the page audio, as an image or as JSON
+func apiPageSpectrogram(w http.ResponseWriter, r *http.Request) {
+	serveSpectrogram(w, r, "apiPageSpectrogram", false)
+}
+
+// apiAudioFilePeaks: GET /api/v1/sub_projs/{sub_proj}/audio_files/{audio_file}/peaks?samples_per_pixel=256
+// Returns the waveform peaks of a whole audio file, for an overview
+func apiAudioFilePeaks(w http.ResponseWriter, r *http.Request) {
+	servePeaks(w, r, "apiAudioFilePeaks", true)
+}
+
+// apiAudioFileSpectrogram: GET /api/v1/sub_projs/{sub_proj}/audio_files/{audio_file}/spectrogram?format=png
+// Returns the log-mel spectrogram of a whole audio file
+func apiAudioFileSpectrogram(w http.ResponseWriter, r *http.Request) {
+	serveSpectrogram(w, r, "apiAudioFileSpectrogram", true)
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/stts-se/transtool-open/abbrevs"
+	"github.com/stts-se/transtool-open/dbapi"
+	"github.com/stts-se/transtool-open/normalise"
+	"github.com/stts-se/transtool-open/protocol"
+)
+
+// export_trans prints the transcriptions of OK chunks as tab separated
+// lines: audio, start, end, verbatim transcription, normalised transcription.
+// With -tiers, the tier and speaker of each chunk are added as two
+// extra columns. Chunks of different tiers may overlap.
+
+func main() {
+	normaliseConfig := flag.String("normalise_config", "", "Normalisation config JSON `file` (default: strip labels, remove punctuation and lowercase)")
+	abbrevDir := flag.String("abbrev_dir", "", "Abbreviation `dir`, required for normalisation rules of type expand_abbrevs")
+	tiers := flag.Bool("tiers", false, "add columns for the tier and speaker of each chunk")
+	flag.Parse()
+	args := flag.Args()
+
+	if len(args) < 1 {
+		fmt.Fprintf(os.Stderr, "USAGE: export_trans <sub proj dirs> ...\n")
+		fmt.Fprintf(os.Stderr, "\n-normalise_config <file> for normalisation config (see normalise.Config)\n")
+		fmt.Fprintf(os.Stderr, "-abbrev_dir <dir> for abbreviation lists\n")
+		fmt.Fprintf(os.Stderr, "-tiers to add columns for tier and speaker\n")
+		os.Exit(0)
+	}
+
+	var am *abbrevs.AbbrevManager
+	if *abbrevDir != "" {
+		m := abbrevs.NewAbbrevManager(*abbrevDir)
+		err := m.Load()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to load abbreviations : %v\n", err)
+			os.Exit(1)
+		}
+		am = &m
+	}
+
+	var normaliser normalise.Normaliser
+	var err error
+	if *normaliseConfig != "" {
+		bts, err := os.ReadFile(*normaliseConfig)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to read config file '%s' : %v\n", *normaliseConfig, err)
+			os.Exit(1)
+		}
+		normaliser, err = normalise.NewNormaliserFromJSON(bts, am)
+	} else {
+		normaliser, err = normalise.NewNormaliser(normalise.ConfigExample, am)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to create normaliser : %v\n", err)
+		os.Exit(1)
+	}
+
+	for _, dirName := range args {
+		db := dbapi.NewDBAPI(dirName, nil)
+		annos, vRes, err := db.LoadAnnotationData()
+		for _, vr := range vRes {
+			fmt.Fprintf(os.Stderr, "%s\t%s\n", vr.Level, vr.Message)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to load annotation data from '%s' : %v\n", dirName, err)
+			os.Exit(1)
+		}
+		var pageIDs []string
+		for id := range annos {
+			pageIDs = append(pageIDs, id)
+		}
+		sort.Strings(pageIDs)
+
+		for _, id := range pageIDs {
+			anno := annos[id]
+			if anno.CurrentStatus.Name == "delete" || anno.CurrentStatus.Name == "skip" {
+				continue
+			}
+			chunks := make([]protocol.TransChunk, len(anno.Chunks))
+			copy(chunks, anno.Chunks)
+			sort.SliceStable(chunks, func(i, j int) bool { return chunks[i].Start < chunks[j].Start })
+			for _, c := range chunks {
+				if !strings.HasPrefix(c.CurrentStatus.Name, "ok") {
+					continue
+				}
+				norm := normaliser.Normalise(c.Trans)
+				if *tiers {
+					fmt.Printf("%s\t%d\t%d\t%s\t%s\t%s\t%s\n", anno.Page.Audio, c.Start, c.End, c.Trans, norm.Output, c.Tier, c.Speaker)
+					continue
+				}
+				fmt.Printf("%s\t%d\t%d\t%s\t%s\n", anno.Page.Audio, c.Start, c.End, c.Trans, norm.Output)
+			}
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/stts-se/transtool-open/audioimport"
+	"github.com/stts-se/transtool-open/dbapi"
+	"github.com/stts-se/transtool-open/log"
+	"github.com/stts-se/transtool-open/modules/ffmpeg"
+)
+
+// import_audio converts the source audio of sub-projects to a working
+// format (by default 16 kHz mono FLAC), kept next to the original
+// audio. The working copies are listed in the audio_import.json file
+// of each sub-project, and used for ASR. Pages still reference the
+// original audio.
+
+func main() {
+
+	cmd := path.Base(os.Args[0])
+
+	projectDirs := flag.String("project_dirs", "", "Project directories separated by ':' (path1/dir1:path1/dir2 [...])")
+	encoding := flag.String("encoding", ffmpeg.DefaultTranscodeFormat.Encoding, "working audio encoding (flac, wav, mp3 or opus)")
+	sampleRate := flag.Int("sample_rate", ffmpeg.DefaultTranscodeFormat.SampleRate, "working audio sample rate (0 to keep the original sample rate)")
+	channels := flag.Int("channels", ffmpeg.DefaultTranscodeFormat.Channels, "working audio channels (0 to keep the original channels)")
+	loudness := flag.Float64("loudness", 0, "target loudness in `LUFS` for loudness normalisation, such as -23 (0 for no normalisation)")
+	force := flag.Bool("force", false, "convert audio already imported")
+
+	help := flag.Bool("help", false, "Print usage and exit")
+	flag.Parse()
+
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s <flags>\n", cmd)
+		fmt.Fprintf(os.Stderr, "Flags:\n")
+		flag.PrintDefaults()
+	}
+
+	if *help {
+		flag.Usage()
+		os.Exit(0)
+	}
+
+	if strings.HasPrefix(*projectDirs, "-") {
+		fmt.Fprintf(os.Stderr, "Invalid project dirs: %s\n", *projectDirs)
+		flag.Usage()
+		os.Exit(1)
+	}
+	if *projectDirs == "" {
+		fmt.Fprintf(os.Stderr, "Required flag project_dirs not set\n")
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	if len(flag.Args()) != 0 {
+		fmt.Fprintf(os.Stderr, "Didn't expect cmd line args except for flags, found: %#v\n", flag.Args())
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	format := ffmpeg.TranscodeFormat{Encoding: *encoding, SampleRate: *sampleRate, Channels: *channels, Loudness: *loudness}
+	importer, err := audioimport.NewImporter(format)
+	if err != nil {
+		log.Fatal("Failed to initialise audio importer: %v", err)
+	}
+
+	results := map[string][]audioimport.Result{}
+	for _, dir := range strings.Split(*projectDirs, ":") {
+		dir = strings.TrimSuffix(strings.TrimSpace(dir), "/")
+		if _, err := os.Stat(dir); err != nil {
+			log.Fatal("Invalid project dir : %v", err)
+		}
+		res, err := importer.Import(dbapi.NewDBAPI(dir, nil), *force)
+		if err != nil {
+			log.Fatal("Couldn't import audio in sub project %s : %v", dir, err)
+		}
+		log.Info("Imported %d audio files in sub project %s", len(res), dir)
+		results[dir] = res
+	}
+
+	resJSON, err := json.MarshalIndent(results, "", "  ")
+	if err != nil {
+		log.Fatal("Couldn't marshal result : %v", err)
+	}
+	fmt.Println(string(resJSON))
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/stts-se/transtool-open/dbapi"
+	"github.com/stts-se/transtool-open/log"
+	"github.com/stts-se/transtool-open/validation"
+)
+
+// migrate_speakers is a one-off migration of annotations with speaker
+// labels written first in the transcription, such as "#AGENT hej". The
+// labels are moved into the speaker field of the chunks. Only labels
+// listed as speakers in the validation config of each sub-project
+// (see validation.Config.Speakers) are moved.
+
+func main() {
+
+	cmd := path.Base(os.Args[0])
+
+	projectDirs := flag.String("project_dirs", "", "Project directories separated by ':' (path1/dir1:path1/dir2 [...])")
+	apply := flag.Bool("apply", false, "save the changes (by default, changes are only listed)")
+
+	help := flag.Bool("help", false, "Print usage and exit")
+	flag.Parse()
+
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s <flags>\n", cmd)
+		fmt.Fprintf(os.Stderr, "Flags:\n")
+		flag.PrintDefaults()
+	}
+
+	if *help {
+		flag.Usage()
+		os.Exit(0)
+	}
+
+	if strings.HasPrefix(*projectDirs, "-") {
+		fmt.Fprintf(os.Stderr, "Invalid project dirs: %s\n", *projectDirs)
+		flag.Usage()
+		os.Exit(1)
+	}
+	if *projectDirs == "" {
+		fmt.Fprintf(os.Stderr, "Required flag project_dirs not set\n")
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	if len(flag.Args()) != 0 {
+		fmt.Fprintf(os.Stderr, "Didn't expect cmd line args except for flags, found: %#v\n", flag.Args())
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	proj, err := dbapi.NewProj(*projectDirs, &validation.Validator{})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to load project dir : %v", err)
+		os.Exit(1)
+	}
+	_, err = proj.LoadData()
+	if err != nil {
+		log.Fatal("Couldn't load data: %v", err)
+	}
+
+	results := []dbapi.RewriteResult{}
+	for _, subProj := range proj.ListSubProjs() {
+		validator := proj.Validator(subProj)
+		res, err := proj.RewriteChunks(subProj, validator.LiftSpeakerLabel, *apply)
+		if err != nil {
+			log.Fatal("Couldn't migrate speakers in sub project %s : %v", subProj, err)
+		}
+		if *apply {
+			log.Info("Moved %d speaker labels in sub project %s", len(res.Rewrites), subProj)
+		}
+		for _, p := range res.SkippedLocked {
+			log.Warning("Skipped locked page %s in sub project %s", p, subProj)
+		}
+		for _, p := range res.SkippedChanged {
+			log.Warning("Skipped page %s in sub project %s, saved during the migration", p, subProj)
+		}
+		results = append(results, res)
+	}
+
+	resJSON, err := json.MarshalIndent(results, "", "  ")
+	if err != nil {
+		log.Fatal("Couldn't marshal result : %v", err)
+	}
+	fmt.Println(string(resJSON))
+}
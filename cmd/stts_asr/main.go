@@ -9,6 +9,7 @@ import (
 	"strings"
 	//"sync"
 
+	"github.com/stts-se/transtool-open/audioimport"
 	"github.com/stts-se/transtool-open/dbapi"
 	"github.com/stts-se/transtool-open/log"
 	"github.com/stts-se/transtool-open/modules"
@@ -99,9 +100,10 @@ func main() {
 	for _, pr := range proj.ListSubProjs() {
 		db := proj.GetDB(pr)
 		for _, anno := range db.GetAnnotationData() {
-			audioPath, err := proj.BuildAudioPath(pr, anno.Page.Audio)
+			// use the working copy of the audio, if imported (see the audioimport package)
+			audioPath, err := audioimport.WorkingAudioPath(db, anno.Page.Audio)
 			if err != nil {
-				msg := fmt.Sprintf("db.BuildAudioPath error: %v", err)
+				msg := fmt.Sprintf("audioimport.WorkingAudioPath error: %v", err)
 				log.Fatal(msg)
 			}
 			info, err := aiExtractor.Process(audioPath)
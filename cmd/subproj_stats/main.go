@@ -135,14 +135,27 @@ func okeyedChunks(a protocol.AnnotationPayload) (int, int) {
 	return okey, tot
 }
 
+// okejedMillis returns the audio time covered by OK:ed chunks. Time
+// with overlapping chunks (of different tiers) is only counted once.
 func okejedMillis(a protocol.AnnotationPayload) int64 {
 	var res int64
 
+	var chunks []protocol.Chunk
 	for _, c := range a.Chunks {
 		if strings.HasPrefix(c.CurrentStatus.Name, "ok") {
-			t := c.End - c.Start
+			chunks = append(chunks, c.Chunk)
+		}
+	}
+	sort.Slice(chunks, func(i, j int) bool { return chunks[i].Start < chunks[j].Start })
 
-			res += t
+	var end int64
+	for _, c := range chunks {
+		if c.Start < end {
+			c.Start = end
+		}
+		if c.End > c.Start {
+			res += c.End - c.Start
+			end = c.End
 		}
 	}
 
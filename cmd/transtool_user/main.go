@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/stts-se/transtool-open/auth"
+)
+
+// Manage the user file used by app_server with -auth local
+
+func main() {
+	cmd := path.Base(os.Args[0])
+
+	usersFile := flag.String("users_file", "", "User `file` (JSON, created if it doesn't exist)")
+	admin := flag.Bool("admin", false, "Give the user admin rights")
+	del := flag.Bool("delete", false, "Delete the user")
+	list := flag.Bool("list", false, "List users")
+	flag.Parse()
+
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s -users_file <file> [-admin|-delete] <user name>\n", cmd)
+		fmt.Fprintf(os.Stderr, "       %s -users_file <file> -list\n", cmd)
+		fmt.Fprintf(os.Stderr, "\nThe password is read from stdin.\n")
+		fmt.Fprintf(os.Stderr, "Flags:\n")
+		flag.PrintDefaults()
+	}
+
+	if *usersFile == "" {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	db, err := auth.LoadUserDB(*usersFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load user file : %v\n", err)
+		os.Exit(1)
+	}
+
+	if *list {
+		for _, u := range db.UserNames() {
+			user, _ := db.Get(u)
+			if user.Admin {
+				fmt.Printf("%s\tadmin\n", u)
+			} else {
+				fmt.Printf("%s\n", u)
+			}
+		}
+		return
+	}
+
+	if len(flag.Args()) != 1 {
+		flag.Usage()
+		os.Exit(1)
+	}
+	userName := flag.Args()[0]
+
+	if *del {
+		err = db.Delete(userName)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to delete user : %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Fprintf(os.Stderr, "Deleted user %s\n", auth.NormaliseUserName(userName))
+		return
+	}
+
+	fmt.Fprintf(os.Stderr, "Password for %s: ", auth.NormaliseUserName(userName))
+	password, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && password == "" {
+		fmt.Fprintf(os.Stderr, "\nFailed to read password : %v\n", err)
+		os.Exit(1)
+	}
+	password = strings.TrimRight(password, "\r\n")
+
+	err = db.Set(userName, password, *admin)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to save user : %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Fprintf(os.Stderr, "Saved user %s\n", auth.NormaliseUserName(userName))
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"path"
 	//"regexp"
@@ -10,6 +11,7 @@ import (
 	"path/filepath"
 	"sort"
 	"strings"
+	"time"
 
 	"github.com/stts-se/transtool-open/dbapi"
 	"github.com/stts-se/transtool-open/protocol"
@@ -117,6 +119,74 @@ func listJSONFiles(dir string) []string {
 	return res
 }
 
+// fixAnnotations applies the safe fixes to the annotations, prints a
+// diff, and saves the fixed annotations (unless dryRun). The annos
+// map is updated with the fixed annotations, unless dryRun. Returns
+// the number of fixed pages.
+func fixAnnotations(out io.Writer, dirName string, validator validation.Validator, annos map[string]protocol.AnnotationPayload, dryRun bool) int {
+	var ids []string
+	for id := range annos {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	db := dbapi.NewDBAPI(dirName, nil)
+	n := 0
+	for _, id := range ids {
+		anno := annos[id]
+		if anno.CurrentStatus.Name == "delete" || anno.CurrentStatus.Name == "skip" {
+			continue
+		}
+		subProj := anno.SubProj
+		anno.SubProj = strings.TrimSuffix(dirName, "/")
+		fixed, fixes := validator.FixAnnotation(anno, true)
+		if len(fixes) == 0 {
+			continue
+		}
+		fixed.SubProj = subProj
+		n++
+
+		// the fixed chunks keep their status name, with the fix as status source
+		timestamp := time.Now().Format("2006-01-02 15:04:05")
+		for i, c := range fixed.Chunks {
+			if c.Trans == anno.Chunks[i].Trans {
+				continue
+			}
+			if c.CurrentStatus.Name != "" {
+				c.StatusHistory = append(append([]protocol.Status{}, c.StatusHistory...), c.CurrentStatus)
+			}
+			c.CurrentStatus = protocol.Status{Name: c.CurrentStatus.Name, Source: dbapi.AutoFixSource, Timestamp: timestamp}
+			fixed.Chunks[i] = c
+		}
+
+		f := path.Join(db.AnnotationDataDir, id+".json")
+		fmt.Fprintf(out, "--- %s\n+++ %s\n", f, f)
+		for i, c := range anno.Chunks {
+			if c.Trans == fixed.Chunks[i].Trans {
+				continue
+			}
+			var rules []string
+			for _, vr := range fixes {
+				if vr.ChunkIndex == i {
+					rules = append(rules, vr.RuleName)
+				}
+			}
+			fmt.Fprintf(out, "@@ chunk %d (%s) @@\n-%s\n+%s\n", i+1, strings.Join(rules, ", "), c.Trans, fixed.Chunks[i].Trans)
+		}
+
+		if dryRun {
+			continue
+		}
+		err := db.Save(fixed)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to save fixed annotation %s : %v\n", f, err)
+			os.Exit(1)
+		}
+		annos[id] = fixed
+	}
+	return n
+}
+
 type kv struct {
 	k string
 	v int
@@ -125,17 +195,43 @@ type kv struct {
 func main() {
 
 	annotationOnly := flag.Bool("annotation_json_only", false, "Validate only annotation JSON files, ignoring audio and JSON \"source\" files")
+	format := flag.String("format", formatText, "Output `format`: text, json, junit or html (for formats other than text, the text output is printed to stderr)")
+	output := flag.String("output", "", "Output `file` for json, junit and html reports (default stdout)")
+	fix := flag.Bool("fix", false, "Apply safe fixes suggested by the validation rules, and save the fixed annotation files")
+	dryRun := flag.Bool("dry_run", false, "With -fix: print a diff of the fixes, without saving")
+	failOn := flag.String("fail_on", "none", "Exit with non-zero exit code if an issue of at least this `level` is found: fatal, error, warning or none.\nThe exit code is the highest level found: 3 for fatal, 2 for error and 1 for warning")
 	flag.Parse()
 	args := flag.Args()
 
 	if len(args) < 2 {
 		fmt.Fprintf(os.Stderr, "USAGE: <JSON config file> <sub proj dirs> ...\n")
 		fmt.Fprintf(os.Stderr, "\n-annotation_json_only to ignore audio and JSON \"source\" files\n")
+		fmt.Fprintf(os.Stderr, "-format text|json|junit|html for output format, and -output <file> to write the report to file\n")
+		fmt.Fprintf(os.Stderr, "-fix to apply safe fixes, and -dry_run to only print a diff of the fixes\n")
+		fmt.Fprintf(os.Stderr, "-fail_on fatal|error|warning|none for exit code policy (exit code 3 for fatal, 2 for error, 1 for warning)\n")
 		fmt.Fprintf(os.Stderr, "\n(Sample config file in validation/sample_validation_config.json)\n")
 		os.Exit(0)
 
 	}
 
+	switch *format {
+	case formatText, formatJSON, formatJUnit, formatHTML:
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown format '%s'\n", *format)
+		os.Exit(1)
+	}
+	if _, ok := levelRank[*failOn]; !ok && *failOn != "none" {
+		fmt.Fprintf(os.Stderr, "Unknown level for -fail_on '%s'\n", *failOn)
+		os.Exit(1)
+	}
+
+	// text output goes to stderr if a report is written to stdout
+	var out io.Writer = os.Stdout
+	if *format != formatText {
+		out = os.Stderr
+	}
+	rep := newReport()
+
 	bts, err := os.ReadFile(args[0])
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Failed to read config file '%s' : %v", args[0], err)
@@ -162,13 +258,14 @@ func main() {
 	//var issues []dbapi.ValRes
 	issues := map[dbapi.ValRes]int{}
 	for _, dirName := range args[1:] {
-		fmt.Println("DIR ", dirName)
+		fmt.Fprintln(out, "DIR ", dirName)
 		_, err := os.Stat(dirName)
 		if err != nil {
 
 			msg := fmt.Sprintf("Failed for dir '%s' : %v\n", dirName, err)
 			//issues = append(issues, dbapi.ValRes{Level:"error", Message:msg})
 			issues[dbapi.ValRes{Level: "error", Message: msg}]++
+			rep.add(reportItem{SubProj: dirName, RuleName: "load_data", Level: "error", ChunkIndex: -1, Message: strings.TrimSpace(msg)})
 			//fmt.Fprintf(os.Stderr, msg)
 			//os.Exit(1)
 		}
@@ -179,6 +276,7 @@ func main() {
 			msg := fmt.Sprintf("Failed for dir '%s' : %v\n", sourcePath, err)
 			//issues = append(issues, dbapi.ValRes{Level:"error", Message:msg})
 			issues[dbapi.ValRes{Level: "error", Message: msg}]++
+			rep.add(reportItem{SubProj: dirName, RuleName: "load_data", Level: "error", ChunkIndex: -1, Message: strings.TrimSpace(msg)})
 			//fmt.Fprintf(os.Stderr, msg)
 			//os.Exit(1)
 		}
@@ -189,6 +287,7 @@ func main() {
 			msg := fmt.Sprintf("Failed for dir '%s' : %v\n", annotationPath, err)
 			//issues = append(issues, dbapi.ValRes{Level:"error", Message:msg})
 			issues[dbapi.ValRes{Level: "error", Message: msg}]++
+			rep.add(reportItem{SubProj: dirName, RuleName: "load_data", Level: "error", ChunkIndex: -1, Message: strings.TrimSpace(msg)})
 			//fmt.Fprintf(os.Stderr, msg)
 			//os.Exit(1)
 		}
@@ -203,12 +302,14 @@ func main() {
 				//fmt.Fprintf(os.Stderr, msg)
 				//issues = append(issues, dbapi.ValRes{Level:"error", Message:msg})
 				issues[dbapi.ValRes{Level: "error", Message: msg}]++
+				rep.add(reportItem{SubProj: dirName, RuleName: "load_data", Level: "error", ChunkIndex: -1, Message: strings.TrimSpace(msg)})
 
 				//os.Exit(1)
 			}
 			//issues = append(issues, vRes...)
 			for _, vr := range vRes {
 				issues[vr]++
+				rep.add(reportItem{SubProj: dirName, RuleName: "load_data", Level: vr.Level, ChunkIndex: -1, Message: vr.Message})
 			}
 
 			annos = db.GetAnnotationData()
@@ -219,6 +320,7 @@ func main() {
 				msg := fmt.Sprintf("Failed for dir '%s' : %v\n", annotationPath, err)
 				//issues = append(issues, dbapi.ValRes{Level:"error", Message:msg})
 				issues[dbapi.ValRes{Level: "error", Message: msg}]++
+				rep.add(reportItem{SubProj: dirName, RuleName: "load_data", Level: "error", ChunkIndex: -1, Message: strings.TrimSpace(msg)})
 				//fmt.Fprintf(os.Stderr, msg)
 				//os.Exit(1)
 			}
@@ -227,6 +329,7 @@ func main() {
 				msg := fmt.Sprintf("Failed to load annotation files from '%s': %v", annotationPath, err)
 				//issues = append(issues, dbapi.ValRes{Level:"error", Message:msg})
 				issues[dbapi.ValRes{Level: "error", Message: msg}]++
+				rep.add(reportItem{SubProj: dirName, RuleName: "load_data", Level: "error", ChunkIndex: -1, Message: strings.TrimSpace(msg)})
 				//fmt.Fprintf(os.Stderr, msg)
 				//os.Exit(1)
 
@@ -235,6 +338,7 @@ func main() {
 			//issues = append(issues, vRes...)
 			for _, vr := range vRes {
 				issues[vr]++
+				rep.add(reportItem{SubProj: dirName, RuleName: "load_data", Level: vr.Level, ChunkIndex: -1, Message: vr.Message})
 			}
 
 		}
@@ -261,9 +365,9 @@ func main() {
 				msg := fmt.Sprintf("File name '%s' in multiple dirs %s", fn, strings.Join(dirs0, ", "))
 
 				issues[dbapi.ValRes{Level: "error", Message: "duplicate_file_name" + "\t" + msg}]++
+				rep.add(reportItem{SubProj: dirName, PageID: a.Page.ID, Audio: fn, RuleName: "duplicate_file_name", Level: "error", ChunkIndex: -1, Message: msg})
 			}
 
-
 			//if a.CurrentStatus.Name != "" {
 			pageStatusStats[a.CurrentStatus.Name]++
 			//}
@@ -279,22 +383,44 @@ func main() {
 			}
 		}
 
-		fmt.Printf("\nPAGE STATUS COUNT\n==============\n")
+		fmt.Fprintf(out, "\nPAGE STATUS COUNT\n==============\n")
 		for k, v := range pageStatusStats {
-			fmt.Printf("'%s'\t%d\n", k, v)
+			fmt.Fprintf(out, "'%s'\t%d\n", k, v)
 		}
 
-		fmt.Printf("\nCHUNK STATUS COUNT (excluding skip or delete pages)\n==============\n")
+		fmt.Fprintf(out, "\nCHUNK STATUS COUNT (excluding skip or delete pages)\n==============\n")
 		for k, v := range statusStats {
-			fmt.Printf("%s\t%d\n", k, v)
+			fmt.Fprintf(out, "%s\t%d\n", k, v)
 		}
 
-		fmt.Printf("\nCHUNK SOURCE COUNT\n==============\n")
+		fmt.Fprintf(out, "\nCHUNK SOURCE COUNT\n==============\n")
 		for k, v := range chunkSourceStats {
-			fmt.Printf("%s\t%d\n", k, v)
+			fmt.Fprintf(out, "%s\t%d\n", k, v)
 		}
 
-		fmt.Println()
+		fmt.Fprintln(out)
+
+		// the sub project may have its own validation config
+		dirValidator := validator
+		dirConfig := path.Join(dirName, dbapi.ValidationConfigFileName)
+		if bts, err := os.ReadFile(dirConfig); err == nil {
+			dirValidator, err = validation.NewValidatorFromJSON(bts)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to create validator from '%s' : %v\n", dirConfig, err)
+				os.Exit(1)
+			}
+			fmt.Fprintf(out, "Using validation config %s\n\n", dirConfig)
+		}
+		rep.addSubProj(dirName, dirValidator)
+
+		if *fix {
+			nFixed := fixAnnotations(out, dirName, dirValidator, annos, *dryRun)
+			if *dryRun {
+				fmt.Fprintf(out, "%d pages in %s would be fixed (dry run)\n\n", nFixed, dirName)
+			} else {
+				fmt.Fprintf(out, "Fixed %d pages in %s\n\n", nFixed, dirName)
+			}
+		}
 
 		for fn, anno := range annos {
 
@@ -302,22 +428,40 @@ func main() {
 				continue
 			}
 
-			valres := validator.ValidateAnnotation(anno)
+			anno.SubProj = strings.TrimSuffix(dirName, "/")
+			valres := dirValidator.ValidateAnnotation(anno)
 
-			valres = append(valres, validator.IdenticalTranscriptions(anno)...)
+			valres = append(valres, dirValidator.IdenticalTranscriptions(anno)...)
 
 			valres = ignoreEQTransVal(valres, fn)
 
 			if len(valres) > 0 {
-				fmt.Printf("%s has %d issues\n", fn, len(valres))
+				fmt.Fprintf(out, "%s has %d issues\n", fn, len(valres))
 				for _, vr := range valres {
 
-					fmt.Printf("%s\t%s\t%s\t%s\n", fn, vr.Level, vr.RuleName, vr.Message) //, vr.ChunkIndex)
+					fmt.Fprintf(out, "%s\t%s\t%s\t%s\n", fn, vr.Level, vr.RuleName, vr.Message) //, vr.ChunkIndex)
 					issues[dbapi.ValRes{Level: "validation", Message: vr.Level + "\t" + vr.RuleName /*+ "\t" + vr.Message*/}]++
+					rep.add(reportItem{SubProj: dirName, PageID: anno.Page.ID, Audio: anno.Page.Audio, RuleName: vr.RuleName, Level: vr.Level, ChunkIndex: vr.ChunkIndex, Message: vr.Message})
 				}
-				fmt.Println()
+				fmt.Fprintln(out)
 			}
 		}
+
+		// corpus level validation of the sub project
+		var annoList []protocol.AnnotationPayload
+		for _, anno := range annos {
+			annoList = append(annoList, anno)
+		}
+		corpusRes := dirValidator.ValidateCorpus(annoList)
+		if len(corpusRes) > 0 {
+			fmt.Fprintf(out, "%s has %d corpus issues\n", dirName, len(corpusRes))
+			for _, vr := range corpusRes {
+				fmt.Fprintf(out, "%s\t%s\t%s\t%s\t%s\n", dirName, vr.Level, vr.RuleName, vr.Message, strings.Join(vr.PageIDs, ","))
+				issues[dbapi.ValRes{Level: "validation", Message: vr.Level + "\t" + vr.RuleName}]++
+				rep.add(reportItem{SubProj: dirName, PageIDs: vr.PageIDs, RuleName: vr.RuleName, Level: vr.Level, ChunkIndex: -1, Message: vr.Message})
+			}
+			fmt.Fprintln(out)
+		}
 		//res := validate(config, db)
 		//_ = res
 	}
@@ -332,22 +476,48 @@ func main() {
 
 	sort.Slice(kvs, func(i, j int) bool { return kvs[i].v > kvs[j].v })
 	if len(kvs) > 0 {
-		fmt.Println("=== ISSUES BY FREQUENCY===")
+		fmt.Fprintln(out, "=== ISSUES BY FREQUENCY===")
 	}
 	for _, kv := range kvs {
-		fmt.Printf("%s\t%d\n", kv.k, kv.v)
+		fmt.Fprintf(out, "%s\t%d\n", kv.k, kv.v)
 	}
 	// if len(kvs) > 0 {
-	// 	fmt.Println()
+	// 	fmt.Fprintln(out)
 	// }
 
 	// if len(issType) > 0 {
-	// 	fmt.Println("=== NUMBER OF ISSUES BY TYPE ===")
+	// 	fmt.Fprintln(out, "=== NUMBER OF ISSUES BY TYPE ===")
 	// }
 	// for issT, freq := range issType {
-	// 	fmt.Printf("%s\t%d\n", issT, freq)
+	// 	fmt.Fprintf(out, "%s\t%d\n", issT, freq)
 	// }
 
+	if *format != formatText {
+		w := os.Stdout
+		if *output != "" {
+			w, err = os.Create(*output)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to create output file : %v\n", err)
+				os.Exit(1)
+			}
+		}
+		err = rep.write(w, *format)
+		if *output != "" {
+			if cErr := w.Close(); err == nil {
+				err = cErr
+			}
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to write %s report : %v\n", *format, err)
+			os.Exit(1)
+		}
+	}
+
+	if code := rep.exitCode(*failOn); code != 0 {
+		fmt.Fprintf(os.Stderr, "Highest issue level found: %s\n", rep.maxLevel())
+		os.Exit(code)
+	}
+
 }
 
 /*
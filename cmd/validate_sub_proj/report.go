@@ -0,0 +1,308 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"html/template"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/stts-se/transtool-open/validation"
+)
+
+// Output formats
+const (
+	formatText  = "text"
+	formatJSON  = "json"
+	formatJUnit = "junit"
+	formatHTML  = "html"
+)
+
+// levelRank is used for the exit code policy: the exit code is the
+// rank of the highest level found (if at least the -fail_on level)
+var levelRank = map[string]int{
+	"warning": 1,
+	"error":   2,
+	"fatal":   3,
+}
+
+// reportItem is a validation result with information on where it was found
+type reportItem struct {
+	SubProj    string   `json:"sub_proj"`
+	PageID     string   `json:"page_id,omitempty"`
+	PageIDs    []string `json:"page_ids,omitempty"`
+	Audio      string   `json:"audio,omitempty"`
+	RuleName   string   `json:"rule_name"`
+	Level      string   `json:"level"`
+	ChunkIndex int      `json:"chunk_index"`
+	Message    string   `json:"message"`
+}
+
+type report struct {
+	Created  string         `json:"created"`
+	SubProjs []string       `json:"sub_projs"`
+	Counts   map[string]int `json:"counts"` // level -> number of items
+	Items    []reportItem   `json:"items"`
+
+	// rule names known from the validation configs, per sub project
+	rules map[string]map[string]bool
+}
+
+func newReport() *report {
+	return &report{
+		Created: time.Now().Format("2006-01-02 15:04:05"),
+		Counts:  map[string]int{},
+		rules:   map[string]map[string]bool{},
+	}
+}
+
+// registerSubProj adds subProj to the sub projects of the report, if not already added
+func (r *report) registerSubProj(subProj string) {
+	if _, ok := r.rules[subProj]; ok {
+		return
+	}
+	r.SubProjs = append(r.SubProjs, subProj)
+	r.rules[subProj] = map[string]bool{}
+}
+
+func (r *report) addSubProj(subProj string, v validation.Validator) {
+	r.registerSubProj(subProj)
+	cfg := v.Config()
+	for _, rv := range cfg.TransMustMatch {
+		r.rules[subProj][rv.RuleName] = true
+	}
+	for _, rv := range cfg.TransMustNotMatch {
+		r.rules[subProj][rv.RuleName] = true
+	}
+}
+
+// add adds an item to the report. Items may be added before the sub
+// project's validator is known (e.g., load errors), so that sub
+// projects failing to load are also included in the report.
+func (r *report) add(item reportItem) {
+	r.registerSubProj(item.SubProj)
+	r.rules[item.SubProj][item.RuleName] = true
+	r.Counts[item.Level]++
+	r.Items = append(r.Items, item)
+}
+
+// maxLevel returns the highest level found (by levelRank), or the empty string
+func (r *report) maxLevel() string {
+	res := ""
+	for l := range r.Counts {
+		if levelRank[l] > levelRank[res] {
+			res = l
+		}
+	}
+	return res
+}
+
+// exitCode returns the rank of the highest level found, if it is at least failOn, otherwise 0
+func (r *report) exitCode(failOn string) int {
+	max := r.maxLevel()
+	if failOn == "none" || levelRank[max] < levelRank[failOn] {
+		return 0
+	}
+	return levelRank[max]
+}
+
+func (r *report) write(w io.Writer, format string) error {
+	switch format {
+	case formatJSON:
+		return r.writeJSON(w)
+	case formatJUnit:
+		return r.writeJUnit(w)
+	case formatHTML:
+		return r.writeHTML(w)
+	}
+	return fmt.Errorf("unknown report format '%s'", format)
+}
+
+func (r *report) writeJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+type junitTestSuites struct {
+	XMLName  xml.Name         `xml:"testsuites"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Suites   []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Timestamp string          `xml:"timestamp,attr"`
+	Cases     []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Text    string `xml:",chardata"`
+}
+
+func (i reportItem) String() string {
+	where := i.PageID
+	if len(i.PageIDs) > 0 {
+		where = strings.Join(i.PageIDs, ",")
+	}
+	return fmt.Sprintf("%s\t%s\t%s", i.Level, where, i.Message)
+}
+
+// writeJUnit writes one test suite per sub project, and one test case
+// per rule. Rules with fatal or error issues fail, other issues are
+// listed as test output.
+func (r *report) writeJUnit(w io.Writer) error {
+	res := junitTestSuites{}
+	for _, sp := range r.SubProjs {
+		suite := junitTestSuite{Name: sp, Timestamp: r.Created}
+		var rules []string
+		for rule := range r.rules[sp] {
+			rules = append(rules, rule)
+		}
+		sort.Strings(rules)
+		for _, rule := range rules {
+			tc := junitTestCase{Name: rule, ClassName: sp}
+			var failures, other []string
+			maxLevel := ""
+			for _, i := range r.Items {
+				if i.SubProj != sp || i.RuleName != rule {
+					continue
+				}
+				if levelRank[i.Level] >= levelRank["error"] {
+					failures = append(failures, i.String())
+					if levelRank[i.Level] > levelRank[maxLevel] {
+						maxLevel = i.Level
+					}
+				} else {
+					other = append(other, i.String())
+				}
+			}
+			if len(failures) > 0 {
+				tc.Failure = &junitFailure{
+					Message: fmt.Sprintf("%d issues", len(failures)),
+					Type:    maxLevel,
+					Text:    strings.Join(failures, "\n"),
+				}
+				suite.Failures++
+			}
+			if len(other) > 0 {
+				tc.SystemOut = strings.Join(other, "\n")
+			}
+			suite.Cases = append(suite.Cases, tc)
+		}
+		suite.Tests = len(suite.Cases)
+		res.Tests += suite.Tests
+		res.Failures += suite.Failures
+		res.Suites = append(res.Suites, suite)
+	}
+
+	_, err := io.WriteString(w, xml.Header)
+	if err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	err = enc.Encode(res)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, "\n")
+	return err
+}
+
+type htmlRule struct {
+	RuleName string
+	Items    []reportItem
+}
+
+type htmlLevel struct {
+	Level string
+	Count int
+	Rules []htmlRule
+}
+
+var htmlTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Validation report {{.Created}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+h2.fatal, h2.error { color: #b00; }
+h2.warning { color: #c70; }
+summary { cursor: pointer; font-weight: bold; margin: 0.3em 0; }
+table { border-collapse: collapse; margin: 0.5em 0 1em 1em; }
+td, th { border: 1px solid #ccc; padding: 0.2em 0.5em; text-align: left; vertical-align: top; }
+th { background: #eee; }
+</style>
+</head>
+<body>
+<h1>Validation report</h1>
+<p>Created {{.Created}}<br>Sub projects: {{range $i, $sp := .SubProjs}}{{if $i}}, {{end}}{{$sp}}{{end}}</p>
+{{if not .Levels}}<p>No issues found.</p>{{end}}
+{{range .Levels}}
+<h2 class="{{.Level}}">{{.Level}} ({{.Count}})</h2>
+{{range .Rules}}
+<details>
+<summary>{{.RuleName}} ({{len .Items}})</summary>
+<table>
+<tr><th>Sub project</th><th>Page</th><th>Audio</th><th>Chunk</th><th>Message</th></tr>
+{{range .Items}}<tr><td>{{.SubProj}}</td><td>{{if .PageIDs}}{{range $i, $id := .PageIDs}}{{if $i}}, {{end}}{{$id}}{{end}}{{else}}{{.PageID}}{{end}}</td><td>{{.Audio}}</td><td>{{if ge .ChunkIndex 0}}{{.ChunkIndex}}{{end}}</td><td>{{.Message}}</td></tr>
+{{end}}</table>
+</details>
+{{end}}
+{{end}}
+</body>
+</html>
+`))
+
+// writeHTML writes a self-contained HTML report, grouped by level and rule
+func (r *report) writeHTML(w io.Writer) error {
+	byLevel := map[string]map[string][]reportItem{}
+	for _, i := range r.Items {
+		if _, ok := byLevel[i.Level]; !ok {
+			byLevel[i.Level] = map[string][]reportItem{}
+		}
+		byLevel[i.Level][i.RuleName] = append(byLevel[i.Level][i.RuleName], i)
+	}
+
+	var levels []htmlLevel
+	for l, rules := range byLevel {
+		hl := htmlLevel{Level: l, Count: r.Counts[l]}
+		for rule, items := range rules {
+			hl.Rules = append(hl.Rules, htmlRule{RuleName: rule, Items: items})
+		}
+		sort.Slice(hl.Rules, func(i, j int) bool { return hl.Rules[i].RuleName < hl.Rules[j].RuleName })
+		levels = append(levels, hl)
+	}
+	// highest level first
+	sort.Slice(levels, func(i, j int) bool {
+		if levelRank[levels[i].Level] != levelRank[levels[j].Level] {
+			return levelRank[levels[i].Level] > levelRank[levels[j].Level]
+		}
+		return levels[i].Level < levels[j].Level
+	})
+
+	data := struct {
+		Created  string
+		SubProjs []string
+		Levels   []htmlLevel
+	}{r.Created, r.SubProjs, levels}
+
+	return htmlTemplate.Execute(w, data)
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"strings"
+	"testing"
+
+	"github.com/stts-se/transtool-open/validation"
+)
+
+func TestExitCode(t *testing.T) {
+	for _, test := range []struct {
+		levels []string
+		failOn string
+		exp    int
+	}{
+		{levels: nil, failOn: "warning", exp: 0},
+		{levels: []string{"warning", "error"}, failOn: "none", exp: 0},
+		{levels: []string{"warning"}, failOn: "warning", exp: 1},
+		{levels: []string{"warning"}, failOn: "error", exp: 0},
+		{levels: []string{"warning", "error"}, failOn: "warning", exp: 2},
+		{levels: []string{"warning", "error"}, failOn: "error", exp: 2},
+		{levels: []string{"error", "fatal"}, failOn: "error", exp: 3},
+		{levels: []string{"error"}, failOn: "fatal", exp: 0},
+		{levels: []string{"fatal"}, failOn: "fatal", exp: 3},
+	} {
+		rep := newReport()
+		for _, l := range test.levels {
+			rep.add(reportItem{SubProj: "sp1", RuleName: "rule", Level: l, ChunkIndex: -1})
+		}
+		if w, g := test.exp, rep.exitCode(test.failOn); w != g {
+			t.Errorf("levels %v with fail on %s: wanted %d got %d", test.levels, test.failOn, w, g)
+		}
+	}
+}
+
+func TestWriteJUnit(t *testing.T) {
+	v, err := validation.NewValidator(validation.ConfigExample2)
+	if err != nil {
+		t.Fatalf("got error from NewValidator: %v", err)
+	}
+
+	rep := newReport()
+	// a sub project failing to load has no validator
+	rep.add(reportItem{SubProj: "sp0", RuleName: "load_data", Level: "error", ChunkIndex: -1, Message: "Failed for dir 'sp0'"})
+	rep.addSubProj("sp1", v)
+	rep.add(reportItem{SubProj: "sp1", PageID: "p1", RuleName: "repeated_full_stops", Level: "error", ChunkIndex: 0, Message: "repeated full stops"})
+	rep.add(reportItem{SubProj: "sp1", PageID: "p2", RuleName: "repeated_full_stops", Level: "fatal", ChunkIndex: 1, Message: "repeated full stops"})
+	rep.add(reportItem{SubProj: "sp1", PageID: "p3", RuleName: "long_chunk", Level: "warning", ChunkIndex: 2, Message: "long chunk"})
+
+	var buf bytes.Buffer
+	if err := rep.write(&buf, formatJUnit); err != nil {
+		t.Fatalf("got error from write: %v", err)
+	}
+	if !strings.HasPrefix(buf.String(), xml.Header) {
+		t.Errorf("expected XML header, got %s", buf.String())
+	}
+
+	var res junitTestSuites
+	if err := xml.Unmarshal(buf.Bytes(), &res); err != nil {
+		t.Fatalf("got error from xml.Unmarshal: %v", err)
+	}
+	if w, g := 4, res.Tests; w != g {
+		t.Errorf("wanted %d got %d", w, g)
+	}
+	if w, g := 2, res.Failures; w != g {
+		t.Errorf("wanted %d got %d", w, g)
+	}
+	if len(res.Suites) != 2 {
+		t.Fatalf("expected 2 test suites, got %#v", res.Suites)
+	}
+
+	sp0 := res.Suites[0]
+	if sp0.Name != "sp0" || len(sp0.Cases) != 1 || sp0.Failures != 1 {
+		t.Fatalf("expected one failing test case for sp0, got %#v", sp0)
+	}
+	if w, g := "load_data", sp0.Cases[0].Name; w != g {
+		t.Errorf("wanted %s got %s", w, g)
+	}
+
+	sp1 := res.Suites[1]
+	if sp1.Name != "sp1" || sp1.Tests != 3 || sp1.Failures != 1 {
+		t.Fatalf("expected three test cases for sp1 with one failure, got %#v", sp1)
+	}
+	cases := map[string]junitTestCase{}
+	for _, tc := range sp1.Cases {
+		cases[tc.Name] = tc
+	}
+	// rules without issues pass
+	if tc, ok := cases["trans_initial_label"]; !ok || tc.Failure != nil || tc.SystemOut != "" {
+		t.Errorf("expected passing test case trans_initial_label, got %#v", tc)
+	}
+	// errors and fatals fail, with the highest level as type
+	if tc := cases["repeated_full_stops"]; tc.Failure == nil || tc.Failure.Type != "fatal" || tc.Failure.Message != "2 issues" {
+		t.Errorf("expected fatal failure with 2 issues for repeated_full_stops, got %#v", tc)
+	}
+	// warnings are listed as test output
+	if tc := cases["long_chunk"]; tc.Failure != nil || !strings.Contains(tc.SystemOut, "long chunk") {
+		t.Errorf("expected passing test case long_chunk with output, got %#v", tc)
+	}
+}
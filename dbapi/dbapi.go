@@ -7,11 +7,13 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"reflect"
 	"regexp"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	// "golang.org/x/exp/maps"
 	"golang.org/x/exp/slices"
@@ -25,6 +27,11 @@ const (
 	debug = false
 )
 
+// ValidationConfigFileName is the name of an optional validation
+// config in a sub-project directory, overriding the default
+// validation config for the sub-project
+const ValidationConfigFileName = "validation_config.json"
+
 type ClientID struct {
 	ID       string `json:"id"`
 	UserName string `json:"user_name"`
@@ -44,6 +51,7 @@ func NewProj(dirList string, validator *validation.Validator) (Proj, error) {
 		mutex:         &sync.RWMutex{},
 		DBs:           map[string]*DBAPI{},
 		statusSources: map[string]bool{},
+		validator:     validator,
 	}
 
 	paths := strings.Split(dirList, ":")
@@ -98,10 +106,100 @@ func (p *Proj) AddProj(dir string, validator *validation.Validator) error {
 	}
 
 	db := NewDBAPI(dir, validator)
+	err = db.loadValidationConfig()
+	if err != nil {
+		return err
+	}
 	p.DBs[dir] = db
 	return nil
 }
 
+// Validator returns the validator of the sub-project, or the default
+// validator if the sub-project has no validation config of its own
+func (p *Proj) Validator(subProj string) *validation.Validator {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	if db, ok := p.DBs[subProj]; ok {
+		return db.Validator()
+	}
+	return p.validator
+}
+
+// ReloadValidationConfigs re-reads the sub-project validation configs
+func (p *Proj) ReloadValidationConfigs() error {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	for sp, db := range p.DBs {
+		err := db.loadValidationConfig()
+		if err != nil {
+			return fmt.Errorf("failed to reload validation config for sub project %s : %v", sp, err)
+		}
+	}
+	return nil
+}
+
+// ValidateCorpus runs the corpus level validation of a sub-project, using the validator of the sub-project
+func (p *Proj) ValidateCorpus(subProj string) (validation.CorpusValidation, error) {
+	db := p.GetDB(subProj)
+	if db == nil {
+		return validation.CorpusValidation{}, fmt.Errorf("dbapi.Proj.ValidateCorpus: no such sub proj '%s'", subProj)
+	}
+	annos := db.AnnotationList()
+	res := validation.CorpusValidation{
+		SubProj: subProj,
+		Pages:   len(annos),
+		Result:  db.Validator().ValidateCorpus(annos),
+	}
+	return res, nil
+}
+
+// RewriteTrans rewrites the chunk transcriptions of a sub-project
+// using the rewrite function (see DBAPI.RewriteTrans).
+func (p *Proj) RewriteTrans(subProj string, rewrite func(string) string, apply bool) (RewriteResult, error) {
+	db := p.GetDB(subProj)
+	if db == nil {
+		return RewriteResult{}, fmt.Errorf("dbapi.Proj.RewriteTrans: no such sub proj '%s'", subProj)
+	}
+	res, err := db.RewriteTrans(rewrite, apply)
+	res.SubProj = subProj
+	return res, err
+}
+
+// RewriteChunks rewrites the chunks of a sub-project using the rewrite
+// function (see DBAPI.RewriteChunks).
+func (p *Proj) RewriteChunks(subProj string, rewrite func(protocol.TransChunk) protocol.TransChunk, apply bool) (RewriteResult, error) {
+	db := p.GetDB(subProj)
+	if db == nil {
+		return RewriteResult{}, fmt.Errorf("dbapi.Proj.RewriteChunks: no such sub proj '%s'", subProj)
+	}
+	res, err := db.RewriteChunks(rewrite, apply)
+	res.SubProj = subProj
+	return res, err
+}
+
+// UpdateQuality updates the audio quality of the pages of a sub-project
+// (see DBAPI.UpdateQuality).
+func (p *Proj) UpdateQuality(subProj string, analyse func(protocol.AnnotationPayload) (protocol.AnnotationPayload, error), autoSkip string, force bool) (QualityResult, error) {
+	db := p.GetDB(subProj)
+	if db == nil {
+		return QualityResult{}, fmt.Errorf("dbapi.Proj.UpdateQuality: no such sub proj '%s'", subProj)
+	}
+	res, err := db.UpdateQuality(analyse, autoSkip, force)
+	res.SubProj = subProj
+	return res, err
+}
+
+// OKTranscriptions returns the okayed chunk transcriptions of all sub-projects (see DBAPI.OKTranscriptions)
+func (p *Proj) OKTranscriptions() []string {
+	res := []string{}
+	for _, subProj := range p.ListSubProjs() {
+		if db := p.GetDB(subProj); db != nil {
+			res = append(res, db.OKTranscriptions()...)
+		}
+	}
+	return res
+}
+
 // GetStatusSources returns a list of the "status sources" (typically editor user names) known in the project
 func (p *Proj) GetStatusSources() []string {
 	var res []string
@@ -127,6 +225,55 @@ func (p *Proj) GetDB(projName string) *DBAPI {
 	return p.DBs[projName]
 }
 
+// SubProjName returns the name of a sub-project in URLs (see
+// ResolveSubProj): the base name of the directory, if no other
+// sub-project has the same base name, otherwise the full directory path
+// with ':' instead of '/'. Directory paths cannot contain ':', since it
+// separates the project directories (see NewProj).
+func (p *Proj) SubProjName(subProj string) string {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	base := filepath.Base(subProj)
+	for sp := range p.DBs {
+		if sp != subProj && filepath.Base(sp) == base {
+			return strings.ReplaceAll(filepath.ToSlash(subProj), "/", ":")
+		}
+	}
+	return base
+}
+
+// ResolveSubProj returns the sub-project with the given name: the full
+// directory path (with '/' or ':' as separator, see SubProjName), or a
+// base name matching a single sub-project
+func (p *Proj) ResolveSubProj(name string) (string, error) {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	if _, ok := p.DBs[name]; ok {
+		return name, nil
+	}
+	if strings.Contains(name, ":") {
+		dir := filepath.FromSlash(strings.ReplaceAll(name, ":", "/"))
+		if _, ok := p.DBs[dir]; ok {
+			return dir, nil
+		}
+		return "", fmt.Errorf("no such sub proj '%s'", name)
+	}
+	var res []string
+	for sp := range p.DBs {
+		if filepath.Base(sp) == name {
+			res = append(res, sp)
+		}
+	}
+	switch len(res) {
+	case 0:
+		return "", fmt.Errorf("no such sub proj '%s'", name)
+	case 1:
+		return res[0], nil
+	}
+	sort.Strings(res)
+	return "", fmt.Errorf("ambiguous sub proj name '%s' (%s)", name, strings.Join(res, ", "))
+}
+
 func (p *Proj) ListSubProjs() []string {
 	var res []string
 	p.mutex.RLock()
@@ -316,6 +463,71 @@ func (p *Proj) ListAudioFiles(subProj string) ([]string, error) {
 	return db.ListAudioFiles(), nil
 }
 
+// PageInfos wraps dbapi.DBAPI.PageInfos
+func (p *Proj) PageInfos(subProj string) ([]PageInfo, error) {
+	p.mutex.RLock()
+	db, ok := p.DBs[subProj]
+	p.mutex.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("dbapi.Proj.PageInfos: no such sub proj '%s'", subProj)
+	}
+	return db.PageInfos(), nil
+}
+
+// Annotation wraps dbapi.DBAPI.Annotation
+func (p *Proj) Annotation(subProj, pageID string) (protocol.AnnotationPayload, error) {
+	p.mutex.RLock()
+	db, ok := p.DBs[subProj]
+	p.mutex.RUnlock()
+	if !ok {
+		return protocol.AnnotationPayload{}, fmt.Errorf("dbapi.Proj.Annotation: no such sub proj '%s'", subProj)
+	}
+	res, err := db.Annotation(pageID)
+	res.SubProj = subProj
+	return res, err
+}
+
+// Query wraps dbapi.DBAPI.Query
+func (p *Proj) Query(subProj string, request protocol.QueryRequest) ([]protocol.AnnotationPayload, error) {
+	p.mutex.RLock()
+	db, ok := p.DBs[subProj]
+	p.mutex.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("dbapi.Proj.Query: no such sub proj '%s'", subProj)
+	}
+	res, err := db.Query(request)
+	for i := range res {
+		res[i].SubProj = subProj
+	}
+	return res, err
+}
+
+// Lock wraps dbapi.DBAPI.Lock. The page must exist in the sub project.
+func (p *Proj) Lock(subProj, pageID string, ci ClientID) error {
+	p.mutex.RLock()
+	db, ok := p.DBs[subProj]
+	p.mutex.RUnlock()
+	if !ok {
+		return fmt.Errorf("dbapi.Proj.Lock: no such sub proj '%s'", subProj)
+	}
+	if _, err := db.PageFromID(pageID); err != nil {
+		return err
+	}
+	return db.Lock(pageID, ci)
+}
+
+// LockedBy wraps dbapi.DBAPI.LockedBy
+func (p *Proj) LockedBy(subProj, pageID string) (ClientID, bool, error) {
+	p.mutex.RLock()
+	db, ok := p.DBs[subProj]
+	p.mutex.RUnlock()
+	if !ok {
+		return ClientID{}, false, fmt.Errorf("dbapi.Proj.LockedBy: no such sub proj '%s'", subProj)
+	}
+	ci, locked := db.LockedBy(pageID)
+	return ci, locked, nil
+}
+
 func (p *Proj) PageFromID(subProj, id string) (protocol.PagePayload, error) {
 	p.mutex.RLock()
 	//defer p.mutex.RUnlock()
@@ -386,6 +598,60 @@ func (p *Proj) Save(annotation protocol.AnnotationPayload) error {
 	return db.Save(annotation)
 }
 
+// StampSources sets the status source of each page/chunk status
+// that has changed since the annotation was last saved to userName,
+// so that status changes can't be made in someone else's name.
+// Unchanged statuses keep their original source.
+func (p *Proj) StampSources(annotation *protocol.AnnotationPayload, userName string) error {
+	p.mutex.RLock()
+	db, ok := p.DBs[annotation.SubProj]
+	p.mutex.RUnlock()
+	if !ok {
+		return fmt.Errorf("dbapi.Proj.StampSources: no such sub proj '%s'", annotation.SubProj)
+	}
+
+	db.dbMutex.RLock()
+	saved := db.annotationData[annotation.Page.ID]
+	db.dbMutex.RUnlock()
+
+	stampSources(saved, annotation, userName)
+	return nil
+}
+
+func stampSources(saved protocol.AnnotationPayload, annotation *protocol.AnnotationPayload, userName string) {
+	if annotation.CurrentStatus != saved.CurrentStatus && annotation.CurrentStatus.Name != "" {
+		annotation.CurrentStatus.Source = userName
+	}
+
+	savedChunks := map[string]protocol.TransChunk{}
+	for _, c := range saved.Chunks {
+		if c.UUID != "" {
+			savedChunks[c.UUID] = c
+		}
+	}
+	for i, c := range annotation.Chunks {
+		sc, ok := savedChunks[c.UUID]
+		if ok && c.UUID != "" && sc.CurrentStatus == c.CurrentStatus && sc.Trans == c.Trans && sameChunkFields(sc, c) {
+			continue
+		}
+		annotation.Chunks[i].CurrentStatus.Source = userName
+	}
+}
+
+// sameChunkFields returns true if the speaker, language and attributes
+// of the chunks are the same
+func sameChunkFields(c1, c2 protocol.TransChunk) bool {
+	if c1.Speaker != c2.Speaker || c1.Language != c2.Language || len(c1.Attributes) != len(c2.Attributes) {
+		return false
+	}
+	for k, v := range c1.Attributes {
+		if v2, ok := c2.Attributes[k]; !ok || v2 != v {
+			return false
+		}
+	}
+	return true
+}
+
 func (p *Proj) GetNextPage(subProj string, query protocol.QueryPayload, currentlyLockedID string, clientID ClientID, lockOnLoad bool) (protocol.AnnotationPayload, string, error) {
 	p.mutex.RLock()
 	//defer p.mutex.RUnlock()
@@ -413,7 +679,12 @@ type DBAPI struct {
 	lockMapMutex *sync.RWMutex       // for page locking
 	lockMap      map[string]ClientID // page id -> user
 
-	validator *validation.Validator
+	validator *validation.Validator // default validator
+
+	validatorMutex *sync.RWMutex
+	// ValidationConfigFile is the sub-project's own validation config, if any
+	ValidationConfigFile string
+	subProjValidator     *validation.Validator
 }
 
 func NewDBAPI(projectDir string, validator *validation.Validator) *DBAPI {
@@ -429,11 +700,49 @@ func NewDBAPI(projectDir string, validator *validation.Validator) *DBAPI {
 		lockMapMutex: &sync.RWMutex{},
 		lockMap:      map[string]ClientID{},
 
-		validator: validator,
+		validator:      validator,
+		validatorMutex: &sync.RWMutex{},
 	}
 	return &res
 }
 
+// Validator returns the sub-project's own validator, if it has a
+// validation config file, otherwise the default validator
+func (api *DBAPI) Validator() *validation.Validator {
+	api.validatorMutex.RLock()
+	defer api.validatorMutex.RUnlock()
+	if api.subProjValidator != nil {
+		return api.subProjValidator
+	}
+	return api.validator
+}
+
+// loadValidationConfig (re-)reads ValidationConfigFileName in the project dir, if it exists
+func (api *DBAPI) loadValidationConfig() error {
+	f := path.Join(api.ProjectDir, ValidationConfigFileName)
+	bts, err := os.ReadFile(f)
+	if os.IsNotExist(err) {
+		api.validatorMutex.Lock()
+		api.ValidationConfigFile = ""
+		api.subProjValidator = nil
+		api.validatorMutex.Unlock()
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read validation config %s : %v", f, err)
+	}
+	v, err := validation.NewValidatorFromJSON(bts)
+	if err != nil {
+		return fmt.Errorf("invalid validation config %s : %v", f, err)
+	}
+	api.validatorMutex.Lock()
+	api.ValidationConfigFile = f
+	api.subProjValidator = &v
+	api.validatorMutex.Unlock()
+	log.Info("[dbapi] Loaded validation config %s", f)
+	return nil
+}
+
 func (api *DBAPI) ProjectName() string {
 	return path.Base(api.ProjectDir)
 }
@@ -678,12 +987,29 @@ func validateAnnotation(anno protocol.AnnotationPayload) error {
 	if anno.Page.Start > anno.Page.End {
 		return fmt.Errorf("annotation end must be after annotation start, found start: %v, end: %v", anno.Page.Start, anno.Page.End)
 	}
-	for i, chunk := range anno.Chunks {
+	tiers := map[string]bool{}
+	for _, tier := range anno.Tiers {
+		if tier.Name == "" {
+			return fmt.Errorf("empty tier name")
+		}
+		if tiers[tier.Name] {
+			return fmt.Errorf("duplicate tier name: %s", tier.Name)
+		}
+		tiers[tier.Name] = true
+	}
+	// chunks are ordered, and must not overlap, within each tier
+	prevChunks := map[string]protocol.TransChunk{}
+	for _, chunk := range anno.Chunks {
 		if chunk.Start > chunk.End {
 			return fmt.Errorf("chunk end must be after chunk start, found start: %v, end: %v", chunk.Start, chunk.End)
 		}
-		if i > 0 {
-			prevChunk := anno.Chunks[i-1]
+		if len(anno.Tiers) > 0 && !tiers[chunk.Tier] {
+			return fmt.Errorf("unknown tier '%s' for chunk: %#v", chunk.Tier, chunk)
+		}
+		if len(anno.Tiers) == 0 && chunk.Tier != "" {
+			return fmt.Errorf("tier '%s' set for chunk of page without tiers: %#v", chunk.Tier, chunk)
+		}
+		if prevChunk, ok := prevChunks[chunk.Tier]; ok {
 			if prevChunk.Start > chunk.Start {
 				return fmt.Errorf("chunks must be ordered by start time, found %v before %v", prevChunk, chunk)
 			}
@@ -694,6 +1020,7 @@ func validateAnnotation(anno protocol.AnnotationPayload) error {
 				return fmt.Errorf("overlapping chunks is not allowed, found %v before %v", prevChunk, chunk)
 			}
 		}
+		prevChunks[chunk.Tier] = chunk
 		if chunk.CurrentStatus.Name == "" {
 			return fmt.Errorf("invalid current status for chunk: %#v", chunk)
 		}
@@ -779,6 +1106,10 @@ func (api *DBAPI) LoadAnnotationData() (map[string]protocol.AnnotationPayload, [
 				continue
 			}
 
+			// the sub project is identified by its directory (in memory
+			// only: it is not saved, see Save)
+			annotation.SubProj = api.ProjectDir
+
 			//TODO Temp backward compatibility fix NL 20210802
 			if annotation.CurrentStatus.Name == "" || annotation.CurrentStatus.Name == "in progress" {
 				annotation.CurrentStatus.Name = "normal"
@@ -794,6 +1125,354 @@ func (api *DBAPI) GetAnnotationData() map[string]protocol.AnnotationPayload {
 	return api.annotationData
 }
 
+// AnnotationList returns a copy of the annotation data, sorted by page id
+func (api *DBAPI) AnnotationList() []protocol.AnnotationPayload {
+	api.dbMutex.RLock()
+	defer api.dbMutex.RUnlock()
+	var res []protocol.AnnotationPayload
+	for _, a := range api.annotationData {
+		res = append(res, a)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Page.ID < res[j].Page.ID })
+	return res
+}
+
+// OKTranscriptions returns the transcriptions of chunks with a status
+// name starting with "ok", ignoring pages marked for deletion or skipped
+func (api *DBAPI) OKTranscriptions() []string {
+	res := []string{}
+	for _, a := range api.AnnotationList() {
+		if a.CurrentStatus.Name == "delete" || a.CurrentStatus.Name == "skip" {
+			continue
+		}
+		for _, c := range a.Chunks {
+			if strings.HasPrefix(c.CurrentStatus.Name, "ok") && strings.TrimSpace(c.Trans) != "" {
+				res = append(res, c.Trans)
+			}
+		}
+	}
+	return res
+}
+
+// TransRewrite is a chunk transcription changed by RewriteTrans (or a
+// chunk changed by RewriteChunks)
+type TransRewrite struct {
+	PageID     string `json:"page_id"`
+	ChunkIndex int    `json:"chunk_index"`
+	UUID       string `json:"uuid,omitempty"`
+	Old        string `json:"old"`
+	New        string `json:"new"`
+	// OldSpeaker and NewSpeaker are set if the speaker is changed
+	OldSpeaker string `json:"old_speaker,omitempty"`
+	NewSpeaker string `json:"new_speaker,omitempty"`
+}
+
+// RewriteResult lists the transcriptions changed by RewriteTrans.
+// Locked pages (currently being edited) are skipped.
+type RewriteResult struct {
+	SubProj       string         `json:"sub_proj"`
+	Applied       bool           `json:"applied"`
+	Rewrites      []TransRewrite `json:"rewrites"`
+	SkippedLocked []string       `json:"skipped_locked,omitempty"`
+	// SkippedChanged are pages saved by someone else during the
+	// rewrite. They are not saved, and rewritten on the next run.
+	SkippedChanged []string `json:"skipped_changed,omitempty"`
+}
+
+// RewriteTrans calls rewrite for each chunk transcription, and lists
+// the transcriptions that would change. If apply is true, the changed
+// pages are also saved. Pages marked for deletion are not changed.
+func (api *DBAPI) RewriteTrans(rewrite func(string) string, apply bool) (RewriteResult, error) {
+	return api.RewriteChunks(func(c protocol.TransChunk) protocol.TransChunk {
+		c.Trans = rewrite(c.Trans)
+		return c
+	}, apply)
+}
+
+// RewriteChunks is like RewriteTrans, but rewrites whole chunks. Only
+// changes to the transcription and speaker are listed (and saved).
+func (api *DBAPI) RewriteChunks(rewrite func(protocol.TransChunk) protocol.TransChunk, apply bool) (RewriteResult, error) {
+	res := RewriteResult{Applied: apply, Rewrites: []TransRewrite{}}
+	for _, a := range api.AnnotationList() {
+		if a.CurrentStatus.Name == "delete" {
+			continue
+		}
+		var rewrites []TransRewrite
+		chunks := make([]protocol.TransChunk, len(a.Chunks))
+		for i, c := range a.Chunks {
+			c0 := rewrite(c)
+			if c0.Trans != c.Trans || c0.Speaker != c.Speaker {
+				rw := TransRewrite{PageID: a.Page.ID, ChunkIndex: i, UUID: c.UUID, Old: c.Trans, New: c0.Trans}
+				if c0.Speaker != c.Speaker {
+					rw.OldSpeaker = c.Speaker
+					rw.NewSpeaker = c0.Speaker
+				}
+				rewrites = append(rewrites, rw)
+				c.Trans = c0.Trans
+				c.Speaker = c0.Speaker
+			}
+			chunks[i] = c
+		}
+		if len(rewrites) == 0 {
+			continue
+		}
+		if api.Locked(a.Page.ID) {
+			res.SkippedLocked = append(res.SkippedLocked, a.Page.ID)
+			continue
+		}
+		if apply {
+			rewritten := a
+			rewritten.Chunks = chunks
+			// the page may have been saved since the snapshot was taken
+			saved, err := api.saveIfUnchanged(a, rewritten)
+			if err != nil {
+				return res, fmt.Errorf("dbapi.RewriteChunks: failed to save page %s : %v", a.Page.ID, err)
+			}
+			if !saved {
+				res.SkippedChanged = append(res.SkippedChanged, a.Page.ID)
+				continue
+			}
+		}
+		res.Rewrites = append(res.Rewrites, rewrites...)
+	}
+	return res, nil
+}
+
+// QualityCondition is a condition on an audio quality measure of a
+// page, such as "snr < 10 dB". The measures are clipping, rms, peak,
+// snr and silence (see protocol.AudioQuality). The ratios (clipping
+// and silence) can be given as percentages, such as "silence > 90%".
+type QualityCondition struct {
+	Measure string
+	Op      string
+	Value   float64
+}
+
+var qualityConditionRE = regexp.MustCompile(`^\s*([a-z]+)\s*(<=|>=|<|>|=)\s*(-?[0-9]+(?:[.][0-9]+)?)\s*(db|%)?\s*$`)
+
+// ParseQualityCondition parses a quality condition (see QualityCondition)
+func ParseQualityCondition(s string) (QualityCondition, error) {
+	m := qualityConditionRE.FindStringSubmatch(strings.ToLower(s))
+	if m == nil {
+		return QualityCondition{}, fmt.Errorf("invalid quality condition '%s' (expected <measure> <op> <value>, such as 'snr < 10 dB')", s)
+	}
+	res := QualityCondition{Measure: m[1], Op: m[2]}
+	v, err := strconv.ParseFloat(m[3], 64)
+	if err != nil {
+		return res, fmt.Errorf("invalid value in quality condition '%s' : %v", s, err)
+	}
+	switch res.Measure {
+	case "clipping", "silence":
+		if m[4] == "db" {
+			return res, fmt.Errorf("invalid unit in quality condition '%s' (%s is a ratio)", s, res.Measure)
+		}
+		if m[4] == "%" {
+			v /= 100
+		}
+	case "rms", "peak", "snr":
+		if m[4] == "%" {
+			return res, fmt.Errorf("invalid unit in quality condition '%s' (%s is in dB)", s, res.Measure)
+		}
+	default:
+		return res, fmt.Errorf("unknown measure in quality condition '%s' (valid values: clipping, rms, peak, snr, silence)", s)
+	}
+	res.Value = v
+	return res, nil
+}
+
+// Match returns true if the audio quality matches the condition. Audio
+// that is not analysed (nil) never matches.
+func (c QualityCondition) Match(q *protocol.AudioQuality) bool {
+	if q == nil {
+		return false
+	}
+	var v float64
+	switch c.Measure {
+	case "clipping":
+		v = q.ClippingRatio
+	case "rms":
+		v = q.RMS
+	case "peak":
+		v = q.Peak
+	case "snr":
+		v = q.SNR
+	case "silence":
+		v = q.SilenceRatio
+	}
+	switch c.Op {
+	case "<":
+		return v < c.Value
+	case "<=":
+		return v <= c.Value
+	case ">":
+		return v > c.Value
+	case ">=":
+		return v >= c.Value
+	case "=":
+		return v == c.Value
+	}
+	return false
+}
+
+// keepQuality keeps the saved audio quality of the page and of chunks
+// with unchanged times, if the annotation has none (the quality is
+// computed on the server, and not sent back by the editor)
+func keepQuality(saved protocol.AnnotationPayload, annotation *protocol.AnnotationPayload) {
+	if annotation.Quality == nil {
+		annotation.Quality = saved.Quality
+	}
+	savedChunks := map[string]protocol.TransChunk{}
+	for _, c := range saved.Chunks {
+		if c.UUID != "" && c.Quality != nil {
+			savedChunks[c.UUID] = c
+		}
+	}
+	if len(savedChunks) == 0 {
+		return
+	}
+	chunks := make([]protocol.TransChunk, len(annotation.Chunks))
+	for i, c := range annotation.Chunks {
+		if sc, ok := savedChunks[c.UUID]; ok && c.Quality == nil && sc.Chunk == c.Chunk {
+			c.Quality = sc.Quality
+		}
+		chunks[i] = c
+	}
+	annotation.Chunks = chunks
+}
+
+// AutoFixSource is the status source of chunks with transcriptions
+// fixed in bulk (see validation.Validator.FixAnnotation)
+const AutoFixSource = "auto:fix"
+
+// AutoSkipSource is the status source of pages marked as skip by
+// UpdateQuality
+const AutoSkipSource = "auto:quality"
+
+// QualityResult lists the pages changed by UpdateQuality
+type QualityResult struct {
+	SubProj string `json:"sub_proj"`
+	// Analysed is the number of analysed pages
+	Analysed int `json:"analysed"`
+	// AutoSkipped are the pages marked as skip
+	AutoSkipped   []string `json:"auto_skipped,omitempty"`
+	SkippedLocked []string `json:"skipped_locked,omitempty"`
+	// SkippedChanged are pages with chunks changed during the analysis.
+	// They are not saved, and analysed again on the next update.
+	SkippedChanged []string `json:"skipped_changed,omitempty"`
+}
+
+// autoSkippable returns true if no work has been done on the page
+func autoSkippable(a protocol.AnnotationPayload) bool {
+	if a.CurrentStatus.Name != "" && a.CurrentStatus.Name != "normal" {
+		return false
+	}
+	for _, c := range a.Chunks {
+		if c.CurrentStatus.Name != "" && c.CurrentStatus.Name != StatusUnchecked {
+			return false
+		}
+	}
+	return true
+}
+
+// sameChunkTimes returns true if the chunks of a and b have the same times
+func sameChunkTimes(a, b protocol.AnnotationPayload) bool {
+	if len(a.Chunks) != len(b.Chunks) {
+		return false
+	}
+	for i, c := range a.Chunks {
+		if c.Chunk != b.Chunks[i].Chunk {
+			return false
+		}
+	}
+	return true
+}
+
+// UpdateQuality calls analyse for each page without audio quality (or
+// for each page, if force is true), and saves the audio quality of the
+// page and its chunks, as set by analyse. If autoSkip is a quality
+// condition (see QualityCondition), matching pages are marked as skip,
+// with AutoSkipSource as status source, unless they have another
+// status than normal, or checked chunks. Pages marked for deletion and
+// locked pages are not changed. It is meant to run in the server, that
+// holds the page locks: since the analysis may be slow, pages are
+// re-read after the analysis, and left unchanged if they have been
+// locked or re-chunked meanwhile.
+func (api *DBAPI) UpdateQuality(analyse func(protocol.AnnotationPayload) (protocol.AnnotationPayload, error), autoSkip string, force bool) (QualityResult, error) {
+	res := QualityResult{}
+	var cond *QualityCondition
+	if autoSkip != "" {
+		c, err := ParseQualityCondition(autoSkip)
+		if err != nil {
+			return res, err
+		}
+		cond = &c
+	}
+	for _, a := range api.AnnotationList() {
+		if a.CurrentStatus.Name == "delete" {
+			continue
+		}
+		if api.Locked(a.Page.ID) {
+			res.SkippedLocked = append(res.SkippedLocked, a.Page.ID)
+			continue
+		}
+		var analysed *protocol.AnnotationPayload
+		if a.Quality == nil || force {
+			an, err := analyse(a)
+			if err != nil {
+				return res, fmt.Errorf("dbapi.UpdateQuality: failed to analyse page %s : %v", a.Page.ID, err)
+			}
+			if !sameChunkTimes(a, an) {
+				return res, fmt.Errorf("dbapi.UpdateQuality: analysis of page %s changed the chunks", a.Page.ID)
+			}
+			analysed = &an
+			res.Analysed++
+		}
+
+		// the page may have been locked or saved during the analysis
+		if api.Locked(a.Page.ID) {
+			res.SkippedLocked = append(res.SkippedLocked, a.Page.ID)
+			continue
+		}
+		api.dbMutex.RLock()
+		cur, ok := api.annotationData[a.Page.ID]
+		api.dbMutex.RUnlock()
+		if !ok || cur.CurrentStatus.Name == "delete" {
+			continue
+		}
+		if analysed != nil && !sameChunkTimes(cur, *analysed) {
+			res.SkippedChanged = append(res.SkippedChanged, a.Page.ID)
+			continue
+		}
+
+		changed := false
+		if analysed != nil {
+			cur.Quality = analysed.Quality
+			chunks := make([]protocol.TransChunk, len(cur.Chunks))
+			for i, c := range cur.Chunks {
+				c.Quality = analysed.Chunks[i].Quality
+				chunks[i] = c
+			}
+			cur.Chunks = chunks
+			changed = true
+		}
+		if cond != nil && cond.Match(cur.Quality) && autoSkippable(cur) {
+			if cur.CurrentStatus.Name != "" {
+				cur.StatusHistory = append(cur.StatusHistory, cur.CurrentStatus)
+			}
+			cur.CurrentStatus = protocol.Status{Name: StatusSkip, Source: AutoSkipSource, Timestamp: time.Now().Format("2006-01-02 15:04:05")}
+			res.AutoSkipped = append(res.AutoSkipped, cur.Page.ID)
+			changed = true
+		}
+		if changed {
+			err := api.Save(cur)
+			if err != nil {
+				return res, fmt.Errorf("dbapi.UpdateQuality: failed to save page %s : %v", cur.Page.ID, err)
+			}
+		}
+	}
+	return res, nil
+}
+
 // Pages returns the number of page annotations.
 func (api *DBAPI) Pages() int {
 	api.dbMutex.RLock()
@@ -892,6 +1571,14 @@ func (api *DBAPI) Locked(pageID string) bool {
 	return res
 }
 
+// LockedBy returns the client holding the lock of a page, if it is locked
+func (api *DBAPI) LockedBy(pageID string) (ClientID, bool) {
+	api.lockMapMutex.RLock()
+	defer api.lockMapMutex.RUnlock()
+	ci, res := api.lockMap[pageID]
+	return ci, res
+}
+
 func (api *DBAPI) Lock(pageID string, ci ClientID) error {
 	log.Info("[dbapi] Lock %s %v", pageID, ci)
 	if strings.TrimSpace(ci.ID) == "" {
@@ -920,9 +1607,18 @@ func (api *DBAPI) chunkStats() StatsPerAudio {
 			res[audio] = Stats{}
 		}
 		audioStats := res[audio]
-		for _, chunk := range anno.Chunks {
+		overlapping := overlappingChunks(anno)
+		for i, chunk := range anno.Chunks {
 			audioStats["total"]++
 			allStats["total"]++
+			if chunk.Tier != "" {
+				audioStats["tier:"+chunk.Tier]++
+				allStats["tier:"+chunk.Tier]++
+			}
+			if overlapping[i] {
+				audioStats["overlapping"]++
+				allStats["overlapping"]++
+			}
 			status := chunk.CurrentStatus
 			if status.Name == "unchecked" {
 				audioStats[status.Name]++
@@ -944,6 +1640,21 @@ func (api *DBAPI) chunkStats() StatsPerAudio {
 	return res
 }
 
+// overlappingChunks marks the chunks that overlap a chunk of another
+// tier (chunks of the same tier never overlap)
+func overlappingChunks(anno protocol.AnnotationPayload) []bool {
+	res := make([]bool, len(anno.Chunks))
+	for i, c1 := range anno.Chunks {
+		for j, c2 := range anno.Chunks[:i] {
+			if c1.Tier != c2.Tier && c1.Start < c2.End && c2.Start < c1.End {
+				res[i] = true
+				res[j] = true
+			}
+		}
+	}
+	return res
+}
+
 type annotationStatus struct {
 	name    string
 	sources map[string]bool
@@ -1150,12 +1861,43 @@ func (api *DBAPI) pageStats() StatsPerAudio {
 			audioStats["comment"]++
 			allStats["comment"]++
 		}
+		for _, k := range qualityStats(anno) {
+			audioStats[k]++
+			allStats[k]++
+		}
 		res[audio] = audioStats
 	}
 	res["all"] = allStats
 	return res
 }
 
+// qualityStats returns the stats keys of the audio quality of a page
+func qualityStats(anno protocol.AnnotationPayload) []string {
+	q := anno.Quality
+	if q == nil {
+		return nil
+	}
+	res := []string{"quality:analysed"}
+	switch {
+	case q.SNR < 10:
+		res = append(res, "quality:snr<10dB")
+	case q.SNR < 20:
+		res = append(res, "quality:snr 10-20dB")
+	default:
+		res = append(res, "quality:snr>=20dB")
+	}
+	if q.ClippingRatio > 0.001 {
+		res = append(res, "quality:clipping>0.1%")
+	}
+	if q.SilenceRatio > 0.9 {
+		res = append(res, "quality:silence>90%")
+	}
+	if anno.CurrentStatus.Name == StatusSkip && anno.CurrentStatus.Source == AutoSkipSource {
+		res = append(res, "quality:auto_skipped")
+	}
+	return res
+}
+
 // Stats returns 1) page stats per audio file; 2) chunk stats per audio file; or an error if something is wrong
 func (api *DBAPI) Stats() (StatsPerAudio, StatsPerAudio, error) {
 	pageStats := api.pageStats()
@@ -1180,7 +1922,23 @@ const (
 	AudioFileAny = "any"
 )
 
-func queryMatch(request protocol.QueryRequest, annotation protocol.AnnotationPayload, validator *validation.Validator) (bool, error) {
+// queryQuality parses the quality condition of the request (see
+// QualityCondition), once per query. It returns nil if the request has
+// no quality condition.
+func queryQuality(request protocol.QueryRequest) (*QualityCondition, error) {
+	if request.Quality == "" {
+		return nil, nil
+	}
+	cond, err := ParseQualityCondition(request.Quality)
+	if err != nil {
+		return nil, err
+	}
+	return &cond, nil
+}
+
+// queryMatch returns true if the annotation matches the request. The
+// quality condition of the request is passed parsed (see queryQuality).
+func queryMatch(request protocol.QueryRequest, quality *QualityCondition, annotation protocol.AnnotationPayload, validator *validation.Validator) (bool, error) {
 
 	//matchingChunks := map[int]bool{}
 
@@ -1240,6 +1998,22 @@ func queryMatch(request protocol.QueryRequest, annotation protocol.AnnotationPay
 		}
 	}
 
+	var fieldsMatch = true
+	if request.Speaker != "" || request.Language != "" || request.Attribute != "" {
+		fieldsMatch = false
+		for _, tc := range annotation.Chunks {
+			if chunkFieldsMatch(request, tc) {
+				fieldsMatch = true
+				break
+			}
+		}
+	}
+
+	var qualityMatch = true
+	if quality != nil {
+		qualityMatch = quality.Match(annotation.Quality)
+	}
+
 	var validationMatch = false
 	if validator == nil || !request.ValidationIssue.HasIssue {
 		validationMatch = true
@@ -1270,7 +2044,26 @@ func queryMatch(request protocol.QueryRequest, annotation protocol.AnnotationPay
 	// slices.Sort(res)
 	// return res, nil
 
-	return pageStatusMatch && statusMatch && sourceMatch && audioFileMatch && transMatch && validationMatch, nil
+	return pageStatusMatch && statusMatch && sourceMatch && audioFileMatch && transMatch && fieldsMatch && qualityMatch && validationMatch, nil
+}
+
+// chunkFieldsMatch returns true if the chunk has the speaker, language
+// and attribute of the request (empty values match any chunk)
+func chunkFieldsMatch(request protocol.QueryRequest, tc protocol.TransChunk) bool {
+	if request.Speaker != "" && tc.Speaker != request.Speaker {
+		return false
+	}
+	if request.Language != "" && tc.Language != request.Language {
+		return false
+	}
+	if request.Attribute != "" {
+		key, value, hasValue := strings.Cut(request.Attribute, "=")
+		v, ok := tc.Attributes[key]
+		if !ok || (hasValue && v != value) {
+			return false
+		}
+	}
+	return true
 }
 
 func abs(i int64) int64 {
@@ -1308,6 +2101,11 @@ func (api *DBAPI) GetNextPage(query protocol.QueryPayload, currentlyLockedID str
 		return protocol.AnnotationPayload{}, "", fmt.Errorf("empty ClientID.UserName field: %#v", query)
 	}
 
+	quality, err := queryQuality(query.Request)
+	if err != nil {
+		return protocol.AnnotationPayload{}, "", err
+	}
+
 	var currIndex int
 	var seenCurrID int64
 	if query.RequestIndex != "" {
@@ -1361,7 +2159,7 @@ func (api *DBAPI) GetNextPage(query protocol.QueryPayload, currentlyLockedID str
 				log.Debug("[dbapi] GetNextPage index=%v seenCurrID=%v page.ID=%v stepSize=%v derived status=%v", i+1, seenCurrID, page.ID, query.StepSize, deriveAnnotationStatus(annotation).name)
 			}
 			if seenCurrID >= 0 {
-				matches, err := queryMatch(query.Request, annotation, api.validator)
+				matches, err := queryMatch(query.Request, quality, annotation, api.Validator())
 
 				if err != nil {
 					return protocol.AnnotationPayload{}, "", err
@@ -1402,6 +2200,71 @@ func (api *DBAPI) GetNextPage(query protocol.QueryPayload, currentlyLockedID str
 	return protocol.AnnotationPayload{}, fmt.Sprintf("no page matching query request\n%s", prettyQuery), nil
 }
 
+// PageInfo summarises a page and its annotation, for page listings
+type PageInfo struct {
+	protocol.PagePayload
+	Index  int64  `json:"index"`
+	Status string `json:"status"`
+	Chunks int    `json:"chunks"`
+	Locked bool   `json:"locked"`
+}
+
+// PageInfos lists the pages in source data order
+func (api *DBAPI) PageInfos() []PageInfo {
+	api.dbMutex.RLock()
+	defer api.dbMutex.RUnlock()
+	res := []PageInfo{}
+	for i, page := range api.sourceData {
+		a := api.annotationFromPage(page)
+		res = append(res, PageInfo{
+			PagePayload: page,
+			Index:       int64(i + 1),
+			Status:      a.CurrentStatus.Name,
+			Chunks:      len(a.Chunks),
+			Locked:      api.Locked(page.ID),
+		})
+	}
+	return res
+}
+
+// Annotation returns the annotation of a page, with the page index set
+func (api *DBAPI) Annotation(pageID string) (protocol.AnnotationPayload, error) {
+	api.dbMutex.RLock()
+	defer api.dbMutex.RUnlock()
+	for i, page := range api.sourceData {
+		if page.ID == pageID {
+			res := api.annotationFromPage(page)
+			res.Index = int64(i + 1)
+			return res, nil
+		}
+	}
+	return protocol.AnnotationPayload{}, fmt.Errorf("no page with id: %s", pageID)
+}
+
+// Query returns the annotations matching the query request (as used by
+// GetNextPage), in source data order, with the page index set
+func (api *DBAPI) Query(request protocol.QueryRequest) ([]protocol.AnnotationPayload, error) {
+	api.dbMutex.RLock()
+	defer api.dbMutex.RUnlock()
+	res := []protocol.AnnotationPayload{}
+	quality, err := queryQuality(request)
+	if err != nil {
+		return res, err
+	}
+	for i, page := range api.sourceData {
+		a := api.annotationFromPage(page)
+		match, err := queryMatch(request, quality, a, api.Validator())
+		if err != nil {
+			return res, err
+		}
+		if match {
+			a.Index = int64(i + 1)
+			res = append(res, a)
+		}
+	}
+	return res, nil
+}
+
 func (api *DBAPI) ListAudioFiles() []string {
 	res := []string{}
 	for _, page := range api.sourceData {
@@ -1433,14 +2296,39 @@ func (api *DBAPI) Save(annotation protocol.AnnotationPayload) error {
 	api.dbMutex.Lock()
 	defer api.dbMutex.Unlock()
 
+	return api.save(annotation)
+}
+
+// saveIfUnchanged saves annotation, unless the page in the cache differs
+// from old, the version that annotation is based on. It returns false if
+// the page was changed, and not saved.
+func (api *DBAPI) saveIfUnchanged(old, annotation protocol.AnnotationPayload) (bool, error) {
+	trimSpace(&annotation)
+
+	api.dbMutex.Lock()
+	defer api.dbMutex.Unlock()
+
+	if cur, ok := api.annotationData[annotation.Page.ID]; !ok || !reflect.DeepEqual(cur, old) {
+		return false, nil
+	}
+	return true, api.save(annotation)
+}
+
+// exec in a locked context only
+func (api *DBAPI) save(annotation protocol.AnnotationPayload) error {
+	keepQuality(api.annotationData[annotation.Page.ID], &annotation)
+
 	/* SAVE TO CACHE */
 	api.annotationData[annotation.Page.ID] = annotation
 
 	/* PRINT TO FILE */
 
-	// create copy for writing, and remove internal index
+	// create copy for writing, and remove internal index and sub project
+	// (the sub project is set from the directory on load, see
+	// LoadAnnotationData)
 	saveAnno := annotation
 	saveAnno.Index = 0
+	saveAnno.SubProj = ""
 
 	f := path.Join(api.AnnotationDataDir, fmt.Sprintf("%s.json", annotation.Page.ID))
 	writeJSON, err := json.MarshalIndent(saveAnno, " ", " ")
@@ -2,19 +2,23 @@ package dbapi
 
 import (
 	//"fmt"
+	"os"
+	"path/filepath"
 	"regexp"
 	"strings"
 	"sync"
 	"testing"
 
 	"github.com/stts-se/transtool-open/protocol"
+	"github.com/stts-se/transtool-open/validation"
 )
 
 func TestSearch(t *testing.T) {
 	// TODO create proper setup with corresponding file directories
 	var db = DBAPI{
-		dbMutex:      &sync.RWMutex{},
-		lockMapMutex: &sync.RWMutex{},
+		dbMutex:        &sync.RWMutex{},
+		lockMapMutex:   &sync.RWMutex{},
+		validatorMutex: &sync.RWMutex{},
 
 		annotationData: map[string]protocol.AnnotationPayload{
 
@@ -136,8 +140,9 @@ func TestPageStatus(t *testing.T) {
 	}
 
 	var db = &DBAPI{
-		dbMutex:      &sync.RWMutex{},
-		lockMapMutex: &sync.RWMutex{},
+		dbMutex:        &sync.RWMutex{},
+		lockMapMutex:   &sync.RWMutex{},
+		validatorMutex: &sync.RWMutex{},
 
 		sourceData: []protocol.PagePayload{
 			p1,
@@ -242,4 +247,677 @@ func TestTrimSpace(t *testing.T) {
 
 }
 
+func TestStampSources(t *testing.T) {
+	saved := protocol.AnnotationPayload{
+		CurrentStatus: protocol.Status{Name: "normal", Source: "s1"},
+		Chunks: []protocol.TransChunk{
+			{UUID: "c1", Trans: "trans1", CurrentStatus: protocol.Status{Name: "ok", Source: "s1"}},
+			{UUID: "c2", Trans: "trans2", CurrentStatus: protocol.Status{Name: "ok", Source: "s1"}},
+		},
+	}
+
+	a := protocol.AnnotationPayload{
+		// new status (normal -> skip), old source
+		CurrentStatus: protocol.Status{Name: "skip", Source: "s1"},
+		Chunks: []protocol.TransChunk{
+			// unchanged
+			{UUID: "c1", Trans: "trans1", CurrentStatus: protocol.Status{Name: "ok", Source: "s1"}},
+			// new transcription, old source
+			{UUID: "c2", Trans: "trans2b", CurrentStatus: protocol.Status{Name: "ok", Source: "s1"}},
+			// new chunk
+			{UUID: "c3", Trans: "trans3", CurrentStatus: protocol.Status{Name: "unchecked", Source: "s3"}},
+		},
+	}
+	saved.Chunks = append(saved.Chunks, protocol.TransChunk{UUID: "c4", Trans: "trans4", CurrentStatus: protocol.Status{Name: "ok", Source: "s1"}})
+	// new speaker, old source
+	a.Chunks = append(a.Chunks, protocol.TransChunk{UUID: "c4", Trans: "trans4", Speaker: "#AGENT", CurrentStatus: protocol.Status{Name: "ok", Source: "s1"}})
+
+	stampSources(saved, &a, "s2")
+
+	if w, g := "s2", a.CurrentStatus.Source; w != g {
+		t.Errorf("wanted %s got %s", w, g)
+	}
+	if w, g := "s1", a.Chunks[0].CurrentStatus.Source; w != g {
+		t.Errorf("wanted %s got %s", w, g)
+	}
+	if w, g := "s2", a.Chunks[1].CurrentStatus.Source; w != g {
+		t.Errorf("wanted %s got %s", w, g)
+	}
+	if w, g := "s2", a.Chunks[2].CurrentStatus.Source; w != g {
+		t.Errorf("wanted %s got %s", w, g)
+	}
+	if w, g := "s2", a.Chunks[3].CurrentStatus.Source; w != g {
+		t.Errorf("wanted %s got %s", w, g)
+	}
+}
+
+func TestSubProjValidationConfig(t *testing.T) {
+	dir, err := os.MkdirTemp("", "transtool_dbapi_test")
+	if err != nil {
+		t.Fatalf("failed to create temp dir : %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, sp := range []string{"sp1", "sp2"} {
+		for _, d := range []string{"source", "annotation"} {
+			if err := os.MkdirAll(filepath.Join(dir, sp, d), 0700); err != nil {
+				t.Fatalf("failed to create dir : %v", err)
+			}
+		}
+	}
+	cfgJSON := `{"page_status_names": "normal skip", "status_names": "ok skip", "valid_chars_regexp": "[a-z ]", "token_split_regexp": " ", "label_prefix": "#", "labels": "#NOISE"}`
+	err = os.WriteFile(filepath.Join(dir, "sp2", ValidationConfigFileName), []byte(cfgJSON), 0600)
+	if err != nil {
+		t.Fatalf("failed to write validation config : %v", err)
+	}
+
+	defaultValidator, err := validation.NewValidator(validation.ConfigExample)
+	if err != nil {
+		t.Fatalf("failed to create validator : %v", err)
+	}
+	sp1, sp2 := filepath.Join(dir, "sp1"), filepath.Join(dir, "sp2")
+	proj, err := NewProj(sp1+":"+sp2, &defaultValidator)
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+
+	if proj.Validator(sp1) != &defaultValidator {
+		t.Errorf("expected default validator for %s", sp1)
+	}
+	if w, g := "ok skip", proj.Validator(sp2).Config().StatusNames; w != g {
+		t.Errorf("wanted '%s' got '%s'", w, g)
+	}
+	if w, g := filepath.Join(sp2, ValidationConfigFileName), proj.GetDB(sp2).ValidationConfigFile; w != g {
+		t.Errorf("wanted '%s' got '%s'", w, g)
+	}
+
+	// removed config file falls back to default on reload
+	os.Remove(filepath.Join(sp2, ValidationConfigFileName))
+	if err := proj.ReloadValidationConfigs(); err != nil {
+		t.Errorf("expected nil, got %v", err)
+	}
+	if proj.Validator(sp2) != &defaultValidator {
+		t.Errorf("expected default validator for %s", sp2)
+	}
+
+	// invalid config
+	err = os.WriteFile(filepath.Join(sp1, ValidationConfigFileName), []byte(`{"status_names": "ok"}`), 0600)
+	if err != nil {
+		t.Fatalf("failed to write validation config : %v", err)
+	}
+	if _, err := NewProj(sp1, &defaultValidator); err == nil {
+		t.Errorf("expected error for invalid validation config, got nil")
+	}
+}
+
 //func dummy() { fmt.Println() }
+
+func TestRewriteTrans(t *testing.T) {
+	dir, err := os.MkdirTemp("", "transtool_dbapi_test")
+	if err != nil {
+		t.Fatalf("failed to create temp dir : %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	db := NewDBAPI(dir, nil)
+	db.AnnotationDataDir = dir
+	db.annotationData = map[string]protocol.AnnotationPayload{
+		"p1": {
+			Page:          protocol.PagePayload{ID: "p1"},
+			CurrentStatus: protocol.Status{Name: "normal"},
+			Chunks: []protocol.TransChunk{
+				{Trans: "ca tio kr", CurrentStatus: protocol.Status{Name: "ok"}},
+				{Trans: "hej", CurrentStatus: protocol.Status{Name: "ok"}},
+			},
+		},
+		"p2": {
+			Page:          protocol.PagePayload{ID: "p2"},
+			CurrentStatus: protocol.Status{Name: "normal"},
+			Chunks:        []protocol.TransChunk{{Trans: "fem kr"}},
+		},
+		"p3": {
+			Page:          protocol.PagePayload{ID: "p3"},
+			CurrentStatus: protocol.Status{Name: "delete"},
+			Chunks:        []protocol.TransChunk{{Trans: "fem kr"}},
+		},
+	}
+	err = db.Lock("p2", ClientID{ID: "c1", UserName: "u1"})
+	if err != nil {
+		t.Fatalf("failed to lock page : %v", err)
+	}
+
+	rewrite := func(s string) string { return strings.ReplaceAll(s, "kr", "kronor") }
+
+	// preview
+	res, err := db.RewriteTrans(rewrite, false)
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	if w, g := 1, len(res.Rewrites); w != g {
+		t.Fatalf("wanted %d got %d", w, g)
+	}
+	if w, g := "ca tio kronor", res.Rewrites[0].New; w != g {
+		t.Errorf("wanted '%s' got '%s'", w, g)
+	}
+	if w, g := "p2", strings.Join(res.SkippedLocked, " "); w != g {
+		t.Errorf("wanted '%s' got '%s'", w, g)
+	}
+	if w, g := "ca tio kr", db.annotationData["p1"].Chunks[0].Trans; w != g {
+		t.Errorf("wanted '%s' got '%s'", w, g)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "p1.json")); !os.IsNotExist(err) {
+		t.Errorf("expected no saved file for preview")
+	}
+
+	// apply
+	_, err = db.RewriteTrans(rewrite, true)
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	if w, g := "ca tio kronor", db.annotationData["p1"].Chunks[0].Trans; w != g {
+		t.Errorf("wanted '%s' got '%s'", w, g)
+	}
+	if w, g := "fem kr", db.annotationData["p2"].Chunks[0].Trans; w != g {
+		t.Errorf("wanted '%s' got '%s'", w, g)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "p1.json")); err != nil {
+		t.Errorf("expected saved file : %v", err)
+	}
+}
+
+func TestQueryChunkFields(t *testing.T) {
+	a := protocol.AnnotationPayload{
+		Chunks: []protocol.TransChunk{
+			{Trans: "hej", Speaker: "#AGENT", Language: "sv"},
+			{Trans: "hello", Speaker: "#CUSTOMER", Language: "en", Attributes: map[string]string{"noise": "music"}},
+		},
+	}
+	for _, test := range []struct {
+		speaker, language, attribute string
+		exp                          bool
+	}{
+		{"", "", "", true},
+		{"#AGENT", "", "", true},
+		{"#AGENT", "sv", "", true},
+		{"#AGENT", "en", "", false}, // the same chunk must match
+		{"#SYS", "", "", false},
+		{"", "en", "noise", true},
+		{"", "", "noise=music", true},
+		{"", "", "noise=speech", false},
+		{"", "", "accent", false},
+	} {
+		request := protocol.QueryRequest{PageStatus: StatusAny, Status: StatusAny, Source: SourceAny, Speaker: test.speaker, Language: test.language, Attribute: test.attribute}
+		got, err := queryMatch(request, nil, a, nil)
+		if err != nil {
+			t.Fatalf("expected nil, got %v", err)
+		}
+		if got != test.exp {
+			t.Errorf("wanted %v got %v for %#v", test.exp, got, test)
+		}
+	}
+}
+
+func TestRewriteChunks(t *testing.T) {
+	dir, err := os.MkdirTemp("", "transtool_dbapi_test")
+	if err != nil {
+		t.Fatalf("failed to create temp dir : %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	validator, err := validation.NewValidator(validation.ConfigExample2)
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	db := NewDBAPI(dir, &validator)
+	db.AnnotationDataDir = dir
+	db.annotationData = map[string]protocol.AnnotationPayload{
+		"p1": {
+			Page:          protocol.PagePayload{ID: "p1"},
+			CurrentStatus: protocol.Status{Name: "normal"},
+			Chunks: []protocol.TransChunk{
+				{Trans: "#AGENT hej", CurrentStatus: protocol.Status{Name: "ok"}},
+				{Trans: "hej", CurrentStatus: protocol.Status{Name: "ok"}},
+			},
+		},
+	}
+
+	res, err := db.RewriteChunks(validator.LiftSpeakerLabel, true)
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	if w, g := 1, len(res.Rewrites); w != g {
+		t.Fatalf("wanted %d got %d", w, g)
+	}
+	if w, g := "#AGENT", res.Rewrites[0].NewSpeaker; w != g {
+		t.Errorf("wanted '%s' got '%s'", w, g)
+	}
+	c := db.annotationData["p1"].Chunks[0]
+	if w, g := "hej", c.Trans; w != g {
+		t.Errorf("wanted '%s' got '%s'", w, g)
+	}
+	if w, g := "#AGENT", c.Speaker; w != g {
+		t.Errorf("wanted '%s' got '%s'", w, g)
+	}
+}
+
+func TestRewriteChangedPage(t *testing.T) {
+	dir, err := os.MkdirTemp("", "transtool_dbapi_test")
+	if err != nil {
+		t.Fatalf("failed to create temp dir : %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	db := NewDBAPI(dir, nil)
+	db.AnnotationDataDir = dir
+	anno := protocol.AnnotationPayload{
+		Page:   protocol.PagePayload{ID: "p1"},
+		Chunks: []protocol.TransChunk{{Trans: "tio kr", CurrentStatus: protocol.Status{Name: "ok"}}},
+	}
+	db.annotationData = map[string]protocol.AnnotationPayload{"p1": anno}
+
+	// an editor saves the page while it is rewritten
+	edited := anno
+	edited.Chunks = []protocol.TransChunk{{Trans: "elva kr", CurrentStatus: protocol.Status{Name: "ok"}}}
+	rewrite := func(s string) string {
+		if err := db.Save(edited); err != nil {
+			t.Fatalf("expected nil, got %v", err)
+		}
+		return strings.ReplaceAll(s, "kr", "kronor")
+	}
+
+	res, err := db.RewriteTrans(rewrite, true)
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	if w, g := 0, len(res.Rewrites); w != g {
+		t.Errorf("wanted %d got %d", w, g)
+	}
+	if w, g := "p1", strings.Join(res.SkippedChanged, " "); w != g {
+		t.Errorf("wanted '%s' got '%s'", w, g)
+	}
+	if w, g := "elva kr", db.annotationData["p1"].Chunks[0].Trans; w != g {
+		t.Errorf("wanted '%s' got '%s'", w, g)
+	}
+}
+
+func TestTiers(t *testing.T) {
+	ok := protocol.Status{Name: "ok"}
+	anno := protocol.AnnotationPayload{
+		Page:  protocol.PagePayload{ID: "p1", Audio: "a.wav", Chunk: protocol.Chunk{Start: 0, End: 100}},
+		Tiers: []protocol.Tier{{Name: "a"}, {Name: "c"}},
+		Chunks: []protocol.TransChunk{
+			{Chunk: protocol.Chunk{Start: 0, End: 20}, Tier: "a", CurrentStatus: ok},
+			{Chunk: protocol.Chunk{Start: 10, End: 30}, Tier: "c", CurrentStatus: ok},
+			{Chunk: protocol.Chunk{Start: 20, End: 40}, Tier: "a", CurrentStatus: ok},
+		},
+	}
+	if err := validateAnnotation(anno); err != nil {
+		t.Errorf("expected nil, got %v", err)
+	}
+
+	db := NewDBAPI("", nil)
+	db.annotationData = map[string]protocol.AnnotationPayload{"p1": anno}
+	stats := db.chunkStats()["all"]
+	if w, g := 3, stats["overlapping"]; w != g {
+		t.Errorf("wanted %d got %d", w, g)
+	}
+	if w, g := 2, stats["tier:a"]; w != g {
+		t.Errorf("wanted %d got %d", w, g)
+	}
+
+	anno.Chunks[2].Start = 15
+	if err := validateAnnotation(anno); err == nil {
+		t.Errorf("expected error for overlapping chunks in the same tier, got nil")
+	}
+	anno.Chunks[2].Start = 20
+	anno.Chunks[2].Tier = "x"
+	if err := validateAnnotation(anno); err == nil {
+		t.Errorf("expected error for unknown tier, got nil")
+	}
+	anno.Tiers = nil
+	anno.Chunks[2].Tier = ""
+	if err := validateAnnotation(anno); err == nil {
+		t.Errorf("expected error for tier of page without tiers, got nil")
+	}
+}
+
+func TestOKTranscriptions(t *testing.T) {
+	db := NewDBAPI("", nil)
+	db.annotationData = map[string]protocol.AnnotationPayload{
+		"p1": {
+			Page:          protocol.PagePayload{ID: "p1"},
+			CurrentStatus: protocol.Status{Name: "normal"},
+			Chunks: []protocol.TransChunk{
+				{Trans: "trans1", CurrentStatus: protocol.Status{Name: "ok"}},
+				{Trans: "trans2", CurrentStatus: protocol.Status{Name: "ok2"}},
+				{Trans: "trans3", CurrentStatus: protocol.Status{Name: "unchecked"}},
+				{Trans: " ", CurrentStatus: protocol.Status{Name: "ok"}},
+			},
+		},
+		"p2": {
+			Page:          protocol.PagePayload{ID: "p2"},
+			CurrentStatus: protocol.Status{Name: "skip"},
+			Chunks:        []protocol.TransChunk{{Trans: "trans4", CurrentStatus: protocol.Status{Name: "ok"}}},
+		},
+	}
+
+	if w, g := "trans1 trans2", strings.Join(db.OKTranscriptions(), " "); w != g {
+		t.Errorf("wanted '%s' got '%s'", w, g)
+	}
+}
+
+func TestPageInfosQueryAndLocks(t *testing.T) {
+	db := NewDBAPI("", nil)
+	db.sourceData = []protocol.PagePayload{{ID: "p1", Audio: "a.wav"}, {ID: "p2", Audio: "b.wav"}, {ID: "p3", Audio: "b.wav"}}
+	db.annotationData = map[string]protocol.AnnotationPayload{
+		"p1": {
+			Page:          protocol.PagePayload{ID: "p1", Audio: "a.wav"},
+			CurrentStatus: protocol.Status{Name: "normal"},
+			Chunks:        []protocol.TransChunk{{Trans: "trans1", CurrentStatus: protocol.Status{Name: "ok"}}},
+		},
+		"p2": {
+			Page:          protocol.PagePayload{ID: "p2", Audio: "b.wav"},
+			CurrentStatus: protocol.Status{Name: "skip"},
+			Chunks:        []protocol.TransChunk{{Trans: "trans2", CurrentStatus: protocol.Status{Name: "unchecked"}}},
+		},
+	}
+	p := Proj{mutex: &sync.RWMutex{}, DBs: map[string]*DBAPI{"/data/sp1": db}}
+
+	sp, err := p.ResolveSubProj("sp1")
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	if w, g := "/data/sp1", sp; w != g {
+		t.Errorf("wanted '%s' got '%s'", w, g)
+	}
+	if _, err := p.ResolveSubProj("sp2"); err == nil {
+		t.Errorf("expected error, got nil")
+	}
+
+	// sub projects with the same base name
+	p2 := Proj{mutex: &sync.RWMutex{}, DBs: map[string]*DBAPI{"/data/a/sp1": db, "/data/b/sp1": db, "/data/sp2": db}}
+	if _, err := p2.ResolveSubProj("sp1"); err == nil {
+		t.Errorf("expected error for ambiguous name, got nil")
+	}
+	for _, test := range []struct{ subProj, name string }{
+		{"/data/a/sp1", ":data:a:sp1"},
+		{"/data/b/sp1", ":data:b:sp1"},
+		{"/data/sp2", "sp2"},
+	} {
+		if w, g := test.name, p2.SubProjName(test.subProj); w != g {
+			t.Errorf("wanted '%s' got '%s'", w, g)
+		}
+		sp, err := p2.ResolveSubProj(test.name)
+		if err != nil {
+			t.Errorf("expected nil, got %v", err)
+		}
+		if w, g := test.subProj, sp; w != g {
+			t.Errorf("wanted '%s' got '%s'", w, g)
+		}
+	}
+	if _, err := p2.ResolveSubProj(":data:c:sp1"); err == nil {
+		t.Errorf("expected error, got nil")
+	}
+
+	ci := ClientID{ID: "api", UserName: "anna"}
+	if err := p.Lock(sp, "p2", ci); err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	if err := p.Lock(sp, "p2", ClientID{ID: "api", UserName: "bo"}); err == nil {
+		t.Errorf("expected error, got nil")
+	}
+	if err := p.Lock(sp, "p4", ci); err == nil {
+		t.Errorf("expected error, got nil")
+	}
+	if lockedBy, locked, _ := p.LockedBy(sp, "p2"); !locked || lockedBy != ci {
+		t.Errorf("expected p2 to be locked by %v, got %v %v", ci, locked, lockedBy)
+	}
+
+	infos, err := p.PageInfos(sp)
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	if w, g := 3, len(infos); w != g {
+		t.Fatalf("wanted %d got %d", w, g)
+	}
+	if w, g := (PageInfo{PagePayload: db.sourceData[1], Index: 2, Status: "skip", Chunks: 1, Locked: true}), infos[1]; w != g {
+		t.Errorf("wanted %#v got %#v", w, g)
+	}
+
+	a, err := p.Annotation(sp, "p3")
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	if w, g := int64(3), a.Index; w != g {
+		t.Errorf("wanted %d got %d", w, g)
+	}
+	if w, g := sp, a.SubProj; w != g {
+		t.Errorf("wanted '%s' got '%s'", w, g)
+	}
+
+	res, err := p.Query(sp, protocol.QueryRequest{PageStatus: StatusAny, Status: StatusAny, Source: SourceAny, AudioFile: "b", TransRE: "trans"})
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	if w, g := 1, len(res); w != g {
+		t.Fatalf("wanted %d got %d", w, g)
+	}
+	if w, g := "p2", res[0].Page.ID; w != g {
+		t.Errorf("wanted '%s' got '%s'", w, g)
+	}
+	if _, err := p.Query(sp, protocol.QueryRequest{TransRE: "("}); err == nil {
+		t.Errorf("expected error, got nil")
+	}
+}
+
+func TestQualityCondition(t *testing.T) {
+	q := &protocol.AudioQuality{SNR: 8, SilenceRatio: 0.95, ClippingRatio: 0.002, RMS: -30, Peak: -3}
+	for _, s := range []string{"snr < 10 dB", "SNR<10dB", "silence > 90%", "silence >= 0.95", "clipping > 0.1 %", "peak > -6", "rms = -30"} {
+		c, err := ParseQualityCondition(s)
+		if err != nil {
+			t.Errorf("expected nil for '%s', got %v", s, err)
+			continue
+		}
+		if !c.Match(q) {
+			t.Errorf("expected '%s' to match %#v", s, q)
+		}
+		if c.Match(nil) {
+			t.Errorf("expected '%s' not to match nil", s)
+		}
+	}
+	c, err := ParseQualityCondition("snr >= 10 dB")
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	if c.Match(q) {
+		t.Errorf("expected '%#v' not to match %#v", c, q)
+	}
+	for _, s := range []string{"", "snr", "snr < x", "silence > 90 dB", "snr < 10%", "noise < 10"} {
+		if _, err := ParseQualityCondition(s); err == nil {
+			t.Errorf("expected error for '%s', got nil", s)
+		}
+	}
+}
+
+func TestUpdateQuality(t *testing.T) {
+	db := NewDBAPI(t.TempDir(), nil)
+	if err := os.MkdirAll(db.AnnotationDataDir, 0755); err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	unchecked := protocol.Status{Name: StatusUnchecked}
+	db.sourceData = []protocol.PagePayload{{ID: "p1"}, {ID: "p2"}, {ID: "p3"}, {ID: "p4"}}
+	db.annotationData = map[string]protocol.AnnotationPayload{
+		"p1": {Page: protocol.PagePayload{ID: "p1"}, Chunks: []protocol.TransChunk{{UUID: "c1", Chunk: protocol.Chunk{Start: 0, End: 10}, CurrentStatus: unchecked}}},
+		"p2": {Page: protocol.PagePayload{ID: "p2"}, Chunks: []protocol.TransChunk{{UUID: "c2", CurrentStatus: protocol.Status{Name: "ok"}}}},
+		"p3": {Page: protocol.PagePayload{ID: "p3"}},
+		"p4": {Page: protocol.PagePayload{ID: "p4"}},
+	}
+	if err := db.Lock("p4", ClientID{ID: "api", UserName: "anna"}); err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+
+	analysed := 0
+	analyse := func(a protocol.AnnotationPayload) (protocol.AnnotationPayload, error) {
+		analysed++
+		snr := 5.0
+		if a.Page.ID == "p3" {
+			snr = 30
+		}
+		a.Quality = &protocol.AudioQuality{SNR: snr}
+		for i := range a.Chunks {
+			a.Chunks[i].Quality = &protocol.AudioQuality{SNR: snr}
+		}
+		return a, nil
+	}
+	if _, err := db.UpdateQuality(analyse, "snr <", false); err == nil {
+		t.Errorf("expected error for invalid condition, got nil")
+	}
+	res, err := db.UpdateQuality(analyse, "snr < 10 dB", false)
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	if w, g := 3, res.Analysed; w != g {
+		t.Errorf("wanted %d got %d", w, g)
+	}
+	if w, g := "p1", strings.Join(res.AutoSkipped, " "); w != g {
+		t.Errorf("wanted '%s' got '%s'", w, g)
+	}
+	if w, g := "p4", strings.Join(res.SkippedLocked, " "); w != g {
+		t.Errorf("wanted '%s' got '%s'", w, g)
+	}
+	p1 := db.annotationData["p1"]
+	if w, g := (protocol.Status{Name: StatusSkip, Source: AutoSkipSource}), (protocol.Status{Name: p1.CurrentStatus.Name, Source: p1.CurrentStatus.Source}); w != g {
+		t.Errorf("wanted %#v got %#v", w, g)
+	}
+	if p1.Chunks[0].Quality == nil || db.annotationData["p2"].CurrentStatus.Name != "" {
+		t.Errorf("expected chunk quality of p1, and p2 not to be skipped")
+	}
+	if _, err := os.Stat(filepath.Join(db.AnnotationDataDir, "p1.json")); err != nil {
+		t.Errorf("expected nil, got %v", err)
+	}
+	stats := db.pageStats()["all"]
+	if w, g := 3, stats["quality:analysed"]; w != g {
+		t.Errorf("wanted %d got %d", w, g)
+	}
+	if w, g := 1, stats["quality:auto_skipped"]; w != g {
+		t.Errorf("wanted %d got %d", w, g)
+	}
+
+	// analysed pages are only analysed again with force
+	if _, err := db.UpdateQuality(analyse, "", false); err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	if w, g := 3, analysed; w != g {
+		t.Errorf("wanted %d got %d", w, g)
+	}
+
+	// the quality is kept when saving from the editor
+	p1.Quality = nil
+	p1.Chunks = []protocol.TransChunk{{UUID: "c1", Chunk: protocol.Chunk{Start: 0, End: 10}, Trans: "hej"}}
+	if err := db.Save(p1); err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	if db.annotationData["p1"].Quality == nil || db.annotationData["p1"].Chunks[0].Quality == nil {
+		t.Errorf("expected saved quality to be kept")
+	}
+	p1.Chunks[0].End = 20
+	if err := db.Save(p1); err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	if db.annotationData["p1"].Chunks[0].Quality != nil {
+		t.Errorf("expected quality of changed chunk to be dropped")
+	}
+
+	qRes, err := db.Query(protocol.QueryRequest{PageStatus: StatusAny, Status: StatusAny, Source: SourceAny, Quality: "snr >= 20 dB"})
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	if len(qRes) != 1 || qRes[0].Page.ID != "p3" {
+		t.Errorf("expected p3, got %v", qRes)
+	}
+	if _, err := db.Query(protocol.QueryRequest{Quality: "snr"}); err == nil {
+		t.Errorf("expected error, got nil")
+	}
+}
+
+func TestSaveSubProj(t *testing.T) {
+	dir := t.TempDir()
+	db := NewDBAPI(dir, nil)
+	if err := os.MkdirAll(db.AnnotationDataDir, 0755); err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	anno := protocol.AnnotationPayload{SubProj: "/other/machine/sp1", Page: protocol.PagePayload{ID: "p1", Audio: "a.wav"}, CurrentStatus: protocol.Status{Name: "normal"}}
+	if err := db.Save(anno); err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	bts, err := os.ReadFile(filepath.Join(db.AnnotationDataDir, "p1.json"))
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	if strings.Contains(string(bts), "/other/machine") {
+		t.Errorf("expected sub project not to be saved, got %s", bts)
+	}
+
+	loaded, _, err := db.LoadAnnotationData()
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	if w, g := dir, loaded["p1"].SubProj; w != g {
+		t.Errorf("wanted '%s' got '%s'", w, g)
+	}
+}
+
+func TestUpdateQualityChangedPages(t *testing.T) {
+	db := NewDBAPI(t.TempDir(), nil)
+	if err := os.MkdirAll(db.AnnotationDataDir, 0755); err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	chunks := []protocol.TransChunk{{UUID: "c1", Chunk: protocol.Chunk{Start: 0, End: 10}}}
+	db.sourceData = []protocol.PagePayload{{ID: "p1"}, {ID: "p2"}, {ID: "p3"}}
+	db.annotationData = map[string]protocol.AnnotationPayload{
+		"p1": {Page: protocol.PagePayload{ID: "p1"}, Chunks: chunks},
+		"p2": {Page: protocol.PagePayload{ID: "p2"}, Chunks: chunks},
+		"p3": {Page: protocol.PagePayload{ID: "p3"}, Chunks: chunks},
+	}
+
+	// an editor locks p1 and re-chunks p2 during the analysis
+	analyse := func(a protocol.AnnotationPayload) (protocol.AnnotationPayload, error) {
+		switch a.Page.ID {
+		case "p1":
+			if err := db.Lock("p1", ClientID{ID: "c", UserName: "anna"}); err != nil {
+				t.Fatalf("expected nil, got %v", err)
+			}
+		case "p2":
+			edited := a
+			edited.Chunks = []protocol.TransChunk{{UUID: "c1", Chunk: protocol.Chunk{Start: 0, End: 20}, Trans: "hej"}}
+			if err := db.Save(edited); err != nil {
+				t.Fatalf("expected nil, got %v", err)
+			}
+		}
+		res := a
+		res.Quality = &protocol.AudioQuality{SNR: 5}
+		res.Chunks = []protocol.TransChunk{a.Chunks[0]}
+		res.Chunks[0].Quality = &protocol.AudioQuality{SNR: 5}
+		return res, nil
+	}
+	res, err := db.UpdateQuality(analyse, "snr < 10 dB", false)
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	if w, g := "p1", strings.Join(res.SkippedLocked, " "); w != g {
+		t.Errorf("wanted '%s' got '%s'", w, g)
+	}
+	if w, g := "p2", strings.Join(res.SkippedChanged, " "); w != g {
+		t.Errorf("wanted '%s' got '%s'", w, g)
+	}
+	if w, g := "p3", strings.Join(res.AutoSkipped, " "); w != g {
+		t.Errorf("wanted '%s' got '%s'", w, g)
+	}
+	p2 := db.annotationData["p2"]
+	if p2.Quality != nil || p2.CurrentStatus.Name != "" || p2.Chunks[0].Trans != "hej" {
+		t.Errorf("expected the edit of p2 to be kept, got %#v", p2)
+	}
+	if db.annotationData["p1"].Quality != nil {
+		t.Errorf("expected locked page not to be saved")
+	}
+}
@@ -1,6 +1,7 @@
 package ffmpeg
 
 import (
+	"errors"
 	"fmt"
 	//"log"
 	"os"
@@ -8,36 +9,67 @@ import (
 	"path/filepath"
 	"strings"
 
+	"github.com/stts-se/transtool-open/modules/wav"
 	"github.com/stts-se/transtool-open/protocol"
 )
 
 // Chunk2File extracts time chunks from an audio file, creating a subset of files containing "phrases" from the file.
+// PCM WAV chunks are extracted natively, other formats using ffmpeg.
 // For initialization, use NewChunk2File().
 type Chunk2File struct {
 }
 
-// NewChunk2File creates a new Chunk2File after first checking that the ffmpeg command exists
+// NewChunk2File creates a new Chunk2File. The ffmpeg command is only required for audio other than PCM WAV.
 func NewChunk2File() (Chunk2File, error) {
-	if err := ffmpegEnabled(); err != nil {
-		return Chunk2File{}, err
-	}
 	return Chunk2File{}, nil
 }
 
+// nativeWAV returns true if the output is WAV, so that the chunk can be
+// extracted without ffmpeg (if the input is PCM WAV)
+func nativeWAV(outFile, encoding string) bool {
+	if encoding != "" {
+		return encoding == "wav"
+	}
+	return strings.ToLower(filepath.Ext(outFile)) == ".wav"
+}
+
 // ProcessChunk extracts the specified chunk from the audioFile into the outFile
 func (ch Chunk2File) ProcessChunk(audioFile string, chunk protocol.Chunk, outFile, encoding string) error {
+	return ch.ProcessChannelChunk(audioFile, chunk, 0, outFile, encoding)
+}
+
+// ProcessChannelChunk extracts the specified chunk of a channel (1 for the first channel) from the audioFile into the outFile, as mono audio.
+// Channel 0 extracts all channels.
+func (ch Chunk2File) ProcessChannelChunk(audioFile string, chunk protocol.Chunk, channel int, outFile, encoding string) error {
 	if chunk.Start > chunk.End {
 		return fmt.Errorf("cannot process input chunk with negative duration: %v-%v", chunk.Start, chunk.End)
 	}
 	if chunk.Start == chunk.End && chunk.Start > 0 {
 		return fmt.Errorf("cannot process input chunk with zero duration: %v-%v", chunk.Start, chunk.End)
 	}
+	if channel < 0 {
+		return fmt.Errorf("invalid channel: %d", channel)
+	}
+
+	if localFile, ok := wav.LocalFile(audioFile); ok && nativeWAV(outFile, encoding) {
+		err := wav.ExtractFile(localFile, chunk, channel, outFile)
+		if err == nil || !errors.Is(err, wav.ErrUnsupported) {
+			return err
+		}
+		// not a PCM WAV file: use ffmpeg
+	}
+	if err := Enabled(); err != nil {
+		return err
+	}
 
 	startFloat := float64(chunk.Start) / 1000.0
 	endFloat := float64(chunk.End) / 1000.0
 	duration := endFloat - startFloat
 	//ffmpeg -y -ss 0 -t 30 -i <in> <out>
 	args := []string{"-y", "-ss", fmt.Sprintf("%v", startFloat), "-t", fmt.Sprintf("%v", duration), "-i", audioFile}
+	if channel > 0 {
+		args = append(args, "-af", panFilter(channel))
+	}
 	if encoding != "" {
 		args = append(args, "-f")
 		args = append(args, encoding)
@@ -2,6 +2,7 @@ package ffmpeg
 
 import (
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"os"
 	"path"
@@ -11,6 +12,7 @@ import (
 
 	"github.com/google/uuid"
 
+	"github.com/stts-se/transtool-open/modules/wav"
 	"github.com/stts-se/transtool-open/protocol"
 )
 
@@ -20,7 +22,7 @@ type ChunkExtractor struct {
 	chunk2file Chunk2File
 }
 
-// NewChunkExtractor creates a new ChunkExtractor after first checking that the ffmpeg command exists
+// NewChunkExtractor creates a new ChunkExtractor. The ffmpeg command is only required for audio other than PCM WAV (see Chunk2File).
 func NewChunkExtractor() (ChunkExtractor, error) {
 	c2f, err := NewChunk2File()
 	if err != nil {
@@ -31,52 +33,68 @@ func NewChunkExtractor() (ChunkExtractor, error) {
 
 // ProcessFileWithContext an audioFile, extracting the specified chunks to slices of byte
 func (ch ChunkExtractor) ProcessFileWithContext(payload protocol.SplitRequestPayload, encoding string) (protocol.AnnotationWithAudioData, error) {
-	if _, err := os.Stat(payload.Audio); os.IsNotExist(err) {
-		return protocol.AnnotationWithAudioData{}, fmt.Errorf("no such file: %s", payload.Audio)
+	bts, res, err := ch.ExtractWithContext(payload, encoding)
+	if err != nil {
+		return res, err
 	}
+	res.Base64Audio = base64.StdEncoding.EncodeToString(bts)
+	return res, nil
+}
 
-	offset := payload.Chunk.Start - payload.LeftContext
-	if offset < 0 {
-		offset = 0
-	}
-	processChunk := protocol.Chunk{
-		Start: offset,
-		End:   payload.Chunk.End + payload.RightContext,
+// ExtractWithContext extracts the chunk of an audio file, with context,
+// and returns the audio data. The returned AnnotationWithAudioData holds
+// the file type and offset of the audio, but no audio data.
+func (ch ChunkExtractor) ExtractWithContext(payload protocol.SplitRequestPayload, encoding string) ([]byte, protocol.AnnotationWithAudioData, error) {
+	if _, err := os.Stat(payload.Audio); os.IsNotExist(err) {
+		return nil, protocol.AnnotationWithAudioData{}, fmt.Errorf("no such file: %s", payload.Audio)
 	}
 
-	ext := filepath.Ext(payload.Audio)
-	ext = strings.TrimPrefix(ext, ".")
-	ext = trimURLParamsRE.ReplaceAllString(ext, "")
-	if encoding == "" {
-		encoding = ext
-	}
-	btss, err := ch.ProcessFile(payload.Audio, []protocol.Chunk{processChunk}, encoding)
+	res := AudioInfo(payload, encoding)
+	btss, err := ch.ProcessChannelFile(payload.Audio, []protocol.Chunk{payload.WithContext()}, payload.Channel, res.FileType)
 	if err != nil {
-		return protocol.AnnotationWithAudioData{}, err
+		return nil, protocol.AnnotationWithAudioData{}, err
 	}
 
 	if len(btss) != 1 {
-		return protocol.AnnotationWithAudioData{}, fmt.Errorf("expected one byte array, found %d", len(btss))
+		return nil, protocol.AnnotationWithAudioData{}, fmt.Errorf("expected one byte array, found %d", len(btss))
 	}
 
-	bts := btss[0]
+	//os.WriteFile("chunk_extractor_debug.wav", btss[0], 0644)
 
-	//os.WriteFile("chunk_extractor_debug.wav", bts, 0644)
+	return btss[0], res, nil
+}
+
+// AudioInfo returns the file type and offset of the audio extracted by
+// ExtractWithContext, and the chunk relative to the offset, without
+// extracting any audio
+func AudioInfo(payload protocol.SplitRequestPayload, encoding string) protocol.AnnotationWithAudioData {
+	offset := payload.WithContext().Start
 
+	ext := filepath.Ext(payload.Audio)
+	ext = strings.TrimPrefix(ext, ".")
+	ext = trimURLParamsRE.ReplaceAllString(ext, "")
+	if encoding == "" {
+		encoding = ext
+	}
 	res := protocol.AnnotationWithAudioData{
-		Base64Audio: base64.StdEncoding.EncodeToString(bts),
-		FileType:    encoding,
-		Offset:      offset,
+		FileType: encoding,
+		Offset:   offset,
 	}
 	res.Start = payload.Chunk.Start - offset
 	res.End = payload.Chunk.End - offset
-	return res, nil
+	return res
 }
 
 var trimURLParamsRE = regexp.MustCompile(`\?[^?.]*$`)
 
 // ProcessFile an audioFile, extracting the specified chunks to slices of byte
 func (ch ChunkExtractor) ProcessFile(audioFile string, chunks []protocol.Chunk, encoding string) ([][]byte, error) {
+	return ch.ProcessChannelFile(audioFile, chunks, 0, encoding)
+}
+
+// ProcessChannelFile an audioFile, extracting the specified chunks of a channel (1 for the first channel) to slices of byte.
+// Channel 0 extracts all channels.
+func (ch ChunkExtractor) ProcessChannelFile(audioFile string, chunks []protocol.Chunk, channel int, encoding string) ([][]byte, error) {
 	res := [][]byte{}
 	// if _, err := os.Stat(audioFile); os.IsNotExist(err) {
 	// 	return res, fmt.Errorf("No such file: %s", audioFile)
@@ -98,6 +116,17 @@ func (ch ChunkExtractor) ProcessFile(audioFile string, chunks []protocol.Chunk,
 		} else {
 			encoding = ext
 		}
+		if localFile, ok := wav.LocalFile(audioFile); ok && encoding == "wav" {
+			bts, err := wav.ExtractBytes(localFile, chunk, channel)
+			if err == nil {
+				res = append(res, bts)
+				continue
+			}
+			if !errors.Is(err, wav.ErrUnsupported) {
+				return res, fmt.Errorf("failed to extract wav chunk : %v", err)
+			}
+			// not a PCM WAV file: use ffmpeg
+		}
 		id, err := uuid.NewUUID()
 		if err != nil {
 			return res, fmt.Errorf("couldn't create uuid : %v", err)
@@ -106,9 +135,9 @@ func (ch ChunkExtractor) ProcessFile(audioFile string, chunks []protocol.Chunk,
 		//log.Info("chunk_extractor tmpFile", tmpFile)
 		defer os.Remove(tmpFile)
 		//c2fStart := time.Now()
-		err = ch.chunk2file.ProcessChunk(audioFile, chunk, tmpFile, encoding)
+		err = ch.chunk2file.ProcessChannelChunk(audioFile, chunk, channel, tmpFile, encoding)
 		if err != nil {
-			return res, fmt.Errorf("chunk2file.ProcessChunk failed : %v", err)
+			return res, fmt.Errorf("chunk2file.ProcessChannelChunk failed : %v", err)
 		}
 		//c2fDur := time.Since(c2fStart)
 		//log.Info("chunk2file dur %v", c2fDur)
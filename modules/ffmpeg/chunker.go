@@ -21,7 +21,7 @@ type Chunker struct {
 
 // NewChunker creates a new Chunker after first checking that the ffmpeg command exists
 func NewChunker(minSilenceLen, extendChunk int64) (Chunker, error) {
-	if err := ffmpegEnabled(); err != nil {
+	if err := Enabled(); err != nil {
 		return Chunker{}, err
 	}
 	return Chunker{MinSilenceLen: minSilenceLen, ExtendChunk: extendChunk}, nil
@@ -29,7 +29,7 @@ func NewChunker(minSilenceLen, extendChunk int64) (Chunker, error) {
 
 // NewDefaultChunker creates a new Chunker after first checking that the ffmpeg command exists
 func NewDefaultChunker() (Chunker, error) {
-	if err := ffmpegEnabled(); err != nil {
+	if err := Enabled(); err != nil {
 		return Chunker{}, err
 	}
 	return Chunker{MinSilenceLen: DefaultMinSilenceLen, ExtendChunk: DefaultExtendChunk}, nil
@@ -48,9 +48,15 @@ const (
 
 // ProcessChunk the audioFile into time chunks
 func (ch Chunker) ProcessChunk(audioFile string, chunk protocol.Chunk) ([]protocol.Chunk, error) {
+	return ch.ProcessChannelChunk(audioFile, 0, chunk)
+}
+
+// ProcessChannelChunk a channel (1 for the first channel) of the audioFile into time chunks.
+// Channel 0 processes all channels, mixed.
+func (ch Chunker) ProcessChannelChunk(audioFile string, channel int, chunk protocol.Chunk) ([]protocol.Chunk, error) {
 	res := []protocol.Chunk{}
 	//log.Printf("chunker input chunk: %#v", chunk)
-	tmpRes, err := ch.ProcessFile(audioFile)
+	tmpRes, err := ch.ProcessChannelFile(audioFile, channel)
 	if err != nil {
 		return res, err
 	}
@@ -73,7 +79,16 @@ func (ch Chunker) ProcessChunk(audioFile string, chunk protocol.Chunk) ([]protoc
 
 // ProcessFile the audioFile into time chunks
 func (ch Chunker) ProcessFile(audioFile string) ([]protocol.Chunk, error) {
+	return ch.ProcessChannelFile(audioFile, 0)
+}
+
+// ProcessChannelFile a channel (1 for the first channel) of the audioFile into time chunks.
+// Channel 0 processes all channels, mixed.
+func (ch Chunker) ProcessChannelFile(audioFile string, channel int) ([]protocol.Chunk, error) {
 	res := []protocol.Chunk{}
+	if channel < 0 {
+		return res, fmt.Errorf("invalid channel: %d", channel)
+	}
 
 	minSilenceLen := float64(ch.MinSilenceLen) / 1000.0
 
@@ -81,7 +96,11 @@ func (ch Chunker) ProcessFile(audioFile string) ([]protocol.Chunk, error) {
 		return res, fmt.Errorf("no such file: %s", audioFile)
 	}
 	//ffmpeg -i <LJUDFIL> -af silencedetect=noise=-50dB:d=1 -f null -
-	cmd := exec.Command(FfmpegCmd, "-i", audioFile, "-af", fmt.Sprintf("silencedetect=noise=-50dB:d=%.3f", minSilenceLen), "-f", "null", "-")
+	filter := fmt.Sprintf("silencedetect=noise=-50dB:d=%.3f", minSilenceLen)
+	if channel > 0 {
+		filter = panFilter(channel) + "," + filter
+	}
+	cmd := exec.Command(FfmpegCmd, "-i", audioFile, "-af", filter, "-f", "null", "-")
 	//log.Printf("chunker cmd: %v", cmd)
 	out, err := cmd.CombinedOutput()
 	if err != nil {
@@ -0,0 +1,151 @@
+package ffmpeg
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+
+	"github.com/stts-se/transtool-open/modules/wav"
+)
+
+// ConvertToWAV converts an audio file to 16 bit PCM WAV, keeping the sample rate and channels
+func ConvertToWAV(audioFile, outFile string) error {
+	if err := Enabled(); err != nil {
+		return err
+	}
+	cmd := exec.Command(FfmpegCmd, "-y", "-i", audioFile, "-acodec", "pcm_s16le", "-f", "wav", outFile)
+	err := cmd.Run()
+	if err != nil {
+		return fmt.Errorf("command %s failed : %#v", cmd, err)
+	}
+	return nil
+}
+
+// TranscodeFormat is the output format of Transcode
+type TranscodeFormat struct {
+	// Encoding is the output encoding: flac, wav (16 bit PCM), mp3 or opus
+	Encoding string `json:"encoding"`
+	// SampleRate is the output sample rate, or 0 to keep the input sample rate
+	SampleRate int `json:"sample_rate,omitempty"`
+	// Channels is the number of output channels, or 0 to keep the input channels
+	Channels int `json:"channels,omitempty"`
+	// Loudness is the target integrated loudness in LUFS (such as -23)
+	// of EBU R128 loudness normalisation, or 0 for no normalisation
+	Loudness float64 `json:"loudness,omitempty"`
+}
+
+// DefaultTranscodeFormat is 16 kHz mono FLAC, without loudness normalisation
+var DefaultTranscodeFormat = TranscodeFormat{Encoding: "flac", SampleRate: 16000, Channels: 1}
+
+// transcodeCodecs maps encodings to ffmpeg codec and output format
+var transcodeCodecs = map[string][2]string{
+	"flac": {"flac", "flac"},
+	"wav":  {"pcm_s16le", "wav"},
+	"mp3":  {"libmp3lame", "mp3"},
+	"opus": {"libopus", "opus"},
+}
+
+// Codec returns the ffmpeg name of the codec of the encoding (as in
+// ffprobe.AudioInfo.Codec), or the empty string for unknown encodings
+func (f TranscodeFormat) Codec() string {
+	return transcodeCodecs[f.Encoding][0]
+}
+
+// Validate returns an error if the format is invalid
+func (f TranscodeFormat) Validate() error {
+	if _, ok := transcodeCodecs[f.Encoding]; !ok {
+		return fmt.Errorf("unknown encoding '%s' (valid values: flac, wav, mp3, opus)", f.Encoding)
+	}
+	if f.SampleRate < 0 {
+		return fmt.Errorf("invalid sample rate: %d", f.SampleRate)
+	}
+	if f.Channels < 0 {
+		return fmt.Errorf("invalid channel count: %d", f.Channels)
+	}
+	if f.Loudness > 0 || (f.Loudness != 0 && f.Loudness < -70) {
+		return fmt.Errorf("invalid loudness: %v (valid values: -70 to 0 LUFS)", f.Loudness)
+	}
+	return nil
+}
+
+// transcodeArgs returns the ffmpeg arguments of Transcode
+func transcodeArgs(audioFile, outFile string, format TranscodeFormat) []string {
+	codec := transcodeCodecs[format.Encoding]
+	args := []string{"-y", "-i", audioFile, "-vn"}
+	if format.Loudness != 0 {
+		args = append(args, "-af", fmt.Sprintf("loudnorm=I=%v:TP=-2:LRA=11", format.Loudness))
+	}
+	if format.SampleRate > 0 {
+		args = append(args, "-ar", fmt.Sprintf("%d", format.SampleRate))
+	}
+	if format.Channels > 0 {
+		args = append(args, "-ac", fmt.Sprintf("%d", format.Channels))
+	}
+	return append(args, "-acodec", codec[0], "-f", codec[1], outFile)
+}
+
+// Transcode converts an audio file to the format. The output is written
+// to a temporary file, which is renamed to outFile when complete.
+func Transcode(audioFile, outFile string, format TranscodeFormat) error {
+	if err := format.Validate(); err != nil {
+		return err
+	}
+	if err := Enabled(); err != nil {
+		return err
+	}
+	tmpFile := outFile + ".tmp"
+	cmd := exec.Command(FfmpegCmd, transcodeArgs(audioFile, tmpFile, format)...)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		os.Remove(tmpFile)
+		return fmt.Errorf("command %s failed : %v : %s", cmd, err, out)
+	}
+	if err := os.Rename(tmpFile, outFile); err != nil {
+		os.Remove(tmpFile)
+		return fmt.Errorf("failed to rename %s : %v", tmpFile, err)
+	}
+	return nil
+}
+
+// OpenWAV returns a reader of the samples of an audio file. Audio
+// other than PCM WAV is first converted to a temporary WAV file. The
+// returned function closes the reader (and removes the temporary file).
+func OpenWAV(audioFile string) (*wav.Reader, func(), error) {
+	f, err := os.Open(audioFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	r, err := wav.NewReader(f)
+	if err == nil {
+		return r, func() { f.Close() }, nil
+	}
+	f.Close()
+	if !errors.Is(err, wav.ErrUnsupported) {
+		return nil, nil, err
+	}
+
+	tmp, err := os.CreateTemp("", "transtool-convert-*.wav")
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create temp file : %v", err)
+	}
+	tmp.Close()
+	remove := func() { os.Remove(tmp.Name()) }
+	err = ConvertToWAV(audioFile, tmp.Name())
+	if err != nil {
+		remove()
+		return nil, nil, err
+	}
+	f, err = os.Open(tmp.Name())
+	if err != nil {
+		remove()
+		return nil, nil, err
+	}
+	r, err = wav.NewReader(f)
+	if err != nil {
+		f.Close()
+		remove()
+		return nil, nil, err
+	}
+	return r, func() { f.Close(); remove() }, nil
+}
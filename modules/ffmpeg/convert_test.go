@@ -0,0 +1,38 @@
+package ffmpeg
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTranscodeArgs(t *testing.T) {
+	got := strings.Join(transcodeArgs("in.mp3", "out.flac", DefaultTranscodeFormat), " ")
+	exp := "-y -i in.mp3 -vn -ar 16000 -ac 1 -acodec flac -f flac out.flac"
+	if got != exp {
+		t.Errorf("expected '%s', got '%s'", exp, got)
+	}
+
+	got = strings.Join(transcodeArgs("in.wav", "out.wav", TranscodeFormat{Encoding: "wav", Loudness: -23}), " ")
+	exp = "-y -i in.wav -vn -af loudnorm=I=-23:TP=-2:LRA=11 -acodec pcm_s16le -f wav out.wav"
+	if got != exp {
+		t.Errorf("expected '%s', got '%s'", exp, got)
+	}
+}
+
+func TestTranscodeFormatValidate(t *testing.T) {
+	if err := DefaultTranscodeFormat.Validate(); err != nil {
+		t.Errorf("expected nil, got %v", err)
+	}
+	for _, f := range []TranscodeFormat{
+		{Encoding: "aiff"},
+		{Encoding: "flac", SampleRate: -1},
+		{Encoding: "flac", Loudness: 3},
+	} {
+		if err := f.Validate(); err == nil {
+			t.Errorf("expected error for %#v, got nil", f)
+		}
+	}
+	if w, g := "pcm_s16le", (TranscodeFormat{Encoding: "wav"}).Codec(); w != g {
+		t.Errorf("expected %s, got %s", w, g)
+	}
+}
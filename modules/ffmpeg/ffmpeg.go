@@ -7,10 +7,17 @@ import (
 
 var FfmpegCmd = "ffmpeg"
 
-func ffmpegEnabled() error {
+// Enabled returns an error if the ffmpeg command doesn't exist
+func Enabled() error {
 	_, pErr := exec.LookPath(FfmpegCmd)
 	if pErr != nil {
 		return fmt.Errorf("external command does not exist: %s", FfmpegCmd)
 	}
 	return nil
 }
+
+// panFilter returns an audio filter selecting a single channel (1 for
+// the first channel), as mono audio
+func panFilter(channel int) string {
+	return fmt.Sprintf("pan=mono|c0=c%d", channel-1)
+}
@@ -0,0 +1,264 @@
+// Package quality computes audio quality measures of pages and chunks:
+// clipping, RMS and peak levels, an SNR estimate, and the ratio of
+// silence.
+package quality
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+
+	"github.com/stts-se/transtool-open/modules/ffmpeg"
+	"github.com/stts-se/transtool-open/modules/segment"
+	"github.com/stts-se/transtool-open/modules/wav"
+	"github.com/stts-se/transtool-open/protocol"
+)
+
+// Config holds the parameters of the analysis
+type Config struct {
+	// FrameLen is the length of the analysis frames in milliseconds
+	FrameLen int64 `json:"frame_len"`
+	// ClipLevel is the absolute sample value (of full scale 1.0) at or
+	// above which samples are clipped
+	ClipLevel float64 `json:"clip_level"`
+	// VAD is the config of the voice activity detector used for the
+	// silence ratio
+	VAD segment.VADConfig `json:"vad"`
+}
+
+// DefaultConfig is a config for speech recordings
+var DefaultConfig = Config{
+	FrameLen:  20,
+	ClipLevel: 0.999,
+	VAD:       segment.DefaultVADConfig,
+}
+
+// Analyser computes audio quality measures, working on decoded PCM
+// (audio other than PCM WAV is converted using ffmpeg).
+// For initialization, use NewAnalyser().
+type Analyser struct {
+	Config Config
+	vad    segment.VAD
+}
+
+// NewAnalyser creates a new Analyser, after first checking the config
+func NewAnalyser(config Config) (Analyser, error) {
+	if config.FrameLen <= 0 {
+		return Analyser{}, fmt.Errorf("invalid frame length: %d", config.FrameLen)
+	}
+	if config.ClipLevel <= 0 || config.ClipLevel > 1 {
+		return Analyser{}, fmt.Errorf("invalid clip level: %v", config.ClipLevel)
+	}
+	vad, err := segment.NewVAD(config.VAD)
+	if err != nil {
+		return Analyser{}, err
+	}
+	return Analyser{Config: config, vad: vad}, nil
+}
+
+// NewDefaultAnalyser creates a new Analyser with the default config
+func NewDefaultAnalyser() (Analyser, error) {
+	return NewAnalyser(DefaultConfig)
+}
+
+// minDB is the level of digital silence
+const minDB = -100
+
+// frame holds the sample statistics of an analysis frame (all channels)
+type frame struct {
+	sumSq   float64
+	peak    float64
+	clipped int
+	n       int
+}
+
+func (f frame) energy() float64 {
+	if f.n == 0 || f.sumSq == 0 {
+		return minDB
+	}
+	return math.Max(minDB, 10*math.Log10(f.sumSq/float64(f.n)))
+}
+
+// frames reads at most maxFrames frames of r
+func (a Analyser) frames(r *wav.Reader, maxFrames int) ([]frame, error) {
+	res := []frame{}
+	frameSamples := int(int64(r.SampleRate) * a.Config.FrameLen / 1000)
+	if frameSamples < 1 {
+		return res, fmt.Errorf("frame length %d ms too short for sample rate %d", a.Config.FrameLen, r.SampleRate)
+	}
+	buf := make([][]float32, r.Channels)
+	for c := range buf {
+		buf[c] = make([]float32, frameSamples)
+	}
+	for len(res) < maxFrames {
+		n, err := r.ReadFrames(buf)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return res, err
+		}
+		f := frame{}
+		for c := range buf {
+			for _, s := range buf[c][:n] {
+				v := math.Abs(float64(s))
+				f.sumSq += v * v
+				if v > f.peak {
+					f.peak = v
+				}
+				if v >= a.Config.ClipLevel {
+					f.clipped++
+				}
+			}
+		}
+		f.n = n * len(buf)
+		res = append(res, f)
+	}
+	return res, nil
+}
+
+// percentile returns the p:th percentile (0 to 1) of the values
+func percentile(values []float64, p float64) float64 {
+	if len(values) == 0 {
+		return minDB
+	}
+	sorted := append([]float64{}, values...)
+	sort.Float64s(sorted)
+	return sorted[int(p*float64(len(sorted)-1))]
+}
+
+// measures computes the quality of the part of the audio within chunk,
+// from the frames of the audio starting at start, and the speech chunks
+func (a Analyser) measures(frames []frame, start int64, speech []protocol.Chunk, chunk protocol.Chunk) protocol.AudioQuality {
+	res := protocol.AudioQuality{Chunk: chunk, RMS: minDB, Peak: minDB, SilenceRatio: 1}
+	from := int((chunk.Start - start) / a.Config.FrameLen)
+	to := int((chunk.End - start + a.Config.FrameLen - 1) / a.Config.FrameLen)
+	if from < 0 {
+		from = 0
+	}
+	if to > len(frames) {
+		to = len(frames)
+	}
+	if from >= to {
+		return res
+	}
+
+	var total frame
+	energies := []float64{}
+	for _, f := range frames[from:to] {
+		total.sumSq += f.sumSq
+		total.clipped += f.clipped
+		total.n += f.n
+		total.peak = math.Max(total.peak, f.peak)
+		energies = append(energies, f.energy())
+	}
+	if total.n > 0 {
+		res.ClippingRatio = float64(total.clipped) / float64(total.n)
+	}
+	res.RMS = total.energy()
+	if total.peak > 0 {
+		res.Peak = math.Max(minDB, 20*math.Log10(total.peak))
+	}
+	// the loud frames are mostly speech, and the quiet frames noise
+	res.SNR = percentile(energies, 0.95) - percentile(energies, 0.1)
+
+	if chunk.End > chunk.Start {
+		var speechMs int64
+		for _, s := range speech {
+			if s.Start < chunk.End && s.End > chunk.Start {
+				speechMs += min64(s.End, chunk.End) - max64(s.Start, chunk.Start)
+			}
+		}
+		res.SilenceRatio = 1 - float64(speechMs)/float64(chunk.End-chunk.Start)
+	}
+	return res
+}
+
+func max64(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func min64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// Analyse computes the quality of the part of audioFile within page,
+// and of each of the chunks within page
+func (a Analyser) Analyse(audioFile string, page protocol.Chunk, chunks []protocol.Chunk) (protocol.AudioQuality, []protocol.AudioQuality, error) {
+	if page.Start > page.End {
+		return protocol.AudioQuality{}, nil, fmt.Errorf("cannot analyse page with negative duration: %v-%v", page.Start, page.End)
+	}
+
+	// convert once for both the levels and the VAD
+	_, err := wav.ReadHeaderFile(audioFile)
+	if errors.Is(err, wav.ErrUnsupported) {
+		tmp, err := os.CreateTemp("", "transtool-quality-*.wav")
+		if err != nil {
+			return protocol.AudioQuality{}, nil, fmt.Errorf("failed to create temp file : %v", err)
+		}
+		tmp.Close()
+		defer os.Remove(tmp.Name())
+		err = ffmpeg.ConvertToWAV(audioFile, tmp.Name())
+		if err != nil {
+			return protocol.AudioQuality{}, nil, err
+		}
+		audioFile = tmp.Name()
+	} else if err != nil {
+		return protocol.AudioQuality{}, nil, err
+	}
+
+	r, closeWAV, err := ffmpeg.OpenWAV(audioFile)
+	if err != nil {
+		return protocol.AudioQuality{}, nil, err
+	}
+	defer closeWAV()
+	if err := r.SeekTime(page.Start); err != nil {
+		return protocol.AudioQuality{}, nil, err
+	}
+	frames, err := a.frames(r, int((page.End-page.Start+a.Config.FrameLen-1)/a.Config.FrameLen))
+	if err != nil {
+		return protocol.AudioQuality{}, nil, err
+	}
+	speech, err := a.vad.ProcessChunk(audioFile, page)
+	if err != nil {
+		return protocol.AudioQuality{}, nil, err
+	}
+
+	res := a.measures(frames, page.Start, speech, page)
+	var chunkRes []protocol.AudioQuality
+	for _, c := range chunks {
+		chunkRes = append(chunkRes, a.measures(frames, page.Start, speech, c))
+	}
+	return res, chunkRes, nil
+}
+
+// AnalyseAnnotation returns the annotation with the quality of the page
+// and its chunks set. The input annotation is not modified.
+func (a Analyser) AnalyseAnnotation(audioFile string, anno protocol.AnnotationPayload) (protocol.AnnotationPayload, error) {
+	var chunks []protocol.Chunk
+	for _, c := range anno.Chunks {
+		chunks = append(chunks, c.Chunk)
+	}
+	page, chunkRes, err := a.Analyse(audioFile, anno.Page.Chunk, chunks)
+	if err != nil {
+		return anno, err
+	}
+	res := anno
+	res.Quality = &page
+	res.Chunks = make([]protocol.TransChunk, len(anno.Chunks))
+	for i, c := range anno.Chunks {
+		q := chunkRes[i]
+		c.Quality = &q
+		res.Chunks[i] = c
+	}
+	return res, nil
+}
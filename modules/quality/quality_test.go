@@ -0,0 +1,150 @@
+package quality
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stts-se/transtool-open/modules/wav"
+	"github.com/stts-se/transtool-open/protocol"
+)
+
+const testSampleRate = 16000
+
+// part is a part of a test signal: a 200 Hz tone with the given
+// amplitude (clipped at full scale), or noise if tone is false
+type part struct {
+	ms        int
+	tone      bool
+	amplitude float64
+}
+
+// testWAV writes a mono 16 bit WAV file with low level noise and the
+// tones of the parts
+func testWAV(t *testing.T, parts []part) string {
+	rnd := rand.New(rand.NewSource(1))
+	var data bytes.Buffer
+	i := 0
+	for _, p := range parts {
+		for n := 0; n < p.ms*testSampleRate/1000; n++ {
+			v := 0.001 * (rnd.Float64()*2 - 1)
+			if p.tone {
+				v += p.amplitude * math.Sin(2*math.Pi*200*float64(i)/testSampleRate)
+			}
+			v = math.Max(-1, math.Min(1, v))
+			binary.Write(&data, binary.LittleEndian, int16(v*32767))
+			i++
+		}
+	}
+	format := wav.Format{AudioFormat: wav.FormatPCM, Channels: 1, SampleRate: testSampleRate, ByteRate: testSampleRate * 2, BlockAlign: 2, BitsPerSample: 16}
+	var buf bytes.Buffer
+	wav.WriteHeader(&buf, format, int64(data.Len()))
+	buf.Write(data.Bytes())
+	fName := filepath.Join(t.TempDir(), "test.wav")
+	if err := os.WriteFile(fName, buf.Bytes(), 0644); err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	return fName
+}
+
+func near(got, exp, margin float64) bool {
+	return math.Abs(got-exp) <= margin
+}
+
+func TestAnalyse(t *testing.T) {
+	fName := testWAV(t, []part{
+		{ms: 1000},
+		{ms: 1000, tone: true, amplitude: 0.5},
+		{ms: 1000},
+		{ms: 1000, tone: true, amplitude: 2}, // clipped
+	})
+	a, err := NewDefaultAnalyser()
+	if err != nil {
+		t.Fatalf("got error from NewDefaultAnalyser: %v", err)
+	}
+
+	page, chunks, err := a.Analyse(fName, protocol.Chunk{Start: 0, End: 3000}, []protocol.Chunk{
+		{Start: 1000, End: 2000},
+		{Start: 2000, End: 3000},
+		{Start: 3000, End: 4000}, // outside the page
+	})
+	if err != nil {
+		t.Fatalf("got error from Analyse: %v", err)
+	}
+	// a 0.5 amplitude sine wave: -6 dB peak, -9 dB RMS (during a third of the page)
+	if !near(page.Peak, -6, 0.5) || !near(page.RMS, -9-4.8, 0.5) {
+		t.Errorf("expected peak -6 and RMS -13.8, got %v", page)
+	}
+	if page.SNR < 40 {
+		t.Errorf("expected SNR above 40 dB, got %v", page)
+	}
+	if page.ClippingRatio != 0 {
+		t.Errorf("expected no clipping, got %v", page)
+	}
+	// speech for 1000 ms, with 200 ms padding on each side
+	if !near(page.SilenceRatio, 1-1400.0/3000, 0.02) {
+		t.Errorf("expected silence ratio 0.53, got %v", page)
+	}
+	if len(chunks) != 3 {
+		t.Fatalf("expected 3 chunk measures, got %v", chunks)
+	}
+	if !near(chunks[0].RMS, -9, 0.5) || chunks[0].SilenceRatio > 0.01 {
+		t.Errorf("expected RMS -9 and no silence, got %v", chunks[0])
+	}
+	if chunks[1].RMS > -60 || !near(chunks[1].SilenceRatio, 0.8, 0.02) {
+		t.Errorf("expected low RMS and silence ratio 0.8, got %v", chunks[1])
+	}
+	if chunks[2].RMS != minDB || chunks[2].SilenceRatio != 1 {
+		t.Errorf("expected no measures outside the page, got %v", chunks[2])
+	}
+
+	clipped, _, err := a.Analyse(fName, protocol.Chunk{Start: 3000, End: 4000}, nil)
+	if err != nil {
+		t.Fatalf("got error from Analyse: %v", err)
+	}
+	// the tone is above full scale two thirds of the time
+	if clipped.ClippingRatio < 0.5 || clipped.Peak < -0.01 {
+		t.Errorf("expected clipping, got %v", clipped)
+	}
+}
+
+func TestAnalyseAnnotation(t *testing.T) {
+	fName := testWAV(t, []part{{ms: 1000}, {ms: 1000, tone: true, amplitude: 0.5}, {ms: 1000}})
+	a, err := NewDefaultAnalyser()
+	if err != nil {
+		t.Fatalf("got error from NewDefaultAnalyser: %v", err)
+	}
+	anno := protocol.AnnotationPayload{
+		Page:   protocol.PagePayload{ID: "p1", Chunk: protocol.Chunk{Start: 0, End: 3000}},
+		Chunks: []protocol.TransChunk{{UUID: "c1", Chunk: protocol.Chunk{Start: 1000, End: 2000}, Trans: "hej"}},
+	}
+	res, err := a.AnalyseAnnotation(fName, anno)
+	if err != nil {
+		t.Fatalf("got error from AnalyseAnnotation: %v", err)
+	}
+	if res.Quality == nil || res.Chunks[0].Quality == nil {
+		t.Fatalf("expected page and chunk quality, got %#v", res)
+	}
+	if res.Chunks[0].Trans != "hej" || res.Chunks[0].Quality.Chunk != anno.Chunks[0].Chunk {
+		t.Errorf("expected chunk quality of chunk c1, got %#v", res.Chunks[0])
+	}
+	if anno.Quality != nil || anno.Chunks[0].Quality != nil {
+		t.Errorf("expected input annotation to be unmodified")
+	}
+}
+
+func TestNewAnalyser(t *testing.T) {
+	for _, config := range []Config{
+		{},
+		{FrameLen: 20, ClipLevel: 1.5, VAD: DefaultConfig.VAD},
+		{FrameLen: 20, ClipLevel: 0.999},
+	} {
+		if _, err := NewAnalyser(config); err == nil {
+			t.Errorf("expected error for %#v, got nil", config)
+		}
+	}
+}
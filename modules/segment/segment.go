@@ -0,0 +1,128 @@
+// Package segment splits audio into chunks of speech.
+package segment
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/google/uuid"
+
+	"github.com/stts-se/transtool-open/modules/ffmpeg"
+	"github.com/stts-se/transtool-open/modules/ffprobe"
+	"github.com/stts-se/transtool-open/modules/wav"
+	"github.com/stts-se/transtool-open/protocol"
+)
+
+// Segmenter splits audio files into time chunks of speech (in milliseconds)
+type Segmenter interface {
+	// ProcessFile splits the whole audioFile into chunks
+	ProcessFile(audioFile string) ([]protocol.Chunk, error)
+	// ProcessChunk splits the part of audioFile within chunk
+	ProcessChunk(audioFile string, chunk protocol.Chunk) ([]protocol.Chunk, error)
+}
+
+// ChannelSegmenter is a Segmenter that can split the channels of an
+// audio file separately, such as stereo recordings with one speaker
+// per channel. Channel 1 is the first channel, and channel 0 is all
+// channels, mixed.
+type ChannelSegmenter interface {
+	Segmenter
+	// ProcessChannelFile splits a channel of the whole audioFile into chunks
+	ProcessChannelFile(audioFile string, channel int) ([]protocol.Chunk, error)
+	// ProcessChannelChunk splits the part of a channel of audioFile within chunk
+	ProcessChannelChunk(audioFile string, channel int, chunk protocol.Chunk) ([]protocol.Chunk, error)
+}
+
+// the ffmpeg silencedetect chunker is a ChannelSegmenter
+var _ ChannelSegmenter = ffmpeg.Chunker{}
+
+// the energy based VAD is a ChannelSegmenter
+var _ ChannelSegmenter = VAD{}
+
+// Channels returns the number of channels of an audio file. The
+// ffprobe command is only required for audio other than PCM WAV.
+func Channels(audioFile string) (int, error) {
+	h, err := wav.ReadHeaderFile(audioFile)
+	if err == nil {
+		return int(h.Channels), nil
+	}
+	if !errors.Is(err, wav.ErrUnsupported) {
+		return 0, err
+	}
+	infoExtractor, err := ffprobe.NewInfoExtractor()
+	if err != nil {
+		return 0, err
+	}
+	info, err := infoExtractor.Process(audioFile)
+	if err != nil {
+		return 0, err
+	}
+	return int(info.ChannelCount), nil
+}
+
+// ChannelTier returns the name of the tier of a channel
+func ChannelTier(channel int) string {
+	return fmt.Sprintf("channel%d", channel)
+}
+
+// ChannelTiers returns one tier per channel (1 to channels). If label
+// is not nil, the speaker of each tier is set to the speaker label of
+// its channel.
+func ChannelTiers(channels int, label func(channel int) string) []protocol.Tier {
+	var res []protocol.Tier
+	for channel := 1; channel <= channels; channel++ {
+		tier := protocol.Tier{Name: ChannelTier(channel), Channel: channel}
+		if label != nil {
+			tier.Speaker = label(channel)
+		}
+		res = append(res, tier)
+	}
+	return res
+}
+
+// ChannelChunks splits each of the channels (1 to channels) of
+// audioFile within chunk separately, and returns the chunks of all
+// channels, ordered by start time. Chunks of different channels may
+// overlap, and are put in the tier of their channel (see
+// ChannelTiers). Each chunk has its channel set, a new UUID and status
+// unchecked. If label is not nil, the speaker of each chunk is set
+// to the speaker label of its channel. If channels is 0,
+// all channels are split mixed, and the chunks have no channel.
+func ChannelChunks(s ChannelSegmenter, audioFile string, chunk protocol.Chunk, channels int, label func(channel int) string) ([]protocol.TransChunk, error) {
+	res := []protocol.TransChunk{}
+	first := 1
+	if channels == 0 {
+		first = 0
+	}
+	for channel := first; channel <= channels; channel++ {
+		chunks, err := s.ProcessChannelChunk(audioFile, channel, chunk)
+		if err != nil {
+			return res, err
+		}
+		speaker, tier := "", ""
+		if channel > 0 {
+			tier = ChannelTier(channel)
+			if label != nil {
+				speaker = label(channel)
+			}
+		}
+		for _, ch := range chunks {
+			res = append(res, protocol.TransChunk{
+				UUID:          uuid.New().String(),
+				Chunk:         ch,
+				CurrentStatus: protocol.Status{Name: "unchecked"},
+				Speaker:       speaker,
+				Channel:       channel,
+				Tier:          tier,
+			})
+		}
+	}
+	sort.SliceStable(res, func(i, j int) bool {
+		if res[i].Start == res[j].Start {
+			return res[i].Channel < res[j].Channel
+		}
+		return res[i].Start < res[j].Start
+	})
+	return res, nil
+}
@@ -0,0 +1,333 @@
+package segment
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+
+	"github.com/stts-se/transtool-open/modules/ffmpeg"
+	"github.com/stts-se/transtool-open/modules/wav"
+	"github.com/stts-se/transtool-open/protocol"
+)
+
+// VADConfig holds the parameters of the energy based VAD. All
+// durations are in milliseconds.
+type VADConfig struct {
+	// FrameLen is the length of the analysis frames
+	FrameLen int64 `json:"frame_len"`
+	// Threshold is the energy (dBFS) above which a frame is speech. If
+	// Adaptive is set, the threshold is relative to the noise floor of
+	// the audio (dB above the noise floor), which is estimated as the
+	// 10th percentile of the frame energies.
+	Threshold float64 `json:"threshold"`
+	Adaptive  bool    `json:"adaptive"`
+	// ZCRThreshold is the zero-crossing rate (crossings per sample)
+	// above which frames with an energy of at most ZCRMargin dB below
+	// the threshold are speech too (for unvoiced sounds, such as
+	// fricatives). 0 disables the zero-crossing rate.
+	ZCRThreshold float64 `json:"zcr_threshold"`
+	ZCRMargin    float64 `json:"zcr_margin"`
+	// MinSpeech is the min length of a chunk; shorter speech is ignored
+	MinSpeech int64 `json:"min_speech"`
+	// MinSilence is the min length of a silence between chunks;
+	// chunks separated by shorter silences are merged
+	MinSilence int64 `json:"min_silence"`
+	// MaxChunk is the max length of a chunk (not counting padding).
+	// Longer chunks are split at the frame with the lowest energy. 0
+	// means no limit.
+	MaxChunk int64 `json:"max_chunk"`
+	// Padding is added before and after each chunk, within the silence
+	// around the chunk
+	Padding int64 `json:"padding"`
+}
+
+// DefaultVADConfig is an adaptive config for speech recordings
+var DefaultVADConfig = VADConfig{
+	FrameLen:     10,
+	Threshold:    12,
+	Adaptive:     true,
+	ZCRThreshold: 0.25,
+	ZCRMargin:    10,
+	MinSpeech:    250,
+	MinSilence:   500,
+	MaxChunk:     30000,
+	Padding:      200,
+}
+
+// VAD is an energy and zero-crossing rate based voice activity
+// detector, working on decoded PCM (audio other than PCM WAV is
+// converted using ffmpeg).
+// For initialization, use NewVAD().
+type VAD struct {
+	Config VADConfig
+}
+
+// NewVAD creates a new VAD, after first checking the config
+func NewVAD(config VADConfig) (VAD, error) {
+	if config.FrameLen <= 0 {
+		return VAD{}, fmt.Errorf("invalid frame length: %d", config.FrameLen)
+	}
+	if config.MinSpeech < 0 || config.MinSilence < 0 || config.MaxChunk < 0 || config.Padding < 0 {
+		return VAD{}, fmt.Errorf("negative duration in VAD config: %#v", config)
+	}
+	if config.MaxChunk > 0 && config.MaxChunk < config.FrameLen {
+		return VAD{}, fmt.Errorf("max chunk length %d is shorter than frame length %d", config.MaxChunk, config.FrameLen)
+	}
+	return VAD{Config: config}, nil
+}
+
+// NewDefaultVAD creates a new VAD with the default config
+func NewDefaultVAD() (VAD, error) {
+	return NewVAD(DefaultVADConfig)
+}
+
+// minDB is the energy of digital silence
+const minDB = -100
+
+// frameFeatures holds the energy (dB) and zero-crossing rate of each frame
+type frameFeatures struct {
+	energy []float64
+	zcr    []float64
+}
+
+// analyse reads at most maxFrames frames (all frames if maxFrames < 0)
+// of a channel of r (1 for the first channel), or of all channels mixed
+// down to one channel if channel is 0
+func (v VAD) analyse(r *wav.Reader, channel int, maxFrames int) (frameFeatures, error) {
+	res := frameFeatures{}
+	if channel < 0 || channel > int(r.Channels) {
+		return res, fmt.Errorf("invalid channel %d for audio with %d channels", channel, r.Channels)
+	}
+	frameSamples := int(int64(r.SampleRate) * v.Config.FrameLen / 1000)
+	if frameSamples < 1 {
+		return res, fmt.Errorf("frame length %d ms too short for sample rate %d", v.Config.FrameLen, r.SampleRate)
+	}
+	buf := make([][]float32, r.Channels)
+	for c := range buf {
+		buf[c] = make([]float32, frameSamples)
+	}
+	for maxFrames < 0 || len(res.energy) < maxFrames {
+		n, err := r.ReadFrames(buf)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return res, err
+		}
+		var sum float64
+		crossings := 0
+		var prev float32
+		for i := 0; i < n; i++ {
+			var s float32
+			if channel > 0 {
+				s = buf[channel-1][i]
+			} else {
+				for c := range buf {
+					s += buf[c][i]
+				}
+				s /= float32(len(buf))
+			}
+			sum += float64(s) * float64(s)
+			if i > 0 && (s >= 0) != (prev >= 0) {
+				crossings++
+			}
+			prev = s
+		}
+		db := float64(minDB)
+		if sum > 0 {
+			db = math.Max(minDB, 10*math.Log10(sum/float64(n)))
+		}
+		res.energy = append(res.energy, db)
+		res.zcr = append(res.zcr, float64(crossings)/float64(n))
+	}
+	return res, nil
+}
+
+// noiseFloor returns the 10th percentile of the frame energies
+func noiseFloor(energy []float64) float64 {
+	if len(energy) == 0 {
+		return minDB
+	}
+	sorted := append([]float64{}, energy...)
+	sort.Float64s(sorted)
+	return sorted[len(sorted)/10]
+}
+
+// span is a range of frames, end exclusive
+type span struct {
+	start, end int
+}
+
+func (v VAD) frames(ms int64) int {
+	return int((ms + v.Config.FrameLen - 1) / v.Config.FrameLen)
+}
+
+// spans returns the speech spans of the frames
+func (v VAD) spans(f frameFeatures) []span {
+	threshold := v.Config.Threshold
+	if v.Config.Adaptive {
+		threshold += noiseFloor(f.energy)
+	}
+	speech := func(i int) bool {
+		if f.energy[i] > threshold {
+			return true
+		}
+		return v.Config.ZCRThreshold > 0 && f.zcr[i] > v.Config.ZCRThreshold && f.energy[i] > threshold-v.Config.ZCRMargin
+	}
+
+	// speech frames, merged over short silences
+	minSilence := v.frames(v.Config.MinSilence)
+	spans := []span{}
+	for i := 0; i < len(f.energy); i++ {
+		if !speech(i) {
+			continue
+		}
+		if n := len(spans); n > 0 && i-spans[n-1].end < minSilence {
+			spans[n-1].end = i + 1
+		} else {
+			spans = append(spans, span{start: i, end: i + 1})
+		}
+	}
+
+	// short speech is ignored
+	minSpeech := v.frames(v.Config.MinSpeech)
+	res := []span{}
+	for _, s := range spans {
+		if s.end-s.start < minSpeech {
+			continue
+		}
+		res = append(res, v.split(s, f.energy, minSpeech)...)
+	}
+	return res
+}
+
+// split splits a span longer than MaxChunk at the frames with the lowest
+// energy, from left to right, keeping parts of at least minSpeech frames
+// if possible
+func (v VAD) split(s span, energy []float64, minSpeech int) []span {
+	if v.Config.MaxChunk == 0 {
+		return []span{s}
+	}
+	maxFrames := int(v.Config.MaxChunk / v.Config.FrameLen)
+	if minSpeech < 1 {
+		minSpeech = 1
+	}
+	res := []span{}
+	for s.end-s.start > maxFrames {
+		from := s.start + minSpeech
+		to := s.start + maxFrames
+		if to > s.end-minSpeech {
+			to = s.end - minSpeech
+		}
+		cut := s.start + maxFrames
+		if from < to {
+			cut = from
+			for i := from; i <= to; i++ {
+				if energy[i] < energy[cut] {
+					cut = i
+				}
+			}
+		}
+		res = append(res, span{start: s.start, end: cut})
+		s.start = cut
+	}
+	return append(res, s)
+}
+
+// chunks converts the spans to time chunks within [start, end], with
+// padding. Padding never makes chunks overlap.
+func (v VAD) chunks(spans []span, start, end int64) []protocol.Chunk {
+	res := []protocol.Chunk{}
+	for i, s := range spans {
+		chStart := start + int64(s.start)*v.Config.FrameLen
+		chEnd := start + int64(s.end)*v.Config.FrameLen
+		lower, upper := start, end
+		if i > 0 {
+			prevEnd := start + int64(spans[i-1].end)*v.Config.FrameLen
+			lower = (prevEnd + chStart) / 2
+		}
+		if i < len(spans)-1 {
+			nextStart := start + int64(spans[i+1].start)*v.Config.FrameLen
+			upper = (chEnd + nextStart) / 2
+		}
+		res = append(res, protocol.Chunk{
+			Start: max64(lower, chStart-v.Config.Padding),
+			End:   min64(upper, chEnd+v.Config.Padding),
+		})
+	}
+	return res
+}
+
+func max64(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func min64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// Process splits the audio of r into chunks
+func (v VAD) Process(r *wav.Reader) ([]protocol.Chunk, error) {
+	return v.ProcessChannel(r, 0)
+}
+
+// ProcessChannel splits a channel (1 for the first channel) of the
+// audio of r into chunks. Channel 0 processes all channels, mixed.
+func (v VAD) ProcessChannel(r *wav.Reader, channel int) ([]protocol.Chunk, error) {
+	f, err := v.analyse(r, channel, -1)
+	if err != nil {
+		return nil, err
+	}
+	return v.chunks(v.spans(f), 0, r.Duration()), nil
+}
+
+// ProcessFile splits the audioFile into chunks
+func (v VAD) ProcessFile(audioFile string) ([]protocol.Chunk, error) {
+	return v.ProcessChannelFile(audioFile, 0)
+}
+
+// ProcessChannelFile splits a channel of the audioFile into chunks (see
+// ProcessChannel)
+func (v VAD) ProcessChannelFile(audioFile string, channel int) ([]protocol.Chunk, error) {
+	r, closeWAV, err := ffmpeg.OpenWAV(audioFile)
+	if err != nil {
+		return nil, err
+	}
+	defer closeWAV()
+	return v.ProcessChannel(r, channel)
+}
+
+// ProcessChunk splits the part of the audioFile within chunk. Only the
+// audio of the chunk is analysed.
+func (v VAD) ProcessChunk(audioFile string, chunk protocol.Chunk) ([]protocol.Chunk, error) {
+	return v.ProcessChannelChunk(audioFile, 0, chunk)
+}
+
+// ProcessChannelChunk splits the part of a channel of the audioFile
+// within chunk (see ProcessChannel and ProcessChunk)
+func (v VAD) ProcessChannelChunk(audioFile string, channel int, chunk protocol.Chunk) ([]protocol.Chunk, error) {
+	if chunk.Start > chunk.End {
+		return nil, fmt.Errorf("cannot process input chunk with negative duration: %v-%v", chunk.Start, chunk.End)
+	}
+	r, closeWAV, err := ffmpeg.OpenWAV(audioFile)
+	if err != nil {
+		return nil, err
+	}
+	defer closeWAV()
+	if err := r.SeekTime(chunk.Start); err != nil {
+		return nil, err
+	}
+	f, err := v.analyse(r, channel, int((chunk.End-chunk.Start)/v.Config.FrameLen))
+	if err != nil {
+		return nil, err
+	}
+	end := min64(chunk.End, r.Duration())
+	return v.chunks(v.spans(f), chunk.Start, end), nil
+}
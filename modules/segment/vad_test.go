@@ -0,0 +1,264 @@
+package segment
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"math/rand"
+	"os"
+	"path"
+	"path/filepath"
+	"testing"
+
+	"github.com/stts-se/transtool-open/modules/wav"
+	"github.com/stts-se/transtool-open/protocol"
+)
+
+const testSampleRate = 16000
+
+// part is a part of a test signal: a 200 Hz tone with the given
+// amplitude, or noise if tone is false
+type part struct {
+	ms        int
+	tone      bool
+	amplitude float64
+}
+
+// testWAV writes a 16 bit WAV file with low level noise and the tones
+// of the parts, one list of parts per channel (of the same total length)
+func testWAV(t *testing.T, channels ...[]part) string {
+	rnd := rand.New(rand.NewSource(1))
+	samples := make([][]float64, len(channels))
+	for c, parts := range channels {
+		for _, p := range parts {
+			for n := 0; n < p.ms*testSampleRate/1000; n++ {
+				v := 0.001 * (rnd.Float64()*2 - 1)
+				if p.tone {
+					i := len(samples[c])
+					v += p.amplitude * math.Sin(2*math.Pi*200*float64(i)/testSampleRate)
+				}
+				samples[c] = append(samples[c], v)
+			}
+		}
+	}
+	var data bytes.Buffer
+	for i := range samples[0] {
+		for c := range samples {
+			binary.Write(&data, binary.LittleEndian, int16(samples[c][i]*32767))
+		}
+	}
+	n := uint16(len(channels))
+	format := wav.Format{AudioFormat: wav.FormatPCM, Channels: n, SampleRate: testSampleRate, ByteRate: testSampleRate * 2 * uint32(n), BlockAlign: 2 * n, BitsPerSample: 16}
+	var buf bytes.Buffer
+	wav.WriteHeader(&buf, format, int64(data.Len()))
+	buf.Write(data.Bytes())
+	fName := filepath.Join(t.TempDir(), "test.wav")
+	if err := os.WriteFile(fName, buf.Bytes(), 0644); err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	return fName
+}
+
+func TestVAD(t *testing.T) {
+	fName := testWAV(t, []part{
+		{ms: 1000},
+		{ms: 1000, tone: true, amplitude: 0.5},
+		{ms: 300}, // short silence: merged
+		{ms: 700, tone: true, amplitude: 0.5},
+		{ms: 2000},
+		{ms: 100, tone: true, amplitude: 0.5}, // short speech: ignored
+		{ms: 1000},
+		{ms: 1000, tone: true, amplitude: 0.5},
+		{ms: 300},
+	})
+	vad, err := NewDefaultVAD()
+	if err != nil {
+		t.Fatalf("got error from NewDefaultVAD: %v", err)
+	}
+
+	got, err := vad.ProcessFile(fName)
+	if err != nil {
+		t.Fatalf("got error from VAD.ProcessFile: %v", err)
+	}
+	exp := []protocol.Chunk{
+		{Start: 800, End: 3200},
+		{Start: 5900, End: 7300},
+	}
+	if len(got) != len(exp) {
+		t.Fatalf("expected %v, got %v", exp, got)
+	}
+	for i, exp0 := range exp {
+		if got[i] != exp0 {
+			t.Errorf("expected %v, got %v", exp0, got[i])
+		}
+	}
+
+	got, err = vad.ProcessChunk(fName, protocol.Chunk{Start: 5000, End: 7100})
+	if err != nil {
+		t.Fatalf("got error from VAD.ProcessChunk: %v", err)
+	}
+	exp = []protocol.Chunk{
+		{Start: 5900, End: 7100}, // limited by the end of the input chunk
+	}
+	if len(got) != len(exp) {
+		t.Fatalf("expected %v, got %v", exp, got)
+	}
+	for i, exp0 := range exp {
+		if got[i] != exp0 {
+			t.Errorf("expected %v, got %v", exp0, got[i])
+		}
+	}
+}
+
+func TestVADAbsoluteThreshold(t *testing.T) {
+	fName := testWAV(t, []part{
+		{ms: 1000},
+		{ms: 1000, tone: true, amplitude: 0.5}, // about -9 dB
+		{ms: 1000},
+		{ms: 1000, tone: true, amplitude: 0.02}, // about -37 dB
+		{ms: 1000},
+	})
+	config := DefaultVADConfig
+	config.Adaptive = false
+	config.Threshold = -20
+	config.Padding = 0
+	vad, err := NewVAD(config)
+	if err != nil {
+		t.Fatalf("got error from NewVAD: %v", err)
+	}
+	got, err := vad.ProcessFile(fName)
+	if err != nil {
+		t.Fatalf("got error from VAD.ProcessFile: %v", err)
+	}
+	exp := []protocol.Chunk{{Start: 1000, End: 2000}}
+	if len(got) != len(exp) || got[0] != exp[0] {
+		t.Errorf("expected %v, got %v", exp, got)
+	}
+}
+
+func TestVADMaxChunk(t *testing.T) {
+	fName := testWAV(t, []part{
+		{ms: 500},
+		{ms: 1800, tone: true, amplitude: 0.5},
+		{ms: 100, tone: true, amplitude: 0.05}, // lowest energy
+		{ms: 1100, tone: true, amplitude: 0.5},
+		{ms: 500},
+	})
+	config := DefaultVADConfig
+	config.MaxChunk = 2500
+	config.Padding = 0
+	vad, err := NewVAD(config)
+	if err != nil {
+		t.Fatalf("got error from NewVAD: %v", err)
+	}
+	got, err := vad.ProcessFile(fName)
+	if err != nil {
+		t.Fatalf("got error from VAD.ProcessFile: %v", err)
+	}
+	if len(got) != 2 {
+		t.Fatalf("expected 2 chunks, got %v", got)
+	}
+	if got[0].Start != 500 || got[1].End != 3500 || got[0].End != got[1].Start {
+		t.Errorf("expected chunks from 500 to 3500, got %v", got)
+	}
+	if got[0].End < 2300 || got[0].End > 2400 {
+		t.Errorf("expected split between 2300 and 2400, got %v", got)
+	}
+}
+
+func TestVADFile(t *testing.T) {
+	vad, err := NewDefaultVAD()
+	if err != nil {
+		t.Fatalf("got error from NewDefaultVAD: %v", err)
+	}
+	got, err := vad.ProcessFile(path.Join("../test_data", "three_sentences.wav"))
+	if err != nil {
+		t.Fatalf("got error from VAD.ProcessFile: %v", err)
+	}
+	// close to the ffmpeg chunker (see ffmpeg.TestChunkerMP3), with
+	// somewhat more padding at the end of the chunks
+	exp := []protocol.Chunk{
+		{Start: 0, End: 2200},
+		{Start: 2460, End: 4480},
+		{Start: 4990, End: 8140},
+	}
+	if len(got) != len(exp) {
+		t.Fatalf("expected %v, got %v", exp, got)
+	}
+	for i, exp0 := range exp {
+		if math.Abs(float64(got[i].Start-exp0.Start)) > 20 || math.Abs(float64(got[i].End-exp0.End)) > 20 {
+			t.Errorf("expected %v +-20, got %v", exp0, got[i])
+		}
+	}
+}
+
+func TestChannelChunks(t *testing.T) {
+	fName := testWAV(t,
+		[]part{{ms: 1000}, {ms: 1000, tone: true, amplitude: 0.5}, {ms: 3000}},
+		[]part{{ms: 1500}, {ms: 2000, tone: true, amplitude: 0.5}, {ms: 1500}},
+	)
+	if n, err := Channels(fName); err != nil || n != 2 {
+		t.Fatalf("expected 2 channels, got %d (%v)", n, err)
+	}
+	config := DefaultVADConfig
+	config.Padding = 0
+	vad, err := NewVAD(config)
+	if err != nil {
+		t.Fatalf("got error from NewVAD: %v", err)
+	}
+	labels := []string{"#AGENT", "#CUSTOMER"}
+	label := func(channel int) string { return labels[channel-1] }
+
+	got, err := ChannelChunks(vad, fName, protocol.Chunk{Start: 0, End: 5000}, 2, label)
+	if err != nil {
+		t.Fatalf("got error from ChannelChunks: %v", err)
+	}
+	exp := []protocol.TransChunk{
+		{Chunk: protocol.Chunk{Start: 1000, End: 2000}, Speaker: "#AGENT", Channel: 1},
+		{Chunk: protocol.Chunk{Start: 1500, End: 3500}, Speaker: "#CUSTOMER", Channel: 2},
+	}
+	if len(got) != len(exp) {
+		t.Fatalf("expected %v, got %v", exp, got)
+	}
+	for i, exp0 := range exp {
+		if got[i].Chunk != exp0.Chunk || got[i].Speaker != exp0.Speaker || got[i].Trans != "" || got[i].Channel != exp0.Channel || got[i].Tier != ChannelTier(exp0.Channel) {
+			t.Errorf("expected %v, got %v", exp0, got[i])
+		}
+		if got[i].UUID == "" || got[i].CurrentStatus.Name != "unchecked" {
+			t.Errorf("expected uuid and status unchecked, got %v", got[i])
+		}
+	}
+
+	tiers := ChannelTiers(2, label)
+	if len(tiers) != 2 {
+		t.Fatalf("expected 2 tiers, got %v", tiers)
+	}
+	if w, g := (protocol.Tier{Name: "channel2", Speaker: "#CUSTOMER", Channel: 2}), tiers[1]; w != g {
+		t.Errorf("expected %v, got %v", w, g)
+	}
+
+	// mixed channels
+	got, err = ChannelChunks(vad, fName, protocol.Chunk{Start: 0, End: 5000}, 0, label)
+	if err != nil {
+		t.Fatalf("got error from ChannelChunks: %v", err)
+	}
+	if len(got) != 1 || got[0].Chunk != (protocol.Chunk{Start: 1000, End: 3500}) || got[0].Channel != 0 || got[0].Speaker != "" || got[0].Tier != "" {
+		t.Errorf("expected one chunk without channel from 1000 to 3500, got %v", got)
+	}
+
+	if _, err := vad.ProcessChannelFile(fName, 3); err == nil {
+		t.Errorf("expected error for channel 3, got nil")
+	}
+}
+
+func TestNewVAD(t *testing.T) {
+	for _, config := range []VADConfig{
+		{},
+		{FrameLen: 10, Padding: -1},
+		{FrameLen: 10, MaxChunk: 5},
+	} {
+		if _, err := NewVAD(config); err == nil {
+			t.Errorf("expected error for %#v, got nil", config)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package wav
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+// Reader reads the samples of a WAV file, as float32 values between -1
+// and 1. For initialization, use NewReader().
+type Reader struct {
+	Header
+	r         io.ReadSeeker
+	remaining int64 // frames left to read
+	buf       []byte
+}
+
+// NewReader reads the header of a WAV file, and returns a reader
+// positioned at the start of the audio data
+func NewReader(r io.ReadSeeker) (*Reader, error) {
+	h, err := ReadHeader(r)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case h.AudioFormat == FormatPCM && (h.BitsPerSample == 8 || h.BitsPerSample == 16 || h.BitsPerSample == 24 || h.BitsPerSample == 32):
+	case h.AudioFormat == FormatIEEEFloat && (h.BitsPerSample == 32 || h.BitsPerSample == 64):
+	default:
+		return nil, fmt.Errorf("%d bit samples in audio format %d : %w", h.BitsPerSample, h.AudioFormat, ErrUnsupported)
+	}
+	if int(h.BlockAlign) != int(h.Channels)*int(h.BitsPerSample)/8 || h.Channels == 0 {
+		return nil, fmt.Errorf("invalid block align %d for %d channels : %w", h.BlockAlign, h.Channels, ErrUnsupported)
+	}
+	if _, err := r.Seek(h.DataOffset, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("failed to seek : %v", err)
+	}
+	return &Reader{Header: h, r: r, remaining: h.Frames()}, nil
+}
+
+// SeekTime positions the reader at the given time (in milliseconds)
+// of the audio data
+func (r *Reader) SeekTime(ms int64) error {
+	frame := r.frame(ms)
+	if _, err := r.r.Seek(r.DataOffset+frame*int64(r.BlockAlign), io.SeekStart); err != nil {
+		return fmt.Errorf("failed to seek : %v", err)
+	}
+	r.remaining = r.Frames() - frame
+	return nil
+}
+
+// ReadFrames reads up to len(frames[0]) sample frames into frames, one
+// slice per channel, and returns the number of frames read. At the end
+// of the audio data, ReadFrames returns 0 and io.EOF.
+func (r *Reader) ReadFrames(frames [][]float32) (int, error) {
+	if len(frames) != int(r.Channels) {
+		return 0, fmt.Errorf("expected %d channels, got %d", r.Channels, len(frames))
+	}
+	n := int64(len(frames[0]))
+	if n > r.remaining {
+		n = r.remaining
+	}
+	if n == 0 {
+		return 0, io.EOF
+	}
+	size := int(n) * int(r.BlockAlign)
+	if len(r.buf) < size {
+		r.buf = make([]byte, size)
+	}
+	bts := r.buf[:size]
+	if _, err := io.ReadFull(r.r, bts); err != nil {
+		return 0, fmt.Errorf("failed to read audio data : %v", err)
+	}
+	r.remaining -= n
+
+	width := int(r.BitsPerSample) / 8
+	for i := 0; i < int(n); i++ {
+		for c := 0; c < int(r.Channels); c++ {
+			p := bts[(i*int(r.Channels)+c)*width:]
+			frames[c][i] = r.sample(p)
+		}
+	}
+	return int(n), nil
+}
+
+func (r *Reader) sample(p []byte) float32 {
+	if r.AudioFormat == FormatIEEEFloat {
+		if r.BitsPerSample == 64 {
+			return float32(math.Float64frombits(binary.LittleEndian.Uint64(p)))
+		}
+		return math.Float32frombits(binary.LittleEndian.Uint32(p))
+	}
+	switch r.BitsPerSample {
+	case 8:
+		// 8 bit samples are unsigned
+		return float32(int(p[0])-128) / 128
+	case 16:
+		return float32(int16(binary.LittleEndian.Uint16(p))) / 32768
+	case 24:
+		v := int32(uint32(p[0])<<8|uint32(p[1])<<16|uint32(p[2])<<24) >> 8
+		return float32(v) / 8388608
+	}
+	return float32(int32(binary.LittleEndian.Uint32(p))) / 2147483648
+}
+
+// readAllBlock is the number of frames read at a time by ReadAll
+const readAllBlock = 65536
+
+// ReadAll reads the remaining samples, one slice per channel
+func (r *Reader) ReadAll() ([][]float32, error) {
+	res := make([][]float32, r.Channels)
+	for c := range res {
+		res[c] = make([]float32, r.remaining)
+	}
+	read := 0
+	for {
+		end := read + readAllBlock
+		if end > len(res[0]) {
+			end = len(res[0])
+		}
+		frames := make([][]float32, r.Channels)
+		for c := range frames {
+			frames[c] = res[c][read:end]
+		}
+		if len(frames[0]) == 0 {
+			return res, nil
+		}
+		n, err := r.ReadFrames(frames)
+		if err == io.EOF {
+			return res, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		read += n
+	}
+}
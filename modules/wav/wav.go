@@ -0,0 +1,283 @@
+// Package wav reads and writes WAV files with PCM or IEEE float
+// samples, and extracts time chunks without decoding the audio.
+package wav
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/stts-se/transtool-open/protocol"
+)
+
+// Audio formats
+const (
+	FormatPCM        = 1
+	FormatIEEEFloat  = 3
+	FormatExtensible = 0xFFFE
+)
+
+// ErrUnsupported is returned for files that are not WAV files, or WAV
+// files with compressed audio
+var ErrUnsupported = errors.New("unsupported wav file")
+
+// Format is the audio format of a WAV file (the fmt chunk)
+type Format struct {
+	AudioFormat   uint16
+	Channels      uint16
+	SampleRate    uint32
+	ByteRate      uint32
+	BlockAlign    uint16
+	BitsPerSample uint16
+}
+
+// Header holds the format of a WAV file, and the position and size of
+// the audio data
+type Header struct {
+	Format
+	DataOffset int64
+	DataSize   int64
+}
+
+// Frames returns the number of sample frames in the audio data
+func (h Header) Frames() int64 {
+	if h.BlockAlign == 0 {
+		return 0
+	}
+	return h.DataSize / int64(h.BlockAlign)
+}
+
+// Duration returns the duration of the audio data in milliseconds
+func (h Header) Duration() int64 {
+	if h.SampleRate == 0 {
+		return 0
+	}
+	return h.Frames() * 1000 / int64(h.SampleRate)
+}
+
+// frame returns the sample frame at the given time (in milliseconds),
+// within the audio data
+func (h Header) frame(ms int64) int64 {
+	f := ms * int64(h.SampleRate) / 1000
+	if f < 0 {
+		return 0
+	}
+	if n := h.Frames(); f > n {
+		return n
+	}
+	return f
+}
+
+// ReadHeader reads the header of a WAV file, up to the start of the
+// audio data. If the data size is missing or too large (as for streamed
+// files), the data size is set to the rest of the file.
+func ReadHeader(r io.ReadSeeker) (Header, error) {
+	var res Header
+	var riff [12]byte
+	if _, err := io.ReadFull(r, riff[:]); err != nil {
+		return res, ErrUnsupported
+	}
+	if string(riff[0:4]) != "RIFF" || string(riff[8:12]) != "WAVE" {
+		return res, ErrUnsupported
+	}
+	fileSize, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return res, fmt.Errorf("failed to seek : %v", err)
+	}
+	pos := int64(12)
+	seenFmt := false
+	for {
+		if _, err := r.Seek(pos, io.SeekStart); err != nil {
+			return res, fmt.Errorf("failed to seek : %v", err)
+		}
+		var ch [8]byte
+		if _, err := io.ReadFull(r, ch[:]); err != nil {
+			return res, fmt.Errorf("no data chunk : %w", ErrUnsupported)
+		}
+		id := string(ch[0:4])
+		size := int64(binary.LittleEndian.Uint32(ch[4:8]))
+		pos += 8
+		switch id {
+		case "fmt ":
+			if size < 16 {
+				return res, fmt.Errorf("invalid fmt chunk : %w", ErrUnsupported)
+			}
+			bts := make([]byte, size)
+			if _, err := io.ReadFull(r, bts); err != nil {
+				return res, fmt.Errorf("failed to read fmt chunk : %v", err)
+			}
+			if err := binary.Read(bytes.NewReader(bts), binary.LittleEndian, &res.Format); err != nil {
+				return res, fmt.Errorf("failed to read fmt chunk : %v", err)
+			}
+			if res.AudioFormat == FormatExtensible && size >= 40 {
+				// the sub format GUID starts with the audio format
+				res.AudioFormat = binary.LittleEndian.Uint16(bts[24:26])
+			}
+			if res.AudioFormat != FormatPCM && res.AudioFormat != FormatIEEEFloat {
+				return res, fmt.Errorf("audio format %d : %w", res.AudioFormat, ErrUnsupported)
+			}
+			if res.BlockAlign == 0 || res.SampleRate == 0 {
+				return res, fmt.Errorf("invalid fmt chunk : %w", ErrUnsupported)
+			}
+			seenFmt = true
+		case "data":
+			if !seenFmt {
+				return res, fmt.Errorf("no fmt chunk before data : %w", ErrUnsupported)
+			}
+			res.DataOffset = pos
+			res.DataSize = size
+			if size == 0 || pos+size > fileSize {
+				res.DataSize = fileSize - pos
+			}
+			res.DataSize -= res.DataSize % int64(res.BlockAlign)
+			return res, nil
+		}
+		// chunks are padded to an even size
+		pos += size + size%2
+	}
+}
+
+// ReadHeaderFile reads the header of a WAV file (see ReadHeader)
+func ReadHeaderFile(fileName string) (Header, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return Header{}, err
+	}
+	defer f.Close()
+	return ReadHeader(f)
+}
+
+// WriteHeader writes a canonical 44 byte WAV header, for dataSize
+// bytes of audio data
+func WriteHeader(w io.Writer, format Format, dataSize int64) error {
+	if dataSize > 0xFFFFFFFF-36 {
+		return fmt.Errorf("data size too large for wav file: %d", dataSize)
+	}
+	var h [44]byte
+	copy(h[0:4], "RIFF")
+	binary.LittleEndian.PutUint32(h[4:8], uint32(36+dataSize))
+	copy(h[8:16], "WAVEfmt ")
+	binary.LittleEndian.PutUint32(h[16:20], 16)
+	binary.LittleEndian.PutUint16(h[20:22], format.AudioFormat)
+	binary.LittleEndian.PutUint16(h[22:24], format.Channels)
+	binary.LittleEndian.PutUint32(h[24:28], format.SampleRate)
+	binary.LittleEndian.PutUint32(h[28:32], format.ByteRate)
+	binary.LittleEndian.PutUint16(h[32:34], format.BlockAlign)
+	binary.LittleEndian.PutUint16(h[34:36], format.BitsPerSample)
+	copy(h[36:40], "data")
+	binary.LittleEndian.PutUint32(h[40:44], uint32(dataSize))
+	_, err := w.Write(h[:])
+	return err
+}
+
+// LocalFile returns the local file name of an audio file name or a
+// file:// URL, or false for other URLs
+func LocalFile(audioFile string) (string, bool) {
+	if strings.HasPrefix(audioFile, "file://") {
+		return strings.TrimPrefix(audioFile, "file://"), true
+	}
+	if strings.Contains(audioFile, "://") {
+		return "", false
+	}
+	return audioFile, true
+}
+
+// Extract writes the chunk (start and end in milliseconds) of a WAV
+// file to w, as a new WAV file. A chunk extending past the end of the
+// audio is truncated.
+func Extract(r io.ReadSeeker, chunk protocol.Chunk, w io.Writer) error {
+	return ExtractChannel(r, chunk, 0, w)
+}
+
+// extractBlock is the number of sample frames copied at a time when
+// extracting a single channel
+const extractBlock = 4096
+
+// ExtractChannel is like Extract, but if channel is not 0, only that
+// channel (1 for the first channel) is extracted, as a mono WAV file.
+// Channel 0 extracts all channels.
+func ExtractChannel(r io.ReadSeeker, chunk protocol.Chunk, channel int, w io.Writer) error {
+	if chunk.Start > chunk.End {
+		return fmt.Errorf("cannot process input chunk with negative duration: %v-%v", chunk.Start, chunk.End)
+	}
+	h, err := ReadHeader(r)
+	if err != nil {
+		return err
+	}
+	if channel < 0 || channel > int(h.Channels) {
+		return fmt.Errorf("invalid channel %d for audio with %d channels", channel, h.Channels)
+	}
+	startFrame := h.frame(chunk.Start)
+	endFrame := h.frame(chunk.End)
+	if _, err := r.Seek(h.DataOffset+startFrame*int64(h.BlockAlign), io.SeekStart); err != nil {
+		return fmt.Errorf("failed to seek : %v", err)
+	}
+	if channel == 0 || h.Channels == 1 {
+		size := (endFrame - startFrame) * int64(h.BlockAlign)
+		if err := WriteHeader(w, h.Format, size); err != nil {
+			return fmt.Errorf("failed to write header : %v", err)
+		}
+		if _, err := io.CopyN(w, r, size); err != nil {
+			return fmt.Errorf("failed to copy audio data : %v", err)
+		}
+		return nil
+	}
+
+	sampleSize := int(h.BlockAlign / h.Channels)
+	format := h.Format
+	format.Channels = 1
+	format.BlockAlign = uint16(sampleSize)
+	format.ByteRate = format.SampleRate * uint32(sampleSize)
+	if err := WriteHeader(w, format, (endFrame-startFrame)*int64(sampleSize)); err != nil {
+		return fmt.Errorf("failed to write header : %v", err)
+	}
+	offset := (channel - 1) * sampleSize
+	in := make([]byte, extractBlock*int(h.BlockAlign))
+	out := make([]byte, extractBlock*sampleSize)
+	for remaining := endFrame - startFrame; remaining > 0; {
+		n := int64(extractBlock)
+		if remaining < n {
+			n = remaining
+		}
+		if _, err := io.ReadFull(r, in[:n*int64(h.BlockAlign)]); err != nil {
+			return fmt.Errorf("failed to read audio data : %v", err)
+		}
+		for i := 0; i < int(n); i++ {
+			copy(out[i*sampleSize:(i+1)*sampleSize], in[i*int(h.BlockAlign)+offset:])
+		}
+		if _, err := w.Write(out[:n*int64(sampleSize)]); err != nil {
+			return fmt.Errorf("failed to write audio data : %v", err)
+		}
+		remaining -= n
+	}
+	return nil
+}
+
+// ExtractBytes returns the chunk of a channel of a WAV file as a new
+// WAV file (see ExtractChannel)
+func ExtractBytes(audioFile string, chunk protocol.Chunk, channel int) ([]byte, error) {
+	f, err := os.Open(audioFile)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var buf bytes.Buffer
+	if err := ExtractChannel(f, chunk, channel, &buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// ExtractFile writes the chunk of a channel of a WAV file to outFile
+// (see ExtractChannel)
+func ExtractFile(audioFile string, chunk protocol.Chunk, channel int, outFile string) error {
+	bts, err := ExtractBytes(audioFile, chunk, channel)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(outFile, bts, 0644)
+}
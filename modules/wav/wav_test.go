@@ -0,0 +1,185 @@
+package wav
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path"
+	"path/filepath"
+	"testing"
+
+	"github.com/stts-se/transtool-open/protocol"
+)
+
+func TestReadHeader(t *testing.T) {
+	h, err := ReadHeaderFile(path.Join("../test_data", "three_sentences.wav"))
+	if err != nil {
+		t.Fatalf("got error from ReadHeaderFile: %v", err)
+	}
+	if w, g := uint16(FormatPCM), h.AudioFormat; w != g {
+		t.Errorf("wanted %d got %d", w, g)
+	}
+	if w, g := uint32(44100), h.SampleRate; w != g {
+		t.Errorf("wanted %d got %d", w, g)
+	}
+	if w, g := int64(44), h.DataOffset; w != g {
+		t.Errorf("wanted %d got %d", w, g)
+	}
+	if w, g := int64(8265), h.Duration(); w != g {
+		t.Errorf("wanted %d got %d", w, g)
+	}
+
+	// mp3 data in a file with wav extension
+	_, err = ReadHeaderFile(path.Join("../test_data", "a_pause.wav"))
+	if !errors.Is(err, ErrUnsupported) {
+		t.Errorf("expected %v, got %v", ErrUnsupported, err)
+	}
+}
+
+// testWAV returns a stereo 16 bit WAV file at 1000 Hz, with an extra
+// chunk before the data chunk. Sample i is i in the first channel, and
+// -i in the second.
+func testWAV(frames int) []byte {
+	format := Format{AudioFormat: FormatPCM, Channels: 2, SampleRate: 1000, ByteRate: 4000, BlockAlign: 4, BitsPerSample: 16}
+	var data bytes.Buffer
+	for i := 0; i < frames; i++ {
+		j := -i
+		data.Write([]byte{byte(i), byte(i >> 8), byte(j), byte(j >> 8)})
+	}
+	var buf bytes.Buffer
+	WriteHeader(&buf, format, int64(data.Len()))
+	bts := buf.Bytes()
+	// insert a LIST chunk (odd size, padded) after the fmt chunk
+	list := []byte("LIST\x03\x00\x00\x00abc\x00")
+	res := append([]byte{}, bts[:36]...)
+	res = append(res, list...)
+	res = append(res, bts[36:]...)
+	return append(res, data.Bytes()...)
+}
+
+func TestExtract(t *testing.T) {
+	in := testWAV(2000)
+
+	var out bytes.Buffer
+	err := Extract(bytes.NewReader(in), protocol.Chunk{Start: 500, End: 750}, &out)
+	if err != nil {
+		t.Fatalf("got error from Extract: %v", err)
+	}
+	h, err := ReadHeader(bytes.NewReader(out.Bytes()))
+	if err != nil {
+		t.Fatalf("got error from ReadHeader: %v", err)
+	}
+	if w, g := int64(250), h.Frames(); w != g {
+		t.Errorf("wanted %d got %d", w, g)
+	}
+	if w, g := uint16(2), h.Channels; w != g {
+		t.Errorf("wanted %d got %d", w, g)
+	}
+	// the first sample of the chunk is sample 500
+	first := out.Bytes()[h.DataOffset:]
+	if w, g := 500, int(first[0])|int(first[1])<<8; w != g {
+		t.Errorf("wanted %d got %d", w, g)
+	}
+
+	// a chunk past the end is truncated
+	out.Reset()
+	err = Extract(bytes.NewReader(in), protocol.Chunk{Start: 1900, End: 2500}, &out)
+	if err != nil {
+		t.Fatalf("got error from Extract: %v", err)
+	}
+	h, _ = ReadHeader(bytes.NewReader(out.Bytes()))
+	if w, g := int64(100), h.Frames(); w != g {
+		t.Errorf("wanted %d got %d", w, g)
+	}
+
+	err = Extract(bytes.NewReader(in), protocol.Chunk{Start: 750, End: 500}, &out)
+	if err == nil {
+		t.Errorf("expected error, got nil")
+	}
+}
+
+func TestExtractChannel(t *testing.T) {
+	in := testWAV(10000)
+
+	var out bytes.Buffer
+	err := ExtractChannel(bytes.NewReader(in), protocol.Chunk{Start: 500, End: 5500}, 2, &out)
+	if err != nil {
+		t.Fatalf("got error from ExtractChannel: %v", err)
+	}
+	h, err := ReadHeader(bytes.NewReader(out.Bytes()))
+	if err != nil {
+		t.Fatalf("got error from ReadHeader: %v", err)
+	}
+	if w, g := uint16(1), h.Channels; w != g {
+		t.Errorf("wanted %d got %d", w, g)
+	}
+	if w, g := uint16(2), h.BlockAlign; w != g {
+		t.Errorf("wanted %d got %d", w, g)
+	}
+	if w, g := int64(5000), h.Frames(); w != g {
+		t.Errorf("wanted %d got %d", w, g)
+	}
+	// samples of the second channel, past the first block
+	data := out.Bytes()[h.DataOffset:]
+	for _, i := range []int{0, 4500} {
+		if w, g := int16(-(500 + i)), int16(uint16(data[2*i])|uint16(data[2*i+1])<<8); w != g {
+			t.Errorf("wanted %d got %d", w, g)
+		}
+	}
+
+	for _, channel := range []int{-1, 3} {
+		err = ExtractChannel(bytes.NewReader(in), protocol.Chunk{Start: 500, End: 750}, channel, &out)
+		if err == nil {
+			t.Errorf("expected error for channel %d, got nil", channel)
+		}
+	}
+}
+
+func TestExtractFile(t *testing.T) {
+	outFile := filepath.Join(t.TempDir(), "chunk.wav")
+	err := ExtractFile(path.Join("../test_data", "three_sentences.wav"), protocol.Chunk{Start: 1587, End: 3885}, 0, outFile)
+	if err != nil {
+		t.Fatalf("got error from ExtractFile: %v", err)
+	}
+	info, err := os.Stat(outFile)
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	// 44 byte header + 2298 ms of mono 16 bit audio at 44.1 kHz
+	if w, g := int64(44+101342*2), info.Size(); w != g {
+		t.Errorf("wanted %d got %d", w, g)
+	}
+}
+
+func TestLocalFile(t *testing.T) {
+	for in, exp := range map[string]string{
+		"audio/a.wav":         "audio/a.wav",
+		"file:///audio/a.wav": "/audio/a.wav",
+		"http://host/a.wav":   "",
+	} {
+		got, ok := LocalFile(in)
+		if got != exp || ok != (exp != "") {
+			t.Errorf("expected '%s', got '%s' (%v)", exp, got, ok)
+		}
+	}
+}
+
+func TestReader(t *testing.T) {
+	r, err := NewReader(bytes.NewReader(testWAV(100)))
+	if err != nil {
+		t.Fatalf("got error from NewReader: %v", err)
+	}
+	samples, err := r.ReadAll()
+	if err != nil {
+		t.Fatalf("got error from ReadAll: %v", err)
+	}
+	if w, g := 2, len(samples); w != g {
+		t.Fatalf("wanted %d got %d", w, g)
+	}
+	if w, g := 100, len(samples[1]); w != g {
+		t.Errorf("wanted %d got %d", w, g)
+	}
+	if w, g := float32(-42)/32768, samples[1][42]; w != g {
+		t.Errorf("wanted %v got %v", w, g)
+	}
+}
@@ -0,0 +1,139 @@
+// Package waveform computes waveform peaks and spectrograms of audio,
+// for display in the client.
+package waveform
+
+import (
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/stts-se/transtool-open/modules/wav"
+)
+
+// ZoomLevels are the supported numbers of samples per peak
+var ZoomLevels = []int{64, 256, 1024, 4096, 16384}
+
+// ValidZoomLevel returns true if samplesPerPixel is one of ZoomLevels
+func ValidZoomLevel(samplesPerPixel int) bool {
+	for _, z := range ZoomLevels {
+		if z == samplesPerPixel {
+			return true
+		}
+	}
+	return false
+}
+
+// Peaks holds the min and max sample value per SamplesPerPixel samples
+// of audio (mixed down to one channel). The format is that of the
+// audiowaveform JSON format (version 2), with 16 bit values, so that
+// the data can be used as peaks for wavesurfer.js after dividing by
+// 32768.
+type Peaks struct {
+	Version         int     `json:"version"`
+	Channels        int     `json:"channels"`
+	SampleRate      int     `json:"sample_rate"`
+	SamplesPerPixel int     `json:"samples_per_pixel"`
+	Bits            int     `json:"bits"`
+	Length          int     `json:"length"` // number of min/max pairs
+	Data            []int16 `json:"data"`   // min, max, min, max, ...
+}
+
+func newPeaks(sampleRate, samplesPerPixel int) *Peaks {
+	return &Peaks{
+		Version:         2,
+		Channels:        1,
+		SampleRate:      sampleRate,
+		SamplesPerPixel: samplesPerPixel,
+		Bits:            16,
+		Data:            []int16{},
+	}
+}
+
+func toInt16(v float32) int16 {
+	return int16(math.Max(-32768, math.Min(32767, math.Round(float64(v)*32768))))
+}
+
+// peakAccumulator computes the peaks of one zoom level
+type peakAccumulator struct {
+	peaks    *Peaks
+	min, max float32
+	n        int
+}
+
+func (a *peakAccumulator) add(v float32) {
+	if a.n == 0 || v < a.min {
+		a.min = v
+	}
+	if a.n == 0 || v > a.max {
+		a.max = v
+	}
+	a.n++
+	if a.n == a.peaks.SamplesPerPixel {
+		a.flush()
+	}
+}
+
+func (a *peakAccumulator) flush() {
+	if a.n == 0 {
+		return
+	}
+	a.peaks.Data = append(a.peaks.Data, toInt16(a.min), toInt16(a.max))
+	a.peaks.Length++
+	a.n = 0
+}
+
+// readBlock is the number of frames read at a time
+const readBlock = 8192
+
+// ComputePeaks reads the audio of r, and returns the peaks of each zoom
+// level (in the same order as zoomLevels). The audio is read once, and
+// is never kept in memory, so that the peaks of long recordings can be
+// computed.
+func ComputePeaks(r *wav.Reader, zoomLevels []int) ([]Peaks, error) {
+	accs := []*peakAccumulator{}
+	for _, z := range zoomLevels {
+		if z <= 0 {
+			return nil, fmt.Errorf("invalid zoom level: %d", z)
+		}
+		accs = append(accs, &peakAccumulator{peaks: newPeaks(int(r.SampleRate), z)})
+	}
+	err := readMono(r, func(v float32) {
+		for _, a := range accs {
+			a.add(v)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	res := []Peaks{}
+	for _, a := range accs {
+		a.flush()
+		res = append(res, *a.peaks)
+	}
+	return res, nil
+}
+
+// readMono reads the audio of r, and calls f for each sample, mixed
+// down to one channel
+func readMono(r *wav.Reader, f func(float32)) error {
+	frames := make([][]float32, r.Channels)
+	for c := range frames {
+		frames[c] = make([]float32, readBlock)
+	}
+	for {
+		n, err := r.ReadFrames(frames)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		for i := 0; i < n; i++ {
+			var v float32
+			for c := range frames {
+				v += frames[c][i]
+			}
+			f(v / float32(len(frames)))
+		}
+	}
+}
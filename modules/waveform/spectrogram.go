@@ -0,0 +1,204 @@
+package waveform
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"math"
+	"math/cmplx"
+
+	"github.com/stts-se/transtool-open/modules/wav"
+)
+
+// SpectrogramOptions are the analysis parameters of a spectrogram
+type SpectrogramOptions struct {
+	WindowMs int // analysis window length
+	HopMs    int // time between frames
+	Bands    int // number of mel bands
+	MaxFreq  int // upper frequency of the mel bands (Hz), limited by the Nyquist frequency
+}
+
+// DefaultSpectrogramOptions are the options used for speech
+var DefaultSpectrogramOptions = SpectrogramOptions{WindowMs: 25, HopMs: 10, Bands: 80, MaxFreq: 8000}
+
+// Spectrogram is a log-mel spectrogram: the energy in dB of each mel
+// band, per frame of HopMs milliseconds
+type Spectrogram struct {
+	SampleRate int `json:"sample_rate"`
+	HopMs      int `json:"hop_ms"`
+	Bands      int `json:"bands"`
+	MaxFreq    int `json:"max_freq"`
+	Frames     int `json:"frames"`
+	// Data holds Bands values per frame, the lowest band first
+	Data []float32 `json:"data"`
+}
+
+// minDB is the energy of silence
+const minDB = -100
+
+func hzToMel(hz float64) float64 {
+	return 2595 * math.Log10(1+hz/700)
+}
+
+func melToHz(mel float64) float64 {
+	return 700 * (math.Pow(10, mel/2595) - 1)
+}
+
+// melFilters returns triangular filters over the FFT bins, one per band
+func melFilters(bands, fftSize, sampleRate int, maxFreq float64) [][]float64 {
+	bins := fftSize/2 + 1
+	maxMel := hzToMel(maxFreq)
+	// band edges, in FFT bins
+	edges := make([]float64, bands+2)
+	for i := range edges {
+		hz := melToHz(maxMel * float64(i) / float64(bands+1))
+		edges[i] = hz * float64(fftSize) / float64(sampleRate)
+	}
+	res := make([][]float64, bands)
+	for b := 0; b < bands; b++ {
+		res[b] = make([]float64, bins)
+		lo, mid, hi := edges[b], edges[b+1], edges[b+2]
+		for k := 0; k < bins; k++ {
+			f := float64(k)
+			switch {
+			case f > lo && f <= mid:
+				res[b][k] = (f - lo) / (mid - lo)
+			case f > mid && f < hi:
+				res[b][k] = (hi - f) / (hi - mid)
+			}
+		}
+	}
+	return res
+}
+
+// fft is an in-place radix-2 FFT. The length of x must be a power of 2.
+func fft(x []complex128) {
+	n := len(x)
+	for i, j := 1, 0; i < n; i++ {
+		bit := n >> 1
+		for ; j&bit != 0; bit >>= 1 {
+			j ^= bit
+		}
+		j ^= bit
+		if i < j {
+			x[i], x[j] = x[j], x[i]
+		}
+	}
+	for size := 2; size <= n; size <<= 1 {
+		w := cmplx.Exp(complex(0, -2*math.Pi/float64(size)))
+		for start := 0; start < n; start += size {
+			wk := complex(1, 0)
+			for k := 0; k < size/2; k++ {
+				a, b := x[start+k], x[start+k+size/2]*wk
+				x[start+k], x[start+k+size/2] = a+b, a-b
+				wk *= w
+			}
+		}
+	}
+}
+
+// ComputeSpectrogram reads the audio of r (mixed down to one channel),
+// and returns its log-mel spectrogram. The audio is never kept in
+// memory, only the current analysis window.
+func ComputeSpectrogram(r *wav.Reader, opts SpectrogramOptions) (Spectrogram, error) {
+	sampleRate := int(r.SampleRate)
+	if opts.WindowMs <= 0 || opts.HopMs <= 0 || opts.Bands <= 0 {
+		return Spectrogram{}, fmt.Errorf("invalid spectrogram options: %#v", opts)
+	}
+	maxFreq := opts.MaxFreq
+	if maxFreq <= 0 || maxFreq > sampleRate/2 {
+		maxFreq = sampleRate / 2
+	}
+	window := sampleRate * opts.WindowMs / 1000
+	hop := sampleRate * opts.HopMs / 1000
+	if window < 2 || hop < 1 {
+		return Spectrogram{}, fmt.Errorf("sample rate too low for spectrogram: %d", sampleRate)
+	}
+	fftSize := 1
+	for fftSize < window {
+		fftSize <<= 1
+	}
+	filters := melFilters(opts.Bands, fftSize, sampleRate, float64(maxFreq))
+	hann := make([]float64, window)
+	for i := range hann {
+		hann[i] = 0.5 - 0.5*math.Cos(2*math.Pi*float64(i)/float64(window-1))
+	}
+
+	res := Spectrogram{SampleRate: sampleRate, HopMs: opts.HopMs, Bands: opts.Bands, MaxFreq: maxFreq, Data: []float32{}}
+	buf := make([]complex128, fftSize)
+	power := make([]float64, fftSize/2+1)
+	analyse := func(samples []float32) {
+		for i := range buf {
+			buf[i] = 0
+		}
+		for i, v := range samples {
+			buf[i] = complex(float64(v)*hann[i], 0)
+		}
+		fft(buf)
+		for k := range power {
+			power[k] = real(buf[k])*real(buf[k]) + imag(buf[k])*imag(buf[k])
+		}
+		for _, filter := range filters {
+			var e float64
+			for k, w := range filter {
+				e += w * power[k]
+			}
+			db := float64(minDB)
+			if e > 0 {
+				db = math.Max(minDB, 10*math.Log10(e))
+			}
+			res.Data = append(res.Data, float32(db))
+		}
+		res.Frames++
+	}
+
+	// samples of the current window
+	samples := make([]float32, 0, window)
+	err := readMono(r, func(v float32) {
+		samples = append(samples, v)
+		if len(samples) == window {
+			analyse(samples)
+			samples = append(samples[:0], samples[hop:]...)
+		}
+	})
+	if err != nil {
+		return Spectrogram{}, err
+	}
+	if res.Frames == 0 && len(samples) > 0 {
+		// audio shorter than the window
+		analyse(samples)
+	}
+	return res, nil
+}
+
+// WritePNG writes the spectrogram as a grayscale image, one pixel
+// column per frame, with the lowest band at the bottom. The dynamic
+// range is 80 dB below the loudest band.
+func (s Spectrogram) WritePNG(w io.Writer) error {
+	if s.Frames == 0 || s.Bands == 0 {
+		return fmt.Errorf("empty spectrogram")
+	}
+	max := float32(minDB)
+	for _, v := range s.Data {
+		if v > max {
+			max = v
+		}
+	}
+	const dynRange = 80
+	img := image.NewGray(image.Rect(0, 0, s.Frames, s.Bands))
+	for f := 0; f < s.Frames; f++ {
+		for b := 0; b < s.Bands; b++ {
+			v := (s.Data[f*s.Bands+b] - (max - dynRange)) / dynRange
+			if v < 0 {
+				v = 0
+			}
+			if v > 1 {
+				v = 1
+			}
+			img.SetGray(f, s.Bands-1-b, color.Gray{Y: uint8(v * 255)})
+		}
+	}
+	return png.Encode(w, img)
+}
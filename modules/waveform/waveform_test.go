@@ -0,0 +1,148 @@
+package waveform
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image/png"
+	"math"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stts-se/transtool-open/modules/wav"
+)
+
+// sineWAV returns a mono 16 bit WAV file with a sine tone of freq Hz at
+// half amplitude
+func sineWAV(sampleRate, frames int, freq float64) []byte {
+	format := wav.Format{AudioFormat: wav.FormatPCM, Channels: 1, SampleRate: uint32(sampleRate), ByteRate: uint32(sampleRate * 2), BlockAlign: 2, BitsPerSample: 16}
+	var buf bytes.Buffer
+	wav.WriteHeader(&buf, format, int64(frames*2))
+	for i := 0; i < frames; i++ {
+		v := 0.5 * math.Sin(2*math.Pi*freq*float64(i)/float64(sampleRate))
+		binary.Write(&buf, binary.LittleEndian, int16(v*32767))
+	}
+	return buf.Bytes()
+}
+
+func TestComputePeaks(t *testing.T) {
+	r, err := wav.NewReader(bytes.NewReader(sineWAV(16000, 16000, 100)))
+	if err != nil {
+		t.Fatalf("got error from NewReader: %v", err)
+	}
+	peaks, err := ComputePeaks(r, []int{256, 1024})
+	if err != nil {
+		t.Fatalf("got error from ComputePeaks: %v", err)
+	}
+	if w, g := 2, len(peaks); w != g {
+		t.Fatalf("wanted %d got %d", w, g)
+	}
+	// 16000/256 = 62.5
+	if w, g := 63, peaks[0].Length; w != g {
+		t.Errorf("wanted %d got %d", w, g)
+	}
+	if w, g := 126, len(peaks[0].Data); w != g {
+		t.Errorf("wanted %d got %d", w, g)
+	}
+	if w, g := 16, peaks[1].Length; w != g {
+		t.Errorf("wanted %d got %d", w, g)
+	}
+	// a 100 Hz period is 160 samples, so each peak covers the max amplitude
+	min, max := peaks[1].Data[0], peaks[1].Data[1]
+	if min > -16000 || min < -16500 || max < 16000 || max > 16500 {
+		t.Errorf("expected min/max of about -16384/16384, got %d/%d", min, max)
+	}
+
+	_, err = ComputePeaks(r, []int{0})
+	if err == nil {
+		t.Errorf("expected error, got nil")
+	}
+}
+
+func TestComputePeaksFile(t *testing.T) {
+	f, err := os.Open(path.Join("../test_data", "three_sentences.wav"))
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	defer f.Close()
+	r, err := wav.NewReader(f)
+	if err != nil {
+		t.Fatalf("got error from NewReader: %v", err)
+	}
+	peaks, err := ComputePeaks(r, ZoomLevels)
+	if err != nil {
+		t.Fatalf("got error from ComputePeaks: %v", err)
+	}
+	frames := int(r.Frames())
+	for i, z := range ZoomLevels {
+		if w, g := (frames+z-1)/z, peaks[i].Length; w != g {
+			t.Errorf("wanted %d got %d", w, g)
+		}
+	}
+}
+
+func TestComputeSpectrogram(t *testing.T) {
+	r, err := wav.NewReader(bytes.NewReader(sineWAV(16000, 16000, 1000)))
+	if err != nil {
+		t.Fatalf("got error from NewReader: %v", err)
+	}
+	s, err := ComputeSpectrogram(r, DefaultSpectrogramOptions)
+	if err != nil {
+		t.Fatalf("got error from ComputeSpectrogram: %v", err)
+	}
+	// (16000 - 400) / 160 + 1
+	if w, g := 98, s.Frames; w != g {
+		t.Errorf("wanted %d got %d", w, g)
+	}
+	if w, g := s.Frames*s.Bands, len(s.Data); w != g {
+		t.Errorf("wanted %d got %d", w, g)
+	}
+
+	// the loudest band is the one around 1000 Hz
+	frame := s.Data[50*s.Bands : 51*s.Bands]
+	loudest := 0
+	for b, v := range frame {
+		if v > frame[loudest] {
+			loudest = b
+		}
+	}
+	maxMel := hzToMel(float64(s.MaxFreq))
+	lo := melToHz(maxMel * float64(loudest) / float64(s.Bands+1))
+	hi := melToHz(maxMel * float64(loudest+2) / float64(s.Bands+1))
+	if lo > 1000 || hi < 1000 {
+		t.Errorf("expected loudest band around 1000 Hz, got %.0f-%.0f Hz", lo, hi)
+	}
+
+	var buf bytes.Buffer
+	err = s.WritePNG(&buf)
+	if err != nil {
+		t.Fatalf("got error from WritePNG: %v", err)
+	}
+	img, err := png.Decode(&buf)
+	if err != nil {
+		t.Fatalf("got error from png.Decode: %v", err)
+	}
+	if w, g := s.Frames, img.Bounds().Dx(); w != g {
+		t.Errorf("wanted %d got %d", w, g)
+	}
+	if w, g := s.Bands, img.Bounds().Dy(); w != g {
+		t.Errorf("wanted %d got %d", w, g)
+	}
+}
+
+func TestFFT(t *testing.T) {
+	x := make([]complex128, 8)
+	for i := range x {
+		x[i] = complex(math.Cos(2*math.Pi*float64(i)/8), 0)
+	}
+	fft(x)
+	for k, v := range x {
+		exp := 0.0
+		if k == 1 || k == 7 {
+			exp = 4
+		}
+		if math.Abs(real(v)-exp) > 1e-9 || math.Abs(imag(v)) > 1e-9 {
+			t.Errorf("bin %d: expected %v, got %v", k, exp, v)
+		}
+	}
+}
@@ -0,0 +1,273 @@
+package normalise
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/stts-se/transtool-open/abbrevs"
+)
+
+// Rule types
+const (
+	// RuleStripLabels removes labels (tokens starting with
+	// Config.LabelPrefix and, if set, ending with Config.LabelSuffix)
+	RuleStripLabels = "strip_labels"
+
+	// RuleRemovePunctuation removes punctuation (unicode category P,
+	// or the characters matching RuleConfig.Regexp if set). Characters
+	// in RuleConfig.Keep are kept if surrounded by letters or digits.
+	RuleRemovePunctuation = "remove_punctuation"
+
+	// RuleLowercase lowercases the text
+	RuleLowercase = "lowercase"
+
+	// RuleUppercase uppercases the text
+	RuleUppercase = "uppercase"
+
+	// RuleExpandAbbrevs replaces abbreviations found in the
+	// RuleConfig.AbbrevLists abbreviation lists with their expansions
+	RuleExpandAbbrevs = "expand_abbrevs"
+
+	// RuleExpandNumbers expands digit sequences to words, using RuleConfig.Language ("sv" or "ga")
+	RuleExpandNumbers = "expand_numbers"
+
+	// RuleReplace replaces RuleConfig.Regexp with RuleConfig.Replacement
+	RuleReplace = "replace"
+)
+
+// RuleConfig is a normalisation rule. Which fields are used depends on Type.
+type RuleConfig struct {
+	Type string `json:"type"`
+
+	Regexp      string   `json:"regexp,omitempty"`
+	Replacement string   `json:"replacement,omitempty"`
+	Keep        string   `json:"keep,omitempty"`
+	AbbrevLists []string `json:"abbrev_lists,omitempty"`
+	Language    string   `json:"language,omitempty"`
+}
+
+// Config is a list of normalisation rules, applied in order
+type Config struct {
+	LabelPrefix string       `json:"label_prefix"`
+	LabelSuffix string       `json:"label_suffix"`
+	Rules       []RuleConfig `json:"rules"`
+}
+
+// ConfigExample strips labels, removes punctuation and lowercases
+var ConfigExample = Config{
+	LabelPrefix: "#",
+	Rules: []RuleConfig{
+		{Type: RuleStripLabels},
+		{Type: RuleRemovePunctuation, Keep: "-'"},
+		{Type: RuleLowercase},
+	},
+}
+
+// Step is the output of a single rule
+type Step struct {
+	Rule   string `json:"rule"`
+	Output string `json:"output"`
+}
+
+// Result is the result of a normalisation. The verbatim input is kept,
+// along with the output of each step, so that the normalisation can be
+// inspected (and the verbatim text never needs to be overwritten).
+type Result struct {
+	Input  string `json:"input"`
+	Output string `json:"output"`
+	Steps  []Step `json:"steps"`
+}
+
+type rule struct {
+	ruleType    string
+	re          *regexp.Regexp
+	replacement string
+	keep        string
+	abbrevLists []string
+	numbers     func(int64) (string, bool)
+}
+
+// Normaliser applies a list of normalisation rules to transcriptions.
+// For initialization, use NewNormaliser().
+type Normaliser struct {
+	config   Config
+	rules    []rule
+	labelRe  *regexp.Regexp
+	spaceRe  *regexp.Regexp
+	abbrevs  *abbrevs.AbbrevManager
+	numberRe *regexp.Regexp
+}
+
+// NewNormaliser creates a Normaliser from the config. The abbreviation
+// manager is only needed for RuleExpandAbbrevs (the abbreviation lists
+// are looked up on each call, so that added abbreviations are used).
+func NewNormaliser(c Config, am *abbrevs.AbbrevManager) (Normaliser, error) {
+	res := Normaliser{
+		config:   c,
+		abbrevs:  am,
+		spaceRe:  regexp.MustCompile(`\s+`),
+		numberRe: regexp.MustCompile(`[0-9]+`),
+	}
+
+	for i, rc := range c.Rules {
+		r := rule{ruleType: rc.Type}
+		switch rc.Type {
+		case RuleStripLabels:
+			if c.LabelPrefix == "" {
+				return res, fmt.Errorf("normalise.NewNormaliser: rule %d (%s) requires a label prefix", i+1, rc.Type)
+			}
+			if c.LabelSuffix == "" {
+				res.labelRe = regexp.MustCompile(regexp.QuoteMeta(c.LabelPrefix) + `\S*`)
+			} else {
+				res.labelRe = regexp.MustCompile(regexp.QuoteMeta(c.LabelPrefix) + `\S*?` + regexp.QuoteMeta(c.LabelSuffix))
+			}
+		case RuleRemovePunctuation:
+			if rc.Regexp != "" {
+				re, err := regexp.Compile(rc.Regexp)
+				if err != nil {
+					return res, fmt.Errorf("normalise.NewNormaliser: rule %d (%s) failed to compile regexp : %v", i+1, rc.Type, err)
+				}
+				r.re = re
+			}
+			r.keep = rc.Keep
+		case RuleLowercase, RuleUppercase:
+		case RuleExpandAbbrevs:
+			if am == nil {
+				return res, fmt.Errorf("normalise.NewNormaliser: rule %d (%s) requires an abbreviation manager", i+1, rc.Type)
+			}
+			if len(rc.AbbrevLists) == 0 {
+				return res, fmt.Errorf("normalise.NewNormaliser: rule %d (%s) requires at least one abbreviation list", i+1, rc.Type)
+			}
+			r.abbrevLists = rc.AbbrevLists
+		case RuleExpandNumbers:
+			f, ok := numberExpanders[rc.Language]
+			if !ok {
+				return res, fmt.Errorf("normalise.NewNormaliser: rule %d (%s) has unknown language '%s'", i+1, rc.Type, rc.Language)
+			}
+			r.numbers = f
+		case RuleReplace:
+			re, err := regexp.Compile(rc.Regexp)
+			if err != nil || rc.Regexp == "" {
+				return res, fmt.Errorf("normalise.NewNormaliser: rule %d (%s) has invalid regexp '%s' : %v", i+1, rc.Type, rc.Regexp, err)
+			}
+			r.re = re
+			r.replacement = rc.Replacement
+		default:
+			return res, fmt.Errorf("normalise.NewNormaliser: rule %d has unknown type '%s'", i+1, rc.Type)
+		}
+		res.rules = append(res.rules, r)
+	}
+
+	return res, nil
+}
+
+// NewNormaliserFromJSON creates a Normaliser from a JSON config
+func NewNormaliserFromJSON(configJSON []byte, am *abbrevs.AbbrevManager) (Normaliser, error) {
+	var c Config
+	err := json.Unmarshal(configJSON, &c)
+	if err != nil {
+		return Normaliser{}, fmt.Errorf("normalise.NewNormaliserFromJSON: failed to unmarshal config : %v", err)
+	}
+	return NewNormaliser(c, am)
+}
+
+func (n *Normaliser) Config() Config { return n.config }
+
+// Normalise applies the rules, in order, to the text
+func (n *Normaliser) Normalise(text string) Result {
+	res := Result{Input: text}
+	s := text
+	for _, r := range n.rules {
+		switch r.ruleType {
+		case RuleStripLabels:
+			s = n.labelRe.ReplaceAllString(s, " ")
+		case RuleRemovePunctuation:
+			s = removePunctuation(r.re, r.keep, s)
+		case RuleLowercase:
+			s = strings.ToLower(s)
+		case RuleUppercase:
+			s = strings.ToUpper(s)
+		case RuleExpandAbbrevs:
+			s = n.expandAbbrevs(r.abbrevLists, s)
+		case RuleExpandNumbers:
+			s = n.numberRe.ReplaceAllStringFunc(s, func(digits string) string {
+				if exp, ok := expandDigits(r.numbers, digits); ok {
+					return " " + exp + " "
+				}
+				return digits
+			})
+		case RuleReplace:
+			s = r.re.ReplaceAllString(s, r.replacement)
+		}
+		s = strings.TrimSpace(n.spaceRe.ReplaceAllString(s, " "))
+		res.Steps = append(res.Steps, Step{Rule: r.ruleType, Output: s})
+	}
+	res.Output = s
+	return res
+}
+
+func isAlnum(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+func removePunctuation(re *regexp.Regexp, keep, s string) string {
+	rs := []rune(s)
+	var b strings.Builder
+	for i, r := range rs {
+		var isPunct bool
+		if re != nil {
+			isPunct = re.MatchString(string(r))
+		} else {
+			isPunct = unicode.IsPunct(r)
+		}
+		if !isPunct {
+			b.WriteRune(r)
+			continue
+		}
+		if strings.ContainsRune(keep, r) && i > 0 && i < len(rs)-1 && isAlnum(rs[i-1]) && isAlnum(rs[i+1]) {
+			b.WriteRune(r)
+			continue
+		}
+		b.WriteRune(' ')
+	}
+	return b.String()
+}
+
+// expandAbbrevs replaces whitespace separated tokens found in the
+// abbreviation lists. If a token is not found, it is looked up with
+// trailing punctuation removed, one char at a time (the removed
+// punctuation is kept after the expansion).
+func (n *Normaliser) expandAbbrevs(lists []string, s string) string {
+	abbs := map[string]string{}
+	for _, l := range lists {
+		for k, v := range n.abbrevs.AbbrevsFor(l) {
+			if _, ok := abbs[k]; !ok {
+				abbs[k] = v
+			}
+		}
+	}
+	if len(abbs) == 0 {
+		return s
+	}
+
+	toks := strings.Fields(s)
+	for i, t := range toks {
+		end := len(t)
+		for end > 0 {
+			if exp, ok := abbs[t[:end]]; ok {
+				toks[i] = exp + t[end:]
+				break
+			}
+			r, size := utf8.DecodeLastRuneInString(t[:end])
+			if !unicode.IsPunct(r) {
+				break
+			}
+			end -= size
+		}
+	}
+	return strings.Join(toks, " ")
+}
@@ -0,0 +1,124 @@
+package normalise
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stts-se/transtool-open/abbrevs"
+)
+
+func TestNormaliseExample(t *testing.T) {
+	n, err := NewNormaliser(ConfigExample, nil)
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	res := n.Normalise("#AGENT Hej, det är Anna-Karin! #eeh Vad gäller det? ")
+	if w, g := "hej det är anna-karin vad gäller det", res.Output; w != g {
+		t.Errorf("wanted '%s' got '%s'", w, g)
+	}
+	if w, g := 3, len(res.Steps); w != g {
+		t.Errorf("wanted %d got %d", w, g)
+	}
+	if w, g := "Hej, det är Anna-Karin! Vad gäller det?", res.Steps[0].Output; w != g {
+		t.Errorf("wanted '%s' got '%s'", w, g)
+	}
+	if w, g := "#AGENT Hej, det är Anna-Karin! #eeh Vad gäller det? ", res.Input; w != g {
+		t.Errorf("wanted '%s' got '%s'", w, g)
+	}
+}
+
+func TestNormaliseLabelSuffix(t *testing.T) {
+	n, err := NewNormaliser(Config{LabelPrefix: "[", LabelSuffix: "]", Rules: []RuleConfig{{Type: RuleStripLabels}}}, nil)
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	if w, g := "hej då", n.Normalise("[q:noise] hej[o:overlap] då").Output; w != g {
+		t.Errorf("wanted '%s' got '%s'", w, g)
+	}
+}
+
+func TestNormaliseAbbrevsAndNumbers(t *testing.T) {
+	dir, err := os.MkdirTemp("", "transtool_normalise_test")
+	if err != nil {
+		t.Fatalf("failed to create temp dir : %v", err)
+	}
+	defer os.RemoveAll(dir)
+	err = os.WriteFile(filepath.Join(dir, "sv.abb"), []byte("t.ex.\ttill exempel\nkr\tkronor\n"), 0600)
+	if err != nil {
+		t.Fatalf("failed to write abbrev file : %v", err)
+	}
+	am := abbrevs.NewAbbrevManager(dir)
+	if err := am.Load(); err != nil {
+		t.Fatalf("failed to load abbrevs : %v", err)
+	}
+
+	cfg := Config{
+		Rules: []RuleConfig{
+			{Type: RuleExpandAbbrevs, AbbrevLists: []string{"sv"}},
+			{Type: RuleExpandNumbers, Language: "sv"},
+			{Type: RuleRemovePunctuation},
+			{Type: RuleLowercase},
+		},
+	}
+	n, err := NewNormaliser(cfg, &am)
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	if w, g := "det kostar till exempel tjugoett kronor", n.Normalise("Det kostar t.ex. 21 kr.").Output; w != g {
+		t.Errorf("wanted '%s' got '%s'", w, g)
+	}
+
+	if _, err := NewNormaliser(Config{Rules: []RuleConfig{{Type: RuleExpandAbbrevs, AbbrevLists: []string{"sv"}}}}, nil); err == nil {
+		t.Errorf("expected error for missing abbreviation manager, got nil")
+	}
+	if _, err := NewNormaliser(Config{Rules: []RuleConfig{{Type: RuleExpandNumbers, Language: "xx"}}}, nil); err == nil {
+		t.Errorf("expected error for unknown language, got nil")
+	}
+	if _, err := NewNormaliser(Config{Rules: []RuleConfig{{Type: "upcase"}}}, nil); err == nil {
+		t.Errorf("expected error for unknown rule type, got nil")
+	}
+}
+
+func TestExpandNumbers(t *testing.T) {
+	for _, test := range []struct {
+		lang, digits, want string
+	}{
+		{"sv", "0", "noll"},
+		{"sv", "7", "sju"},
+		{"sv", "18", "arton"},
+		{"sv", "21", "tjugoett"},
+		{"sv", "100", "etthundra"},
+		{"sv", "1000", "ettusen"},
+		{"sv", "2021", "tvåtusentjugoett"},
+		{"sv", "123456", "etthundratjugotretusenfyrahundrafemtiosex"},
+		{"sv", "1000001", "en miljon ett"},
+		{"sv", "3200000", "tre miljoner tvåhundratusen"},
+		{"sv", "007", "noll noll sju"},
+		{"ga", "0", "náid"},
+		{"ga", "1", "a haon"},
+		{"ga", "12", "a dó dhéag"},
+		{"ga", "15", "a cúig déag"},
+		{"ga", "20", "fiche"},
+		{"ga", "42", "daichead a dó"},
+		{"ga", "100", "céad"},
+		{"ga", "300", "trí chéad"},
+		{"ga", "800", "ocht gcéad"},
+		{"ga", "1995", "míle naoi gcéad nócha a cúig"},
+		{"ga", "2000", "dhá mhíle"},
+		{"ga", "20000", "fiche míle"},
+		{"ga", "5000000", "cúig mhilliún"},
+	} {
+		got, ok := expandDigits(numberExpanders[test.lang], test.digits)
+		if !ok {
+			t.Errorf("%s %s: expected ok", test.lang, test.digits)
+		}
+		if w, g := test.want, got; w != g {
+			t.Errorf("%s %s: wanted '%s' got '%s'", test.lang, test.digits, w, g)
+		}
+	}
+
+	if _, ok := expandDigits(expandNumberSv, "1000000000000"); ok {
+		t.Errorf("expected out of range")
+	}
+}
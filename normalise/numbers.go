@@ -0,0 +1,176 @@
+package normalise
+
+import (
+	"strconv"
+	"strings"
+)
+
+// numberExpanders expand non-negative integers to words, per language
+// code. The second return value is false if the number is out of range.
+var numberExpanders = map[string]func(int64) (string, bool){
+	"sv": expandNumberSv,
+	"ga": expandNumberGa,
+}
+
+// maxNumber is the largest number expanded (larger numbers are kept as digits)
+const maxNumber = 999999999999
+
+// expandDigits expands a string of digits. Numbers with leading zeros
+// (except "0") are expanded digit by digit.
+func expandDigits(expand func(int64) (string, bool), digits string) (string, bool) {
+	if len(digits) > 1 && strings.HasPrefix(digits, "0") {
+		var res []string
+		for _, d := range digits {
+			s, ok := expand(int64(d - '0'))
+			if !ok {
+				return "", false
+			}
+			res = append(res, s)
+		}
+		return strings.Join(res, " "), true
+	}
+	n, err := strconv.ParseInt(digits, 10, 64)
+	if err != nil {
+		return "", false
+	}
+	return expand(n)
+}
+
+// Swedish
+
+var svOnes = []string{"noll", "ett", "två", "tre", "fyra", "fem", "sex", "sju", "åtta", "nio", "tio", "elva", "tolv", "tretton", "fjorton", "femton", "sexton", "sjutton", "arton", "nitton"}
+
+var svTens = []string{"", "", "tjugo", "trettio", "fyrtio", "femtio", "sextio", "sjuttio", "åttio", "nittio"}
+
+// svBelowMillion expands 1-999999 as a single word, e.g. "tvåtusentjugoett"
+func svBelowMillion(n int64) string {
+	var res string
+	if n >= 1000 {
+		// "ettusen", not "etttusen"
+		res += strings.TrimSuffix(svBelowThousand(n/1000), "t") + "tusen"
+		n %= 1000
+	}
+	if n > 0 {
+		res += svBelowThousand(n)
+	}
+	return res
+}
+
+func svBelowThousand(n int64) string {
+	var res string
+	if n >= 100 {
+		res += svOnes[n/100] + "hundra"
+		n %= 100
+	}
+	if n >= 20 {
+		res += svTens[n/10]
+		n %= 10
+	}
+	if n > 0 {
+		res += svOnes[n]
+	}
+	return res
+}
+
+func expandNumberSv(n int64) (string, bool) {
+	if n < 0 || n > maxNumber {
+		return "", false
+	}
+	if n == 0 {
+		return svOnes[0], true
+	}
+	var res []string
+	for _, big := range []struct {
+		n                int64
+		sg, pl, sgPrefix string
+	}{
+		{1000000000, "miljard", "miljarder", "en"},
+		{1000000, "miljon", "miljoner", "en"},
+	} {
+		if n >= big.n {
+			m := n / big.n
+			if m == 1 {
+				res = append(res, big.sgPrefix, big.sg)
+			} else {
+				res = append(res, svBelowMillion(m), big.pl)
+			}
+			n %= big.n
+		}
+	}
+	if n > 0 {
+		res = append(res, svBelowMillion(n))
+	}
+	return strings.Join(res, " "), true
+}
+
+// Irish (counting numbers, a haon, a dó, ...)
+
+var gaOnes = []string{"náid", "a haon", "a dó", "a trí", "a ceathair", "a cúig", "a sé", "a seacht", "a hocht", "a naoi"}
+
+var gaTens = []string{"", "a deich", "fiche", "tríocha", "daichead", "caoga", "seasca", "seachtó", "ochtó", "nócha"}
+
+// gaMultipliers are the forms of 1-10 used before a noun (céad, míle, milliún)
+var gaMultipliers = []string{"", "", "dhá", "trí", "ceithre", "cúig", "sé", "seacht", "ocht", "naoi", "deich"}
+
+// gaCounted returns "<n> <noun>" with initial mutation of the noun
+// after 2-10 (lenition after 2-6, eclipsis after 7-10). The nouns are
+// given in their basic, lenited and eclipsed forms.
+func gaCounted(n int64, noun, lenited, eclipsed string) string {
+	switch {
+	case n == 1:
+		return noun
+	case n >= 2 && n <= 6:
+		return gaMultipliers[n] + " " + lenited
+	case n >= 7 && n <= 10:
+		return gaMultipliers[n] + " " + eclipsed
+	}
+	s, _ := expandNumberGa(n)
+	return s + " " + noun
+}
+
+func gaBelowHundred(n int64) string {
+	switch {
+	case n < 10:
+		return gaOnes[n]
+	case n == 10:
+		return gaTens[1]
+	case n == 12:
+		return "a dó dhéag"
+	case n < 20:
+		return gaOnes[n-10] + " déag"
+	}
+	if n%10 == 0 {
+		return gaTens[n/10]
+	}
+	return gaTens[n/10] + " " + gaOnes[n%10]
+}
+
+func expandNumberGa(n int64) (string, bool) {
+	if n < 0 || n > maxNumber {
+		return "", false
+	}
+	if n == 0 {
+		return gaOnes[0], true
+	}
+	var res []string
+	if n >= 1000000000 {
+		res = append(res, gaCounted(n/1000000000, "billiún", "bhilliún", "mbilliún"))
+		n %= 1000000000
+	}
+	if n >= 1000000 {
+		res = append(res, gaCounted(n/1000000, "milliún", "mhilliún", "milliún"))
+		n %= 1000000
+	}
+	if n >= 1000 {
+		res = append(res, gaCounted(n/1000, "míle", "mhíle", "míle"))
+		n %= 1000
+	}
+	if n >= 100 {
+		res = append(res, gaCounted(n/100, "céad", "chéad", "gcéad"))
+		n %= 100
+	}
+	if n > 0 {
+		res = append(res, gaBelowHundred(n))
+	}
+	return strings.Join(res, " "), true
+}
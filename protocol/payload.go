@@ -17,6 +17,19 @@ type SplitRequestPayload struct {
 	// RightContext in milliseconds
 	RightContext int64 `json:"right_context"`
 	Chunk        Chunk `json:"chunk"`
+	// Channel is the channel to extract (1 for the first channel), or
+	// 0 for all channels
+	Channel int `json:"channel,omitempty"`
+}
+
+// WithContext returns the chunk extended with the left and right
+// context. The start time is never negative.
+func (p SplitRequestPayload) WithContext() Chunk {
+	res := Chunk{Start: p.Chunk.Start - p.LeftContext, End: p.Chunk.End + p.RightContext}
+	if res.Start < 0 {
+		res.Start = 0
+	}
+	return res
 }
 
 type Chunk struct {
@@ -32,6 +45,50 @@ type TransChunk struct {
 	Trans         string   `json:"trans"`          //`json:"trans,omitempty"`
 	CurrentStatus Status   `json:"current_status"` //`json:"current_status,omitempty"`
 	StatusHistory []Status `json:"status_history"` //`json:"status_history,omitempty"`
+	// Speaker is the speaker label of the chunk (for example #AGENT),
+	// if any
+	Speaker string `json:"speaker,omitempty"`
+	// Channel is the audio channel of the chunk (1 for the first
+	// channel), or 0 if the chunk is not tied to a channel
+	Channel int `json:"channel,omitempty"`
+	// Language is the language code of the chunk, if any
+	Language string `json:"language,omitempty"`
+	// Attributes are free key/value attributes of the chunk
+	Attributes map[string]string `json:"attributes,omitempty"`
+	// Tier is the name of the tier of the chunk (see
+	// AnnotationPayload.Tiers), or empty if the page has no tiers
+	Tier string `json:"tier,omitempty"`
+	// Quality is the audio quality of the chunk, if analysed
+	Quality *AudioQuality `json:"quality,omitempty"`
+}
+
+// AudioQuality holds audio quality measures of a page or chunk (see the
+// quality package). Levels are in dBFS, with -100 for digital silence.
+type AudioQuality struct {
+	// Chunk is the analysed part of the audio
+	Chunk Chunk `json:"chunk"`
+	// ClippingRatio is the ratio of clipped samples (0 to 1)
+	ClippingRatio float64 `json:"clipping_ratio"`
+	// RMS is the RMS level
+	RMS float64 `json:"rms"`
+	// Peak is the peak level
+	Peak float64 `json:"peak"`
+	// SNR is an estimate of the signal-to-noise ratio in dB
+	SNR float64 `json:"snr"`
+	// SilenceRatio is the ratio of the audio without detected speech (0 to 1)
+	SilenceRatio float64 `json:"silence_ratio"`
+}
+
+// Tier is an annotation tier of a page, typically the speech of one
+// speaker or channel. Chunks of the same tier must not overlap, but
+// chunks of different tiers may (for example, overlapping speech).
+type Tier struct {
+	Name string `json:"name"`
+	// Speaker is the speaker label of the tier, if any
+	Speaker string `json:"speaker,omitempty"`
+	// Channel is the audio channel of the tier (1 for the first
+	// channel), or 0 if the tier is not tied to a channel
+	Channel int `json:"channel,omitempty"`
 }
 
 type AnnotationWithAudioData struct {
@@ -41,6 +98,9 @@ type AnnotationWithAudioData struct {
 	Base64Audio string `json:"base64audio,omitempty"`
 	FileType    string `json:"file_type"`
 	Offset      int64  `json:"offset"`
+	// AudioURL is the URL of the audio, if it is not included as
+	// Base64Audio
+	AudioURL string `json:"audio_url,omitempty"`
 }
 
 func (aa AnnotationWithAudioData) PrettyMarshal() ([]byte, error) {
@@ -66,6 +126,11 @@ type AnnotationPayload struct {
 	StatusHistory []Status     `json:"status_history,omitempty"`
 	Comment       string       `json:"comment,omitempty"`
 	Index         int64        `json:"index,omitempty"`
+	// Tiers are the tiers of the page, if any. Without tiers, all
+	// chunks are in a single tier, and must not overlap.
+	Tiers []Tier `json:"tiers,omitempty"`
+	// Quality is the audio quality of the page, if analysed
+	Quality *AudioQuality `json:"quality,omitempty"`
 }
 
 // func (tc *TransChunk) SetCurrentStatus(s Status) {
@@ -112,6 +177,15 @@ type QueryRequest struct {
 	Source          string   `json:"source,omitempty"`
 	TransRE         string   `json:"trans_re,omitempty"`
 	ValidationIssue ValIssue `json:"validation_issue,omitempty"`
+	// Speaker, Language and Attribute match pages with a chunk with
+	// the speaker, language or attribute. Attribute is a key, or
+	// key=value.
+	Speaker   string `json:"speaker,omitempty"`
+	Language  string `json:"language,omitempty"`
+	Attribute string `json:"attribute,omitempty"`
+	// Quality matches pages with an audio quality condition, such as
+	// "snr < 10 dB" (see dbapi.QualityCondition)
+	Quality string `json:"quality,omitempty"`
 	//	transRECompiled *regexp.Regexp
 }
 
@@ -128,6 +202,21 @@ type ListFiles struct {
 	SubProj string `json:"sub_proj"`
 }
 
+// ValidateTransPayload is a request to validate the transcription of
+// the chunk being edited, using the validator of the sub-project
+type ValidateTransPayload struct {
+	SubProj string `json:"sub_proj"`
+	TransChunk
+}
+
+// ExpandAbbrevsPayload is a request to expand the abbreviations of a
+// transcription, using the abbreviation lists in the order given
+type ExpandAbbrevsPayload struct {
+	SubProj string   `json:"sub_proj"`
+	Lists   []string `json:"lists"`
+	Trans   string   `json:"trans"`
+}
+
 // ASR
 type ASROutputChunk struct {
 	Chunk
@@ -68,3 +68,15 @@ func TestAnnotationPayload(t *testing.T) {
 	// }
 
 }
+
+func TestSplitRequestWithContext(t *testing.T) {
+	p := SplitRequestPayload{Chunk: Chunk{Start: 300, End: 900}, LeftContext: 500, RightContext: 200}
+	if w, g := (Chunk{Start: 0, End: 1100}), p.WithContext(); w != g {
+		t.Errorf("wanted %#v got %#v", w, g)
+	}
+	p.Chunk.Start = 1000
+	p.Chunk.End = 2000
+	if w, g := (Chunk{Start: 500, End: 2200}), p.WithContext(); w != g {
+		t.Errorf("wanted %#v got %#v", w, g)
+	}
+}
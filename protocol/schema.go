@@ -0,0 +1,311 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// JSONSchemaDraft is the JSON Schema version of generated schemas
+const JSONSchemaDraft = "https://json-schema.org/draft/2020-12/schema"
+
+// SchemaType is a JSON Schema type, or a list of types. A single type
+// is marshalled as a string.
+type SchemaType []string
+
+func (st SchemaType) MarshalJSON() ([]byte, error) {
+	if len(st) == 1 {
+		return json.Marshal(st[0])
+	}
+	return json.Marshal([]string(st))
+}
+
+func (st *SchemaType) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*st = SchemaType{s}
+		return nil
+	}
+	var ss []string
+	if err := json.Unmarshal(data, &ss); err != nil {
+		return err
+	}
+	*st = ss
+	return nil
+}
+
+func (st SchemaType) has(t string) bool {
+	for _, t0 := range st {
+		if t0 == t {
+			return true
+		}
+	}
+	return false
+}
+
+// Schema is a JSON Schema. Only the parts needed to describe the
+// protocol types are supported (see SchemaGenerator).
+type Schema struct {
+	Schema               string             `json:"$schema,omitempty"`
+	ID                   string             `json:"$id,omitempty"`
+	Ref                  string             `json:"$ref,omitempty"`
+	Title                string             `json:"title,omitempty"`
+	Description          string             `json:"description,omitempty"`
+	Type                 SchemaType         `json:"type,omitempty"`
+	Const                interface{}        `json:"const,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	AnyOf                []*Schema          `json:"anyOf,omitempty"`
+	OneOf                []*Schema          `json:"oneOf,omitempty"`
+	// ContentMediaType and ContentSchema describe JSON encoded in a string
+	ContentMediaType string             `json:"contentMediaType,omitempty"`
+	ContentSchema    *Schema            `json:"contentSchema,omitempty"`
+	Defs             map[string]*Schema `json:"$defs,omitempty"`
+}
+
+// SchemaGenerator generates JSON Schemas from Go types, following the
+// encoding/json rules for field names and embedded structs. Named
+// struct types are added to Defs, and referenced by RefPrefix + the
+// type name (e.g. "#/$defs/protocol.Chunk").
+type SchemaGenerator struct {
+	RefPrefix string
+	Defs      map[string]*Schema
+}
+
+// NewSchemaGenerator returns a generator with an empty set of
+// definitions, referenced using refPrefix
+func NewSchemaGenerator(refPrefix string) *SchemaGenerator {
+	return &SchemaGenerator{RefPrefix: refPrefix, Defs: map[string]*Schema{}}
+}
+
+// Schema returns the schema for the type of v, or nil if v is nil
+func (g *SchemaGenerator) Schema(v interface{}) *Schema {
+	if v == nil {
+		return nil
+	}
+	return g.schemaFor(reflect.TypeOf(v))
+}
+
+// DefName returns the definition name of a named type: the package
+// base name and the type name. Types in package main have no prefix.
+func DefName(t reflect.Type) string {
+	pkg := path.Base(t.PkgPath())
+	if pkg == "main" || pkg == "." {
+		return t.Name()
+	}
+	return pkg + "." + t.Name()
+}
+
+var jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+
+func (g *SchemaGenerator) schemaFor(t reflect.Type) *Schema {
+	if t.Implements(jsonMarshalerType) && t.Kind() != reflect.Ptr {
+		// custom JSON encoding: anything
+		return &Schema{}
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return &Schema{Type: SchemaType{"boolean"}}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &Schema{Type: SchemaType{"integer"}}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: SchemaType{"number"}}
+	case reflect.String:
+		return &Schema{Type: SchemaType{"string"}}
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: SchemaType{"string", "null"}, ContentMediaType: "application/octet-stream"}
+		}
+		return &Schema{Type: SchemaType{"array", "null"}, Items: g.schemaFor(t.Elem())}
+	case reflect.Array:
+		return &Schema{Type: SchemaType{"array"}, Items: g.schemaFor(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: SchemaType{"object", "null"}, AdditionalProperties: g.schemaFor(t.Elem())}
+	case reflect.Ptr:
+		return g.schemaFor(t.Elem())
+	case reflect.Struct:
+		if t.Name() == "" {
+			return g.structSchema(t)
+		}
+		name := DefName(t)
+		if _, ok := g.Defs[name]; !ok {
+			// placeholder, for recursive types
+			g.Defs[name] = &Schema{}
+			*g.Defs[name] = *g.structSchema(t)
+		}
+		return &Schema{Ref: g.RefPrefix + name}
+	}
+	// interfaces, and anything else: any value
+	return &Schema{}
+}
+
+// structSchema returns an object schema. Fields without omitempty are
+// not required, since encoding/json accepts missing fields.
+func (g *SchemaGenerator) structSchema(t reflect.Type) *Schema {
+	res := &Schema{Type: SchemaType{"object"}, Properties: map[string]*Schema{}}
+	var embedded []reflect.Type
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name := strings.Split(tag, ",")[0]
+		ft := f.Type
+		if f.Anonymous && name == "" {
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				embedded = append(embedded, ft)
+				continue
+			}
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		res.Properties[name] = g.schemaFor(ft)
+	}
+	// fields of embedded structs, unless shadowed by the outer struct
+	for _, et := range embedded {
+		for name, s := range g.structSchema(et).Properties {
+			if _, ok := res.Properties[name]; !ok {
+				res.Properties[name] = s
+			}
+		}
+	}
+	return res
+}
+
+// Validate decodes data as JSON, and validates it against the schema.
+// References are resolved in the generator's definitions.
+func (g *SchemaGenerator) Validate(s *Schema, data []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return fmt.Errorf("invalid JSON : %v", err)
+	}
+	return g.validate(s, v, "")
+}
+
+func jsonType(v interface{}) string {
+	switch x := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case json.Number:
+		if _, err := x.Int64(); err == nil {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+func (g *SchemaGenerator) validate(s *Schema, v interface{}, at string) error {
+	if s == nil {
+		return nil
+	}
+	where := at
+	if where == "" {
+		where = "value"
+	}
+	if s.Ref != "" {
+		def, ok := g.Defs[strings.TrimPrefix(s.Ref, g.RefPrefix)]
+		if !ok {
+			return fmt.Errorf("%s: unknown schema reference '%s'", where, s.Ref)
+		}
+		return g.validate(def, v, at)
+	}
+	if s.Const != nil && !reflect.DeepEqual(fmt.Sprint(s.Const), fmt.Sprint(v)) {
+		return fmt.Errorf("%s: expected %v, got %v", where, s.Const, v)
+	}
+	if len(s.AnyOf) > 0 {
+		var errs []string
+		for _, s0 := range s.AnyOf {
+			err := g.validate(s0, v, at)
+			if err == nil {
+				errs = nil
+				break
+			}
+			errs = append(errs, err.Error())
+		}
+		if len(errs) > 0 {
+			return fmt.Errorf("%s: no matching schema (%s)", where, strings.Join(errs, "; "))
+		}
+	}
+	if len(s.OneOf) > 0 {
+		n := 0
+		for _, s0 := range s.OneOf {
+			if g.validate(s0, v, at) == nil {
+				n++
+			}
+		}
+		if n != 1 {
+			return fmt.Errorf("%s: expected exactly one matching schema, found %d", where, n)
+		}
+	}
+
+	vt := jsonType(v)
+	if len(s.Type) > 0 && !s.Type.has(vt) && !(vt == "integer" && s.Type.has("number")) {
+		return fmt.Errorf("%s: expected %s, got %s", where, strings.Join(s.Type, " or "), vt)
+	}
+
+	switch x := v.(type) {
+	case []interface{}:
+		for i, v0 := range x {
+			if err := g.validate(s.Items, v0, fmt.Sprintf("%s[%d]", at, i)); err != nil {
+				return err
+			}
+		}
+	case map[string]interface{}:
+		for _, name := range s.Required {
+			if _, ok := x[name]; !ok {
+				return fmt.Errorf("%s: missing required field '%s'", where, name)
+			}
+		}
+		keys := make([]string, 0, len(x))
+		for k := range x {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			sub := k
+			if at != "" {
+				sub = at + "." + k
+			}
+			if ps, ok := s.Properties[k]; ok {
+				if err := g.validate(ps, x[k], sub); err != nil {
+					return err
+				}
+			} else if err := g.validate(s.AdditionalProperties, x[k], sub); err != nil {
+				return err
+			}
+		}
+	case string:
+		if s.ContentSchema != nil {
+			if err := g.Validate(s.ContentSchema, []byte(x)); err != nil {
+				return fmt.Errorf("%s: %v", where, err)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package protocol
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestSchemaGenerator(t *testing.T) {
+	g := NewSchemaGenerator("#/$defs/")
+	s := g.Schema(AnnotationPayload{})
+	if w, g := "#/$defs/protocol.AnnotationPayload", s.Ref; w != g {
+		t.Errorf("wanted '%s' got '%s'", w, g)
+	}
+	for _, name := range []string{"protocol.AnnotationPayload", "protocol.PagePayload", "protocol.Status", "protocol.TransChunk"} {
+		if _, ok := g.Defs[name]; !ok {
+			t.Errorf("expected definition %s", name)
+		}
+	}
+
+	// fields of the embedded Chunk are flattened into PagePayload
+	page := g.Defs["protocol.PagePayload"]
+	for _, name := range []string{"id", "audio", "start", "end"} {
+		if _, ok := page.Properties[name]; !ok {
+			t.Errorf("expected property %s", name)
+		}
+	}
+	if w, g := "integer", page.Properties["start"].Type[0]; w != g {
+		t.Errorf("wanted '%s' got '%s'", w, g)
+	}
+
+	// a single type is marshalled as a string
+	bts, err := json.Marshal(&Schema{Type: SchemaType{"string"}})
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	if w, g := `{"type":"string"}`, string(bts); w != g {
+		t.Errorf("wanted '%s' got '%s'", w, g)
+	}
+}
+
+func TestSchemaValidate(t *testing.T) {
+	g := NewSchemaGenerator("#/$defs/")
+	s := g.Schema(AnnotationPayload{})
+
+	for _, valid := range []string{
+		`{}`,
+		`{"sub_proj": "sp1", "page": {"id": "1", "start": 301, "end": 351}, "labels": ["bad"]}`,
+		`{"labels": null, "unknown_field": 1}`,
+	} {
+		if err := g.Validate(s, []byte(valid)); err != nil {
+			t.Errorf("expected nil for %s, got %v", valid, err)
+		}
+	}
+
+	for _, invalid := range []string{
+		`[]`,
+		`{"sub_proj": 1}`,
+		`{"page": {"start": "301"}}`,
+		`{"page": {"start": 30.1}}`,
+		`{"labels": [1]}`,
+		`{"sub_proj": `,
+	} {
+		if err := g.Validate(s, []byte(invalid)); err == nil {
+			t.Errorf("expected error for %s, got nil", invalid)
+		}
+	}
+
+	err := g.Validate(s, []byte(`{"page": {"start": "301"}}`))
+	if w, g := "page.start: expected integer, got string", err.Error(); w != g {
+		t.Errorf("wanted '%s' got '%s'", w, g)
+	}
+
+	// JSON encoded in a string
+	env := &Schema{Type: SchemaType{"string"}, ContentMediaType: "application/json", ContentSchema: s}
+	if err := g.Validate(env, []byte(`"{\"sub_proj\": \"sp1\"}"`)); err != nil {
+		t.Errorf("expected nil, got %v", err)
+	}
+	if err := g.Validate(env, []byte(`"{\"sub_proj\": true}"`)); err == nil {
+		t.Errorf("expected error, got nil")
+	}
+}
@@ -0,0 +1,213 @@
+package validation
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/stts-se/transtool-open/protocol"
+)
+
+// CorpusValRes is a validation result concerning one or more pages
+// of a sub-project (as opposed to ValRes, which concerns a single
+// page)
+type CorpusValRes struct {
+	RuleName string   `json:"rule_name"`
+	Level    string   `json:"level"`
+	PageIDs  []string `json:"page_ids,omitempty"`
+	Message  string   `json:"message"`
+}
+
+// CorpusValidation is the result of Validator.ValidateCorpus
+type CorpusValidation struct {
+	SubProj string         `json:"sub_proj,omitempty"`
+	Pages   int            `json:"pages"`
+	Result  []CorpusValRes `json:"result"`
+}
+
+// ValidateCorpus runs consistency checks over all the annotated pages
+// of a sub-project:
+//
+//   - identical transcriptions of the last and first chunks of adjacent pages of the same audio
+//   - chunks that straddle the boundaries of their page
+//   - gaps in the coverage of the audio between adjacent pages (longer than Config.MaxPageGapMs)
+//   - spelling variants of the same word (differing in more than case)
+//   - labels that are not in the validation config
+//
+// Pages with status "delete" or "skip" are ignored.
+func (v *Validator) ValidateCorpus(annos []protocol.AnnotationPayload) []CorpusValRes {
+	var res []CorpusValRes
+
+	var pages []protocol.AnnotationPayload
+	for _, a := range annos {
+		if a.CurrentStatus.Name == "delete" || a.CurrentStatus.Name == "skip" {
+			continue
+		}
+		pages = append(pages, a)
+	}
+
+	res = append(res, v.adjacentPages(pages)...)
+	res = append(res, v.straddlingChunks(pages)...)
+	res = append(res, v.spellingVariants(pages)...)
+	res = append(res, v.unknownLabels(pages)...)
+
+	return res
+}
+
+// pagesByAudio groups pages by audio file, sorted by start time
+func pagesByAudio(pages []protocol.AnnotationPayload) map[string][]protocol.AnnotationPayload {
+	res := map[string][]protocol.AnnotationPayload{}
+	for _, a := range pages {
+		res[a.Page.Audio] = append(res[a.Page.Audio], a)
+	}
+	for _, ps := range res {
+		sort.Slice(ps, func(i, j int) bool { return ps[i].Page.Start < ps[j].Page.Start })
+	}
+	return res
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	var res []string
+	for k := range m {
+		res = append(res, k)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// sortedChunks returns the chunks of a page sorted by start time
+func sortedChunks(a protocol.AnnotationPayload) []protocol.TransChunk {
+	res := make([]protocol.TransChunk, len(a.Chunks))
+	copy(res, a.Chunks)
+	sort.Slice(res, func(i, j int) bool { return res[i].Start < res[j].Start })
+	return res
+}
+
+func (v *Validator) adjacentPages(pages []protocol.AnnotationPayload) []CorpusValRes {
+	var res []CorpusValRes
+
+	byAudio := pagesByAudio(pages)
+	for _, audio := range sortedKeys(byAudio) {
+		ps := byAudio[audio]
+		for i := 1; i < len(ps); i++ {
+			prev, curr := ps[i-1], ps[i]
+			ids := []string{prev.Page.ID, curr.Page.ID}
+
+			gap := curr.Page.Start - prev.Page.End
+			if gap > int64(v.config.MaxPageGapMs) {
+				msg := fmt.Sprintf("%s\t%d ms not covered between pages (%d-%d)", audio, gap, prev.Page.End, curr.Page.Start)
+				res = append(res, CorpusValRes{RuleName: "page_coverage_gap", Level: "warning", PageIDs: ids, Message: msg})
+			}
+
+			prevChunks, currChunks := sortedChunks(prev), sortedChunks(curr)
+			if len(prevChunks) == 0 || len(currChunks) == 0 {
+				continue
+			}
+			t1 := strings.TrimSpace(prevChunks[len(prevChunks)-1].Trans)
+			t2 := strings.TrimSpace(currChunks[0].Trans)
+			if t1 == t2 && !ignoreTrans(t1, v) {
+				msg := fmt.Sprintf("%s\tidentical transcriptions on adjacent pages: '%s'", audio, t1)
+				res = append(res, CorpusValRes{RuleName: "identical_adjacent_page_transcriptions", Level: "warning", PageIDs: ids, Message: msg})
+			}
+		}
+	}
+
+	return res
+}
+
+func (v *Validator) straddlingChunks(pages []protocol.AnnotationPayload) []CorpusValRes {
+	var res []CorpusValRes
+
+	for _, a := range pages {
+		for i, c := range a.Chunks {
+			if c.Start < a.Page.Start || c.End > a.Page.End {
+				msg := fmt.Sprintf("%s\tchunk no. %d (%d-%d) straddles the page boundaries (%d-%d)", a.Page.Audio, i+1, c.Start, c.End, a.Page.Start, a.Page.End)
+				res = append(res, CorpusValRes{RuleName: "chunk_straddles_page", Level: "error", PageIDs: []string{a.Page.ID}, Message: msg})
+			}
+		}
+	}
+
+	return res
+}
+
+// spellingKey is used to group spelling variants: lower case, with hyphens and apostrophes removed
+func spellingKey(w string) string {
+	w = strings.ToLower(w)
+	return strings.NewReplacer("-", "", "'", "", "’", "").Replace(w)
+}
+
+func (v *Validator) spellingVariants(pages []protocol.AnnotationPayload) []CorpusValRes {
+	var res []CorpusValRes
+
+	// key -> lower case form -> frequency
+	variants := map[string]map[string]int{}
+	// key -> pages
+	pageIDs := map[string]map[string]bool{}
+
+	for _, a := range pages {
+		for _, c := range a.Chunks {
+			for _, tok := range v.tokenSplitRegexp.Split(c.Trans, -1) {
+				if tok == "" || v.isLabel(tok) {
+					continue
+				}
+				key := spellingKey(tok)
+				if key == "" {
+					continue
+				}
+				if _, ok := variants[key]; !ok {
+					variants[key] = map[string]int{}
+					pageIDs[key] = map[string]bool{}
+				}
+				variants[key][strings.ToLower(tok)]++
+				pageIDs[key][a.Page.ID] = true
+			}
+		}
+	}
+
+	for _, key := range sortedKeys(variants) {
+		forms := variants[key]
+		if len(forms) < 2 {
+			continue
+		}
+		var fs []string
+		for _, f := range sortedKeys(forms) {
+			fs = append(fs, fmt.Sprintf("%s (%d)", f, forms[f]))
+		}
+		msg := fmt.Sprintf("spelling variants: %s", strings.Join(fs, ", "))
+		res = append(res, CorpusValRes{RuleName: "spelling_variants", Level: "warning", PageIDs: sortedKeys(pageIDs[key]), Message: msg})
+	}
+
+	return res
+}
+
+func (v *Validator) isLabel(tok string) bool {
+	return strings.HasPrefix(tok, v.labelPrefix) || (v.labelSuffix != "" && strings.HasSuffix(tok, v.labelSuffix))
+}
+
+func (v *Validator) unknownLabels(pages []protocol.AnnotationPayload) []CorpusValRes {
+	var res []CorpusValRes
+
+	freq := map[string]int{}
+	pageIDs := map[string]map[string]bool{}
+	for _, a := range pages {
+		for _, c := range a.Chunks {
+			for _, tok := range v.tokenSplitRegexp.Split(c.Trans, -1) {
+				if tok == "" || !v.isLabel(tok) || v.labels[tok] {
+					continue
+				}
+				if _, ok := pageIDs[tok]; !ok {
+					pageIDs[tok] = map[string]bool{}
+				}
+				freq[tok]++
+				pageIDs[tok][a.Page.ID] = true
+			}
+		}
+	}
+
+	for _, l := range sortedKeys(freq) {
+		msg := fmt.Sprintf("label '%s' is not in the validation config (used %d times)", l, freq[l])
+		res = append(res, CorpusValRes{RuleName: "unknown_label", Level: "error", PageIDs: sortedKeys(pageIDs[l]), Message: msg})
+	}
+
+	return res
+}
@@ -0,0 +1,95 @@
+package validation
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stts-se/transtool-open/protocol"
+)
+
+func corpusPage(id, audio string, start, end int64, chunks ...protocol.TransChunk) protocol.AnnotationPayload {
+	return protocol.AnnotationPayload{
+		Page:          protocol.PagePayload{ID: id, Audio: audio, Chunk: protocol.Chunk{Start: start, End: end}},
+		CurrentStatus: protocol.Status{Name: "normal"},
+		Chunks:        chunks,
+	}
+}
+
+func corpusChunk(start, end int64, trans string) protocol.TransChunk {
+	return protocol.TransChunk{Chunk: protocol.Chunk{Start: start, End: end}, Trans: trans, CurrentStatus: protocol.Status{Name: "ok"}}
+}
+
+func countRules(res []CorpusValRes) map[string]int {
+	m := map[string]int{}
+	for _, vr := range res {
+		m[vr.RuleName]++
+	}
+	return m
+}
+
+func TestValidateCorpus(t *testing.T) {
+	cfg := ConfigExample2
+	cfg.MaxPageGapMs = 100
+	v, err := NewValidator(cfg)
+	if err != nil {
+		t.Fatalf("failed to create new validator : %v", err)
+	}
+
+	annos := []protocol.AnnotationPayload{
+		// given in reverse order
+		corpusPage("p2", "a.wav", 10050, 20000,
+			corpusChunk(10050, 15000, "#AGENT och så vidare med e-post"),
+			corpusChunk(15000, 20500, "#AGENT det här går över sidgränsen"),
+		),
+		corpusPage("p1", "a.wav", 0, 10000,
+			corpusChunk(0, 5000, "#CUSTOMER jag skickar ett epost"),
+			corpusChunk(5000, 10000, "#AGENT och så vidare med e-post"),
+		),
+		// gap of 1000 ms
+		corpusPage("p3", "a.wav", 21000, 30000,
+			corpusChunk(21000, 30000, "#SPEAKER hej #AGENT"),
+		),
+		// other audio file, Epost only differs in case from epost
+		corpusPage("p4", "b.wav", 0, 10000,
+			corpusChunk(0, 10000, "#AGENT Epost"),
+		),
+		// ignored
+		{Page: protocol.PagePayload{ID: "p5", Audio: "a.wav"}, CurrentStatus: protocol.Status{Name: "skip"},
+			Chunks: []protocol.TransChunk{corpusChunk(50000, 60000, "#FEL")}},
+	}
+
+	res := v.ValidateCorpus(annos)
+	rules := countRules(res)
+
+	for rule, n := range map[string]int{
+		"page_coverage_gap":                      1,
+		"identical_adjacent_page_transcriptions": 1,
+		"chunk_straddles_page":                   1,
+		"spelling_variants":                      1,
+		"unknown_label":                          1,
+	} {
+		if w, g := n, rules[rule]; w != g {
+			t.Errorf("%s: wanted %d got %d", rule, w, g)
+		}
+	}
+	if w, g := 5, len(res); w != g {
+		t.Errorf("wanted %d got %d", w, g)
+	}
+
+	byRule := map[string]CorpusValRes{}
+	for _, vr := range res {
+		byRule[vr.RuleName] = vr
+	}
+	if w, g := "p2 p3", strings.Join(byRule["page_coverage_gap"].PageIDs, " "); w != g {
+		t.Errorf("wanted '%s' got '%s'", w, g)
+	}
+	if w, g := "p1 p2", strings.Join(byRule["identical_adjacent_page_transcriptions"].PageIDs, " "); w != g {
+		t.Errorf("wanted '%s' got '%s'", w, g)
+	}
+	if w, g := "spelling variants: e-post (2), epost (2)", byRule["spelling_variants"].Message; w != g {
+		t.Errorf("wanted '%s' got '%s'", w, g)
+	}
+	if w, g := "p3", strings.Join(byRule["unknown_label"].PageIDs, " "); w != g {
+		t.Errorf("wanted '%s' got '%s'", w, g)
+	}
+}
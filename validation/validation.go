@@ -3,6 +3,7 @@ package validation
 import (
 	"encoding/json"
 	"fmt"
+	"path/filepath"
 	"regexp"
 	//"strconv"
 	"sort"
@@ -21,6 +22,27 @@ type RegexpValidation struct {
 	Regexp   string `json:"regexp"`
 	Level    string `json:"level"`
 	Message  string `json:"message"`
+
+	// Optional scope of the rule. If more than one is set, all must match.
+
+	// Statuses are the chunk status names the rule applies to. If
+	// empty, the rule applies to chunks with a status name starting with "ok".
+	Statuses []string `json:"statuses,omitempty"`
+	// Labels limits the rule to transcriptions containing at least one of the labels
+	Labels []string `json:"labels,omitempty"`
+	// SubProjs are the sub-projects (directory name or path) the rule applies to
+	SubProjs []string `json:"sub_projs,omitempty"`
+
+	// Optional auto-fix
+
+	// Replacement is a replacement template (see regexp.Regexp.Expand)
+	// used to suggest a fixed transcription
+	Replacement *string `json:"replacement,omitempty"`
+	// FixRegexp is the regexp used with Replacement. If empty,
+	// Regexp is used (it must be set for TransMustMatch rules).
+	FixRegexp string `json:"fix_regexp,omitempty"`
+	// SafeFix marks the fix as safe to apply without manual inspection
+	SafeFix bool `json:"safe_fix,omitempty"`
 }
 
 type Config struct {
@@ -36,6 +58,21 @@ type Config struct {
 
 	TransMustMatch    []RegexpValidation `json:"trans_must_match"`
 	TransMustNotMatch []RegexpValidation `json:"trans_must_not_match"`
+
+	// MaxPageGapMs is the longest gap between adjacent pages of the
+	// same audio not reported by ValidateCorpus
+	MaxPageGapMs int `json:"max_page_gap_ms,omitempty"`
+
+	// ChannelLabels are the speaker labels of the audio channels (the
+	// first label for the first channel), used to pre-fill the
+	// speakers of chunks segmented per channel
+	ChannelLabels []string `json:"channel_labels,omitempty"`
+
+	// Speakers and Languages are the valid values of the speaker and
+	// language fields of chunks. If empty, any value is valid. Speakers
+	// are labels (see Labels).
+	Speakers  string `json:"speakers,omitempty"`
+	Languages string `json:"languages,omitempty"`
 }
 
 var ConfigExample = Config{
@@ -68,6 +105,9 @@ var ConfigExample2 = Config{
 
 	TokenSplitRegexp: `[ \n,.!?]`,
 
+	ChannelLabels: []string{"#AGENT", "#CUSTOMER"},
+	Speakers:      "#AGENT #CUSTOMER",
+
 	TransMustMatch: []RegexpValidation{
 		{
 			RuleName: "trans_initial_label",
@@ -96,6 +136,62 @@ type regexpValidator struct {
 	ruleName string
 	level    string
 	message  string
+
+	statuses map[string]bool
+	labels   []string
+	subProjs map[string]bool
+
+	fixRe       *regexp.Regexp
+	replacement *string
+	safeFix     bool
+}
+
+var multiSpaceRe = regexp.MustCompile(`  +`)
+
+// fix returns the transcription fixed using the replacement template
+// of the rule, or the empty string if there is no fix
+func (rv regexpValidator) fix(t string) string {
+	if rv.replacement == nil || rv.fixRe == nil {
+		return ""
+	}
+	res := rv.fixRe.ReplaceAllString(t, *rv.replacement)
+	res = strings.TrimSpace(multiSpaceRe.ReplaceAllString(res, " "))
+	if res == t {
+		return ""
+	}
+	return res
+}
+
+// appliesTo checks the scope of the rule. An empty subProj means
+// unknown, and is not used to exclude the rule.
+func (rv regexpValidator) appliesTo(transLabels map[string]bool, status, subProj string) bool {
+	if len(rv.statuses) > 0 && !rv.statuses[status] {
+		return false
+	}
+	if len(rv.statuses) == 0 && !strings.HasPrefix(status, "ok") {
+		return false
+	}
+
+	if subProj != "" && len(rv.subProjs) > 0 {
+		if !rv.subProjs[subProj] && !rv.subProjs[filepath.Base(subProj)] {
+			return false
+		}
+	}
+
+	if len(rv.labels) > 0 {
+		found := false
+		for _, l := range rv.labels {
+			if transLabels[l] {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	return true
 }
 
 type Validator struct {
@@ -109,6 +205,9 @@ type Validator struct {
 
 	labels map[string]bool
 
+	speakers  map[string]bool
+	languages map[string]bool
+
 	tokenSplitRegexp *regexp.Regexp
 
 	transMustMatch    []regexpValidator
@@ -164,6 +263,31 @@ func NewValidator(c Config) (Validator, error) {
 		res.labels[l] = true
 	}
 
+	if strings.TrimSpace(c.Speakers) != "" {
+		res.speakers = map[string]bool{}
+		for _, l := range splt.Split(strings.TrimSpace(c.Speakers), -1) {
+			if !res.labels[l] {
+				return res, fmt.Errorf("speaker '%s' is not a label", l)
+			}
+			res.speakers[l] = true
+		}
+	}
+	if strings.TrimSpace(c.Languages) != "" {
+		res.languages = map[string]bool{}
+		for _, l := range splt.Split(strings.TrimSpace(c.Languages), -1) {
+			res.languages[l] = true
+		}
+	}
+
+	for _, l := range c.ChannelLabels {
+		if l != "" && !res.labels[l] {
+			return res, fmt.Errorf("unknown channel label '%s'", l)
+		}
+		if l != "" && res.speakers != nil && !res.speakers[l] {
+			return res, fmt.Errorf("channel label '%s' is not a speaker", l)
+		}
+	}
+
 	tokSplit, err := regexp.Compile(c.TokenSplitRegexp)
 	if err != nil {
 		return res, fmt.Errorf("TokenSplitRegexp failed to compile : %v", err)
@@ -177,65 +301,88 @@ func NewValidator(c Config) (Validator, error) {
 	res.validCharsRegexp = validChars
 
 	for _, v := range c.TransMustMatch {
-		if v.Regexp == "" {
-			return res, fmt.Errorf("validation.NewValidator failed since RegexpValidation in TransMustMatch had empty Regexp")
+		rv, err := res.newRegexpValidator(v, "TransMustMatch")
+		if err != nil {
+			return res, err
 		}
+		res.transMustMatch = append(res.transMustMatch, rv)
+	}
 
-		if v.RuleName == "" {
-			return res, fmt.Errorf("validation.NewValidator failed since RegexpValidation in TransMustMatch had empty RuleName")
+	for _, v := range c.TransMustNotMatch {
+		rv, err := res.newRegexpValidator(v, "TransMustNotMatch")
+		if err != nil {
+			return res, err
 		}
+		res.transMustNotMatch = append(res.transMustNotMatch, rv)
+	}
 
-		if v.Level == "" {
-			return res, fmt.Errorf("validation.NewValidator failed since RegexpValidation in TransMustMatch had empty Level")
-		}
-		if v.Message == "" {
-			return res, fmt.Errorf("validation.NewValidator failed since RegexpValidation in TransMustMatch had empty Message")
-		}
+	return res, nil
+}
 
-		tMatch, err := regexp.Compile(v.Regexp)
-		if err != nil {
-			return res, fmt.Errorf("validation.NewValidator failed to compile TransMustMatch regexp : %v", err)
-		}
+// newRegexpValidator checks and compiles a RegexpValidation. The
+// status and label names of the validator must be set before calling.
+func (v *Validator) newRegexpValidator(rv RegexpValidation, field string) (regexpValidator, error) {
+	if rv.Regexp == "" {
+		return regexpValidator{}, fmt.Errorf("validation.NewValidator failed since RegexpValidation in %s had empty Regexp", field)
+	}
 
-		rv := regexpValidator{
-			re:       tMatch,
-			ruleName: v.RuleName,
-			level:    v.Level,
-			message:  v.Message,
-		}
+	if rv.RuleName == "" {
+		return regexpValidator{}, fmt.Errorf("validation.NewValidator failed since RegexpValidation in %s had empty RuleName", field)
+	}
 
-		res.transMustMatch = append(res.transMustMatch, rv)
+	if rv.Level == "" {
+		return regexpValidator{}, fmt.Errorf("validation.NewValidator failed since RegexpValidation in %s had empty Level", field)
+	}
+	if rv.Message == "" {
+		return regexpValidator{}, fmt.Errorf("validation.NewValidator failed since RegexpValidation in %s had empty Message", field)
 	}
 
-	for _, v := range c.TransMustNotMatch {
-		if v.Regexp == "" {
-			return res, fmt.Errorf("validation.NewValidator failed since RegexpValidation in TransMustNotMatch had empty Regexp")
-		}
+	re, err := regexp.Compile(rv.Regexp)
+	if err != nil {
+		return regexpValidator{}, fmt.Errorf("validation.NewValidator failed to compile %s regexp : %v", field, err)
+	}
 
-		if v.RuleName == "" {
-			return res, fmt.Errorf("validation.NewValidator failed since RegexpValidation in TransMustNotMatch had empty RuleName")
-		}
+	res := regexpValidator{
+		re:       re,
+		ruleName: rv.RuleName,
+		level:    rv.Level,
+		message:  rv.Message,
+		statuses: map[string]bool{},
+		labels:   rv.Labels,
+		subProjs: map[string]bool{},
+	}
 
-		if v.Level == "" {
-			return res, fmt.Errorf("validation.NewValidator failed since RegexpValidation in TransMustNotMatch had empty Level")
-		}
-		if v.Message == "" {
-			return res, fmt.Errorf("validation.NewValidator failed since RegexpValidation in TransMustNotMatch had empty Message")
+	for _, s := range rv.Statuses {
+		if !v.statusNames[s] {
+			return regexpValidator{}, fmt.Errorf("validation.NewValidator failed since RegexpValidation '%s' in %s has unknown status name '%s'", rv.RuleName, field, s)
 		}
-
-		tMatch, err := regexp.Compile(v.Regexp)
-		if err != nil {
-			return res, fmt.Errorf("validation.NewValidator failed to compile TransMustNotMatch regexp : %v", err)
+		res.statuses[s] = true
+	}
+	for _, l := range rv.Labels {
+		if !v.labels[l] {
+			return regexpValidator{}, fmt.Errorf("validation.NewValidator failed since RegexpValidation '%s' in %s has unknown label '%s'", rv.RuleName, field, l)
 		}
+	}
+	for _, sp := range rv.SubProjs {
+		res.subProjs[strings.TrimSuffix(sp, "/")] = true
+	}
 
-		rv := regexpValidator{
-			re:       tMatch,
-			ruleName: v.RuleName,
-			level:    v.Level,
-			message:  v.Message,
+	if rv.FixRegexp != "" && rv.Replacement == nil {
+		return regexpValidator{}, fmt.Errorf("validation.NewValidator failed since RegexpValidation '%s' in %s has FixRegexp but no Replacement", rv.RuleName, field)
+	}
+	if rv.Replacement != nil {
+		res.replacement = rv.Replacement
+		res.safeFix = rv.SafeFix
+		if rv.FixRegexp != "" {
+			res.fixRe, err = regexp.Compile(rv.FixRegexp)
+			if err != nil {
+				return regexpValidator{}, fmt.Errorf("validation.NewValidator failed to compile %s fix regexp : %v", field, err)
+			}
+		} else if field == "TransMustNotMatch" {
+			res.fixRe = re
+		} else {
+			return regexpValidator{}, fmt.Errorf("validation.NewValidator failed since RegexpValidation '%s' in %s has Replacement but no FixRegexp", rv.RuleName, field)
 		}
-
-		res.transMustNotMatch = append(res.transMustNotMatch, rv)
 	}
 
 	return res, nil
@@ -265,41 +412,179 @@ func (v *Validator) ValidateAnnotation(a protocol.AnnotationPayload) []ValRes {
 	}
 
 	res = append(res, validateAnnotationPayload(v.statusNames, a)...)
-	for _, c := range a.Chunks {
+	for i, c := range a.Chunks {
 		//res = append(res, ValidateTransChunk(c)...)
-		// TODO only validate OK/OK2?
-		if strings.HasPrefix(c.CurrentStatus.Name, "ok") {
-			res = append(res, v.ValidateTrans(c.Trans)...)
+		res = append(res, v.validateChunkFields(i, c)...)
+		res = append(res, v.ValidateTransFor(a.SubProj, c.CurrentStatus.Name, v.labelledTrans(c))...)
+	}
+
+	return res
+}
+
+// labelledTrans returns the transcription of a chunk, with the speaker
+// of the chunk (if any) as a leading label. Transcription rules are
+// applied to the labelled transcription, so that rules for speaker
+// labels in the text (such as a rule requiring an initial label) apply
+// to chunks with a speaker field too.
+func (v *Validator) labelledTrans(c protocol.TransChunk) string {
+	if c.Speaker == "" {
+		return c.Trans
+	}
+	if c.Trans == "" {
+		return c.Speaker
+	}
+	return c.Speaker + " " + c.Trans
+}
+
+// unlabelledTrans removes the speaker label added by labelledTrans from
+// the transcription t
+func (v *Validator) unlabelledTrans(c protocol.TransChunk, t string) string {
+	if c.Speaker == "" || (t != c.Speaker && !strings.HasPrefix(t, c.Speaker+" ")) {
+		return t
+	}
+	return strings.TrimSpace(strings.TrimPrefix(t, c.Speaker))
+}
+
+// validateChunkFields checks the speaker and language fields of a chunk
+func (v *Validator) validateChunkFields(i int, c protocol.TransChunk) []ValRes {
+	var res []ValRes
+	if c.Speaker != "" && v.speakers != nil && !v.speakers[c.Speaker] {
+		res = append(res, ValRes{
+			RuleName:   "unknown_speaker",
+			ChunkIndex: i,
+			Level:      "error",
+			Message:    fmt.Sprintf("Unknown speaker: '%s'", c.Speaker),
+		})
+	}
+	if c.Language != "" && v.languages != nil && !v.languages[c.Language] {
+		res = append(res, ValRes{
+			RuleName:   "unknown_language",
+			ChunkIndex: i,
+			Level:      "error",
+			Message:    fmt.Sprintf("Unknown language: '%s'", c.Language),
+		})
+	}
+	return res
+}
+
+// LiftSpeakerLabel moves a leading speaker label (see Config.Speakers)
+// from the transcription of a chunk to its speaker field, unless the
+// chunk already has another speaker. The input chunk is not modified.
+func (v *Validator) LiftSpeakerLabel(c protocol.TransChunk) protocol.TransChunk {
+	trans := strings.TrimSpace(c.Trans)
+	label := trans
+	if i := strings.IndexAny(trans, " \t\n"); i >= 0 {
+		label = trans[:i]
+	}
+	if !v.speakers[label] || (c.Speaker != "" && c.Speaker != label) {
+		return c
+	}
+	c.Speaker = label
+	c.Trans = strings.TrimSpace(strings.TrimPrefix(trans, label))
+	return c
+}
+
+// maxFixIterations limits the number of fixes applied to a single chunk
+const maxFixIterations = 10
+
+// FixAnnotation applies the suggested fixes to the chunks of the
+// annotation, one at a time, re-validating the transcription after
+// each fix. If safeOnly is true, only fixes marked as safe are
+// applied. The input annotation is not modified. The fixed
+// annotation is returned along with the issues that were fixed.
+func (v *Validator) FixAnnotation(a protocol.AnnotationPayload, safeOnly bool) (protocol.AnnotationPayload, []ValRes) {
+	var fixed []ValRes
+	res := a
+	res.Chunks = make([]protocol.TransChunk, len(a.Chunks))
+	copy(res.Chunks, a.Chunks)
+
+	for i, c := range res.Chunks {
+		for n := 0; n < maxFixIterations; n++ {
+			var fix *ValRes
+			for _, vr := range v.ValidateTransFor(a.SubProj, c.CurrentStatus.Name, v.labelledTrans(c)) {
+				if vr.Fix != "" && (vr.SafeFix || !safeOnly) {
+					fix = &vr
+					break
+				}
+			}
+			if fix == nil {
+				break
+			}
+			fix.ChunkIndex = i
+			fixed = append(fixed, *fix)
+			c.Trans = v.unlabelledTrans(c, fix.Fix)
 		}
+		res.Chunks[i] = c
 	}
 
+	return res, fixed
+}
+
+// ValidateLabelledTrans validates the transcription of a chunk being
+// edited in the given sub-project, as the transcription of an "ok"
+// chunk, with the speaker of the chunk as a leading label (see
+// labelledTrans). Suggested fixes are returned without the label.
+func (v *Validator) ValidateLabelledTrans(subProj string, c protocol.TransChunk) []ValRes {
+	res := v.ValidateTransFor(subProj, "ok", v.labelledTrans(c))
+	for i, vr := range res {
+		if vr.Fix != "" {
+			res[i].Fix = v.unlabelledTrans(c, vr.Fix)
+		}
+	}
 	return res
 }
 
+// ValidateTrans validates a transcription as the transcription of an
+// "ok" chunk in an unknown sub-project (rules scoped by sub-project
+// are applied regardless of their scope).
 func (v *Validator) ValidateTrans(t string) []ValRes {
+	return v.ValidateTransFor("", "ok", t)
+}
+
+// ValidateTransFor validates the transcription of a chunk with the
+// given status in the given sub-project. Only rules in scope are
+// applied. The character and label checks are only applied to
+// chunks with a status name starting with "ok".
+func (v *Validator) ValidateTransFor(subProj, status, t string) []ValRes {
 	var res []ValRes
-	res = append(res, validateTransChars(v.validCharsRegexp, v.labels, t)...)
-	res = append(res, validateInTransLabels(v.labelPrefix, v.labelSuffix, v.tokenSplitRegexp, v.labels, t)...)
+	if strings.HasPrefix(status, "ok") {
+		res = append(res, validateTransChars(v.validCharsRegexp, v.labels, t)...)
+		res = append(res, validateInTransLabels(v.labelPrefix, v.labelSuffix, v.tokenSplitRegexp, v.labels, t)...)
+	}
+
+	transLabels := v.transLabels(t)
 
 	for _, rv := range v.transMustMatch {
+		if !rv.appliesTo(transLabels, status, subProj) {
+			continue
+		}
 		if !rv.re.MatchString(t) {
+			fix := rv.fix(t)
 			vr := ValRes{
 				RuleName:   rv.ruleName,
 				Level:      rv.level,
 				Message:    rv.message,
 				ChunkIndex: -1,
+				Fix:        fix,
+				SafeFix:    rv.safeFix && fix != "",
 			}
 			res = append(res, vr)
 		}
 	}
 
 	for _, rv := range v.transMustNotMatch {
+		if !rv.appliesTo(transLabels, status, subProj) {
+			continue
+		}
 		if rv.re.MatchString(t) {
+			fix := rv.fix(t)
 			vr := ValRes{
 				RuleName:   rv.ruleName,
 				Level:      rv.level,
 				Message:    rv.message,
 				ChunkIndex: -1,
+				Fix:        fix,
+				SafeFix:    rv.safeFix && fix != "",
 			}
 			res = append(res, vr)
 		}
@@ -310,6 +595,17 @@ func (v *Validator) ValidateTrans(t string) []ValRes {
 	return res
 }
 
+// transLabels returns the set of valid labels in the transcription
+func (v *Validator) transLabels(t string) map[string]bool {
+	res := map[string]bool{}
+	for _, tok := range v.tokenSplitRegexp.Split(t, -1) {
+		if v.labels[tok] {
+			res[tok] = true
+		}
+	}
+	return res
+}
+
 func remDupes(valRes []ValRes) []ValRes {
 	var res []ValRes
 	seen := map[string]bool{}
@@ -330,6 +626,14 @@ func (v *Validator) IdenticalTranscriptions(a protocol.AnnotationPayload) []ValR
 
 	seenTrans := map[string]map[int]bool{}
 
+	// position of each chunk within its tier
+	tierPos := make([]int, len(a.Chunks))
+	tierLen := map[string]int{}
+	for i, c := range a.Chunks {
+		tierPos[i] = tierLen[c.Tier]
+		tierLen[c.Tier]++
+	}
+
 	for i, c := range a.Chunks {
 		t := c.Trans
 		t = strings.TrimSpace(t)
@@ -356,10 +660,11 @@ func (v *Validator) IdenticalTranscriptions(a protocol.AnnotationPayload) []ValR
 
 			adjacent := false
 			for i, n := range indx {
-				// consecutive chunks
-				if i > 0 && n-indx[i-1] == 1 {
-					adjacent = true
-					break
+				for _, m := range indx[:i] {
+					// consecutive chunks of the same tier
+					if a.Chunks[n-1].Tier == a.Chunks[m-1].Tier && tierPos[n-1]-tierPos[m-1] == 1 {
+						adjacent = true
+					}
 				}
 			}
 
@@ -410,6 +715,15 @@ func ignoreTrans(trans string, v *Validator) bool {
 
 func (v *Validator) Config() Config { return v.config }
 
+// ChannelLabel returns the speaker label of an audio channel (1 for
+// the first channel), or the empty string if there is none
+func (v *Validator) ChannelLabel(channel int) string {
+	if channel < 1 || channel > len(v.config.ChannelLabels) {
+		return ""
+	}
+	return v.config.ChannelLabels[channel-1]
+}
+
 type Validation struct {
 	Result []ValRes `json:"result"`
 }
@@ -419,6 +733,11 @@ type ValRes struct {
 	Level      string `json:"level"`
 	ChunkIndex int    `json:"chunk_index"`
 	Message    string `json:"message"`
+
+	// Fix is a suggested corrected transcription, if any
+	Fix string `json:"fix,omitempty"`
+	// SafeFix is true if Fix can be applied without manual inspection
+	SafeFix bool `json:"safe_fix,omitempty"`
 }
 
 func validateAnnotationPayload(validStatusNames map[string]bool, a protocol.AnnotationPayload) []ValRes {
@@ -440,14 +759,19 @@ func validateAnnotationPayload(validStatusNames map[string]bool, a protocol.Anno
 
 func validateChunks(validStatusNames map[string]bool, a protocol.AnnotationPayload) []ValRes {
 	var res []ValRes
-	// Look for overlapping chunks
+
+	res = append(res, validateTiers(a)...)
+
+	// Look for overlapping chunks (within each tier)
+	prevChunks := map[string]protocol.TransChunk{}
 	for i, c := range a.Chunks {
-		if i == 0 {
+		prev, ok := prevChunks[c.Tier]
+		prevChunks[c.Tier] = c
+		if !ok {
 			continue
 		}
 
 		// Look for overlapping chunks
-		prev := a.Chunks[i-1]
 		if prev.End > c.Start {
 
 			msg := fmt.Sprintf("Overlapping chunks: the end time of previous chunk is higher than the start time of the current one: %d vs %d", prev.End, c.Start)
@@ -468,6 +792,33 @@ func validateChunks(validStatusNames map[string]bool, a protocol.AnnotationPaylo
 	return res
 }
 
+// validateTiers checks that the tiers of the chunks are tiers of the
+// page, and that the speakers of the chunks match their tiers
+func validateTiers(a protocol.AnnotationPayload) []ValRes {
+	var res []ValRes
+	tiers := map[string]protocol.Tier{}
+	for _, t := range a.Tiers {
+		if _, ok := tiers[t.Name]; ok || t.Name == "" {
+			msg := fmt.Sprintf("Empty or duplicate tier name '%s'", t.Name)
+			res = append(res, ValRes{Level: "fatal", RuleName: "invalid_tier", ChunkIndex: -1, Message: msg})
+		}
+		tiers[t.Name] = t
+	}
+	for i, c := range a.Chunks {
+		t, ok := tiers[c.Tier]
+		if !ok && (c.Tier != "" || len(a.Tiers) > 0) {
+			msg := fmt.Sprintf("Chunk no. %d has unknown tier '%s'", i+1, c.Tier)
+			res = append(res, ValRes{Level: "fatal", RuleName: "unknown_tier", ChunkIndex: i, Message: msg})
+			continue
+		}
+		if t.Speaker != "" && c.Speaker != "" && c.Speaker != t.Speaker {
+			msg := fmt.Sprintf("Chunk no. %d has speaker '%s', but is in the tier of speaker '%s'", i+1, c.Speaker, t.Speaker)
+			res = append(res, ValRes{Level: "error", RuleName: "tier_speaker_mismatch", ChunkIndex: i, Message: msg})
+		}
+	}
+	return res
+}
+
 //var statusName = map[string]bool{
 //	"ok":        true,
 //	"ok2":       true,
@@ -547,7 +898,7 @@ func validateChunk(i int, validStatusNames map[string]bool, c protocol.TransChun
 
 // TODO Validating a single TransChunk returns ChunkIndex = -1
 func (v *Validator) ValidateTransChunk(c protocol.TransChunk) []ValRes {
-	return validateChunk(-1, v.statusNames, c)
+	return append(validateChunk(-1, v.statusNames, c), v.validateChunkFields(-1, c)...)
 }
 
 // TODO return index for illegal chars, so that it could be highlighted?
@@ -580,6 +931,28 @@ func validateTransChars(allowedChars *regexp.Regexp, labels map[string]bool, tra
 	return res
 }
 
+// replaceToken replaces the whole tokens of trans (as split by
+// tokenSplitPattern) that are equal to from with to, keeping the
+// separators
+func replaceToken(tokenSplitPattern *regexp.Regexp, trans, from, to string) string {
+	var b strings.Builder
+	prev := 0
+	seps := append(tokenSplitPattern.FindAllStringIndex(trans, -1), []int{len(trans), len(trans)})
+	for _, sep := range seps {
+		if sep[1] == 0 {
+			continue
+		}
+		tok := trans[prev:sep[0]]
+		if tok == from {
+			tok = to
+		}
+		b.WriteString(tok)
+		b.WriteString(trans[sep[0]:sep[1]])
+		prev = sep[1]
+	}
+	return b.String()
+}
+
 func validateInTransLabels(labelPrefix, labelSuffix string, tokenSplitPattern *regexp.Regexp, validLabels map[string]bool, trans string) []ValRes {
 
 	var res []ValRes
@@ -601,6 +974,14 @@ func validateInTransLabels(labelPrefix, labelSuffix string, tokenSplitPattern *r
 					ChunkIndex: -1,
 					Message:    msg,
 				}
+				// a valid label in the wrong case can be fixed
+				for _, l := range vls {
+					if strings.EqualFold(l, t) {
+						vr.Fix = replaceToken(tokenSplitPattern, trans, t, l)
+						vr.SafeFix = true
+						break
+					}
+				}
 
 				res = append(res, vr)
 			}
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"reflect"
 	"regexp"
 	"testing"
 
@@ -256,3 +257,327 @@ func TestValidator3(t *testing.T) {
 		t.Errorf("Expected 0 got %d", len(vRes1))
 	}
 }
+
+var cfgScoped = Config{
+	PageStatusNames:  "normal delete skip",
+	StatusNames:      "unchecked ok ok2 skip",
+	ValidCharsRegexp: `[\p{L}0-9 _.,#!?:-]`,
+	LabelPrefix:      "#",
+	Labels:           "#AGENT #CUSTOMER #NOISE",
+	TokenSplitRegexp: `[ \n,.!?]`,
+	TransMustMatch: []RegexpValidation{
+		{RuleName: "initial_label", Regexp: `^#`, Level: "fatal", Message: "must start with label"},
+	},
+	TransMustNotMatch: []RegexpValidation{
+		{RuleName: "skip_with_trans", Regexp: `\p{L}`, Level: "error", Message: "skipped chunk with transcription", Statuses: []string{"skip"}},
+		{RuleName: "noise_only", Regexp: `#NOISE.*\p{Ll}`, Level: "error", Message: "#NOISE with transcription", Labels: []string{"#NOISE"}},
+		{RuleName: "no_digits", Regexp: `[0-9]`, Level: "error", Message: "no digits", SubProjs: []string{"proj1"}},
+	},
+}
+
+func TestValidatorScope(t *testing.T) {
+	v, err := NewValidator(cfgScoped)
+	if err != nil {
+		t.Fatalf("failed to create new validator : %v", err)
+	}
+
+	// default scope is ok statuses
+	if w, g := 1, len(v.ValidateTransFor("", "ok", "utan label")); w != g {
+		t.Errorf("wanted %d got %d", w, g)
+	}
+	if w, g := 0, len(v.ValidateTransFor("", "unchecked", "utan label")); w != g {
+		t.Errorf("wanted %d got %d", w, g)
+	}
+
+	// status scope
+	if w, g := 1, len(v.ValidateTransFor("", "skip", "text")); w != g {
+		t.Errorf("wanted %d got %d", w, g)
+	}
+	if w, g := 0, len(v.ValidateTransFor("", "skip", "")); w != g {
+		t.Errorf("wanted %d got %d", w, g)
+	}
+
+	// label scope
+	if w, g := 1, len(v.ValidateTransFor("", "ok", "#NOISE text")); w != g {
+		t.Errorf("wanted %d got %d", w, g)
+	}
+	if w, g := 0, len(v.ValidateTransFor("", "ok", "#AGENT text #NOISE")); w != g {
+		t.Errorf("wanted %d got %d", w, g)
+	}
+
+	// sub project scope, matching dir name or path
+	if w, g := 1, len(v.ValidateTransFor("/data/proj1", "ok", "#AGENT 12")); w != g {
+		t.Errorf("wanted %d got %d", w, g)
+	}
+	if w, g := 0, len(v.ValidateTransFor("/data/proj2", "ok", "#AGENT 12")); w != g {
+		t.Errorf("wanted %d got %d", w, g)
+	}
+
+	anno := protocol.AnnotationPayload{
+		SubProj:       "/data/proj1",
+		CurrentStatus: protocol.Status{Name: "normal"},
+		Chunks: []protocol.TransChunk{
+			{Chunk: protocol.Chunk{Start: 0, End: 10}, Trans: "#AGENT 12", CurrentStatus: protocol.Status{Name: "ok"}},
+			{Chunk: protocol.Chunk{Start: 10, End: 20}, Trans: "text", CurrentStatus: protocol.Status{Name: "skip"}},
+			{Chunk: protocol.Chunk{Start: 20, End: 30}, Trans: "utan label", CurrentStatus: protocol.Status{Name: "unchecked"}},
+		},
+	}
+	vRes := v.ValidateAnnotation(anno)
+	if w, g := 2, len(vRes); w != g {
+		t.Errorf("wanted %d got %d", w, g)
+	}
+
+	// unknown status or label in scope
+	cfg := cfgScoped
+	cfg.TransMustMatch = []RegexpValidation{{RuleName: "r", Regexp: "x", Level: "error", Message: "m", Statuses: []string{"okk"}}}
+	if _, err := NewValidator(cfg); err == nil {
+		t.Errorf("expected error for unknown status, got nil")
+	}
+	cfg.TransMustMatch = []RegexpValidation{{RuleName: "r", Regexp: "x", Level: "error", Message: "m", Labels: []string{"#NOIS"}}}
+	if _, err := NewValidator(cfg); err == nil {
+		t.Errorf("expected error for unknown label, got nil")
+	}
+}
+
+func TestSpeakerField(t *testing.T) {
+	cfg := ConfigExample2
+	cfg.Languages = "sv en"
+	v, err := NewValidator(cfg)
+	if err != nil {
+		t.Fatalf("failed to create new validator : %v", err)
+	}
+
+	ok := protocol.Status{Name: "ok"}
+	anno := protocol.AnnotationPayload{
+		CurrentStatus: protocol.Status{Name: "normal"},
+		Chunks: []protocol.TransChunk{
+			// the speaker field counts as an initial label
+			{Chunk: protocol.Chunk{Start: 0, End: 10}, Speaker: "#AGENT", Trans: "hej", Language: "sv", CurrentStatus: ok},
+			{Chunk: protocol.Chunk{Start: 10, End: 20}, Speaker: "#NOISE", Trans: "hej", Language: "sv", CurrentStatus: ok},
+			{Chunk: protocol.Chunk{Start: 20, End: 30}, Speaker: "#CUSTOMER", Trans: "hej", Language: "fi", CurrentStatus: ok},
+			{Chunk: protocol.Chunk{Start: 30, End: 40}, Trans: "hej", CurrentStatus: ok},
+		},
+	}
+	rules := map[int]string{}
+	for _, vr := range v.ValidateAnnotation(anno) {
+		rules[vr.ChunkIndex] += vr.RuleName
+	}
+	// transcription issues have no chunk index
+	exp := map[int]string{1: "unknown_speaker", 2: "unknown_language", -1: "trans_initial_label"}
+	if !reflect.DeepEqual(exp, rules) {
+		t.Errorf("wanted %v got %v", exp, rules)
+	}
+
+	// live validation of the chunk being edited
+	if vres := v.ValidateLabelledTrans("", protocol.TransChunk{Speaker: "#AGENT", Trans: "hej"}); len(vres) != 0 {
+		t.Errorf("expected no issues, got %v", vres)
+	}
+	if w, g := "trans_initial_label", v.ValidateLabelledTrans("", protocol.TransChunk{Trans: "hej"})[0].RuleName; w != g {
+		t.Errorf("wanted '%s' got '%s'", w, g)
+	}
+
+	cfg.Speakers = "#AGENT #CALLER"
+	if _, err := NewValidator(cfg); err == nil {
+		t.Errorf("expected error for speaker that is not a label, got nil")
+	}
+	cfg.Speakers = "#AGENT"
+	if _, err := NewValidator(cfg); err == nil {
+		t.Errorf("expected error for channel label that is not a speaker, got nil")
+	}
+}
+
+func TestTiers(t *testing.T) {
+	v, err := NewValidator(ConfigExample2)
+	if err != nil {
+		t.Fatalf("failed to create new validator : %v", err)
+	}
+
+	ok := protocol.Status{Name: "ok"}
+	anno := protocol.AnnotationPayload{
+		CurrentStatus: protocol.Status{Name: "normal"},
+		Tiers:         []protocol.Tier{{Name: "a", Speaker: "#AGENT", Channel: 1}, {Name: "c", Speaker: "#CUSTOMER", Channel: 2}},
+		Chunks: []protocol.TransChunk{
+			{Chunk: protocol.Chunk{Start: 0, End: 20}, Tier: "a", Speaker: "#AGENT", Trans: "hej", CurrentStatus: ok},
+			// overlaps the chunk of another tier
+			{Chunk: protocol.Chunk{Start: 10, End: 30}, Tier: "c", Speaker: "#CUSTOMER", Trans: "hej", CurrentStatus: ok},
+			// overlaps the chunk of the same tier
+			{Chunk: protocol.Chunk{Start: 15, End: 40}, Tier: "a", Speaker: "#AGENT", Trans: "hej", CurrentStatus: ok},
+			{Chunk: protocol.Chunk{Start: 40, End: 50}, Tier: "c", Speaker: "#AGENT", Trans: "hej", CurrentStatus: ok},
+			{Chunk: protocol.Chunk{Start: 50, End: 60}, Tier: "x", Speaker: "#AGENT", Trans: "hej", CurrentStatus: ok},
+		},
+	}
+	rules := map[int]string{}
+	for _, vr := range v.ValidateAnnotation(anno) {
+		rules[vr.ChunkIndex] += vr.RuleName
+	}
+	exp := map[int]string{2: "overlapping_chunks", 3: "tier_speaker_mismatch", 4: "unknown_tier"}
+	if !reflect.DeepEqual(exp, rules) {
+		t.Errorf("wanted %v got %v", exp, rules)
+	}
+
+	// identical transcriptions are adjacent within a tier
+	trans := "hej hur mår du"
+	anno.Chunks = []protocol.TransChunk{
+		{Chunk: protocol.Chunk{Start: 0, End: 20}, Tier: "a", Trans: trans},
+		{Chunk: protocol.Chunk{Start: 10, End: 30}, Tier: "c", Trans: "hallå där hur mår du"},
+		{Chunk: protocol.Chunk{Start: 30, End: 40}, Tier: "a", Trans: trans},
+	}
+	if w, g := 1, len(v.IdenticalTranscriptions(anno)); w != g {
+		t.Errorf("wanted %d got %d", w, g)
+	}
+	anno.Chunks[2].Tier = "c"
+	if w, g := 0, len(v.IdenticalTranscriptions(anno)); w != g {
+		t.Errorf("wanted %d got %d", w, g)
+	}
+}
+
+func TestLiftSpeakerLabel(t *testing.T) {
+	v, err := NewValidator(ConfigExample2)
+	if err != nil {
+		t.Fatalf("failed to create new validator : %v", err)
+	}
+	for _, test := range []struct {
+		in, exp protocol.TransChunk
+	}{
+		{in: protocol.TransChunk{Trans: " #AGENT hej  då"}, exp: protocol.TransChunk{Speaker: "#AGENT", Trans: "hej  då"}},
+		{in: protocol.TransChunk{Trans: "#CUSTOMER"}, exp: protocol.TransChunk{Speaker: "#CUSTOMER", Trans: ""}},
+		{in: protocol.TransChunk{Trans: "#AGENT hej", Speaker: "#AGENT"}, exp: protocol.TransChunk{Speaker: "#AGENT", Trans: "hej"}},
+		// not a speaker
+		{in: protocol.TransChunk{Trans: "#NOISE hej"}, exp: protocol.TransChunk{Trans: "#NOISE hej"}},
+		// not leading
+		{in: protocol.TransChunk{Trans: "hej #AGENT"}, exp: protocol.TransChunk{Trans: "hej #AGENT"}},
+		// another speaker
+		{in: protocol.TransChunk{Trans: "#AGENT hej", Speaker: "#CUSTOMER"}, exp: protocol.TransChunk{Speaker: "#CUSTOMER", Trans: "#AGENT hej"}},
+		{in: protocol.TransChunk{Trans: "#AGENTX hej"}, exp: protocol.TransChunk{Trans: "#AGENTX hej"}},
+	} {
+		if g := v.LiftSpeakerLabel(test.in); !reflect.DeepEqual(test.exp, g) {
+			t.Errorf("wanted %#v got %#v", test.exp, g)
+		}
+	}
+}
+
+func TestChannelLabel(t *testing.T) {
+	v, err := NewValidator(ConfigExample2)
+	if err != nil {
+		t.Fatalf("failed to create new validator : %v", err)
+	}
+	for channel, exp := range map[int]string{0: "", 1: "#AGENT", 2: "#CUSTOMER", 3: ""} {
+		if w, g := exp, v.ChannelLabel(channel); w != g {
+			t.Errorf("wanted %s got %s", w, g)
+		}
+	}
+
+	cfg := ConfigExample2
+	cfg.ChannelLabels = []string{"#AGENT", "#CALLER"}
+	if _, err := NewValidator(cfg); err == nil {
+		t.Errorf("expected error for unknown channel label, got nil")
+	}
+}
+
+func TestValidatorFix(t *testing.T) {
+	bts, err := os.ReadFile("sample_validation_config.json")
+	if err != nil {
+		t.Fatalf("failed to read config : %v", err)
+	}
+	v, err := NewValidatorFromJSON(bts)
+	if err != nil {
+		t.Fatalf("failed to create new validator : %v", err)
+	}
+
+	fixes := func(trans string) map[string]ValRes {
+		res := map[string]ValRes{}
+		for _, vr := range v.ValidateTrans(trans) {
+			res[vr.RuleName] = vr
+		}
+		return res
+	}
+
+	vr := fixes("#AGENT hej.. då")["repeated_full_stops"]
+	if w, g := "#AGENT hej. då", vr.Fix; w != g {
+		t.Errorf("wanted '%s' got '%s'", w, g)
+	}
+	if !vr.SafeFix {
+		t.Errorf("expected safe fix")
+	}
+
+	vr = fixes("#AGENT hej\u00a0då")["no_break_space"]
+	if w, g := "#AGENT hej då", vr.Fix; w != g {
+		t.Errorf("wanted '%s' got '%s'", w, g)
+	}
+
+	vr = fixes("#agent hej")["invalid_label"]
+	if w, g := "#AGENT hej", vr.Fix; w != g {
+		t.Errorf("wanted '%s' got '%s'", w, g)
+	}
+	if !vr.SafeFix {
+		t.Errorf("expected safe fix")
+	}
+
+	// only whole tokens are fixed
+	vr = fixes("#agentur x#agent #agent.")["invalid_label"]
+	if w, g := "#agentur x#agent #AGENT.", vr.Fix; w != g {
+		t.Errorf("wanted '%s' got '%s'", w, g)
+	}
+
+	vr = fixes("hej #CUSTOMER på dig")["trans_initial_label"]
+	if w, g := "#CUSTOMER hej på dig", vr.Fix; w != g {
+		t.Errorf("wanted '%s' got '%s'", w, g)
+	}
+	if vr.SafeFix {
+		t.Errorf("expected unsafe fix")
+	}
+
+	// no fix
+	vr = fixes("hej på dig")["trans_initial_label"]
+	if w, g := "", vr.Fix; w != g {
+		t.Errorf("wanted '%s' got '%s'", w, g)
+	}
+
+	anno := protocol.AnnotationPayload{
+		Chunks: []protocol.TransChunk{
+			{Trans: "#agent hej.. .\u00a0då", CurrentStatus: protocol.Status{Name: "ok"}},
+			{Trans: "hej #CUSTOMER", CurrentStatus: protocol.Status{Name: "ok"}},
+		},
+	}
+	fixed, fixedRes := v.FixAnnotation(anno, true)
+	if w, g := "#AGENT hej. då", fixed.Chunks[0].Trans; w != g {
+		t.Errorf("wanted '%s' got '%s'", w, g)
+	}
+	if w, g := "hej #CUSTOMER", fixed.Chunks[1].Trans; w != g {
+		t.Errorf("wanted '%s' got '%s'", w, g)
+	}
+	if w, g := 3, len(fixedRes); w != g {
+		t.Errorf("wanted %d got %d", w, g)
+	}
+	// input is not modified
+	if w, g := "hej #CUSTOMER", anno.Chunks[1].Trans; w != g {
+		t.Errorf("wanted '%s' got '%s'", w, g)
+	}
+
+	fixed, _ = v.FixAnnotation(anno, false)
+	if w, g := "#CUSTOMER hej", fixed.Chunks[1].Trans; w != g {
+		t.Errorf("wanted '%s' got '%s'", w, g)
+	}
+
+	// fixes don't add the speaker label to the transcription
+	anno.Chunks[0] = protocol.TransChunk{Speaker: "#AGENT", Trans: "hej.. då", CurrentStatus: protocol.Status{Name: "ok"}}
+	fixed, _ = v.FixAnnotation(anno, true)
+	if w, g := "hej. då", fixed.Chunks[0].Trans; w != g {
+		t.Errorf("wanted '%s' got '%s'", w, g)
+	}
+	vres := v.ValidateLabelledTrans("", anno.Chunks[0])
+	if w, g := 1, len(vres); w != g {
+		t.Fatalf("wanted %d got %d", w, g)
+	}
+	if w, g := "hej. då", vres[0].Fix; w != g {
+		t.Errorf("wanted '%s' got '%s'", w, g)
+	}
+
+	// fix without fix regexp for must match rule
+	cfg := ConfigExample2
+	repl := ""
+	cfg.TransMustMatch = []RegexpValidation{{RuleName: "r", Regexp: "x", Level: "error", Message: "m", Replacement: &repl}}
+	if _, err := NewValidator(cfg); err == nil {
+		t.Errorf("expected error for replacement without fix regexp, got nil")
+	}
+}